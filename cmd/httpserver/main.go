@@ -1,31 +1,88 @@
 package main
 
 import (
-	"crypto/sha256"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/KrishKoria/HTTPfromTCP/internal/compress"
+	"github.com/KrishKoria/HTTPfromTCP/internal/fileserver"
 	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/proxy"
 	"github.com/KrishKoria/HTTPfromTCP/internal/request"
 	"github.com/KrishKoria/HTTPfromTCP/internal/response"
 	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+	"github.com/KrishKoria/HTTPfromTCP/internal/sse"
+	"github.com/KrishKoria/HTTPfromTCP/internal/websocket"
 )
 
 const port = 42069
 
+var assets = fileserver.New("assets", "/assets/")
+
+var httpbin *proxy.ReverseProxy
+
 func main() {
-	server, err := server.Serve(port, handler)
+	upstream := flag.String("upstream", "https://httpbin.org", "comma separated upstream URLs for /httpbin/ requests")
+	balance := flag.String("balance", "round-robin", "balancing strategy across upstreams: round-robin, least-conn or hash")
+	hashHeader := flag.String("hash-header", "", "header to hash on with -balance=hash, client IP when empty")
+	healthPath := flag.String("health-path", "", "path probed on every upstream, no active checks when empty")
+	connectAllow := flag.String("connect-allow", "", "comma separated CONNECT destinations (host, *.domain, CIDR, optionally :port or :low-high), tunnelling is off when empty")
+	connectDeny := flag.String("connect-deny", "", "comma separated CONNECT destinations that are always refused")
+	h3Cert := flag.String("h3-cert", "", "certificate file to also serve HTTP/3 on the same port over UDP (experimental), with -h3-key")
+	h3Key := flag.String("h3-key", "", "private key file for -h3-cert")
+	pipelineDepth := flag.Int("pipeline-depth", 1, "pipelined safe requests handled at once on a connection")
+	flag.Parse()
+
+	var err error
+	httpbin, err = newProxy(strings.Split(*upstream, ","), *balance, *hashHeader, *healthPath)
+	if err != nil {
+		log.Fatalf("Error configuring proxy: %v", err)
+	}
+	httpbin.StripPrefix = "/httpbin"
+	httpbin.ChecksumTrailers = true
+
+	mux := server.NewMux()
+	mux.Handle(server.AnyMethod, "/httpbin/", proxyHandler)
+	mux.Handle("GET", "/video", handleVideo)
+	mux.Handle("GET", "/assets/", assets.Serve)
+	mux.Handle("GET", "/ws", handleEcho)
+	mux.Handle("GET", "/events", handleEvents)
+	mux.Handle(server.AnyMethod, "/yourproblem", handler200)
+	mux.Handle(server.AnyMethod, "/myproblem", handler500)
+	mux.Handle(server.AnyMethod, "/", handler200)
+	if *connectAllow != "" {
+		tunnel, err := newTunnel(*connectAllow, *connectDeny)
+		if err != nil {
+			log.Fatalf("Error configuring CONNECT tunnels: %v", err)
+		}
+		mux.Connect = tunnel.Serve
+	}
+
+	options := server.Options{PipelineDepth: *pipelineDepth}
+	server, err := server.ServeWithOptions(port, compress.New().Wrap(mux.Serve), options)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
 	log.Println("Server started on port", port)
+	if *h3Cert != "" {
+		cert, err := tls.LoadX509KeyPair(*h3Cert, *h3Key)
+		if err != nil {
+			log.Fatalf("Error loading HTTP/3 certificate: %v", err)
+		}
+		if err := server.ServeHTTP3(port, &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+			log.Fatalf("Error starting HTTP/3 server: %v", err)
+		}
+		log.Println("HTTP/3 started on UDP port", port)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -33,160 +90,176 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler200(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-		return
-	}
-	if req.RequestLine.RequestTarget == "/video" {
-         handleVideo(w)
-		 return
-    }
-	handler200(w, req)
-	return
+// page is a response of the demo handlers, which they send as HTML or
+// plain text, whichever the client accepts.
+type page struct {
+	status  response.StatusCode
+	title   string
+	heading string
+	message string
 }
 
-func handler400(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeBadRequest)
-	body := []byte(`<html>
+func (p page) write(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(0)
+	contentType, err := headers.Negotiate(req.Headers, h, "Accept", "text/html", "text/plain")
+	if err != nil {
+		if p.status != response.StatusCodeSuccess {
+			// an error page in the wrong format beats none at all
+			contentType = "text/plain"
+		} else {
+			p = page{
+				status:  response.StatusCodeNotAcceptable,
+				message: "This resource is available as text/html or text/plain.",
+			}
+			contentType = "text/plain"
+		}
+	}
+	var body []byte
+	if contentType == "text/html" {
+		body = []byte(fmt.Sprintf(`<html>
 <head>
-<title>400 Bad Request</title>
+<title>%s</title>
 </head>
 <body>
-<h1>Bad Request</h1>
-<p>Your request honestly kinda sucked.</p>
+<h1>%s</h1>
+<p>%s</p>
 </body>
 </html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
+`, p.title, p.heading, p.message))
+	} else {
+		body = []byte(p.message + "\n")
+	}
+	w.WriteStatusLine(p.status)
+	h.Override("Content-Length", strconv.Itoa(len(body)))
+	h.Override("Content-Type", contentType)
 	w.WriteHeaders(h)
 	w.WriteBody(body)
-	return
 }
 
-func handler500(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeInternalServerError)
-	body := []byte(`<html>
-<head>
-<title>500 Internal Server Error</title>
-</head>
-<body>
-<h1>Internal Server Error</h1>
-<p>Okay, you know what? This one is on me.</p>
-</body>
-</html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+func handler400(w *response.Writer, req *request.Request) {
+	page{
+		status:  response.StatusCodeBadRequest,
+		title:   "400 Bad Request",
+		heading: "Bad Request",
+		message: "Your request honestly kinda sucked.",
+	}.write(w, req)
 }
 
-func handler200(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	body := []byte(`<html>
-<head>
-<title>200 OK</title>
-</head>
-<body>
-<h1>Success!</h1>
-<p>Your request was an absolute banger.</p>
-</body>
-</html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
-	return
+func handler500(w *response.Writer, req *request.Request) {
+	page{
+		status:  response.StatusCodeInternalServerError,
+		title:   "500 Internal Server Error",
+		heading: "Internal Server Error",
+		message: "Okay, you know what? This one is on me.",
+	}.write(w, req)
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := "https://httpbin.org/" + target
-	fmt.Println("Proxying to", url)
-	resp, err := http.Get(url)
+func handler200(w *response.Writer, req *request.Request) {
+	page{
+		status:  response.StatusCodeSuccess,
+		title:   "200 OK",
+		heading: "Success!",
+		message: "Your request was an absolute banger.",
+	}.write(w, req)
+}
+
+// newProxy fronts a single upstream directly and several through a pool.
+func newProxy(upstreams []string, balance, hashHeader, healthPath string) (*proxy.ReverseProxy, error) {
+	if len(upstreams) == 1 {
+		return proxy.New(upstreams[0])
+	}
+	var strategy proxy.Strategy
+	switch balance {
+	case "round-robin":
+		strategy = &proxy.RoundRobin{}
+	case "least-conn":
+		strategy = proxy.LeastConnections{}
+	case "hash":
+		strategy = &proxy.ConsistentHash{Header: hashHeader}
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy: %s", balance)
+	}
+	pool, err := proxy.NewPool(upstreams, strategy)
 	if err != nil {
-		handler500(w, req)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
+	pool.HealthCheckPath = healthPath
+	pool.StartHealthChecks()
+	return proxy.NewBalanced(pool), nil
+}
 
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Override("Trailer", "X-Content-SHA256, X-Content-Length")
-	h.Remove("Content-Length")
-	w.WriteHeaders(h)
+// newTunnel builds the forward proxy for CONNECT requests.
+func newTunnel(allow, deny string) (*proxy.Tunnel, error) {
+	allowRules, err := proxy.ParseRules(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyRules, err := proxy.ParseRules(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &proxy.Tunnel{Allow: allowRules, Deny: denyRules}, nil
+}
+
+// proxyHandler forwards /httpbin/* to the upstream, streaming the body back
+// chunked with checksum trailers.
+func proxyHandler(w *response.Writer, req *request.Request) {
+	httpbin.Serve(w, req)
+}
+
+func handleVideo(w *response.Writer, req *request.Request) {
+	assets.ServeFile(w, req, "vim.mp4")
+}
 
-	fullBody := make([]byte, 0)
+var upgrader = &websocket.Upgrader{}
 
-	const maxChunkSize = 1024
-	buffer := make([]byte, maxChunkSize)
+// handleEcho sends every WebSocket message back to the client, which is
+// what the Autobahn fuzzing client expects of the server it tests.
+func handleEcho(w *response.Writer, req *request.Request) {
+	conn, err := upgrader.Upgrade(w, req)
+	if err != nil {
+		log.Printf("Error upgrading to WebSocket: %v", err)
+		return
+	}
 	for {
-		n, err := resp.Body.Read(buffer)
-		fmt.Println("Read", n, "bytes")
-		if n > 0 {
-			_, err = w.WriteChunkedBody(buffer[:n])
-			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
-				break
-			}
-			fullBody = append(fullBody, buffer[:n]...)
-		}
-		if err == io.EOF {
-			break
-		}
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			break
+			return
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			return
 		}
 	}
-	_, err = w.WriteChunkedBodyDone()
+}
+
+var streamer = &sse.Streamer{Retry: 2 * time.Second}
+
+// handleEvents streams a counter once a second. A reconnecting client
+// resumes after the last count it received.
+func handleEvents(w *response.Writer, req *request.Request) {
+	stream, err := streamer.Start(w, req)
 	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
+		log.Printf("Error starting event stream: %v", err)
+		return
 	}
-	trailers := headers.NewHeaders()
-	sha256 := fmt.Sprintf("%x", sha256.Sum256(fullBody))
-	trailers.Override("X-Content-SHA256", sha256)
-	trailers.Override("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		fmt.Println("Error writing trailers:", err)
+	defer stream.Close()
+	count, _ := strconv.Atoi(stream.LastEventID)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			count++
+			err := stream.Send(sse.Event{
+				ID:    strconv.Itoa(count),
+				Event: "tick",
+				Data:  now.UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				return
+			}
+		}
 	}
-	fmt.Println("Wrote trailers")
 }
-
-func handleVideo(w *response.Writer) {
-    // Read the video file
-    videoData, err := os.ReadFile("assets/vim.mp4")
-    if err != nil {
-        fmt.Println("Error reading video file:", err)
-        w.WriteStatusLine(response.StatusCodeInternalServerError)
-        body := []byte("Error reading video file")
-        h := response.GetDefaultHeaders(len(body))
-        w.WriteHeaders(h)
-        w.WriteBody(body)
-        return
-    }
-    
-    // Write success status
-    w.WriteStatusLine(response.StatusCodeSuccess)
-    
-    // Set headers with video content type
-    h := response.GetDefaultHeaders(len(videoData))
-    h.Override("Content-Type", "video/mp4")
-    w.WriteHeaders(h)
-    
-    // Write the video data
-    w.WriteBody(videoData)
-}
\ No newline at end of file
@@ -35,8 +35,10 @@ func main() {
         fmt.Printf("- Target: %s\n", res.RequestLine.RequestTarget)
         fmt.Printf("- Version: %s\n", res.RequestLine.HttpVersion[5:])
         fmt.Println("Headers:")
-        for key, value := range res.Headers {
-            fmt.Printf("- %s: %s\n", key, value)
+        for key := range res.Headers {
+            for _, value := range res.Headers.Values(key) {
+                fmt.Printf("- %s: %s\n", key, value)
+            }
         }
         fmt.Println("Body:")
         fmt.Println(string(res.Body))
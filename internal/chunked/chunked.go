@@ -0,0 +1,60 @@
+// Package chunked holds the parts of the chunked transfer coding, RFC 9112
+// section 7.1, that request and response parsing share.
+package chunked
+
+import (
+	"fmt"
+	"math"
+)
+
+// ParseSize parses a chunk line without its CRLF and returns the chunk
+// size. The size is hex digits only: parsers that also take a sign or
+// whitespace around it disagree with stricter ones about where the body
+// ends, which is what request smuggling builds on. Chunk extensions after
+// it are skipped.
+func ParseSize(line []byte) (int, error) {
+	size, i := 0, 0
+	for ; i < len(line); i++ {
+		digit, ok := hexDigit(line[i])
+		if !ok {
+			break
+		}
+		if size > (math.MaxInt32-digit)/16 {
+			return 0, fmt.Errorf("chunk size too large: %q", line)
+		}
+		size = size*16 + digit
+	}
+	if i == 0 || !validExtensions(line[i:]) {
+		return 0, fmt.Errorf("malformed chunk size: %q", line)
+	}
+	return size, nil
+}
+
+func hexDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// validExtensions reports whether what follows the size is nothing, or
+// chunk extensions starting with a ";" after optional whitespace.
+func validExtensions(rest []byte) bool {
+	if len(rest) == 0 {
+		return true
+	}
+	for _, c := range rest {
+		if c == ';' {
+			return true
+		}
+		if c != ' ' && c != '\t' {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package chunked
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	// Test: Hex digits in either case, with or without extensions
+	for line, want := range map[string]int{
+		"0":                  0,
+		"a":                  10,
+		"1F":                 31,
+		"0000000c":           12,
+		"5;name=value":       5,
+		"5 ;name":            5,
+		"5\t; a=\"b;c\"":     5,
+		"7fffffff":           1<<31 - 1,
+		"00000000007fffffff": 1<<31 - 1,
+	} {
+		size, err := ParseSize([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, want, size, line)
+	}
+
+	// Test: Signs, whitespace, prefixes and overflow are refused, RFC 9112
+	// section 7.1
+	for _, line := range []string{"", "+5", "-5", " 5", "5 ", "\t5", "0x5", "5g", "5 6", "5\r", ";ext", "80000000", "ffffffffffffffff"} {
+		_, err := ParseSize([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
@@ -0,0 +1,289 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+const (
+	DefaultMaxIdleConnsPerHost = 8
+	DefaultIdleTimeout         = 90 * time.Second
+	DefaultDialTimeout         = 10 * time.Second
+)
+
+// Client sends requests over HTTP/1.1 connections it dials itself, keeping
+// connections alive and pooled per host between requests.
+type Client struct {
+	MaxIdleConnsPerHost int
+	IdleTimeout         time.Duration
+	DialTimeout         time.Duration
+	// Timeout bounds the time from sending a request to reading the
+	// response headers. Zero means no limit.
+	Timeout   time.Duration
+	TLSConfig *tls.Config
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+func New() *Client {
+	return &Client{
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		IdleTimeout:         DefaultIdleTimeout,
+		DialTimeout:         DefaultDialTimeout,
+	}
+}
+
+type conn struct {
+	net.Conn
+	reader    *response.Reader
+	key       string
+	idleSince time.Time
+	reused    bool
+}
+
+// NewRequest builds a request with an absolute-form target, which is how
+// the client learns where to send it.
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: rawURL,
+			HttpVersion:   "1.1",
+		},
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+// Get sends a GET request and reads the whole response.
+func (c *Client) Get(rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends the request and reads the whole response, body included.
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	resp, body, err := c.Stream(req)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = data
+	return resp, nil
+}
+
+// Stream sends the request and returns once the response headers are in.
+// The body is decoded as it is read; closing it returns the connection to
+// the pool if the body was read to the end and the server allows reuse.
+func (c *Client) Stream(req *request.Request) (*response.Response, io.ReadCloser, error) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, nil, fmt.Errorf("unsupported scheme: %q", target.Scheme)
+	}
+	if target.Host == "" {
+		return nil, nil, fmt.Errorf("request target has no host: %s", req.RequestLine.RequestTarget)
+	}
+
+	outReq := originForm(req, target)
+	for {
+		cn, err := c.getConn(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, body, err := c.roundTrip(cn, outReq)
+		if err != nil {
+			cn.Close()
+			// an idle connection the server already closed fails before
+			// any response arrives, so the request can be sent again
+			if cn.reused && Idempotent(req.RequestLine.Method) && isStaleConnError(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		return resp, &responseBody{client: c, conn: cn, resp: resp, body: body}, nil
+	}
+}
+
+func (c *Client) roundTrip(cn *conn, req *request.Request) (*response.Response, io.Reader, error) {
+	if c.Timeout > 0 {
+		cn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if err := req.Write(cn); err != nil {
+		return nil, nil, err
+	}
+	resp, body, err := cn.reader.ReadResponseHeader(req.RequestLine.Method)
+	if err != nil {
+		return nil, nil, err
+	}
+	cn.SetDeadline(time.Time{})
+	return resp, body, nil
+}
+
+// originForm copies the request with the target rewritten to the origin
+// form sent on the wire and a Host header filled in.
+func originForm(req *request.Request, target *url.URL) *request.Request {
+	out := *req
+	out.Headers = headers.NewHeaders()
+	for k, v := range req.Headers {
+		out.Headers.Override(k, v)
+	}
+	if _, ok := out.Headers.Get("Host"); !ok {
+		out.Headers.Override("Host", target.Host)
+	}
+	out.RequestLine.RequestTarget = target.RequestURI()
+	return &out
+}
+
+func (c *Client) getConn(target *url.URL) (*conn, error) {
+	key := target.Scheme + "://" + hostPort(target)
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		cn := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if c.IdleTimeout > 0 && time.Since(cn.idleSince) > c.IdleTimeout {
+			cn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		cn.reused = true
+		return cn, nil
+	}
+	c.mu.Unlock()
+	return c.dial(target, key)
+}
+
+func (c *Client) dial(target *url.URL, key string) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.DialTimeout}
+	address := hostPort(target)
+	var nc net.Conn
+	var err error
+	if target.Scheme == "https" {
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = target.Hostname()
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		nc, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, reader: response.NewReader(nc), key: key}, nil
+}
+
+// putConn returns a connection to the idle pool, closing it if the pool
+// for its host is full.
+func (c *Client) putConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = map[string][]*conn{}
+	}
+	if len(c.idle[cn.key]) >= c.MaxIdleConnsPerHost {
+		cn.Close()
+		return
+	}
+	cn.idleSince = time.Now()
+	c.idle[cn.key] = append(c.idle[cn.key], cn)
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// responseBody releases the connection when the caller is done with it.
+type responseBody struct {
+	client *Client
+	conn   *conn
+	resp   *response.Response
+	body   io.Reader
+	eof    bool
+	closed bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("read on closed response body")
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.eof && b.resp.KeepAlive() && len(b.conn.reader.Buffered()) == 0 {
+		b.client.putConn(b.conn)
+		return nil
+	}
+	return b.conn.Close()
+}
+
+func hostPort(target *url.URL) string {
+	if target.Port() != "" {
+		return target.Host
+	}
+	if target.Scheme == "https" {
+		return net.JoinHostPort(target.Hostname(), "443")
+	}
+	return net.JoinHostPort(target.Hostname(), "80")
+}
+
+// Idempotent reports whether requests with the method can be repeated
+// without changing the outcome, RFC 9110 section 9.2.2, and so be retried
+// after a failure that may have left their outcome unknown.
+func Idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isStaleConnError(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
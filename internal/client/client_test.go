@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer is an httptest server that counts new connections.
+func countingServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	s := httptest.NewUnstartedServer(handler)
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s, &conns
+}
+
+// rawServer answers every connection with the same bytes and closes it.
+func rawServer(t *testing.T, raw string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == "\r\n" {
+						break
+					}
+				}
+				conn.Write([]byte(raw))
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func TestDo(t *testing.T) {
+	s, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		w.Header().Set("X-Host", r.Host)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	})
+	c := New()
+
+	// Test: Method, target, Host and body go out on the wire
+	req, err := NewRequest("POST", s.URL+"/submit?x=1", []byte("hello"))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeAccepted, resp.StatusLine.StatusCode)
+	assert.Equal(t, "Accepted", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "POST", resp.Headers["x-method"])
+	assert.Equal(t, "/submit?x=1", resp.Headers["x-path"])
+	assert.Equal(t, s.Listener.Addr().String(), resp.Headers["x-host"])
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: Unsupported scheme
+	_, err = c.Get("ftp://example.com/")
+	require.Error(t, err)
+}
+
+func TestChunkedWithTrailers(t *testing.T) {
+	s, _ := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("second"))
+		w.Header().Set("X-Checksum", "abc")
+	})
+	resp, err := New().Get(s.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(resp.Body))
+	assert.Equal(t, "abc", resp.Trailers["x-checksum"])
+}
+
+func TestCloseDelimited(t *testing.T) {
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the connection closes")
+	resp, err := New().Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "until the connection closes", string(resp.Body))
+	assert.False(t, resp.KeepAlive())
+}
+
+func TestKeepAlive(t *testing.T) {
+	s, conns := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	c := New()
+
+	// Test: Sequential requests share one connection
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get(s.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(resp.Body))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: A HEAD response with Content-Length leaves the connection usable
+	req, err := NewRequest("HEAD", s.URL+"/ping", nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "4", resp.Headers["content-length"])
+	assert.Empty(t, resp.Body)
+	_, err = c.Get(s.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), conns.Load())
+
+	// Test: A stale idle connection is replaced transparently
+	s.CloseClientConnections()
+	resp, err = c.Get(s.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(resp.Body))
+	assert.Equal(t, int32(2), conns.Load())
+
+	// Test: Unread bodies are not returned to the pool
+	req, err = NewRequest("GET", s.URL+"/ping", nil)
+	require.NoError(t, err)
+	_, body, err := c.Stream(req)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	_, err = c.Get(s.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), conns.Load())
+}
+
+func TestTLS(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer s.Close()
+	c := New()
+	c.TLSConfig = s.Client().Transport.(*http.Transport).TLSClientConfig
+	resp, err := c.Get(s.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "secure", string(resp.Body))
+}
+
+func TestIdempotent(t *testing.T) {
+	// Test: Safe methods, PUT and DELETE may be retried
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"} {
+		assert.True(t, Idempotent(method), method)
+	}
+
+	// Test: Other methods may not, and methods are case-sensitive
+	for _, method := range []string{"POST", "PATCH", "CONNECT", "get"} {
+		assert.False(t, Idempotent(method), method)
+	}
+}
@@ -0,0 +1,369 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+)
+
+// DefaultMinSize is the smallest body worth compressing. Below it the
+// encoding overhead outweighs the savings.
+const DefaultMinSize = 256
+
+// media types that are already compressed, matched by prefix
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+}
+
+// compressible image types that would otherwise match the image/ prefix
+var compressibleTypes = []string{
+	"image/svg+xml",
+	"image/bmp",
+	"image/x-icon",
+	"image/vnd.microsoft.icon",
+}
+
+// Compressor is middleware that compresses response bodies with the best
+// content coding the client accepts.
+type Compressor struct {
+	// Encodings in order of server preference.
+	Encodings []Encoding
+	// MinSize is the smallest body, in bytes, that gets compressed.
+	MinSize int
+}
+
+func New() *Compressor {
+	return &Compressor{
+		Encodings: []Encoding{Gzip, Deflate},
+		MinSize:   DefaultMinSize,
+	}
+}
+
+// Wrap returns a handler that runs next with a compressing response writer.
+func (c *Compressor) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		encoding, ok := negotiate(acceptEncoding, c.Encodings)
+		f := &compressFramer{
+			inner:    w,
+			encoding: encoding,
+			accepted: ok,
+			minSize:  c.MinSize,
+		}
+		next(response.NewFramedWriter(f), req)
+		if err := f.finish(); err != nil {
+			log.Printf("Error finishing compressed response: %v", err)
+		}
+	}
+}
+
+type framerMode int
+
+const (
+	modeUndecided framerMode = iota
+	modeIdentity
+	modeCompress
+)
+
+// compressFramer decides from the status and headers whether to compress,
+// holding back the headers while a body of unknown length is still too
+// small to tell. Compressed bodies are always sent chunked, since their
+// length is unknown until the encoder is closed.
+type compressFramer struct {
+	inner    response.Framer
+	encoding Encoding
+	accepted bool
+	minSize  int
+
+	mode       framerMode
+	statusCode response.StatusCode
+	headers    headers.Headers
+	chunked    bool
+	pending    []byte
+	out        bytes.Buffer
+	encoder    Encoder
+	bodyDone   bool
+}
+
+func (f *compressFramer) WriteInformational(statusCode response.StatusCode, h headers.Headers) error {
+	return f.inner.WriteInformational(statusCode, h)
+}
+
+func (f *compressFramer) WriteStatusLine(statusCode response.StatusCode) error {
+	f.statusCode = statusCode
+	return f.inner.WriteStatusLine(statusCode)
+}
+
+func (f *compressFramer) WriteHeaders(h headers.Headers) error {
+	if !eligible(f.statusCode, h) {
+		f.mode = modeIdentity
+		return f.inner.WriteHeaders(h)
+	}
+	h.AddVary("Accept-Encoding")
+	if !f.accepted {
+		f.mode = modeIdentity
+		return f.inner.WriteHeaders(h)
+	}
+	f.headers = h
+	if _, ok := h.Get("Content-Length"); ok {
+		n, err := h.ContentLength()
+		if err == nil && n < int64(f.minSize) {
+			f.mode = modeIdentity
+			return f.inner.WriteHeaders(h)
+		}
+		return f.startCompression()
+	}
+	return nil
+}
+
+func (f *compressFramer) WriteBody(p []byte) (int, error) {
+	return f.write(p, false)
+}
+
+func (f *compressFramer) WriteChunkedBody(p []byte) (int, error) {
+	return f.write(p, true)
+}
+
+func (f *compressFramer) WriteChunkedBodyDone() (int, error) {
+	f.chunked = true
+	f.bodyDone = true
+	switch f.mode {
+	case modeUndecided:
+		if err := f.flushIdentity(); err != nil {
+			return 0, err
+		}
+	case modeCompress:
+		if err := f.closeEncoder(); err != nil {
+			return 0, err
+		}
+	}
+	return f.inner.WriteChunkedBodyDone()
+}
+
+func (f *compressFramer) WriteTrailers(h headers.Headers) error {
+	return f.inner.WriteTrailers(h)
+}
+
+// Flush pushes everything written so far to the client. A response that is
+// still undecided gets compressed from here on, since a handler that flushes
+// is streaming and the final size of its body cannot be known.
+func (f *compressFramer) Flush() error {
+	if f.mode == modeUndecided && f.headers != nil && !f.bodyDone {
+		if err := f.startCompression(); err != nil {
+			return err
+		}
+		pending := f.pending
+		f.pending = nil
+		if err := f.compress(pending); err != nil {
+			return err
+		}
+	}
+	if f.mode == modeCompress && !f.bodyDone {
+		if err := f.encoder.Flush(); err != nil {
+			return err
+		}
+		if err := f.emit(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := f.inner.(response.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// ReadFrom hands bodies sent unencoded to the inner ReadFrom, so that files
+// still go out with sendfile. Bodies being compressed, or not yet known to
+// be sent as they are, are copied through write.
+func (f *compressFramer) ReadFrom(r io.Reader) (int64, error) {
+	if f.mode == modeIdentity && !f.chunked {
+		if rf, ok := f.inner.(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+	}
+	return io.Copy(framerBody{f}, r)
+}
+
+// framerBody is the body of a compressFramer as an io.Writer.
+type framerBody struct {
+	f *compressFramer
+}
+
+func (b framerBody) Write(p []byte) (int, error) {
+	return b.f.write(p, false)
+}
+
+func (f *compressFramer) Hijack() (net.Conn, *bufio.Reader, error) {
+	if h, ok := f.inner.(response.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, response.ErrNotHijackable
+}
+
+func (f *compressFramer) write(p []byte, chunked bool) (int, error) {
+	f.chunked = f.chunked || chunked
+	switch f.mode {
+	case modeIdentity:
+		if chunked {
+			return f.inner.WriteChunkedBody(p)
+		}
+		return f.inner.WriteBody(p)
+	case modeUndecided:
+		f.pending = append(f.pending, p...)
+		if len(f.pending) < f.minSize {
+			return len(p), nil
+		}
+		if err := f.startCompression(); err != nil {
+			return 0, err
+		}
+		pending := f.pending
+		f.pending = nil
+		if err := f.compress(pending); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	default:
+		if err := f.compress(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+}
+
+// finish completes the response once the handler has returned. Identity
+// bodies have no explicit end, so this is where a pending small body is
+// released or the encoder is closed and the chunked body terminated.
+func (f *compressFramer) finish() error {
+	if f.bodyDone || f.headers == nil {
+		return nil
+	}
+	f.bodyDone = true
+	switch f.mode {
+	case modeUndecided:
+		if err := f.flushIdentity(); err != nil {
+			return err
+		}
+		if !f.chunked {
+			return nil
+		}
+	case modeCompress:
+		if err := f.closeEncoder(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	if _, err := f.inner.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return f.inner.WriteTrailers(headers.NewHeaders())
+}
+
+func (f *compressFramer) startCompression() error {
+	f.mode = modeCompress
+	h := f.headers
+	h.Remove("Content-Length")
+	h.Override("Content-Encoding", f.encoding.Name)
+	h.Override("Transfer-Encoding", "chunked")
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		// the compressed bytes differ from the identity representation
+		h.Override("ETag", "W/"+etag)
+	}
+	f.encoder = f.encoding.NewWriter(&f.out)
+	return f.inner.WriteHeaders(h)
+}
+
+// flushIdentity sends a body that stayed below MinSize uncompressed.
+func (f *compressFramer) flushIdentity() error {
+	f.mode = modeIdentity
+	if err := f.inner.WriteHeaders(f.headers); err != nil {
+		return err
+	}
+	if len(f.pending) == 0 {
+		return nil
+	}
+	pending := f.pending
+	f.pending = nil
+	var err error
+	if f.chunked {
+		_, err = f.inner.WriteChunkedBody(pending)
+	} else {
+		_, err = f.inner.WriteBody(pending)
+	}
+	return err
+}
+
+func (f *compressFramer) compress(p []byte) error {
+	if _, err := f.encoder.Write(p); err != nil {
+		return err
+	}
+	return f.emit()
+}
+
+func (f *compressFramer) closeEncoder() error {
+	if err := f.encoder.Close(); err != nil {
+		return err
+	}
+	return f.emit()
+}
+
+// emit sends whatever the encoder has produced so far as one chunk.
+func (f *compressFramer) emit() error {
+	if f.out.Len() == 0 {
+		return nil
+	}
+	_, err := f.inner.WriteChunkedBody(f.out.Bytes())
+	f.out.Reset()
+	return err
+}
+
+// eligible reports whether a response could be compressed at all, which is
+// also when it varies on Accept-Encoding.
+func eligible(statusCode response.StatusCode, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 204 || statusCode == response.StatusCodePartialContent ||
+		statusCode == response.StatusCodeNotModified {
+		return false
+	}
+	if encoding, ok := h.Get("Content-Encoding"); ok && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	if cacheControl, ok := h.Get("Cache-Control"); ok && strings.Contains(strings.ToLower(cacheControl), "no-transform") {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	return compressibleType(contentType)
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>compress me, compress me, compress me</p>\n", 100)
+
+func serveCompressed(t *testing.T, handler server.Handler, acceptEncoding string) (head string, body []byte) {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	if acceptEncoding != "" {
+		req.Headers.Set("Accept-Encoding", acceptEncoding)
+	}
+	buf := &bytes.Buffer{}
+	New().Wrap(handler)(response.NewWriter(buf), req)
+	head, rest, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	return head + "\r\n", []byte(rest)
+}
+
+// dechunk decodes a chunked body and returns the data and the trailer section.
+func dechunk(t *testing.T, body []byte) ([]byte, string) {
+	t.Helper()
+	r := bufio.NewReader(bytes.NewReader(body))
+	var data []byte
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		data = append(data, chunk[:size]...)
+	}
+	trailers, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data, string(trailers)
+}
+
+func htmlHandler(body string) server.Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Content-Type", "text/html")
+		h.Override("ETag", `"v1"`)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func chunkedHandler(contentType string, parts ...string) server.Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Override("Content-Type", contentType)
+		h.Override("Transfer-Encoding", "chunked")
+		h.Override("Trailer", "X-Content-Length")
+		w.WriteHeaders(h)
+		total := 0
+		for _, part := range parts {
+			w.WriteChunkedBody([]byte(part))
+			total += len(part)
+		}
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Content-Length", strconv.Itoa(total))
+		w.WriteTrailers(trailers)
+	}
+}
+
+func TestCompressIdentityBody(t *testing.T) {
+	// Test: gzip replaces Content-Length with chunked framing
+	head, body := serveCompressed(t, htmlHandler(page), "gzip, deflate")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "etag: W/\"v1\"\r\n")
+	assert.NotContains(t, head, "content-length")
+	data, trailers := dechunk(t, body)
+	assert.Equal(t, "\r\n", trailers)
+	assert.Less(t, len(data), len(page))
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+
+	// Test: deflate chosen by quality value
+	head, body = serveCompressed(t, htmlHandler(page), "gzip;q=0.5, deflate")
+	assert.Contains(t, head, "content-encoding: deflate\r\n")
+	data, _ = dechunk(t, body)
+	zr2, err := zlib.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+
+	// Test: No Accept-Encoding still varies
+	head, body = serveCompressed(t, htmlHandler(page), "")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(page))+"\r\n")
+	assert.Equal(t, page, string(body))
+
+	// Test: Tiny bodies are not compressed
+	head, body = serveCompressed(t, htmlHandler("<p>hi</p>"), "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Equal(t, "<p>hi</p>", string(body))
+
+	// Test: Already compressed media types are skipped
+	head, body = serveCompressed(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := response.GetDefaultHeaders(len(page))
+		h.Override("Content-Type", "video/mp4")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(page))
+	}, "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+	assert.Equal(t, page, string(body))
+}
+
+func TestCompressChunkedBody(t *testing.T) {
+	// Test: Handler trailers survive compression
+	head, body := serveCompressed(t, chunkedHandler("text/plain", page[:100], page[100:]), "gzip")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "trailer: X-Content-Length\r\n")
+	data, trailers := dechunk(t, body)
+	assert.Equal(t, "x-content-length: "+strconv.Itoa(len(page))+"\r\n\r\n", trailers)
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(plain))
+
+	// Test: Small chunked bodies are sent as they were written
+	head, body = serveCompressed(t, chunkedHandler("text/plain", "small ", "body"), "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	data, trailers = dechunk(t, body)
+	assert.Equal(t, "small body", string(data))
+	assert.Equal(t, "x-content-length: 10\r\n\r\n", trailers)
+}
+
+func TestCompressFlush(t *testing.T) {
+	// Test: Flush commits to compression and pushes the data out before the handler returns
+	var flushed int
+	buf := &bytes.Buffer{}
+	handler := func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("data: hello\n\n"))
+		require.NoError(t, w.Flush())
+		flushed = buf.Len()
+	}
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	req.Headers.Set("Accept-Encoding", "gzip")
+	New().Wrap(handler)(response.NewWriter(buf), req)
+
+	head, rest, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, head+"\r\n", "content-encoding: gzip\r\n")
+	assert.Greater(t, flushed, len(head)+4)
+	data, _ := dechunk(t, []byte(rest))
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello\n\n", string(plain))
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []Encoding{Gzip, Deflate}
+	cases := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"deflate;q=1.0, gzip;q=0.9", "deflate"},
+		{"x-gzip", "gzip"},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"GZIP;Q=0.3", "gzip"},
+		{"gzip;q=abc", ""},
+		{`deflate;x="a, gzip";q=0.9, gzip;q=0.8`, "deflate"},
+	}
+	for _, c := range cases {
+		encoding, ok := negotiate(c.acceptEncoding, offers)
+		if c.want == "" {
+			assert.False(t, ok, c.acceptEncoding)
+			continue
+		}
+		assert.True(t, ok, c.acceptEncoding)
+		assert.Equal(t, c.want, encoding.Name, c.acceptEncoding)
+	}
+}
+
+// readFromFramer records the readers its ReadFrom is given.
+type readFromFramer struct {
+	*response.Writer
+	readers []io.Reader
+}
+
+func (f *readFromFramer) ReadFrom(r io.Reader) (int64, error) {
+	f.readers = append(f.readers, r)
+	return f.Writer.ReadFrom(r)
+}
+
+func TestCompressReadFrom(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "body")
+	require.NoError(t, err)
+	_, err = file.WriteString(page)
+	require.NoError(t, err)
+
+	serve := func(contentType, acceptEncoding string) (*readFromFramer, io.Reader, []byte) {
+		_, err := file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		body := io.LimitReader(file, int64(len(page)))
+		req := &request.Request{
+			RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+			Headers:     headers.NewHeaders(),
+		}
+		req.Headers.Set("Accept-Encoding", acceptEncoding)
+		buf := &bytes.Buffer{}
+		inner := &readFromFramer{Writer: response.NewWriter(buf)}
+		New().Wrap(func(w *response.Writer, _ *request.Request) {
+			w.WriteStatusLine(response.StatusCodeSuccess)
+			h := response.GetDefaultHeaders(len(page))
+			h.Override("Content-Type", contentType)
+			w.WriteHeaders(h)
+			_, err := w.ReadFrom(body)
+			require.NoError(t, err)
+		})(response.NewFramedWriter(inner), req)
+		_, rest, _ := strings.Cut(buf.String(), "\r\n\r\n")
+		return inner, body, []byte(rest)
+	}
+
+	// Test: Files of types not compressed reach the inner ReadFrom
+	inner, body, out := serve("image/png", "gzip")
+	assert.Equal(t, []io.Reader{body}, inner.readers)
+	assert.Equal(t, page, string(out))
+
+	// Test: So do files for clients that accept no coding
+	inner, body, out = serve("text/html", "identity")
+	assert.Equal(t, []io.Reader{body}, inner.readers)
+	assert.Equal(t, page, string(out))
+
+	// Test: Compressed files are copied through the encoder
+	inner, _, out = serve("text/html", "gzip")
+	assert.Empty(t, inner.readers)
+	data, _ := dechunk(t, out)
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+}
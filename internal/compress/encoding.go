@@ -0,0 +1,67 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// Encoder is a streaming compressor for one content coding.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Encoding is a content coding the middleware can apply. Additional codings
+// such as brotli ("br") plug in by adding an Encoding with a constructor for
+// their Encoder.
+type Encoding struct {
+	Name      string
+	NewWriter func(w io.Writer) Encoder
+}
+
+var Gzip = Encoding{
+	Name:      "gzip",
+	NewWriter: func(w io.Writer) Encoder { return gzip.NewWriter(w) },
+}
+
+// Deflate is the "deflate" content coding, which is the zlib format rather
+// than a raw deflate stream.
+var Deflate = Encoding{
+	Name:      "deflate",
+	NewWriter: func(w io.Writer) Encoder { return zlib.NewWriter(w) },
+}
+
+// negotiate picks the encoding with the highest quality value, breaking ties
+// by the order of the offered encodings. It returns false when the client
+// should get the identity coding.
+func negotiate(acceptEncoding string, offers []Encoding) (Encoding, bool) {
+	accepted := map[string]float64{}
+	for _, r := range headers.ParseAccept(acceptEncoding) {
+		name := r.Value
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		if _, ok := accepted[name]; !ok {
+			accepted[name] = r.Q
+		}
+	}
+	var best Encoding
+	bestQ := 0.0
+	for _, offer := range offers {
+		q, ok := accepted[offer.Name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if !ok {
+			continue
+		}
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+	return best, bestQ > 0
+}
@@ -0,0 +1,365 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+)
+
+const sniffLen = 512
+
+// FileServer serves files from a directory on disk. Request paths are
+// resolved inside Root and can never escape it, even through symlinks.
+type FileServer struct {
+	// Root is the directory files are served from.
+	Root string
+	// Prefix is stripped from the request path before it is resolved.
+	Prefix string
+	// Index is served in place of a directory when it exists.
+	Index string
+	// Listing enables HTML listings for directories without an index.
+	Listing bool
+}
+
+func New(root, prefix string) *FileServer {
+	return &FileServer{
+		Root:   root,
+		Prefix: prefix,
+		Index:  "index.html",
+	}
+}
+
+// Serve resolves the request path under Root and serves the file or
+// directory it names. It has the shape of a server.Handler.
+func (s *FileServer) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		h := response.ErrorHeaders(response.StatusCodeMethodNotAllowed)
+		h.Override("Allow", "GET, HEAD")
+		response.WriteError(w, response.StatusCodeMethodNotAllowed, h)
+		return
+	}
+	urlPath := server.RequestPath(req.RequestLine.RequestTarget)
+	name, ok := s.resolve(urlPath)
+	if !ok {
+		response.WriteError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+	s.serve(w, req, urlPath, name)
+}
+
+// ServeFile serves the named file, relative to Root, regardless of the
+// request path.
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	cleaned := path.Clean("/" + filepath.ToSlash(name))
+	s.serve(w, req, cleaned, strings.TrimPrefix(cleaned, "/"))
+}
+
+// resolve maps a URL path to a slash-separated name relative to Root.
+func (s *FileServer) resolve(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, s.Prefix) {
+		return "", false
+	}
+	p, err := url.PathUnescape(strings.TrimPrefix(urlPath, s.Prefix))
+	if err != nil || strings.ContainsAny(p, "\x00\\") {
+		return "", false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, true
+}
+
+func (s *FileServer) serve(w *response.Writer, req *request.Request, urlPath, name string) {
+	root, err := os.OpenRoot(s.Root)
+	if err != nil {
+		log.Printf("Error opening file server root %s: %v", s.Root, err)
+		response.WriteError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+	defer root.Close()
+
+	f, err := root.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			h := response.ErrorHeaders(response.StatusCodeMovedPermanently)
+			h.Override("Location", urlPath+"/")
+			response.WriteError(w, response.StatusCodeMovedPermanently, h)
+			return
+		}
+		if s.Index != "" {
+			index, err := root.Open(path.Join(name, s.Index))
+			if err == nil {
+				defer index.Close()
+				indexInfo, err := index.Stat()
+				if err == nil && !indexInfo.IsDir() {
+					serveContent(w, req, index, indexInfo)
+					return
+				}
+			}
+		}
+		if !s.Listing {
+			response.WriteError(w, response.StatusCodeForbidden, nil)
+			return
+		}
+		serveListing(w, req, urlPath, f)
+		return
+	}
+	serveContent(w, req, f, info)
+}
+
+// serveContent writes the file honouring conditional and range headers.
+func serveContent(w *response.Writer, req *request.Request, f *os.File, info fs.FileInfo) {
+	size := info.Size()
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), size)
+
+	contentType, err := detectContentType(f, info.Name())
+	if err != nil {
+		response.WriteError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+
+	if notModified(req.Headers, etag, modTime) {
+		w.WriteStatusLine(response.StatusCodeNotModified)
+		h := headers.NewHeaders()
+		h.Set("ETag", etag)
+		h.Set("Last-Modified", modTime.Format(headers.TimeFormat))
+		w.WriteHeaders(h)
+		return
+	}
+
+	var ranges []byteRange
+	if rangeHeader, ok := req.Headers.Get("Range"); ok && rangeApplies(req.Headers, etag, modTime) {
+		ranges, err = parseRange(rangeHeader, size)
+		if errors.Is(err, errUnsatisfiable) {
+			h := response.ErrorHeaders(response.StatusCodeRangeNotSatisfiable)
+			h.Override("Content-Range", fmt.Sprintf("bytes */%d", size))
+			response.WriteError(w, response.StatusCodeRangeNotSatisfiable, h)
+			return
+		}
+	}
+
+	var h headers.Headers
+	var parts []string
+	switch len(ranges) {
+	case 0:
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h = response.GetDefaultHeaders(int(size))
+		h.Override("Content-Type", contentType)
+		ranges = []byteRange{{start: 0, length: size}}
+	case 1:
+		w.WriteStatusLine(response.StatusCodePartialContent)
+		h = response.GetDefaultHeaders(int(ranges[0].length))
+		h.Override("Content-Type", contentType)
+		h.Override("Content-Range", ranges[0].contentRange(size))
+	default:
+		w.WriteStatusLine(response.StatusCodePartialContent)
+		boundary := newBoundary()
+		var length int64
+		parts, length = multipartHeaders(ranges, boundary, contentType, size)
+		h = response.GetDefaultHeaders(int(length))
+		h.Override("Content-Type", "multipart/byteranges; boundary="+boundary)
+	}
+	h.Override("Accept-Ranges", "bytes")
+	h.Override("ETag", etag)
+	h.Override("Last-Modified", modTime.Format(headers.TimeFormat))
+	if err := w.WriteHeaders(h); err != nil || req.RequestLine.Method == "HEAD" {
+		return
+	}
+	for i, r := range ranges {
+		if parts != nil {
+			if _, err := w.WriteBody([]byte(parts[i])); err != nil {
+				return
+			}
+		}
+		if err := copyRange(w, f, r); err != nil {
+			log.Printf("Error writing %s: %v", info.Name(), err)
+			return
+		}
+	}
+	if parts != nil {
+		w.WriteBody([]byte(parts[len(ranges)]))
+	}
+}
+
+// multipartHeaders returns the delimiter and part headers preceding each
+// range, the closing delimiter as the final element, and the total length
+// of the multipart/byteranges body.
+func multipartHeaders(ranges []byteRange, boundary, contentType string, size int64) ([]string, int64) {
+	parts := make([]string, 0, len(ranges)+1)
+	var length int64
+	for _, r := range ranges {
+		part := fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(size))
+		parts = append(parts, part)
+		length += int64(len(part)) + r.length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	parts = append(parts, closing)
+	length += int64(len(closing))
+	return parts, length
+}
+
+func copyRange(w *response.Writer, f *os.File, r byteRange) error {
+	if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := w.ReadFrom(io.LimitReader(f, r.length))
+	if err != nil {
+		return err
+	}
+	if n < r.length {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// detectContentType infers the media type from the file extension, falling
+// back to sniffing the first bytes of the file.
+func detectContentType(f *os.File, name string) (string, error) {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when no entity
+// tags were sent.
+func notModified(h headers.Headers, etag string, modTime time.Time) bool {
+	if inm, ok := h.Get("If-None-Match"); ok {
+		return etagMatches(inm, etag, false)
+	}
+	if t, err := h.Time("If-Modified-Since"); err == nil {
+		return !modTime.After(t)
+	}
+	return false
+}
+
+// rangeApplies evaluates If-Range: the Range header is only honoured when
+// the validator still matches the current representation.
+func rangeApplies(h headers.Headers, etag string, modTime time.Time) bool {
+	ifRange, ok := h.Get("If-Range")
+	if !ok {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagMatches(ifRange, etag, true)
+	}
+	t, err := headers.ParseTime(ifRange)
+	return err == nil && t.Equal(modTime)
+}
+
+// etagMatches reports whether etag is in the comma separated list. Weak
+// comparison ignores the W/ prefix; strong comparison never matches weak tags.
+func etagMatches(list, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" && !strong {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func serveListing(w *response.Writer, req *request.Request, urlPath string, dir *os.File) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		response.WriteError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&b, "<html>\n<head>\n<title>Index of %s</title>\n</head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	body := []byte(b.String())
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		response.WriteError(w, response.StatusCodeNotFound, nil)
+	case errors.Is(err, fs.ErrPermission):
+		response.WriteError(w, response.StatusCodeForbidden, nil)
+	default:
+		// os.Root reports paths that escape the root, such as symlinks
+		// pointing outside of it, as plain errors
+		response.WriteError(w, response.StatusCodeNotFound, nil)
+	}
+}
+
+func newBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%x", b[:])
+}
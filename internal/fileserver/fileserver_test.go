@@ -0,0 +1,242 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveRequest(s *FileServer, method, target string, h map[string]string) string {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	buf := &bytes.Buffer{}
+	s.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func responseBody(raw string) string {
+	_, body, _ := strings.Cut(raw, "\r\n\r\n")
+	return body
+}
+
+func newTestRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("0123456789abcdef"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "blob"), []byte("<html><body>sniffed</body></html>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>index</h1>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a <b>.txt"), []byte("a"), 0o644))
+	return root
+}
+
+func TestServeFile(t *testing.T) {
+	s := New(newTestRoot(t), "/static/")
+
+	// Test: Whole file with type from extension
+	out := serveRequest(s, "GET", "/static/hello.txt", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "content-length: 16\r\n")
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+	assert.Equal(t, "0123456789abcdef", responseBody(out))
+
+	// Test: Content type sniffed when there is no extension
+	out = serveRequest(s, "GET", "/static/blob", nil)
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+
+	// Test: Query string is ignored
+	out = serveRequest(s, "GET", "/static/hello.txt?v=1", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: HEAD sends headers only
+	out = serveRequest(s, "HEAD", "/static/hello.txt", nil)
+	assert.Contains(t, out, "content-length: 16\r\n")
+	assert.Equal(t, "", responseBody(out))
+
+	// Test: Missing file
+	out = serveRequest(s, "GET", "/static/nope.txt", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Unsupported method
+	out = serveRequest(s, "POST", "/static/hello.txt", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD\r\n")
+}
+
+func TestPathTraversal(t *testing.T) {
+	root := newTestRoot(t)
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+	s := New(root, "/static/")
+
+	targets := []string{
+		"/static/../secret.txt",
+		"/static/%2e%2e/secret.txt",
+		"/static/docs/../../secret.txt",
+		"/static/..%2fsecret.txt",
+		"/static/..%5csecret.txt",
+		"/static/hello.txt%00",
+		"/static/link.txt",
+		"/secret.txt",
+	}
+	for _, target := range targets {
+		out := serveRequest(s, "GET", target, nil)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), target)
+		assert.NotEqual(t, "secret", responseBody(out), target)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	s := New(newTestRoot(t), "/static/")
+
+	// Test: Directory without trailing slash redirects
+	out := serveRequest(s, "GET", "/static/site", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /static/site/\r\n")
+
+	// Test: Index file is served for the directory
+	out = serveRequest(s, "GET", "/static/site/", nil)
+	assert.Equal(t, "<h1>index</h1>", responseBody(out))
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+
+	// Test: Listing disabled
+	out = serveRequest(s, "GET", "/static/docs/", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Listing enabled escapes names
+	s.Listing = true
+	out = serveRequest(s, "GET", "/static/docs/", nil)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, responseBody(out), `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`)
+
+	// Test: Listing of the root
+	out = serveRequest(s, "GET", "/static/", nil)
+	assert.Contains(t, responseBody(out), `<a href="site/">site/</a>`)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	root := newTestRoot(t)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "hello.txt"), modTime, modTime))
+	s := New(root, "/static/")
+
+	out := serveRequest(s, "GET", "/static/hello.txt", nil)
+	assert.Contains(t, out, "last-modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	etag := ""
+	for _, line := range strings.Split(out, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "etag: "); ok {
+			etag = v
+		}
+	}
+	require.NotEmpty(t, etag)
+
+	// Test: Matching ETag
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n", strings.SplitAfter(out, "\r\n")[0])
+	assert.Equal(t, "", responseBody(out))
+	assert.NotContains(t, out, "content-length")
+
+	// Test: Weak comparison for If-None-Match
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"If-None-Match": "W/" + etag})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Non-matching ETag wins over a matching date
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{
+		"If-None-Match":     `"other"`,
+		"If-Modified-Since": "Wed, 01 May 2024 12:00:00 GMT",
+	})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Not modified since
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"If-Modified-Since": "Wed, 01 May 2024 12:00:00 GMT"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Obsolete RFC 850 date
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"If-Modified-Since": "Thursday, 02-May-24 00:00:00 GMT"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Modified since
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"If-Modified-Since": "Tue, 30 Apr 2024 12:00:00 GMT"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: If-Range with a stale validator serves the whole file
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: If-Range with the current validator honours the range
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=0-3", "If-Range": etag})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=0-3", "If-Range": "Wed, 01 May 2024 12:00:00 GMT"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+}
+
+func TestRangeRequests(t *testing.T) {
+	s := New(newTestRoot(t), "/static/")
+
+	// Test: Single range
+	out := serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=2-5"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 2-5/16\r\n")
+	assert.Contains(t, out, "content-length: 4\r\n")
+	assert.Equal(t, "2345", responseBody(out))
+
+	// Test: Suffix and open ended ranges
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=-3"})
+	assert.Equal(t, "def", responseBody(out))
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=14-"})
+	assert.Equal(t, "ef", responseBody(out))
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=10-100"})
+	assert.Contains(t, out, "content-range: bytes 10-15/16\r\n")
+
+	// Test: Multiple ranges
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=0-1, 10-11"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	var boundary string
+	for _, line := range strings.Split(out, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "content-type: multipart/byteranges; boundary="); ok {
+			boundary = v
+		}
+	}
+	require.NotEmpty(t, boundary)
+	expected := "\r\n--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Range: bytes 0-1/16\r\n\r\n" +
+		"01" +
+		"\r\n--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Range: bytes 10-11/16\r\n\r\n" +
+		"ab" +
+		"\r\n--" + boundary + "--\r\n"
+	assert.Equal(t, expected, responseBody(out))
+	assert.Contains(t, out, "content-length: "+strconv.Itoa(len(expected))+"\r\n")
+
+	// Test: Unsatisfiable range
+	out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": "bytes=100-200"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */16\r\n")
+
+	// Test: Malformed ranges are ignored
+	for _, value := range []string{"bytes=5-2", "items=0-1", "bytes=a-b", "bytes"} {
+		out = serveRequest(s, "GET", "/static/hello.txt", map[string]string{"Range": value})
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), value)
+		assert.Equal(t, "0123456789abcdef", responseBody(out), value)
+	}
+}
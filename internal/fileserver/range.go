@@ -0,0 +1,99 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps how many ranges a single request may ask for, so a client
+// cannot make the server emit a huge multipart body from a small file.
+const maxRanges = 64
+
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// byteRange is an inclusive-exclusive span [start, start+length) of a file.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value against a file of the given size.
+// A nil slice with a nil error means the header should be ignored and the
+// whole file served, as RFC 9110 requires for syntactically invalid ranges.
+// errUnsatisfiable is returned when the header is valid but none of the
+// ranges overlap the file.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+	ranges := make([]byteRange, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+		if first == "" {
+			// suffix-range: the final N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		// overlapping ranges that add up to more than the file are cheaper
+		// to answer with the whole file
+		return nil, nil
+	}
+	return ranges, nil
+}
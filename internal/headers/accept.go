@@ -0,0 +1,214 @@
+package headers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable is returned by Negotiate when the client accepts none of
+// the offers; the handler should answer 406 Not Acceptable.
+var ErrNotAcceptable = errors.New("no acceptable offer")
+
+// AcceptRange is one member of an Accept-style header, RFC 9110 section
+// 12.5: a media range, language range, charset or coding, with its
+// quality value.
+type AcceptRange struct {
+	// Value is lower-cased, such as "text/*", "en-gb" or "utf-8".
+	Value string
+	// Params holds the media type parameters of an Accept member, with
+	// lower-cased names and unquoted values. Parameters after q are
+	// extensions and are left out.
+	Params map[string]string
+	Q      float64
+}
+
+// ParseAccept parses the value of an Accept, Accept-Language,
+// Accept-Charset or Accept-Encoding header. Members are sorted by quality,
+// highest first, keeping the order of the header among equals. A member
+// with an invalid quality value gets 0 and so is refused.
+func ParseAccept(value string) []AcceptRange {
+	var ranges []AcceptRange
+	for _, member := range splitQuoted(value, ',') {
+		r, ok := parseAcceptMember(member)
+		if ok {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Q > ranges[j].Q })
+	return ranges
+}
+
+func parseAcceptMember(member string) (AcceptRange, bool) {
+	parts := splitQuoted(member, ';')
+	if len(parts) == 0 {
+		return AcceptRange{}, false
+	}
+	r := AcceptRange{Value: strings.ToLower(strings.TrimSpace(parts[0])), Q: 1}
+	if r.Value == "" {
+		return AcceptRange{}, false
+	}
+	for _, param := range parts[1:] {
+		k, v, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if k == "q" {
+			q, err := strconv.ParseFloat(v, 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			r.Q = q
+			break
+		}
+		if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			v = strings.ReplaceAll(v[1:len(v)-1], `\`, "")
+		}
+		if r.Params == nil {
+			r.Params = map[string]string{}
+		}
+		r.Params[k] = v
+	}
+	return r, true
+}
+
+// splitQuoted splits s at sep outside quoted strings, dropping empty parts.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			switch {
+			case escaped:
+				escaped = false
+				continue
+			case quoted && c == '\\':
+				escaped = true
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case c != sep || quoted:
+				continue
+			}
+		}
+		if part := strings.TrimSpace(s[start:i]); part != "" {
+			parts = append(parts, part)
+		}
+		start = i + 1
+	}
+	return parts
+}
+
+// Negotiate picks the offer the client prefers according to field, one of
+// Accept, Accept-Language, Accept-Charset or Accept-Encoding, in the
+// request headers req. Each offer gets the quality of the most specific
+// range matching it, and ties go to the earlier offer, so offers are
+// listed in the order the server prefers them. Without the field every
+// offer is acceptable and the first is returned. Negotiate adds field to
+// the Vary header of the response headers resp, even when it returns
+// ErrNotAcceptable.
+func Negotiate(req, resp Headers, field string, offers ...string) (string, error) {
+	resp.AddVary(field)
+	value, ok := req.Get(field)
+	if !ok {
+		if len(offers) == 0 {
+			return "", ErrNotAcceptable
+		}
+		return offers[0], nil
+	}
+	match := matchToken
+	switch strings.ToLower(field) {
+	case "accept":
+		match = matchMediaType
+	case "accept-language":
+		match = matchLanguage
+	}
+	ranges := ParseAccept(value)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		o, ok := parseAcceptMember(offer)
+		if !ok {
+			continue
+		}
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			if s, ok := match(r, o); ok && s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if bestQ == 0 {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+// The match functions report whether range r covers offer o and, if so,
+// how specific r is, so that the most specific match decides the quality.
+
+func matchToken(r, o AcceptRange) (int, bool) {
+	switch r.Value {
+	case "*":
+		return 0, true
+	case o.Value:
+		return 1, true
+	}
+	return 0, false
+}
+
+// matchMediaType matches "*/*", "type/*" and "type/subtype" ranges. A range
+// with parameters only matches offers that have all of them.
+func matchMediaType(r, o AcceptRange) (int, bool) {
+	rType, rSub, _ := strings.Cut(r.Value, "/")
+	oType, oSub, _ := strings.Cut(o.Value, "/")
+	switch {
+	case r.Value == "*/*" || r.Value == "*":
+		return 0, true
+	case rType != oType:
+		return 0, false
+	case rSub == "*":
+		return 1, true
+	case rSub != oSub:
+		return 0, false
+	}
+	for k, v := range r.Params {
+		if ov, ok := o.Params[k]; !ok || !strings.EqualFold(ov, v) {
+			return 0, false
+		}
+	}
+	return 2 + len(r.Params), true
+}
+
+// matchLanguage is the basic filtering of RFC 4647 section 3.3.1: a range
+// matches a tag equal to it or starting with it followed by a hyphen.
+func matchLanguage(r, o AcceptRange) (int, bool) {
+	if r.Value == "*" {
+		return 0, true
+	}
+	if o.Value == r.Value || strings.HasPrefix(o.Value, r.Value+"-") {
+		return len(r.Value), true
+	}
+	return 0, false
+}
+
+// AddVary adds a field name to the Vary header unless it is already listed.
+func (h Headers) AddVary(field string) {
+	if vary, ok := h.Get("Vary"); ok {
+		for _, existing := range strings.Split(vary, ",") {
+			existing = strings.TrimSpace(existing)
+			if existing == "*" || strings.EqualFold(existing, field) {
+				return
+			}
+		}
+	}
+	h.Set("Vary", field)
+}
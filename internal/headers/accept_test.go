@@ -0,0 +1,109 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	// Test: Members are sorted by quality, keeping header order among equals
+	ranges := ParseAccept(`text/*;q=0.3, Text/HTML;Level=1, text/html;q=0.7, */*;q=0.5, application/json`)
+	require.Len(t, ranges, 5)
+	assert.Equal(t, AcceptRange{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1}, ranges[0])
+	assert.Equal(t, "application/json", ranges[1].Value)
+	assert.Equal(t, 0.7, ranges[2].Q)
+	assert.Equal(t, "*/*", ranges[3].Value)
+	assert.Equal(t, "text/*", ranges[4].Value)
+
+	// Test: Quoted parameters may contain commas and semicolons
+	ranges = ParseAccept(`text/plain; format="a,b;c"; q=0.2; ext=1, text/html`)
+	require.Len(t, ranges, 2)
+	assert.Equal(t, map[string]string{"format": "a,b;c"}, ranges[1].Params)
+	assert.Equal(t, 0.2, ranges[1].Q)
+
+	// Test: Invalid quality values refuse the member and empty members are skipped
+	ranges = ParseAccept(" , en;q=2, fr;q=abc,, de ")
+	require.Len(t, ranges, 3)
+	assert.Equal(t, "de", ranges[0].Value)
+	assert.Equal(t, 0.0, ranges[1].Q)
+	assert.Equal(t, 0.0, ranges[2].Q)
+
+	// Test: An empty header has no members
+	assert.Empty(t, ParseAccept(""))
+}
+
+func TestNegotiate(t *testing.T) {
+	negotiate := func(field, value string, offers ...string) (string, Headers, error) {
+		req := NewHeaders()
+		if value != "" {
+			req.Set(field, value)
+		}
+		resp := NewHeaders()
+		offer, err := Negotiate(req, resp, field, offers...)
+		return offer, resp, err
+	}
+
+	// Test: Without the field the first offer wins
+	offer, resp, err := negotiate("Accept", "", "text/html", "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", offer)
+	assert.Equal(t, "Accept", resp["vary"])
+
+	// Test: The highest quality wins, ties go to the earlier offer
+	offer, _, err = negotiate("Accept", "text/plain, text/html;q=0.9", "text/html", "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", offer)
+	offer, _, err = negotiate("Accept", "text/*", "text/html", "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", offer)
+
+	// Test: The most specific media range decides the quality
+	offer, _, err = negotiate("Accept", "text/*;q=0.3, text/html;q=0, */*;q=0.5", "text/html", "text/plain", "application/json")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", offer)
+	offer, _, err = negotiate("Accept", "text/html;level=1, text/html;q=0.1", "text/html", "text/html; level=1")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; level=1", offer)
+
+	// Test: Nothing acceptable is an error, and still varies
+	_, resp, err = negotiate("Accept", "image/png", "text/html", "text/plain")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+	assert.Equal(t, "Accept", resp["vary"])
+	_, _, err = negotiate("Accept", "*/*;q=0", "text/html")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: Language ranges match tags by prefix
+	offer, _, err = negotiate("Accept-Language", "fr;q=0.5, en", "de", "fr-CA", "en-GB")
+	require.NoError(t, err)
+	assert.Equal(t, "en-GB", offer)
+	offer, _, err = negotiate("Accept-Language", "en-us, *;q=0.1", "en", "de")
+	require.NoError(t, err)
+	assert.Equal(t, "en", offer)
+	_, _, err = negotiate("Accept-Language", "en-gb", "en")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+
+	// Test: Charsets match exactly or by wildcard, ignoring case
+	offer, _, err = negotiate("Accept-Charset", "iso-8859-1;q=0.5, UTF-8", "iso-8859-1", "utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", offer)
+	offer, _, err = negotiate("Accept-Charset", "utf-8;q=0, *", "utf-8", "utf-16")
+	require.NoError(t, err)
+	assert.Equal(t, "utf-16", offer)
+}
+
+func TestAddVary(t *testing.T) {
+	// Test: Fields are added once, whatever their case
+	h := NewHeaders()
+	h.AddVary("Accept")
+	h.AddVary("Accept-Language")
+	h.AddVary("accept")
+	assert.Equal(t, "Accept, Accept-Language", h["vary"])
+
+	// Test: Vary: * already covers every field
+	h = NewHeaders()
+	h.Set("Vary", "*")
+	h.AddVary("Accept")
+	assert.Equal(t, "*", h["vary"])
+}
@@ -0,0 +1,68 @@
+package headers
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Cookie is a name-value pair sent in a Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// ParseCookie parses the value of a Cookie header, RFC 6265 section 4.2.1,
+// whose pairs are separated by semicolons. Commas separate them too, as no
+// cookie contains one and Set joins repeated Cookie fields with them.
+// Pairs with an invalid name or value are left out, and double quotes
+// around a value are removed.
+func ParseCookie(value string) []Cookie {
+	var cookies []Cookie
+	for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !ValidCookieName(name) || !ValidCookieValue(value) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ValidCookieName reports whether name is a token, RFC 9110 section 5.6.2.
+func ValidCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidCookieValue reports whether value is made of cookie-octets,
+// possibly wrapped in double quotes: printable US-ASCII other than
+// whitespace, double quotes, commas, semicolons and backslashes.
+func ValidCookieValue(value string) bool {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' ||
+		c >= 'a' && c <= 'z' ||
+		c >= '0' && c <= '9' ||
+		bytes.IndexByte(tokenChars, c) >= 0
+}
@@ -0,0 +1,55 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCookie(t *testing.T) {
+	// Test: Pairs are split on semicolons, and on commas left by Set
+	h := NewHeaders()
+	h.Set("Cookie", `session=abc123; theme="dark"`)
+	h.Set("Cookie", "lang=en")
+	cookie, _ := h.Get("Cookie")
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "lang", Value: "en"},
+	}, ParseCookie(cookie))
+
+	// Test: Malformed pairs are left out
+	assert.Equal(t, []Cookie{{Name: "ok", Value: ""}, {Name: "b", Value: "2"}},
+		ParseCookie(`ok=; noequals; bad name=1; a=x y; c=back\slash; "q"=1; b=2`))
+	assert.Empty(t, ParseCookie(""))
+}
+
+func TestCookieValidation(t *testing.T) {
+	// Test: Names are tokens
+	for _, name := range []string{"id", "__Host-id", "a.b", "x!#$%&'*+-.^_`|~"} {
+		assert.True(t, ValidCookieName(name), name)
+	}
+	for _, name := range []string{"", "a b", "a=b", "a;b", "a,b", "é", "(a)", "a\tb"} {
+		assert.False(t, ValidCookieName(name), name)
+	}
+
+	// Test: Values are cookie-octets, optionally quoted
+	for _, value := range []string{"", "abc", `"abc"`, `""`, "a/b:c?d=e", "!#$%&'()*+-./:<=>?@[]^_`{|}~"} {
+		assert.True(t, ValidCookieValue(value), value)
+	}
+	for _, value := range []string{"a b", "a,b", "a;b", `a"b`, `a\b`, "\x7f", "é", `"a`, "a\tb"} {
+		assert.False(t, ValidCookieValue(value), value)
+	}
+}
+
+func TestSetCookieValues(t *testing.T) {
+	// Test: Set-Cookie values are kept apart, others are combined
+	h := NewHeaders()
+	h.Set("Set-Cookie", "a=1; Path=/")
+	h.Set("set-cookie", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	h.Set("Vary", "Accept")
+	h.Set("Vary", "Cookie")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"}, h.Values("Set-Cookie"))
+	assert.Equal(t, []string{"Accept, Cookie"}, h.Values("vary"))
+	assert.Nil(t, h.Values("Missing"))
+}
@@ -28,6 +28,9 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	}
 
 	parts := bytes.SplitN(data[:idx], []byte(":"), 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("malformed header line: %s", data[:idx])
+	}
 	key := strings.ToLower(string(parts[0]))
 
 	if key != strings.TrimRight(key, " ") {
@@ -39,6 +42,9 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	if !validTokens([]byte(key)) {
 		return 0, false, fmt.Errorf("invalid header token found: %s", key)
 	}
+	if !ValidValue(string(value)) {
+		return 0, false, fmt.Errorf("invalid value for header: %s", key)
+	}
 	h.Set(key, string(value))
 	return idx + 2, false, nil
 }
@@ -49,18 +55,39 @@ func (h Headers) Get(key string) (string, bool) {
 	return v, ok
 }
 
+// Set adds value to the field, after a comma if it already has one.
+// Set-Cookie values cannot be combined that way, RFC 9110 section 5.3,
+// so they are kept on separate lines instead; use Values to list them.
 func (h Headers) Set(key, value string) {
 	key = strings.ToLower(key)
 	v, ok := h[key]
 	if ok {
+		sep := ", "
+		if key == "set-cookie" {
+			sep = "\n"
+		}
 		value = strings.Join([]string{
 			v,
 			value,
-		}, ", ")
+		}, sep)
 	}
 	h[key] = value
 }
 
+// Values returns the values of the field to send as separate field lines:
+// one per Set-Cookie, the combined value for any other field.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	v, ok := h[key]
+	if !ok {
+		return nil
+	}
+	if key == "set-cookie" {
+		return strings.Split(v, "\n")
+	}
+	return []string{v}
+}
+
 func (h Headers) Override(key, value string) {
 	key = strings.ToLower(key)
 	h[key] = value
@@ -86,3 +113,10 @@ func validTokens(data []byte) bool {
 	}
 	return true
 }
+
+// ValidValue reports whether value can be sent as a field value. CR, LF
+// and NUL are refused, RFC 9110 section 5.5, as they would end the field
+// line early and let the rest pass for another field.
+func ValidValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
@@ -64,4 +64,22 @@ func TestHeadersParse(t *testing.T) {
 	require.Error(t, err)
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
+
+	// Test: Missing colon
+	headers = NewHeaders()
+	data = []byte("Host localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Bare LF, CR or NUL in a value
+	for _, line := range []string{"X-A: v\nTransfer-Encoding: chunked\r\n", "X-A: v\rX-B: w\r\n", "X-A: v\x00w\r\n"} {
+		headers = NewHeaders()
+		n, done, err = headers.Parse([]byte(line + "\r\n"))
+		require.Error(t, err, line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+		assert.Empty(t, headers)
+	}
 }
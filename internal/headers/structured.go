@@ -0,0 +1,738 @@
+package headers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Structured Field Values, RFC 9651 (which obsoletes RFC 8941 and adds
+// dates and display strings), give newer fields such as Priority,
+// Cache-Status and Signature-Input a common syntax. A field is an Item, a
+// List or a Dictionary. The bare value of an Item is one of:
+//
+//	int64          Integer
+//	float64        Decimal
+//	string         String
+//	Token          Token
+//	[]byte         Byte Sequence
+//	bool           Boolean
+//	time.Time      Date
+//	DisplayString  Display String
+//
+// An int is accepted as an Integer when serializing.
+
+// ErrNoField is returned when a field parsed as an Item is absent.
+var ErrNoField = errors.New("field not present")
+
+// Token is a short textual word such as an enumerated value.
+type Token string
+
+// DisplayString is Unicode text meant for people to read.
+type DisplayString string
+
+// Param is a parameter of an Item or InnerList.
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params are ordered parameters with unique keys.
+type Params []Param
+
+// Get returns the value of the parameter with the given key.
+func (p Params) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the value of an existing key in place, as RFC 9651 requires
+// of duplicate keys, or appends it.
+func (p Params) set(key string, value any) Params {
+	for i := range p {
+		if p[i].Key == key {
+			p[i].Value = value
+			return p
+		}
+	}
+	return append(p, Param{Key: key, Value: value})
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface {
+	member()
+}
+
+type Item struct {
+	Value  any
+	Params Params
+}
+
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) member()      {}
+func (InnerList) member() {}
+
+type List []Member
+
+// DictMember is a member of a Dictionary.
+type DictMember struct {
+	Key    string
+	Member Member
+}
+
+// Dictionary is an ordered map with unique keys.
+type Dictionary []DictMember
+
+// Get returns the member with the given key.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Member, true
+		}
+	}
+	return nil, false
+}
+
+// Item parses the field as a structured Item. An absent field is
+// ErrNoField.
+func (h Headers) Item(key string) (Item, error) {
+	v, ok := h.Get(key)
+	if !ok {
+		return Item{}, ErrNoField
+	}
+	return ParseItem(v)
+}
+
+// List parses the field as a structured List. An absent field is an empty
+// List.
+func (h Headers) List(key string) (List, error) {
+	v, _ := h.Get(key)
+	return ParseList(v)
+}
+
+// Dictionary parses the field as a structured Dictionary. An absent field
+// is an empty Dictionary.
+func (h Headers) Dictionary(key string) (Dictionary, error) {
+	v, _ := h.Get(key)
+	return ParseDictionary(v)
+}
+
+// SetItem replaces the field with the serialized item.
+func (h Headers) SetItem(key string, item Item) error {
+	v, err := FormatItem(item)
+	if err != nil {
+		return err
+	}
+	h.Override(key, v)
+	return nil
+}
+
+// SetList replaces the field with the serialized list. An empty list
+// removes the field, as it cannot be sent.
+func (h Headers) SetList(key string, l List) error {
+	v, err := FormatList(l)
+	if err != nil {
+		return err
+	}
+	if v == "" {
+		h.Remove(key)
+		return nil
+	}
+	h.Override(key, v)
+	return nil
+}
+
+// SetDictionary replaces the field with the serialized dictionary. An
+// empty dictionary removes the field, as it cannot be sent.
+func (h Headers) SetDictionary(key string, d Dictionary) error {
+	v, err := FormatDictionary(d)
+	if err != nil {
+		return err
+	}
+	if v == "" {
+		h.Remove(key)
+		return nil
+	}
+	h.Override(key, v)
+	return nil
+}
+
+// sfParser follows the parsing algorithms of RFC 9651 section 4.2, which
+// fail on anything they do not expect rather than recovering.
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("structured field: "+format+" at offset %d", append(args, p.i)...)
+}
+
+func (p *sfParser) empty() bool { return p.i >= len(p.s) }
+
+// peek returns the next character, or 0 at the end of input.
+func (p *sfParser) peek() byte {
+	if p.empty() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.empty() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.empty() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// parse runs parseType over the whole field value, allowing only spaces
+// around it.
+func parse[T any](value string, parseType func(*sfParser) (T, error)) (T, error) {
+	p := &sfParser{s: value}
+	p.skipSP()
+	v, err := parseType(p)
+	if err != nil {
+		return v, err
+	}
+	p.skipSP()
+	if !p.empty() {
+		var zero T
+		return zero, p.errorf("unexpected %q", p.peek())
+	}
+	return v, nil
+}
+
+// ParseItem parses a field value as an Item.
+func ParseItem(value string) (Item, error) {
+	return parse(value, (*sfParser).item)
+}
+
+// ParseList parses a field value as a List. Field lines combined by
+// Headers.Set parse as one list.
+func ParseList(value string) (List, error) {
+	return parse(value, (*sfParser).list)
+}
+
+// ParseDictionary parses a field value as a Dictionary. Later members
+// replace earlier members with the same key.
+func ParseDictionary(value string) (Dictionary, error) {
+	return parse(value, (*sfParser).dictionary)
+}
+
+func (p *sfParser) list() (List, error) {
+	l := List{}
+	for !p.empty() {
+		m, err := p.itemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, m)
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (p *sfParser) dictionary() (Dictionary, error) {
+	d := Dictionary{}
+	for !p.empty() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var m Member
+		if p.peek() == '=' {
+			p.i++
+			m, err = p.itemOrInnerList()
+		} else {
+			var params Params
+			params, err = p.params()
+			m = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range d {
+			if d[i].Key == key {
+				d[i].Member = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			d = append(d, DictMember{Key: key, Member: m})
+		}
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// nextMember moves past the comma between members of a List or Dictionary.
+func (p *sfParser) nextMember() error {
+	p.skipOWS()
+	if p.empty() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.errorf("expected comma, found %q", p.peek())
+	}
+	p.i++
+	p.skipOWS()
+	if p.empty() {
+		return p.errorf("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) itemOrInnerList() (Member, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (InnerList, error) {
+	p.i++ // (
+	l := InnerList{Items: []Item{}}
+	for !p.empty() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.params()
+			l.Params = params
+			return l, err
+		}
+		item, err := p.item()
+		if err != nil {
+			return l, err
+		}
+		l.Items = append(l.Items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return l, p.errorf("expected space or ) in inner list")
+		}
+	}
+	return l, p.errorf("unterminated inner list")
+}
+
+func (p *sfParser) item() (Item, error) {
+	v, err := p.bareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.params()
+	return Item{Value: v, Params: params}, err
+}
+
+func (p *sfParser) params() (Params, error) {
+	params := Params{}
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var v any = true
+		if p.peek() == '=' {
+			p.i++
+			if v, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = params.set(key, v)
+	}
+	return params, nil
+}
+
+func isLCAlpha(c byte) bool { return c >= 'a' && c <= 'z' }
+func isAlpha(c byte) bool   { return isLCAlpha(c) || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool   { return c >= '0' && c <= '9' }
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+func (p *sfParser) key() (string, error) {
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("invalid key")
+	}
+	start := p.i
+	for !p.empty() && isKeyChar(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	case c == '@':
+		return p.date()
+	case c == '%':
+		return p.displayString()
+	case p.empty():
+		return nil, p.errorf("missing item")
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+// number returns an int64 or float64. Integers have at most 15 digits,
+// decimals at most 12 before the point and 3 after it.
+func (p *sfParser) number() (any, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("invalid number")
+	}
+	digits := p.i
+	point := -1
+	for !p.empty() {
+		c := p.s[p.i]
+		if c == '.' && point < 0 {
+			if p.i-digits > 12 {
+				return nil, p.errorf("decimal too long")
+			}
+			point = p.i
+		} else if !isDigit(c) {
+			break
+		}
+		p.i++
+		if point < 0 && p.i-digits > 15 || point >= 0 && p.i-digits > 16 {
+			return nil, p.errorf("number too long")
+		}
+	}
+	if point < 0 {
+		return strconv.ParseInt(p.s[start:p.i], 10, 64)
+	}
+	if fraction := p.i - point - 1; fraction == 0 || fraction > 3 {
+		return nil, p.errorf("invalid decimal fraction")
+	}
+	return strconv.ParseFloat(p.s[start:p.i], 64)
+}
+
+func (p *sfParser) string() (string, error) {
+	p.i++ // "
+	var b strings.Builder
+	for !p.empty() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.empty() {
+				return "", p.errorf("unterminated string")
+			}
+			next := p.s[p.i]
+			p.i++
+			if next != '"' && next != '\\' {
+				return "", p.errorf("invalid escape in string")
+			}
+			b.WriteByte(next)
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *sfParser) token() Token {
+	start := p.i
+	p.i++
+	for !p.empty() && (isTokenChar(p.s[p.i]) || p.s[p.i] == ':' || p.s[p.i] == '/') {
+		p.i++
+	}
+	return Token(p.s[start:p.i])
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.i++ // :
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	encoded := p.s[p.i : p.i+end]
+	p.i += end + 1
+	// padding is optional, RFC 9651 section 4.2.7
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || strings.ContainsAny(encoded, "\r\n") {
+		return nil, p.errorf("invalid byte sequence")
+	}
+	return b, nil
+}
+
+func (p *sfParser) boolean() (bool, error) {
+	p.i++ // ?
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+	return false, p.errorf("invalid boolean")
+}
+
+func (p *sfParser) date() (time.Time, error) {
+	p.i++ // @
+	n, err := p.number()
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, ok := n.(int64)
+	if !ok {
+		return time.Time{}, p.errorf("date is not an integer")
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func (p *sfParser) displayString() (DisplayString, error) {
+	p.i++ // %
+	if p.peek() != '"' {
+		return "", p.errorf("invalid display string")
+	}
+	p.i++
+	var b []byte
+	for !p.empty() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in display string")
+		case c == '%':
+			if p.i+2 > len(p.s) || !isLowerHex(p.s[p.i]) || !isLowerHex(p.s[p.i+1]) {
+				return "", p.errorf("invalid escape in display string")
+			}
+			n, _ := strconv.ParseUint(p.s[p.i:p.i+2], 16, 8)
+			b = append(b, byte(n))
+			p.i += 2
+		case c == '"':
+			if !utf8.Valid(b) {
+				return "", p.errorf("display string is not UTF-8")
+			}
+			return DisplayString(b), nil
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", p.errorf("unterminated display string")
+}
+
+func isLowerHex(c byte) bool { return isDigit(c) || c >= 'a' && c <= 'f' }
+
+// FormatItem serializes an Item, RFC 9651 section 4.1, failing on values
+// that cannot be represented.
+func FormatItem(item Item) (string, error) {
+	var b strings.Builder
+	err := writeItem(&b, item)
+	return b.String(), err
+}
+
+// FormatList serializes a List. An empty List is an empty string.
+func FormatList(l List) (string, error) {
+	var b strings.Builder
+	for i, m := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeMember(&b, m); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// FormatDictionary serializes a Dictionary. Members whose value is the
+// Boolean true are written as their key alone.
+func FormatDictionary(d Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeKey(&b, m.Key); err != nil {
+			return "", err
+		}
+		if item, ok := m.Member.(Item); ok && item.Value == true {
+			if err := writeParams(&b, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeMember(&b, m.Member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func writeMember(b *strings.Builder, m Member) error {
+	switch m := m.(type) {
+	case Item:
+		return writeItem(b, m)
+	case InnerList:
+		b.WriteByte('(')
+		for i, item := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := writeItem(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return writeParams(b, m.Params)
+	}
+	return fmt.Errorf("structured field: invalid member %T", m)
+}
+
+func writeItem(b *strings.Builder, item Item) error {
+	if err := writeBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return writeParams(b, item.Params)
+}
+
+func writeParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := writeKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value != true {
+			b.WriteByte('=')
+			if err := writeBareItem(b, param.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeKey(b *strings.Builder, key string) error {
+	if key == "" || !isLCAlpha(key[0]) && key[0] != '*' {
+		return fmt.Errorf("structured field: invalid key %q", key)
+	}
+	for i := 0; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return fmt.Errorf("structured field: invalid key %q", key)
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+const maxSFInteger = 999_999_999_999_999
+
+func writeBareItem(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case int:
+		return writeBareItem(b, int64(v))
+	case int64:
+		if v > maxSFInteger || v < -maxSFInteger {
+			return fmt.Errorf("structured field: integer %d out of range", v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		// three decimal places, rounding half to even
+		rounded := math.RoundToEven(v*1000) / 1000
+		if math.IsNaN(rounded) || math.Abs(rounded) >= 1e12 {
+			return fmt.Errorf("structured field: decimal %v out of range", v)
+		}
+		s := strconv.FormatFloat(rounded, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("structured field: invalid character in string %q", v)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case Token:
+		if v == "" || !isAlpha(v[0]) && v[0] != '*' {
+			return fmt.Errorf("structured field: invalid token %q", v)
+		}
+		for i := 1; i < len(v); i++ {
+			if !isTokenChar(v[i]) && v[i] != ':' && v[i] != '/' {
+				return fmt.Errorf("structured field: invalid token %q", v)
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	case time.Time:
+		b.WriteByte('@')
+		return writeBareItem(b, v.Unix())
+	case DisplayString:
+		if !utf8.ValidString(string(v)) {
+			return fmt.Errorf("structured field: display string is not UTF-8")
+		}
+		b.WriteString(`%"`)
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c == '%' || c == '"' || c < 0x20 || c > 0x7e {
+				fmt.Fprintf(b, "%%%02x", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+	default:
+		return fmt.Errorf("structured field: unsupported value %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package headers
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sfTest is a case of the structured field test corpus in
+// testdata/structured-field-tests.
+type sfTest struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	CanFail    bool            `json:"can_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+func loadSFTests(t *testing.T, pattern string) map[string][]sfTest {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "structured-field-tests", pattern))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	suites := map[string][]sfTest{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		var tests []sfTest
+		require.NoError(t, json.Unmarshal(data, &tests), file)
+		suites[filepath.Base(file)] = tests
+	}
+	return suites
+}
+
+// The corpus writes values as JSON: numbers, strings and booleans as
+// themselves, other bare items as {"__type": ..., "value": ...}, an item
+// as [value, params], parameters and dictionaries as [[key, value], ...]
+// and an inner list as [[items...], params].
+
+func decodeJSON(t *testing.T, raw json.RawMessage) any {
+	t.Helper()
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	d.UseNumber()
+	var v any
+	require.NoError(t, d.Decode(&v))
+	return v
+}
+
+func sfBareItem(t *testing.T, v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			f, err := v.Float64()
+			require.NoError(t, err)
+			return f
+		}
+		n, err := v.Int64()
+		require.NoError(t, err)
+		return n
+	case map[string]any:
+		value := v["value"]
+		switch v["__type"] {
+		case "token":
+			return Token(value.(string))
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(value.(string))
+			require.NoError(t, err)
+			return b
+		case "date":
+			n, err := value.(json.Number).Int64()
+			require.NoError(t, err)
+			return time.Unix(n, 0).UTC()
+		case "displaystring":
+			return DisplayString(value.(string))
+		}
+		t.Fatalf("unknown type %v", v["__type"])
+	}
+	return v
+}
+
+func sfParams(t *testing.T, v any) Params {
+	params := Params{}
+	for _, p := range v.([]any) {
+		p := p.([]any)
+		params = append(params, Param{Key: p[0].(string), Value: sfBareItem(t, p[1])})
+	}
+	return params
+}
+
+func sfItem(t *testing.T, v any) Item {
+	pair := v.([]any)
+	return Item{Value: sfBareItem(t, pair[0]), Params: sfParams(t, pair[1])}
+}
+
+func sfMember(t *testing.T, v any) Member {
+	pair := v.([]any)
+	if items, ok := pair[0].([]any); ok {
+		l := InnerList{Items: []Item{}, Params: sfParams(t, pair[1])}
+		for _, item := range items {
+			l.Items = append(l.Items, sfItem(t, item))
+		}
+		return l
+	}
+	return sfItem(t, v)
+}
+
+func sfExpected(t *testing.T, test sfTest) any {
+	v := decodeJSON(t, test.Expected)
+	switch test.HeaderType {
+	case "item":
+		return sfItem(t, v)
+	case "list":
+		l := List{}
+		for _, m := range v.([]any) {
+			l = append(l, sfMember(t, m))
+		}
+		return l
+	case "dictionary":
+		d := Dictionary{}
+		for _, m := range v.([]any) {
+			m := m.([]any)
+			d = append(d, DictMember{Key: m[0].(string), Member: sfMember(t, m[1])})
+		}
+		return d
+	}
+	t.Fatalf("unknown header type %s", test.HeaderType)
+	return nil
+}
+
+func sfParse(h Headers, headerType string) (any, error) {
+	switch headerType {
+	case "item":
+		return h.Item("Example")
+	case "list":
+		return h.List("Example")
+	default:
+		return h.Dictionary("Example")
+	}
+}
+
+func sfFormat(v any) (string, error) {
+	switch v := v.(type) {
+	case Item:
+		return FormatItem(v)
+	case List:
+		return FormatList(v)
+	default:
+		return FormatDictionary(v.(Dictionary))
+	}
+}
+
+func TestStructuredFieldCorpus(t *testing.T) {
+	for file, tests := range loadSFTests(t, "*.json") {
+		for _, test := range tests {
+			t.Run(file+"/"+test.Name, func(t *testing.T) {
+				// Test: Field lines are combined the way Parse combines them
+				h := NewHeaders()
+				for _, line := range test.Raw {
+					if line = strings.Trim(line, " \t"); line != "" {
+						h.Set("Example", line)
+					}
+				}
+				if len(test.Raw) == 1 {
+					h.Override("Example", test.Raw[0])
+				}
+				got, err := sfParse(h, test.HeaderType)
+				if test.MustFail {
+					assert.Error(t, err)
+					return
+				}
+				if test.CanFail && err != nil {
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, sfExpected(t, test), got)
+
+				// Test: Parsed values serialize to the canonical form
+				canonical := test.Canonical
+				if canonical == nil {
+					canonical = test.Raw
+				}
+				s, err := sfFormat(got)
+				require.NoError(t, err)
+				assert.Equal(t, strings.Join(canonical, ", "), s)
+			})
+		}
+	}
+}
+
+func TestStructuredFieldSerialisation(t *testing.T) {
+	for file, tests := range loadSFTests(t, filepath.Join("serialisation-tests", "*.json")) {
+		for _, test := range tests {
+			t.Run(file+"/"+test.Name, func(t *testing.T) {
+				s, err := sfFormat(sfExpected(t, test))
+				if test.MustFail {
+					assert.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, strings.Join(test.Canonical, ", "), s)
+			})
+		}
+	}
+}
+
+func TestStructuredFieldHeaders(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Absent fields are empty lists and dictionaries, but no item
+	_, err := h.Item("Priority")
+	assert.ErrorIs(t, err, ErrNoField)
+	l, err := h.List("Cache-Status")
+	require.NoError(t, err)
+	assert.Empty(t, l)
+
+	// Test: Dictionaries are read and looked up by key
+	h.Set("Priority", "u=3, i")
+	d, err := h.Dictionary("Priority")
+	require.NoError(t, err)
+	u, ok := d.Get("u")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), u.(Item).Value)
+	_, ok = d.Get("x")
+	assert.False(t, ok)
+
+	// Test: Values set are serialized, replacing the field
+	require.NoError(t, h.SetDictionary("Priority", Dictionary{
+		{Key: "u", Member: Item{Value: 1}},
+		{Key: "i", Member: Item{Value: true}},
+	}))
+	assert.Equal(t, "u=1, i", h["priority"])
+	require.NoError(t, h.SetList("Cache-Status", List{
+		Item{Value: Token("Cache"), Params: Params{{Key: "hit", Value: true}, {Key: "ttl", Value: 30}}},
+	}))
+	assert.Equal(t, "Cache;hit;ttl=30", h["cache-status"])
+	require.NoError(t, h.SetItem("Example", Item{Value: time.Unix(1659578233, 0)}))
+	assert.Equal(t, "@1659578233", h["example"])
+
+	// Test: Parameters are looked up by key
+	item, err := h.Item("Cache-Status")
+	require.NoError(t, err)
+	ttl, ok := item.Params.Get("ttl")
+	assert.True(t, ok)
+	assert.Equal(t, int64(30), ttl)
+
+	// Test: Invalid values leave the field alone, and empty ones remove it
+	assert.Error(t, h.SetItem("Example", Item{Value: "ü"}))
+	assert.Error(t, h.SetItem("Example", Item{Value: 1.5, Params: Params{{Key: "q", Value: struct{}{}}}}))
+	assert.Equal(t, "@1659578233", h["example"])
+	require.NoError(t, h.SetList("Cache-Status", nil))
+	assert.NotContains(t, h, "cache-status")
+}
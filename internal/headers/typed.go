@@ -0,0 +1,571 @@
+package headers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed accessors for common fields. Getters return ErrNoField when the
+// field is absent and an error naming the field when its value does not
+// follow the grammar of RFC 9110 or RFC 9111, rather than guessing.
+
+// TimeFormat is the IMF-fixdate format of HTTP dates, RFC 9110 section
+// 5.6.7.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete date formats that recipients must still accept
+var dateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ContentLength returns the Content-Length. Repeated fields, which Set
+// joins with commas, are accepted only when they all agree.
+func (h Headers) ContentLength() (int64, error) {
+	value, ok := h.Get("Content-Length")
+	if !ok {
+		return 0, ErrNoField
+	}
+	n := int64(-1)
+	for _, part := range strings.Split(value, ",") {
+		m, err := parseDigits(strings.TrimSpace(part))
+		if err != nil {
+			return 0, fmt.Errorf("malformed Content-Length: %s", value)
+		}
+		if n != -1 && m != n {
+			return 0, fmt.Errorf("conflicting Content-Length values: %s", value)
+		}
+		n = m
+	}
+	return n, nil
+}
+
+func (h Headers) SetContentLength(n int64) {
+	h.Override("Content-Length", strconv.FormatInt(n, 10))
+}
+
+// parseDigits parses 1*DIGIT, which unlike strconv.ParseInt allows no
+// sign.
+func parseDigits(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// MediaType is a media type with its parameters, such as the value of
+// Content-Type.
+type MediaType struct {
+	// Type is the lower-cased type and subtype, such as "text/html".
+	Type string
+	// Params has lower-cased names and unquoted values.
+	Params map[string]string
+}
+
+// ParseMediaType parses a media type, RFC 9110 section 8.3.1.
+func ParseMediaType(value string) (MediaType, error) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return MediaType{}, fmt.Errorf("malformed media type %q: %w", value, err)
+	}
+	if typ, sub, ok := strings.Cut(mediaType, "/"); !ok || typ == "" || sub == "" {
+		return MediaType{}, fmt.Errorf("malformed media type %q", value)
+	}
+	return MediaType{Type: mediaType, Params: params}, nil
+}
+
+// String formats the media type, or returns "" if it is not valid.
+func (m MediaType) String() string {
+	return mime.FormatMediaType(m.Type, m.Params)
+}
+
+func (h Headers) ContentType() (MediaType, error) {
+	value, ok := h.Get("Content-Type")
+	if !ok {
+		return MediaType{}, ErrNoField
+	}
+	return ParseMediaType(value)
+}
+
+func (h Headers) SetContentType(m MediaType) error {
+	value := m.String()
+	if value == "" || !strings.Contains(m.Type, "/") {
+		return fmt.Errorf("invalid media type %q", m.Type)
+	}
+	h.Override("Content-Type", value)
+	return nil
+}
+
+// ParseTime parses an HTTP date in the IMF-fixdate format or one of the
+// two obsolete formats, RFC 9110 section 5.6.7.
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range dateFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed HTTP date %q", value)
+}
+
+// Time returns a date field such as Date, Last-Modified or
+// If-Modified-Since.
+func (h Headers) Time(key string) (time.Time, error) {
+	value, ok := h.Get(key)
+	if !ok {
+		return time.Time{}, ErrNoField
+	}
+	t, err := ParseTime(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", key, err)
+	}
+	return t, nil
+}
+
+// SetTime sets a date field in the IMF-fixdate format.
+func (h Headers) SetTime(key string, t time.Time) {
+	h.Override(key, t.UTC().Format(TimeFormat))
+}
+
+// CacheControl holds Cache-Control directives, RFC 9111 section 5.2, by
+// lower-cased name. Directives without an argument map to "".
+type CacheControl map[string]string
+
+// ParseCacheControl parses Cache-Control directives. Quoted arguments are
+// unquoted. Of repeated directives the first is kept, as RFC 9111 section
+// 4.2.1 suggests.
+func ParseCacheControl(value string) (CacheControl, error) {
+	c := CacheControl{}
+	for _, directive := range splitQuoted(value, ',') {
+		name, arg, hasArg := strings.Cut(directive, "=")
+		name = strings.ToLower(name)
+		if !isToken(name) {
+			return nil, fmt.Errorf("malformed Cache-Control directive %q", directive)
+		}
+		if hasArg {
+			var ok bool
+			if arg, ok = parseTokenOrQuoted(arg); !ok {
+				return nil, fmt.Errorf("malformed Cache-Control directive %q", directive)
+			}
+		}
+		if _, ok := c[name]; !ok {
+			c[name] = arg
+		}
+	}
+	return c, nil
+}
+
+// Has reports whether the directive is present.
+func (c CacheControl) Has(directive string) bool {
+	_, ok := c[directive]
+	return ok
+}
+
+// Seconds returns the delta-seconds argument of a directive such as
+// max-age or s-maxage. A malformed argument counts as absent.
+func (c CacheControl) Seconds(directive string) (time.Duration, bool) {
+	arg, ok := c[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := parseDigits(arg)
+	if err != nil {
+		// values too large for delta-seconds mean "forever", RFC 9111
+		// section 1.2.2
+		if arg != "" && strings.TrimLeft(arg, "0123456789") == "" {
+			return time.Duration(1<<31-1) * time.Second, true
+		}
+		return 0, false
+	}
+	return time.Duration(min(n, 1<<31-1)) * time.Second, true
+}
+
+// String formats the directives sorted by name, quoting arguments that
+// are not tokens.
+func (c CacheControl) String() string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		if arg := c[name]; arg != "" {
+			b.WriteByte('=')
+			b.WriteString(quoteIfNeeded(arg))
+		}
+	}
+	return b.String()
+}
+
+// CacheControl returns the Cache-Control directives. An absent field has
+// none.
+func (h Headers) CacheControl() (CacheControl, error) {
+	value, _ := h.Get("Cache-Control")
+	return ParseCacheControl(value)
+}
+
+func (h Headers) SetCacheControl(c CacheControl) error {
+	for name := range c {
+		if !isToken(name) {
+			return fmt.Errorf("invalid Cache-Control directive %q", name)
+		}
+	}
+	h.Override("Cache-Control", c.String())
+	return nil
+}
+
+// Credentials are the value of an Authorization or Proxy-Authorization
+// field, RFC 9110 section 11.4: a scheme followed by either a token68,
+// as for Basic and Bearer, or parameters.
+type Credentials struct {
+	// Scheme is case-insensitive; compare it with strings.EqualFold.
+	Scheme  string
+	Token68 string
+	// Params has lower-cased names and unquoted values.
+	Params map[string]string
+}
+
+// ParseCredentials parses an Authorization value.
+func ParseCredentials(value string) (Credentials, error) {
+	scheme, rest, _ := strings.Cut(value, " ")
+	if !isToken(scheme) {
+		return Credentials{}, fmt.Errorf("malformed credentials scheme %q", scheme)
+	}
+	c := Credentials{Scheme: scheme}
+	rest = strings.TrimLeft(rest, " ")
+	if rest == "" {
+		return c, nil
+	}
+	if isToken68(rest) {
+		c.Token68 = rest
+		return c, nil
+	}
+	c.Params = map[string]string{}
+	for _, param := range splitQuoted(rest, ',') {
+		name, arg, ok := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if ok {
+			arg, ok = parseTokenOrQuoted(strings.TrimSpace(arg))
+		}
+		if !ok || !isToken(name) {
+			return Credentials{}, fmt.Errorf("malformed credentials parameter %q", param)
+		}
+		if _, dup := c.Params[name]; dup {
+			return Credentials{}, fmt.Errorf("repeated credentials parameter %q", name)
+		}
+		c.Params[name] = arg
+	}
+	return c, nil
+}
+
+// String formats the credentials, with parameters sorted by name.
+func (c Credentials) String() string {
+	if c.Token68 != "" {
+		return c.Scheme + " " + c.Token68
+	}
+	names := make([]string, 0, len(c.Params))
+	for name := range c.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + quoteIfNeeded(c.Params[name])
+	}
+	if len(names) == 0 {
+		return c.Scheme
+	}
+	return c.Scheme + " " + strings.Join(names, ", ")
+}
+
+// BasicAuth returns the user and password of Basic credentials, RFC 7617.
+func (c Credentials) BasicAuth() (user, password string, err error) {
+	if !strings.EqualFold(c.Scheme, "Basic") {
+		return "", "", fmt.Errorf("credentials scheme is %s, not Basic", c.Scheme)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(c.Token68)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed Basic credentials: %w", err)
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed Basic credentials: no colon")
+	}
+	return user, password, nil
+}
+
+// BasicCredentials returns Basic credentials for a user and password.
+func BasicCredentials(user, password string) Credentials {
+	return Credentials{
+		Scheme:  "Basic",
+		Token68: base64.StdEncoding.EncodeToString([]byte(user + ":" + password)),
+	}
+}
+
+func (h Headers) Authorization() (Credentials, error) {
+	value, ok := h.Get("Authorization")
+	if !ok {
+		return Credentials{}, ErrNoField
+	}
+	return ParseCredentials(value)
+}
+
+func (h Headers) SetAuthorization(c Credentials) error {
+	if !isToken(c.Scheme) || c.Token68 != "" && !isToken68(c.Token68) {
+		return fmt.Errorf("invalid credentials for scheme %q", c.Scheme)
+	}
+	for name := range c.Params {
+		if !isToken(name) {
+			return fmt.Errorf("invalid credentials parameter %q", name)
+		}
+	}
+	h.Override("Authorization", c.String())
+	return nil
+}
+
+// SplitHost splits a Host value, RFC 9110 section 7.2, into the host and
+// the port, which is empty when absent. IPv6 literals keep their brackets
+// off.
+func SplitHost(value string) (host, port string, err error) {
+	hostPart := value
+	if strings.HasPrefix(value, "[") {
+		end := strings.IndexByte(value, ']')
+		if end < 0 {
+			return "", "", fmt.Errorf("malformed Host %q", value)
+		}
+		host, hostPart = value[1:end], value[end+1:]
+		if host == "" || strings.Trim(host, "0123456789abcdefABCDEF:.") != "" {
+			return "", "", fmt.Errorf("malformed Host %q", value)
+		}
+		if hostPart != "" && hostPart[0] != ':' {
+			return "", "", fmt.Errorf("malformed Host %q", value)
+		}
+	} else {
+		host, hostPart, _ = strings.Cut(value, ":")
+		hostPart = value[len(host):]
+		for i := 0; i < len(host); i++ {
+			if !isRegNameChar(host[i]) {
+				return "", "", fmt.Errorf("malformed Host %q", value)
+			}
+		}
+	}
+	if hostPart != "" {
+		port = hostPart[1:]
+		if n, err := parseDigits(port); port != "" && (err != nil || n > 65535) {
+			return "", "", fmt.Errorf("malformed port in Host %q", value)
+		}
+	}
+	return host, port, nil
+}
+
+// isRegNameChar reports whether c may appear in a reg-name, RFC 3986
+// section 3.2.2: unreserved characters, sub-delims and percent-encoding.
+func isRegNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("-._~!$&'()*+,;=%", c) >= 0
+}
+
+// Host returns the host and port of the Host field. Repeated Host fields
+// are an error, RFC 9112 section 3.2.
+func (h Headers) Host() (host, port string, err error) {
+	value, ok := h.Get("Host")
+	if !ok {
+		return "", "", ErrNoField
+	}
+	if strings.Contains(value, ", ") {
+		return "", "", fmt.Errorf("repeated Host field: %s", value)
+	}
+	return SplitHost(value)
+}
+
+// SetHost sets the Host field, adding brackets to IPv6 literals.
+func (h Headers) SetHost(host, port string) {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	h.Override("Host", host)
+}
+
+// ETag is an entity tag, RFC 9110 section 8.8.3.
+type ETag struct {
+	// Tag is the opaque tag without its quotes.
+	Tag  string
+	Weak bool
+}
+
+// ParseETag parses a single entity tag.
+func ParseETag(value string) (ETag, error) {
+	e, rest, ok := parseETag(value)
+	if !ok || rest != "" {
+		return ETag{}, fmt.Errorf("malformed entity tag %q", value)
+	}
+	return e, nil
+}
+
+func parseETag(s string) (ETag, string, bool) {
+	var e ETag
+	if strings.HasPrefix(s, "W/") {
+		e.Weak = true
+		s = s[2:]
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return ETag{}, s, false
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return ETag{}, s, false
+	}
+	e.Tag = s[1 : end+1]
+	for i := 0; i < len(e.Tag); i++ {
+		// etagc is %x21 / %x23-7E / obs-text
+		if c := e.Tag[i]; c < 0x21 || c == 0x7f {
+			return ETag{}, s, false
+		}
+	}
+	return e, s[end+2:], true
+}
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Tag + `"`
+	}
+	return `"` + e.Tag + `"`
+}
+
+// StrongMatch reports whether both tags are strong and equal.
+func (e ETag) StrongMatch(other ETag) bool {
+	return !e.Weak && !other.Weak && e.Tag == other.Tag
+}
+
+// WeakMatch reports whether the tags are equal, weak or not.
+func (e ETag) WeakMatch(other ETag) bool {
+	return e.Tag == other.Tag
+}
+
+// ParseETags parses a list of entity tags as sent in If-Match and
+// If-None-Match. A list of just "*" matches any tag and is reported as
+// wildcard.
+func ParseETags(value string) (tags []ETag, wildcard bool, err error) {
+	if strings.TrimSpace(value) == "*" {
+		return nil, true, nil
+	}
+	rest := value
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			break
+		}
+		e, after, ok := parseETag(rest)
+		if !ok {
+			return nil, false, fmt.Errorf("malformed entity tag list %q", value)
+		}
+		after = strings.TrimLeft(after, " \t")
+		if after != "" && after[0] != ',' {
+			return nil, false, fmt.Errorf("malformed entity tag list %q", value)
+		}
+		tags = append(tags, e)
+		rest = after
+	}
+	return tags, false, nil
+}
+
+// ETag returns the ETag field.
+func (h Headers) ETag() (ETag, error) {
+	value, ok := h.Get("ETag")
+	if !ok {
+		return ETag{}, ErrNoField
+	}
+	return ParseETag(value)
+}
+
+func (h Headers) SetETag(e ETag) error {
+	if _, err := ParseETag(e.String()); err != nil {
+		return err
+	}
+	h.Override("ETag", e.String())
+	return nil
+}
+
+// ETags returns a list of entity tags such as If-None-Match.
+func (h Headers) ETags(key string) (tags []ETag, wildcard bool, err error) {
+	value, ok := h.Get(key)
+	if !ok {
+		return nil, false, ErrNoField
+	}
+	return ParseETags(value)
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken68 reports whether s is a token68: base64 or base64url
+// characters followed by padding.
+func isToken68(s string) bool {
+	body := strings.TrimRight(s, "=")
+	if body == "" {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~+/", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTokenOrQuoted returns the value of a token or quoted-string, RFC
+// 9110 section 5.6.4, removing the quotes and escapes.
+func parseTokenOrQuoted(s string) (string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, isToken(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), i == len(s)-1
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return "", false
+			}
+			b.WriteByte(s[i])
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// quoteIfNeeded returns s as a token if it is one, or as a quoted-string.
+func quoteIfNeeded(s string) string {
+	if isToken(s) {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,257 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLength(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Absent field
+	_, err := h.ContentLength()
+	assert.ErrorIs(t, err, ErrNoField)
+
+	// Test: Valid and agreeing repeated values
+	h.SetContentLength(42)
+	n, err := h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	h.Set("Content-Length", "42")
+	n, err = h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	// Test: Signs, junk, overflow and conflicting values are refused
+	for _, value := range []string{"", "+5", "-1", "0x10", "1 2", "99999999999999999999", "42, 43"} {
+		h.Override("Content-Length", value)
+		_, err := h.ContentLength()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	h := NewHeaders()
+	_, err := h.ContentType()
+	assert.ErrorIs(t, err, ErrNoField)
+
+	// Test: Types and parameter names are lower-cased, values unquoted
+	h.Set("Content-Type", `Text/HTML; Charset="utf-8"`)
+	m, err := h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, MediaType{Type: "text/html", Params: map[string]string{"charset": "utf-8"}}, m)
+
+	// Test: Malformed media types
+	for _, value := range []string{"text", "text/", "/html", "text/html; charset", "text html"} {
+		_, err := ParseMediaType(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Setting quotes parameters that need it
+	require.NoError(t, h.SetContentType(MediaType{Type: "multipart/form-data", Params: map[string]string{"boundary": "a b"}}))
+	assert.Equal(t, `multipart/form-data; boundary="a b"`, h["content-type"])
+	assert.Error(t, h.SetContentType(MediaType{Type: "text"}))
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: IMF-fixdate and both obsolete formats, RFC 9110 section 5.6.7
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	// Test: Other formats and time zones are refused
+	for _, value := range []string{"", "1994-11-06T08:49:37Z", "Sun, 06 Nov 1994 08:49:37 PST", "Sun, 6 Nov 1994 08:49:37 GMT"} {
+		_, err := ParseTime(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Date fields are set in UTC and read back
+	h := NewHeaders()
+	h.SetTime("Last-Modified", want.In(time.FixedZone("X", 3600)))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", h["last-modified"])
+	got, err := h.Time("Last-Modified")
+	require.NoError(t, err)
+	assert.True(t, want.Equal(got))
+	_, err = h.Time("If-Modified-Since")
+	assert.ErrorIs(t, err, ErrNoField)
+	h.Set("Date", "yesterday")
+	_, err = h.Time("Date")
+	assert.ErrorContains(t, err, "Date")
+}
+
+func TestCacheControl(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Absent field has no directives
+	c, err := h.CacheControl()
+	require.NoError(t, err)
+	assert.Empty(t, c)
+
+	// Test: Directives, arguments and repeats
+	h.Set("Cache-Control", `No-Cache="Set-Cookie, Vary", max-age=60, public`)
+	h.Set("Cache-Control", "max-age=5, s-maxage=99999999999")
+	c, err = h.CacheControl()
+	require.NoError(t, err)
+	assert.Equal(t, "Set-Cookie, Vary", c["no-cache"])
+	assert.True(t, c.Has("public"))
+	assert.False(t, c.Has("private"))
+	maxAge, ok := c.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, maxAge)
+	sMaxAge, ok := c.Seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(1<<31-1)*time.Second, sMaxAge)
+	_, ok = c.Seconds("public")
+	assert.False(t, ok)
+
+	// Test: Malformed directives
+	for _, value := range []string{"max-age=", `private="x`, "max age=1", "a=b c"} {
+		_, err := ParseCacheControl(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Directives are written sorted, quoting where needed
+	require.NoError(t, h.SetCacheControl(CacheControl{"no-store": "", "private": "Set-Cookie, X", "max-age": "0"}))
+	assert.Equal(t, `max-age=0, no-store, private="Set-Cookie, X"`, h["cache-control"])
+	assert.Error(t, h.SetCacheControl(CacheControl{"bad name": ""}))
+}
+
+func TestAuthorization(t *testing.T) {
+	h := NewHeaders()
+	_, err := h.Authorization()
+	assert.ErrorIs(t, err, ErrNoField)
+
+	// Test: Basic credentials round-trip
+	require.NoError(t, h.SetAuthorization(BasicCredentials("Aladdin", "open:sesame")))
+	assert.Equal(t, "Basic QWxhZGRpbjpvcGVuOnNlc2FtZQ==", h["authorization"])
+	c, err := h.Authorization()
+	require.NoError(t, err)
+	user, password, err := c.BasicAuth()
+	require.NoError(t, err)
+	assert.Equal(t, "Aladdin", user)
+	assert.Equal(t, "open:sesame", password)
+
+	// Test: Schemes with parameters
+	c, err = ParseCredentials(`Digest username="Mufasa", realm="http-auth@example.org", nc=00000001`)
+	require.NoError(t, err)
+	assert.Equal(t, "Digest", c.Scheme)
+	assert.Equal(t, map[string]string{"username": "Mufasa", "realm": "http-auth@example.org", "nc": "00000001"}, c.Params)
+	assert.Equal(t, `Digest nc=00000001, realm="http-auth@example.org", username=Mufasa`, c.String())
+	_, _, err = c.BasicAuth()
+	assert.Error(t, err)
+
+	// Test: Bearer tokens and bare schemes
+	c, err = ParseCredentials("bearer mF_9.B5f-4.1JqM")
+	require.NoError(t, err)
+	assert.Equal(t, "mF_9.B5f-4.1JqM", c.Token68)
+	c, err = ParseCredentials("Negotiate")
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Scheme: "Negotiate"}, c)
+
+	// Test: Malformed credentials
+	for _, value := range []string{"", " Basic x", "Basic a b", `Digest a="x`, "Digest a=1, a=2", "B@sic x"} {
+		_, err := ParseCredentials(value)
+		assert.Error(t, err, value)
+	}
+	c, _ = ParseCredentials("Basic !!!")
+	_, _, err = c.BasicAuth()
+	assert.Error(t, err)
+	_, _, err = Credentials{Scheme: "Basic", Token68: "bm9jb2xvbg=="}.BasicAuth()
+	assert.Error(t, err)
+}
+
+func TestHost(t *testing.T) {
+	h := NewHeaders()
+	_, _, err := h.Host()
+	assert.ErrorIs(t, err, ErrNoField)
+
+	// Test: Host names, IPv4 and IPv6 with and without ports
+	for value, want := range map[string][2]string{
+		"example.com":      {"example.com", ""},
+		"example.com:8080": {"example.com", "8080"},
+		"127.0.0.1:80":     {"127.0.0.1", "80"},
+		"[::1]":            {"::1", ""},
+		"[::1]:42069":      {"::1", "42069"},
+		"xn--bcher-kva.de": {"xn--bcher-kva.de", ""},
+		"":                 {"", ""},
+	} {
+		host, port, err := SplitHost(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, [2]string{host, port}, value)
+	}
+
+	// Test: Malformed hosts and ports
+	for _, value := range []string{"a:b", "a:65536", "a:-1", "[::1", "[::1]x", "[zz]", "a b", "a/b", "::1"} {
+		_, _, err := SplitHost(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Repeated Host fields are refused
+	h.Set("Host", "a.example")
+	h.Set("Host", "b.example")
+	_, _, err = h.Host()
+	assert.Error(t, err)
+
+	// Test: IPv6 literals are bracketed when set
+	h.SetHost("::1", "443")
+	assert.Equal(t, "[::1]:443", h["host"])
+	host, port, err := h.Host()
+	require.NoError(t, err)
+	assert.Equal(t, "::1", host)
+	assert.Equal(t, "443", port)
+}
+
+func TestETag(t *testing.T) {
+	h := NewHeaders()
+	_, err := h.ETag()
+	assert.ErrorIs(t, err, ErrNoField)
+
+	// Test: Strong and weak tags
+	require.NoError(t, h.SetETag(ETag{Tag: "xyzzy", Weak: true}))
+	assert.Equal(t, `W/"xyzzy"`, h["etag"])
+	e, err := h.ETag()
+	require.NoError(t, err)
+	assert.Equal(t, ETag{Tag: "xyzzy", Weak: true}, e)
+	assert.Error(t, h.SetETag(ETag{Tag: "a b"}))
+
+	// Test: Comparison, RFC 9110 section 8.8.3.2
+	strong := ETag{Tag: "1"}
+	weak := ETag{Tag: "1", Weak: true}
+	assert.True(t, strong.StrongMatch(ETag{Tag: "1"}))
+	assert.False(t, strong.StrongMatch(weak))
+	assert.True(t, strong.WeakMatch(weak))
+	assert.False(t, strong.WeakMatch(ETag{Tag: "2"}))
+
+	// Test: Malformed tags
+	for _, value := range []string{"", "xyzzy", `"xyzzy`, `w/"x"`, `"x" `, `"a b"`} {
+		_, err := ParseETag(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Lists and the wildcard
+	h.Set("If-None-Match", `"a", W/"b",  "c,d"`)
+	tags, wildcard, err := h.ETags("If-None-Match")
+	require.NoError(t, err)
+	assert.False(t, wildcard)
+	assert.Equal(t, []ETag{{Tag: "a"}, {Tag: "b", Weak: true}, {Tag: "c,d"}}, tags)
+	h.Override("If-Match", "*")
+	tags, wildcard, err = h.ETags("If-Match")
+	require.NoError(t, err)
+	assert.True(t, wildcard)
+	assert.Empty(t, tags)
+	for _, value := range []string{`"a" "b"`, `"a", *`, `a`} {
+		_, _, err := ParseETags(value)
+		assert.Error(t, err, value)
+	}
+}
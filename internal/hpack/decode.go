@@ -0,0 +1,127 @@
+package hpack
+
+import "fmt"
+
+// DefaultTableSize is the dynamic table size both ends assume until the
+// decoding side announces another one, 4096 bytes in HTTP/2.
+const DefaultTableSize = 4096
+
+// DefaultMaxStringLength bounds each decoded name or value.
+const DefaultMaxStringLength = 16 << 10
+
+// Decoder turns header blocks back into fields. The dynamic table carries
+// over from one block to the next, so one Decoder must see every block of a
+// connection in order.
+type Decoder struct {
+	// MaxStringLength bounds each decoded name or value. Zero means
+	// DefaultMaxStringLength.
+	MaxStringLength int
+
+	table dynamicTable
+	// maxTableSize is the largest table the encoder may ask for, the
+	// value advertised to the peer.
+	maxTableSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// SetMaxTableSize changes the limit the encoder's table size updates are
+// checked against, once the new limit has been advertised to the peer.
+func (d *Decoder) SetMaxTableSize(n int) {
+	d.maxTableSize = n
+	if d.table.maxSize > n {
+		d.table.setMaxSize(n)
+	}
+}
+
+// Decode decodes one complete header block.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	maxLength := d.MaxStringLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxStringLength
+	}
+	var fields []HeaderField
+	for len(block) > 0 {
+		b := block[0]
+		var err error
+		switch {
+		case b&0x80 != 0:
+			// indexed field
+			var i int
+			i, block, err = DecodeInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.table.field(i)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			var f HeaderField
+			f, block, err = d.decodeLiteral(block, 6, maxLength)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			fields = append(fields, f)
+		case b&0xe0 == 0x20:
+			// dynamic table size update, only allowed before the first field
+			if len(fields) > 0 {
+				return nil, fmt.Errorf("hpack: table size update after a header field")
+			}
+			var n int
+			n, block, err = DecodeInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if n > d.maxTableSize {
+				return nil, fmt.Errorf("hpack: table size update to %d exceeds limit of %d", n, d.maxTableSize)
+			}
+			d.table.setMaxSize(n)
+		default:
+			// literal without indexing (0000) or never indexed (0001)
+			var f HeaderField
+			f, block, err = d.decodeLiteral(block, 4, maxLength)
+			if err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0xf0 == 0x10
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// decodeLiteral reads a literal field whose name index has an n-bit prefix.
+// An index of zero means the name follows as a string literal.
+func (d *Decoder) decodeLiteral(p []byte, n uint, maxLength int) (HeaderField, []byte, error) {
+	i, p, err := DecodeInt(p, n)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	var f HeaderField
+	if i > 0 {
+		named, err := d.table.field(i)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		f.Name = named.Name
+	} else {
+		f.Name, p, err = decodeString(p, maxLength)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	}
+	f.Value, p, err = decodeString(p, maxLength)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, p, nil
+}
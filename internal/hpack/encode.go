@@ -0,0 +1,74 @@
+package hpack
+
+// Encoder turns fields into header blocks. Fields are added to the dynamic
+// table as they are sent, so one Encoder must produce every block of a
+// connection, and the blocks must reach the peer in the order produced.
+type Encoder struct {
+	table dynamicTable
+	// sizeUpdate is set when the table size changed since the last block.
+	// minSize is the smallest size it passed through, which the decoder
+	// must also hear about so it evicts the same entries.
+	sizeUpdate bool
+	minSize    int
+}
+
+// NewEncoder returns an Encoder whose dynamic table starts at tableSize
+// bytes, the size the decoding side expects.
+func NewEncoder(tableSize int) *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: tableSize}}
+}
+
+// SetMaxTableSize resizes the dynamic table, typically to stay within a
+// limit the peer has announced. The change is signalled at the start of
+// the next header block.
+func (e *Encoder) SetMaxTableSize(n int) {
+	if !e.sizeUpdate || n < e.minSize {
+		e.minSize = n
+	}
+	e.sizeUpdate = true
+	e.table.setMaxSize(n)
+}
+
+// Encode appends the header block for fields to dst.
+func (e *Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	if e.sizeUpdate {
+		e.sizeUpdate = false
+		if e.minSize < e.table.maxSize {
+			dst = AppendInt(dst, 5, 0x20, e.minSize)
+		}
+		dst = AppendInt(dst, 5, 0x20, e.table.maxSize)
+	}
+	for _, f := range fields {
+		dst = e.encodeField(dst, f)
+	}
+	return dst
+}
+
+func (e *Encoder) encodeField(dst []byte, f HeaderField) []byte {
+	if f.Sensitive {
+		// never indexed, not even as an exact match, so a peer's table
+		// cannot be used to confirm a guessed value
+		index, _ := e.table.search(HeaderField{Name: f.Name})
+		return appendLiteral(dst, 4, 0x10, index, f)
+	}
+	index, exact := e.table.search(f)
+	if exact {
+		return AppendInt(dst, 7, 0x80, index)
+	}
+	if f.Size() > e.table.maxSize {
+		// adding it would only empty the table
+		return appendLiteral(dst, 4, 0, index, f)
+	}
+	e.table.add(f)
+	return appendLiteral(dst, 6, 0x40, index, f)
+}
+
+// appendLiteral appends a literal field, naming it by index when the name
+// is in a table.
+func appendLiteral(dst []byte, n uint, flags byte, index int, f HeaderField) []byte {
+	dst = AppendInt(dst, n, flags, index)
+	if index == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
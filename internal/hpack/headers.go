@@ -0,0 +1,63 @@
+package hpack
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// sensitiveHeaders carry credentials and are never indexed.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+}
+
+// FromHeaders lists h as fields in name order, with a field for each
+// Set-Cookie. Credential-bearing fields such as Authorization and Cookie
+// are marked Sensitive.
+func FromHeaders(h headers.Headers) []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+	for name := range h {
+		for _, value := range h.Values(name) {
+			fields = append(fields, HeaderField{
+				Name:      strings.ToLower(name),
+				Value:     value,
+				Sensitive: sensitiveHeaders[strings.ToLower(name)],
+			})
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// ToHeaders collects fields into Headers. Repeated fields are joined with
+// commas as Headers.Set does, except cookies, which HTTP/2 clients may
+// split into one field per cookie and are joined back with "; ".
+// Pseudo-header fields are kept under their names.
+func ToHeaders(fields []HeaderField) headers.Headers {
+	h := headers.NewHeaders()
+	for _, f := range fields {
+		if prev, ok := h.Get(f.Name); ok && strings.EqualFold(f.Name, "cookie") {
+			h.Override(f.Name, prev+"; "+f.Value)
+			continue
+		}
+		h.Set(f.Name, f.Value)
+	}
+	return h
+}
+
+// EncodeHeaders appends the header block for h to dst.
+func (e *Encoder) EncodeHeaders(dst []byte, h headers.Headers) []byte {
+	return e.Encode(dst, FromHeaders(h))
+}
+
+// DecodeHeaders decodes one complete header block into Headers.
+func (d *Decoder) DecodeHeaders(block []byte) (headers.Headers, error) {
+	fields, err := d.Decode(block)
+	if err != nil {
+		return nil, err
+	}
+	return ToHeaders(fields), nil
+}
@@ -0,0 +1,123 @@
+// Package hpack implements HPACK, the header compression format of HTTP/2
+// described in RFC 7541.
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HeaderField is a single name/value pair of a header block.
+type HeaderField struct {
+	Name  string
+	Value string
+	// Sensitive fields are sent as never-indexed literals, so that neither
+	// this encoder nor any intermediary keeps them in a compression
+	// context where they could be probed for.
+	Sensitive bool
+}
+
+// Size returns the size the field takes up in a dynamic table: the length
+// of its name and value plus 32 bytes of overhead.
+func (f HeaderField) Size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+var (
+	ErrIntegerOverflow = errors.New("hpack: integer overflow")
+	ErrTruncated       = errors.New("hpack: truncated header block")
+	ErrInvalidHuffman  = errors.New("hpack: invalid Huffman-encoded string")
+)
+
+// maxInt bounds decoded integers well below the range where the shifts in
+// DecodeInt could overflow.
+const maxInt = 1<<31 - 1
+
+// AppendInt appends i with an n-bit prefix, as in RFC 7541 section 5.1.
+// flags holds the bits of the first byte above the prefix. QPACK codes its
+// integers and strings the same way, with prefixes of other widths.
+func AppendInt(dst []byte, n uint, flags byte, i int) []byte {
+	limit := 1<<n - 1
+	if i < limit {
+		return append(dst, flags|byte(i))
+	}
+	dst = append(dst, flags|byte(limit))
+	i -= limit
+	for i >= 128 {
+		dst = append(dst, byte(i&0x7f|0x80))
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// DecodeInt reads an integer with an n-bit prefix from the start of p and
+// returns it along with the remaining bytes.
+func DecodeInt(p []byte, n uint) (int, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrTruncated
+	}
+	limit := 1<<n - 1
+	i := int(p[0]) & limit
+	p = p[1:]
+	if i < limit {
+		return i, p, nil
+	}
+	shift := uint(0)
+	for {
+		if len(p) == 0 {
+			return 0, nil, ErrTruncated
+		}
+		b := p[0]
+		p = p[1:]
+		i += int(b&0x7f) << shift
+		if i > maxInt {
+			return 0, nil, ErrIntegerOverflow
+		}
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+		shift += 7
+	}
+}
+
+// appendString appends s as a string literal, Huffman-coded unless that
+// would make it longer.
+func appendString(dst []byte, s string) []byte {
+	if n := HuffmanEncodedLen(s); len(s) > 0 && n <= len(s) {
+		dst = AppendInt(dst, 7, 0x80, n)
+		return AppendHuffman(dst, s)
+	}
+	dst = AppendInt(dst, 7, 0, len(s))
+	return append(dst, s...)
+}
+
+// decodeString reads a string literal, Huffman-coded or not, from the start
+// of p. Strings longer than maxLength are rejected before being decoded.
+func decodeString(p []byte, maxLength int) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrTruncated
+	}
+	huffman := p[0]&0x80 != 0
+	length, p, err := DecodeInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > len(p) {
+		return "", nil, ErrTruncated
+	}
+	if length > maxLength {
+		return "", nil, fmt.Errorf("hpack: string of %d bytes exceeds limit of %d", length, maxLength)
+	}
+	data, p := p[:length], p[length:]
+	if !huffman {
+		return string(data), p, nil
+	}
+	s, err := HuffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(s) > maxLength {
+		return "", nil, fmt.Errorf("hpack: string of %d bytes exceeds limit of %d", len(s), maxLength)
+	}
+	return s, p, nil
+}
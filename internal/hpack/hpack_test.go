@@ -0,0 +1,278 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteger(t *testing.T) {
+	// Test: RFC 7541 appendix C.1 examples
+	assert.Equal(t, []byte{0x0a}, AppendInt(nil, 5, 0, 10))
+	assert.Equal(t, []byte{0x1f, 0x9a, 0x0a}, AppendInt(nil, 5, 0, 1337))
+	assert.Equal(t, []byte{0x2a}, AppendInt(nil, 8, 0, 42))
+	i, rest, err := DecodeInt([]byte{0x1f, 0x9a, 0x0a, 0xff}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 1337, i)
+	assert.Equal(t, []byte{0xff}, rest)
+
+	// Test: Truncated and overflowing integers
+	_, _, err = DecodeInt([]byte{0x1f, 0x9a}, 5)
+	assert.ErrorIs(t, err, ErrTruncated)
+	_, _, err = DecodeInt([]byte{0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, 5)
+	assert.ErrorIs(t, err, ErrIntegerOverflow)
+}
+
+func TestHuffmanDecode(t *testing.T) {
+	// Test: RFC 7541 appendix C.4.1
+	s, err := HuffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff})
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", s)
+
+	// Test: Padding must be short and all ones
+	_, err = HuffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0xff})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+	_, err = HuffmanDecode([]byte{0x00})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func hf(pairs ...string) []HeaderField {
+	var fields []HeaderField
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return fields
+}
+
+// appendixCase is one header block of an RFC 7541 appendix C example,
+// along with the dynamic table afterwards, newest entry first.
+type appendixCase struct {
+	block  string
+	fields []HeaderField
+	table  []HeaderField
+	size   int
+}
+
+var requestBlocks = [][]HeaderField{
+	hf(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+	hf(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com",
+		"cache-control", "no-cache"),
+	hf(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com",
+		"custom-key", "custom-value"),
+}
+
+var requestTables = []struct {
+	table []HeaderField
+	size  int
+}{
+	{hf(":authority", "www.example.com"), 57},
+	{hf("cache-control", "no-cache", ":authority", "www.example.com"), 110},
+	{hf("custom-key", "custom-value", "cache-control", "no-cache", ":authority", "www.example.com"), 164},
+}
+
+var responseBlocks = [][]HeaderField{
+	hf(":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+		"location", "https://www.example.com"),
+	hf(":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+		"location", "https://www.example.com"),
+	hf(":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT",
+		"location", "https://www.example.com", "content-encoding", "gzip",
+		"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"),
+}
+
+var responseTables = []struct {
+	table []HeaderField
+	size  int
+}{
+	{hf("location", "https://www.example.com", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+		"cache-control", "private", ":status", "302"), 222},
+	{hf(":status", "307", "location", "https://www.example.com",
+		"date", "Mon, 21 Oct 2013 20:13:21 GMT", "cache-control", "private"), 222},
+	{hf("set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1",
+		"content-encoding", "gzip", "date", "Mon, 21 Oct 2013 20:13:22 GMT"), 215},
+}
+
+// newestFirst lists the dynamic table the way the RFC prints it.
+func newestFirst(tbl dynamicTable) []HeaderField {
+	fields := make([]HeaderField, 0, len(tbl.entries))
+	for i := len(tbl.entries) - 1; i >= 0; i-- {
+		fields = append(fields, tbl.entries[i])
+	}
+	return fields
+}
+
+func runAppendix(t *testing.T, name string, tableSize int, blocks []string, fields [][]HeaderField, tables []struct {
+	table []HeaderField
+	size  int
+}, encode bool) {
+	t.Helper()
+	d := NewDecoder(tableSize)
+	e := NewEncoder(tableSize)
+	for i, block := range blocks {
+		got, err := d.Decode(unhex(t, block))
+		require.NoError(t, err, "%s.%d", name, i+1)
+		assert.Equal(t, fields[i], got, "%s.%d", name, i+1)
+		assert.Equal(t, tables[i].table, newestFirst(d.table), "%s.%d", name, i+1)
+		assert.Equal(t, tables[i].size, d.table.size, "%s.%d", name, i+1)
+		if encode {
+			assert.Equal(t, unhex(t, block), e.Encode(nil, fields[i]), "%s.%d", name, i+1)
+		}
+	}
+}
+
+func TestAppendixC2(t *testing.T) {
+	// Test: C.2.1 literal field with indexing
+	d := NewDecoder(DefaultTableSize)
+	fields, err := d.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	require.NoError(t, err)
+	assert.Equal(t, hf("custom-key", "custom-header"), fields)
+	assert.Equal(t, hf("custom-key", "custom-header"), newestFirst(d.table))
+	assert.Equal(t, 55, d.table.size)
+
+	// Test: C.2.2 literal field without indexing
+	d = NewDecoder(DefaultTableSize)
+	fields, err = d.Decode(unhex(t, "040c 2f73 616d 706c 652f 7061 7468"))
+	require.NoError(t, err)
+	assert.Equal(t, hf(":path", "/sample/path"), fields)
+	assert.Empty(t, d.table.entries)
+
+	// Test: C.2.3 never-indexed literal field
+	fields, err = d.Decode(unhex(t, "1008 7061 7373 776f 7264 0673 6563 7265 74"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "password", Value: "secret", Sensitive: true}}, fields)
+	assert.Empty(t, d.table.entries)
+
+	// Test: C.2.4 indexed field
+	fields, err = d.Decode(unhex(t, "82"))
+	require.NoError(t, err)
+	assert.Equal(t, hf(":method", "GET"), fields)
+}
+
+func TestAppendixRequests(t *testing.T) {
+	// Test: C.3 requests without Huffman coding
+	runAppendix(t, "C.3", DefaultTableSize, []string{
+		"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+		"8286 84be 5808 6e6f 2d63 6163 6865",
+		"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+	}, requestBlocks, requestTables, false)
+
+	// Test: C.4 requests with Huffman coding, which the encoder reproduces
+	runAppendix(t, "C.4", DefaultTableSize, []string{
+		"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+		"8286 84be 5886 a8eb 1064 9cbf",
+		"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+	}, requestBlocks, requestTables, true)
+}
+
+func TestAppendixResponses(t *testing.T) {
+	// Test: C.5 responses without Huffman coding, evicting from a 256-byte table
+	runAppendix(t, "C.5", 256, []string{
+		"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+		"4803 3330 37c1 c0bf",
+		"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+	}, responseBlocks, responseTables, false)
+
+	// Test: C.6 responses with Huffman coding, which the encoder reproduces
+	runAppendix(t, "C.6", 256, []string{
+		"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+		"4883 640e ffc1 c0bf",
+		"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+	}, responseBlocks, responseTables, true)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	d := NewDecoder(DefaultTableSize)
+
+	// Test: Index past the end of the dynamic table
+	_, err := d.Decode([]byte{0xbe})
+	assert.Error(t, err)
+
+	// Test: Size updates must come first and stay within the limit
+	_, err = d.Decode([]byte{0x82, 0x20})
+	assert.Error(t, err)
+	_, err = d.Decode([]byte{0x3f, 0xe2, 0x1f})
+	assert.Error(t, err)
+
+	// Test: Strings longer than the limit
+	d.MaxStringLength = 4
+	_, err = d.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	assert.Error(t, err)
+}
+
+func TestEncoder(t *testing.T) {
+	e := NewEncoder(DefaultTableSize)
+	d := NewDecoder(DefaultTableSize)
+
+	// Test: Sensitive fields are never indexed, by either end
+	fields := []HeaderField{
+		{Name: "authorization", Value: "Bearer secret", Sensitive: true},
+		{Name: "x-request-id", Value: "42"},
+	}
+	block := e.Encode(nil, fields)
+	assert.Equal(t, byte(0x10), block[0]&0xf0)
+	got, err := d.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, got)
+	assert.Equal(t, hf("x-request-id", "42"), newestFirst(e.table))
+	assert.Equal(t, hf("x-request-id", "42"), newestFirst(d.table))
+
+	// Test: Repeated fields shrink to one byte
+	assert.Len(t, e.Encode(nil, fields[1:]), 1)
+
+	// Test: Shrinking the table signals the smallest and the final size
+	d.SetMaxTableSize(DefaultTableSize)
+	e.SetMaxTableSize(0)
+	e.SetMaxTableSize(100)
+	block = e.Encode(nil, hf("x-request-id", "42"))
+	assert.Equal(t, []byte{0x20, 0x3f, 0x45}, block[:3])
+	got, err = d.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, hf("x-request-id", "42"), got)
+	assert.Equal(t, 100, d.table.maxSize)
+	assert.Equal(t, newestFirst(e.table), newestFirst(d.table))
+}
+
+func TestHeadersConversion(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html")
+	h.Set("Cookie", "a=1")
+	h.Set("Authorization", "Basic Zm9vOmJhcg==")
+
+	// Test: Fields are sorted and credentials marked sensitive
+	fields := FromHeaders(h)
+	assert.Equal(t, []HeaderField{
+		{Name: "authorization", Value: "Basic Zm9vOmJhcg==", Sensitive: true},
+		{Name: "content-type", Value: "text/html"},
+		{Name: "cookie", Value: "a=1", Sensitive: true},
+	}, fields)
+
+	// Test: Headers survive a round trip
+	block := NewEncoder(DefaultTableSize).EncodeHeaders(nil, h)
+	got, err := NewDecoder(DefaultTableSize).DecodeHeaders(block)
+	require.NoError(t, err)
+	assert.Equal(t, h, got)
+
+	// Test: Split cookies are joined with semicolons, other fields with commas
+	got = ToHeaders(hf("cookie", "a=1", "cookie", "b=2", "accept", "text/html", "accept", "*/*"))
+	assert.Equal(t, "a=1; b=2", got["cookie"])
+	assert.Equal(t, "text/html, */*", got["accept"])
+
+	// Test: Each Set-Cookie is a field of its own
+	h = headers.NewHeaders()
+	h.Set("Set-Cookie", "a=1")
+	h.Set("Set-Cookie", "b=2")
+	assert.Equal(t, hf("set-cookie", "a=1", "set-cookie", "b=2"), FromHeaders(h))
+	assert.Equal(t, h, ToHeaders(FromHeaders(h)))
+}
@@ -0,0 +1,97 @@
+package hpack
+
+import (
+	"strings"
+	"sync"
+)
+
+// huffmanNode is a node of the decoding tree. Leaves have no children and
+// hold the decoded byte.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		node := huffmanRoot
+		for bit := int(huffmanCodeLengths[sym]) - 1; bit >= 0; bit-- {
+			b := code >> uint(bit) & 1
+			if node.children[b] == nil {
+				node.children[b] = &huffmanNode{}
+			}
+			node = node.children[b]
+		}
+		node.sym = byte(sym)
+	}
+}
+
+// HuffmanDecode decodes a Huffman-coded string literal. The input must end
+// in at most seven bits of padding, all ones, as RFC 7541 section 5.2
+// requires.
+func HuffmanDecode(data []byte) (string, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+	var sb strings.Builder
+	node := huffmanRoot
+	// depth and ones track the bits read since the last complete symbol,
+	// which must all be padding once the input runs out.
+	depth, ones := 0, true
+	for _, b := range data {
+		for bit := 7; bit >= 0; bit-- {
+			v := b >> uint(bit) & 1
+			node = node.children[v]
+			if node == nil {
+				// only the 30-bit end-of-string code leads nowhere
+				return "", ErrInvalidHuffman
+			}
+			depth++
+			ones = ones && v == 1
+			if node.children[0] == nil {
+				sb.WriteByte(node.sym)
+				node, depth, ones = huffmanRoot, 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return "", ErrInvalidHuffman
+	}
+	return sb.String(), nil
+}
+
+// HuffmanEncodedLen returns the length of s once Huffman-coded.
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLengths[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// AppendHuffman appends the Huffman coding of s, padded to a whole byte
+// with the most significant bits of the end-of-string code.
+func AppendHuffman(dst []byte, s string) []byte {
+	// acc holds the pending bits in its low n bits; higher bits have
+	// already been emitted
+	var acc uint64
+	n := uint(0)
+	for i := 0; i < len(s); i++ {
+		length := uint(huffmanCodeLengths[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		n += length
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		pad := 8 - n
+		dst = append(dst, byte(acc<<pad|(1<<pad-1)))
+	}
+	return dst
+}
@@ -0,0 +1,146 @@
+package hpack
+
+import "fmt"
+
+// staticTable is the predefined table of RFC 7541 appendix A. Index 1 is
+// the first entry.
+var staticTable = []HeaderField{
+	{Name: ":authority", Value: ""},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset", Value: ""},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language", Value: ""},
+	{Name: "accept-ranges", Value: ""},
+	{Name: "accept", Value: ""},
+	{Name: "access-control-allow-origin", Value: ""},
+	{Name: "age", Value: ""},
+	{Name: "allow", Value: ""},
+	{Name: "authorization", Value: ""},
+	{Name: "cache-control", Value: ""},
+	{Name: "content-disposition", Value: ""},
+	{Name: "content-encoding", Value: ""},
+	{Name: "content-language", Value: ""},
+	{Name: "content-length", Value: ""},
+	{Name: "content-location", Value: ""},
+	{Name: "content-range", Value: ""},
+	{Name: "content-type", Value: ""},
+	{Name: "cookie", Value: ""},
+	{Name: "date", Value: ""},
+	{Name: "etag", Value: ""},
+	{Name: "expect", Value: ""},
+	{Name: "expires", Value: ""},
+	{Name: "from", Value: ""},
+	{Name: "host", Value: ""},
+	{Name: "if-match", Value: ""},
+	{Name: "if-modified-since", Value: ""},
+	{Name: "if-none-match", Value: ""},
+	{Name: "if-range", Value: ""},
+	{Name: "if-unmodified-since", Value: ""},
+	{Name: "last-modified", Value: ""},
+	{Name: "link", Value: ""},
+	{Name: "location", Value: ""},
+	{Name: "max-forwards", Value: ""},
+	{Name: "proxy-authenticate", Value: ""},
+	{Name: "proxy-authorization", Value: ""},
+	{Name: "range", Value: ""},
+	{Name: "referer", Value: ""},
+	{Name: "refresh", Value: ""},
+	{Name: "retry-after", Value: ""},
+	{Name: "server", Value: ""},
+	{Name: "set-cookie", Value: ""},
+	{Name: "strict-transport-security", Value: ""},
+	{Name: "transfer-encoding", Value: ""},
+	{Name: "user-agent", Value: ""},
+	{Name: "vary", Value: ""},
+	{Name: "via", Value: ""},
+	{Name: "www-authenticate", Value: ""},
+}
+
+// dynamicTable is the FIFO table of fields added by earlier header blocks.
+// The most recently added field has the lowest index.
+type dynamicTable struct {
+	// entries holds the fields oldest first.
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append(t.entries, f)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+// evict drops the oldest fields until the table fits. A field larger than
+// the whole table empties it.
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize {
+		t.size -= t.entries[drop].Size()
+		drop++
+	}
+	if drop > 0 {
+		t.entries = append(t.entries[:0], t.entries[drop:]...)
+	}
+}
+
+// field returns the field at index i of the combined index space, where the
+// dynamic table follows the static one.
+func (t *dynamicTable) field(i int) (HeaderField, error) {
+	if i >= 1 && i <= len(staticTable) {
+		return staticTable[i-1], nil
+	}
+	d := i - len(staticTable)
+	if i < 1 || d > len(t.entries) {
+		return HeaderField{}, fmt.Errorf("hpack: invalid table index %d", i)
+	}
+	return t.entries[len(t.entries)-d], nil
+}
+
+// search looks f up in both tables. It returns the index of an entry that
+// matches f exactly, or failing that of one with the same name, and 0 when
+// there is neither.
+func (t *dynamicTable) search(f HeaderField) (index int, exact bool) {
+	for i, e := range staticTable {
+		if e.Name != f.Name {
+			continue
+		}
+		if e.Value == f.Value {
+			return i + 1, true
+		}
+		if index == 0 {
+			index = i + 1
+		}
+	}
+	for j := len(t.entries) - 1; j >= 0; j-- {
+		e := t.entries[j]
+		if e.Name != f.Name {
+			continue
+		}
+		i := len(staticTable) + len(t.entries) - j
+		if e.Value == f.Value {
+			return i, true
+		}
+		if index == 0 {
+			index = i
+		}
+	}
+	return index, false
+}
@@ -0,0 +1,80 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCode is the error code carried by RST_STREAM and GOAWAY frames.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// ConnectionError is an error that ends the whole connection with a GOAWAY.
+type ConnectionError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnectionError) Error() string {
+	return fmt.Sprintf("http2: connection error: %s: %s", e.Code, e.Reason)
+}
+
+// StreamError is an error that ends a single stream with a RST_STREAM.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error: %s: %s", e.StreamID, e.Code, e.Reason)
+}
+
+var (
+	// ErrStreamReset is returned by response writes once the stream has
+	// been reset by either end.
+	ErrStreamReset = errors.New("http2: stream reset")
+	// ErrConnClosed is returned by response writes once the connection
+	// has gone away.
+	ErrConnClosed = errors.New("http2: connection closed")
+)
@@ -0,0 +1,260 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+var frameNames = map[FrameType]string{
+	FrameData:         "DATA",
+	FrameHeaders:      "HEADERS",
+	FramePriority:     "PRIORITY",
+	FrameRSTStream:    "RST_STREAM",
+	FrameSettings:     "SETTINGS",
+	FramePushPromise:  "PUSH_PROMISE",
+	FramePing:         "PING",
+	FrameGoAway:       "GOAWAY",
+	FrameWindowUpdate: "WINDOW_UPDATE",
+	FrameContinuation: "CONTINUATION",
+}
+
+func (t FrameType) String() string {
+	if name, ok := frameNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+// Flags are the frame flags. Their meaning depends on the frame type, so
+// several share a bit.
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+const (
+	frameHeaderLen = 9
+	// defaultMaxFrameSize is the largest frame payload either end may send
+	// until the other has raised it.
+	defaultMaxFrameSize = 16384
+	maxFrameSizeLimit   = 1<<24 - 1
+	// defaultWindowSize is the initial flow-control window of streams and
+	// of the connection.
+	defaultWindowSize = 65535
+	maxWindowSize     = 1<<31 - 1
+)
+
+type FrameHeader struct {
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+}
+
+// Frame is a frame as read off the wire. Payload is only valid until the
+// next call to ReadFrame.
+type Frame struct {
+	FrameHeader
+	Payload []byte
+}
+
+// Framer reads and writes frames. Reads and writes may happen
+// concurrently, but writes must be serialized by the caller.
+type Framer struct {
+	// MaxReadSize is the largest payload ReadFrame accepts, the
+	// SETTINGS_MAX_FRAME_SIZE advertised to the peer.
+	MaxReadSize uint32
+
+	reader io.Reader
+	writer io.Writer
+	header [frameHeaderLen]byte
+	buf    []byte
+	wbuf   []byte
+}
+
+func NewFramer(w io.Writer, r io.Reader) *Framer {
+	return &Framer{
+		MaxReadSize: defaultMaxFrameSize,
+		reader:      r,
+		writer:      w,
+	}
+}
+
+// ReadFrame reads the next frame. A frame larger than MaxReadSize is a
+// ConnectionError of type FRAME_SIZE_ERROR.
+func (f *Framer) ReadFrame() (*Frame, error) {
+	if _, err := io.ReadFull(f.reader, f.header[:]); err != nil {
+		return nil, err
+	}
+	fh := FrameHeader{
+		Length:   uint32(f.header[0])<<16 | uint32(f.header[1])<<8 | uint32(f.header[2]),
+		Type:     FrameType(f.header[3]),
+		Flags:    Flags(f.header[4]),
+		StreamID: binary.BigEndian.Uint32(f.header[5:]) & (1<<31 - 1),
+	}
+	if fh.Length > f.MaxReadSize {
+		return nil, ConnectionError{ErrCodeFrameSize, fmt.Sprintf("%s frame of %d bytes", fh.Type, fh.Length)}
+	}
+	if uint32(cap(f.buf)) < fh.Length {
+		f.buf = make([]byte, fh.Length)
+	}
+	payload := f.buf[:fh.Length]
+	if _, err := io.ReadFull(f.reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Frame{FrameHeader: fh, Payload: payload}, nil
+}
+
+// WriteFrame writes a frame with a single call to the underlying writer.
+func (f *Framer) WriteFrame(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	f.wbuf = append(f.wbuf[:0],
+		byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)),
+		byte(t), byte(flags))
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, streamID)
+	f.wbuf = append(f.wbuf, payload...)
+	_, err := f.writer.Write(f.wbuf)
+	return err
+}
+
+func (f *Framer) WriteData(streamID uint32, endStream bool, data []byte) error {
+	var flags Flags
+	if endStream {
+		flags |= FlagEndStream
+	}
+	return f.WriteFrame(FrameData, flags, streamID, data)
+}
+
+// WriteHeaders writes a header block, split into a HEADERS frame followed
+// by CONTINUATION frames when it does not fit in maxFrameSize.
+func (f *Framer) WriteHeaders(streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	t := FrameHeaders
+	var flags Flags
+	if endStream {
+		flags |= FlagEndStream
+	}
+	for {
+		fragment := block
+		if uint32(len(fragment)) > maxFrameSize {
+			fragment = block[:maxFrameSize]
+		}
+		block = block[len(fragment):]
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		if err := f.WriteFrame(t, flags, streamID, fragment); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		t, flags = FrameContinuation, 0
+	}
+}
+
+func (f *Framer) WriteSettings(settings ...Setting) error {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return f.WriteFrame(FrameSettings, 0, 0, payload)
+}
+
+func (f *Framer) WriteSettingsAck() error {
+	return f.WriteFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (f *Framer) WritePing(ack bool, data [8]byte) error {
+	var flags Flags
+	if ack {
+		flags |= FlagAck
+	}
+	return f.WriteFrame(FramePing, flags, 0, data[:])
+}
+
+func (f *Framer) WriteGoAway(lastStreamID uint32, code ErrCode, debug []byte) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, debug...)
+	return f.WriteFrame(FrameGoAway, 0, 0, payload)
+}
+
+func (f *Framer) WriteRSTStream(streamID uint32, code ErrCode) error {
+	return f.WriteFrame(FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (f *Framer) WriteWindowUpdate(streamID, increment uint32) error {
+	return f.WriteFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// parseSettings splits a SETTINGS payload into its parameters.
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnectionError{ErrCodeFrameSize, "SETTINGS payload is not a multiple of 6 bytes"}
+	}
+	settings := make([]Setting, 0, len(payload)/6)
+	for ; len(payload) > 0; payload = payload[6:] {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload)),
+			Value: binary.BigEndian.Uint32(payload[2:]),
+		})
+	}
+	return settings, nil
+}
+
+// stripPadding removes the pad length byte and padding of a DATA or
+// HEADERS frame with the PADDED flag.
+func stripPadding(f *Frame) ([]byte, error) {
+	payload := f.Payload
+	if !f.Flags.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, ConnectionError{ErrCodeProtocol, fmt.Sprintf("%s frame padding exceeds payload", f.Type)}
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
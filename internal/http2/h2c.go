@@ -0,0 +1,68 @@
+package http2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+)
+
+const switchingProtocols = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"
+
+// IsUpgradeRequest reports whether req asks to switch its connection to
+// HTTP/2 with "Upgrade: h2c" and carries a well-formed HTTP2-Settings
+// field. Requests with a malformed one can simply be served over HTTP/1.1.
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+	if !hasToken(upgrade, "h2c") {
+		return false
+	}
+	_, err := upgradeSettings(req)
+	return err == nil
+}
+
+// ServeUpgrade switches conn to HTTP/2 after an HTTP/1.1 request for which
+// IsUpgradeRequest holds, and answers that request on stream 1. readAhead
+// holds the bytes read from conn past the end of the request.
+func (s *Server) ServeUpgrade(conn net.Conn, readAhead []byte, req *request.Request) error {
+	settings, err := upgradeSettings(req)
+	if err != nil {
+		return err
+	}
+	sc := s.newConn(conn, readAhead)
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte(switchingProtocols)); err != nil {
+		return err
+	}
+	for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Remove(name)
+	}
+	return sc.serve(req)
+}
+
+// upgradeSettings decodes the HTTP2-Settings field, a base64url SETTINGS
+// payload.
+func upgradeSettings(req *request.Request) ([]Setting, error) {
+	value, ok := req.Headers.Get("HTTP2-Settings")
+	if !ok || strings.Contains(value, ",") {
+		return nil, fmt.Errorf("want exactly one HTTP2-Settings field")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed HTTP2-Settings: %w", err)
+	}
+	return parseSettings(payload)
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
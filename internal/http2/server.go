@@ -0,0 +1,652 @@
+// Package http2 serves HTTP/2 over cleartext TCP connections (h2c), as
+// described in RFC 9113. Each stream is handed to a handler with the same
+// shape as server.Handler, writing through a response.Writer whose parts
+// become HEADERS and DATA frames.
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// ClientPreface opens every HTTP/2 connection. A server that sees it where
+// an HTTP/1.1 request line would start is talking to a client with prior
+// knowledge of HTTP/2.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// DefaultMaxConcurrentStreams is the stream limit advertised when the
+// Server does not set one.
+const DefaultMaxConcurrentStreams = 100
+
+// maxHeaderBlockSize bounds a header block spread over CONTINUATION frames.
+const maxHeaderBlockSize = 1 << 20
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type Server struct {
+	Handler Handler
+	// MaxConcurrentStreams limits the number of streams a client may have
+	// open at once. Zero means DefaultMaxConcurrentStreams.
+	MaxConcurrentStreams uint32
+}
+
+// ServeConn serves HTTP/2 on conn, whose client opened with the connection
+// preface. readAhead holds bytes already read from conn, starting with the
+// preface. It returns once the client has gone and every handler has
+// returned, leaving conn closed.
+func (s *Server) ServeConn(conn net.Conn, readAhead []byte) error {
+	return s.newConn(conn, readAhead).serve(nil)
+}
+
+type streamState int
+
+const (
+	stateOpen streamState = iota
+	stateHalfClosedRemote
+)
+
+type stream struct {
+	id uint32
+
+	// owned by the read loop
+	state         streamState
+	recvWindow    int64
+	req           *request.Request
+	contentLength int
+
+	// guarded by serverConn.mu
+	sendWindow int64
+	reset      bool
+}
+
+// headerBlock collects a header block that continues in CONTINUATION frames.
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+}
+
+type serverConn struct {
+	srv        *Server
+	conn       net.Conn
+	reader     *bufio.Reader
+	framer     *Framer
+	maxStreams uint32
+
+	// owned by the read loop
+	dec         *hpack.Decoder
+	pending     *headerBlock
+	maxStreamID uint32
+	recvWindow  int64
+	sawSettings bool
+
+	// writeMu serializes frames, and with them the encoder state
+	writeMu sync.Mutex
+	enc     *hpack.Encoder
+	hbuf    []byte
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+
+	handlers sync.WaitGroup
+}
+
+func (s *Server) newConn(conn net.Conn, readAhead []byte) *serverConn {
+	var r io.Reader = conn
+	if len(readAhead) > 0 {
+		r = io.MultiReader(bytes.NewReader(bytes.Clone(readAhead)), conn)
+	}
+	reader := bufio.NewReader(r)
+	maxStreams := s.MaxConcurrentStreams
+	if maxStreams == 0 {
+		maxStreams = DefaultMaxConcurrentStreams
+	}
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		reader:            reader,
+		framer:            NewFramer(conn, reader),
+		maxStreams:        maxStreams,
+		dec:               hpack.NewDecoder(hpack.DefaultTableSize),
+		recvWindow:        defaultWindowSize,
+		enc:               hpack.NewEncoder(hpack.DefaultTableSize),
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// serve runs the read loop. upgrade is the HTTP/1.1 request that switched
+// the connection to HTTP/2, answered on stream 1, or nil.
+func (sc *serverConn) serve(upgrade *request.Request) error {
+	err := sc.writeFrame(func(fr *Framer) error {
+		return fr.WriteSettings(Setting{SettingMaxConcurrentStreams, sc.maxStreams})
+	})
+	if err == nil {
+		err = sc.readLoop(upgrade)
+	}
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	var connErr ConnectionError
+	if errors.As(err, &connErr) {
+		// nothing more may be sent, so handlers should fail fast
+		sc.conn.Close()
+	}
+	sc.handlers.Wait()
+	sc.conn.Close()
+	return err
+}
+
+func (sc *serverConn) readLoop(upgrade *request.Request) error {
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return fmt.Errorf("reading connection preface: %w", err)
+	}
+	if string(preface) != ClientPreface {
+		return sc.goAway(ConnectionError{ErrCodeProtocol, "invalid connection preface"})
+	}
+	if upgrade != nil {
+		st := &stream{id: 1, req: upgrade, contentLength: -1}
+		sc.maxStreamID = 1
+		sc.addStream(st)
+		sc.startHandler(st)
+	}
+	for {
+		f, err := sc.framer.ReadFrame()
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		if err == nil {
+			continue
+		}
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			if err := sc.resetStream(streamErr); err != nil {
+				return err
+			}
+			continue
+		}
+		var connErr ConnectionError
+		if errors.As(err, &connErr) {
+			return sc.goAway(connErr)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.pending != nil && (f.Type != FrameContinuation || f.StreamID != sc.pending.streamID) {
+		return ConnectionError{ErrCodeProtocol, fmt.Sprintf("%s frame inside a header block", f.Type)}
+	}
+	if !sc.sawSettings && (f.Type != FrameSettings || f.Flags.Has(FlagAck)) {
+		return ConnectionError{ErrCodeProtocol, "first frame is not SETTINGS"}
+	}
+	switch f.Type {
+	case FrameData:
+		return sc.processData(f)
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnectionError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if f.Length != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY payload is not 5 bytes"}
+		}
+		// stream priorities are advisory and not used
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePushPromise:
+		return ConnectionError{ErrCodeProtocol, "PUSH_PROMISE from a client"}
+	case FramePing:
+		if f.StreamID != 0 {
+			return ConnectionError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if f.Length != 8 {
+			return ConnectionError{ErrCodeFrameSize, "PING payload is not 8 bytes"}
+		}
+		if f.Flags.Has(FlagAck) {
+			return nil
+		}
+		data := [8]byte(f.Payload)
+		return sc.writeFrame(func(fr *Framer) error { return fr.WritePing(true, data) })
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnectionError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		if f.Length < 8 {
+			return ConnectionError{ErrCodeFrameSize, "GOAWAY payload shorter than 8 bytes"}
+		}
+		// the server never opens streams, so there is nothing to stop;
+		// the client closes the connection once its streams are done
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// unknown frame types must be ignored
+		return nil
+	}
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnectionError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	n := int64(f.Length)
+	sc.recvWindow -= n
+	if sc.recvWindow < 0 {
+		return ConnectionError{ErrCodeFlowControl, "connection flow-control window exceeded"}
+	}
+	if n > 0 {
+		// bodies are buffered in full, so the window is given back as soon
+		// as the data arrives
+		sc.recvWindow += n
+		err := sc.writeFrame(func(fr *Framer) error { return fr.WriteWindowUpdate(0, uint32(n)) })
+		if err != nil {
+			return err
+		}
+	}
+	st := sc.stream(f.StreamID)
+	if st == nil {
+		if f.StreamID > sc.maxStreamID {
+			return ConnectionError{ErrCodeProtocol, fmt.Sprintf("DATA on idle stream %d", f.StreamID)}
+		}
+		// the stream was reset and the peer has not noticed yet
+		return nil
+	}
+	if st.state != stateOpen {
+		return StreamError{st.id, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	}
+	st.recvWindow -= n
+	if st.recvWindow < 0 {
+		return StreamError{st.id, ErrCodeFlowControl, "stream flow-control window exceeded"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	st.req.Body = append(st.req.Body, data...)
+	if f.Flags.Has(FlagEndStream) {
+		return sc.endRequest(st)
+	}
+	if n > 0 {
+		st.recvWindow += n
+		return sc.writeFrame(func(fr *Framer) error { return fr.WriteWindowUpdate(st.id, uint32(n)) })
+	}
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnectionError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	payload, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	if f.Flags.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return ConnectionError{ErrCodeFrameSize, "HEADERS priority fields truncated"}
+		}
+		payload = payload[5:]
+	}
+	if sc.stream(f.StreamID) == nil {
+		if f.StreamID%2 == 0 {
+			return ConnectionError{ErrCodeProtocol, fmt.Sprintf("client opened even stream %d", f.StreamID)}
+		}
+		if f.StreamID <= sc.maxStreamID {
+			return ConnectionError{ErrCodeStreamClosed, fmt.Sprintf("HEADERS on closed stream %d", f.StreamID)}
+		}
+		sc.maxStreamID = f.StreamID
+	}
+	sc.pending = &headerBlock{
+		streamID:  f.StreamID,
+		endStream: f.Flags.Has(FlagEndStream),
+		block:     bytes.Clone(payload),
+	}
+	if f.Flags.Has(FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+	return nil
+}
+
+func (sc *serverConn) processContinuation(f *Frame) error {
+	if sc.pending == nil {
+		return ConnectionError{ErrCodeProtocol, "CONTINUATION without a header block"}
+	}
+	sc.pending.block = append(sc.pending.block, f.Payload...)
+	if len(sc.pending.block) > maxHeaderBlockSize {
+		return ConnectionError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if f.Flags.Has(FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+	return nil
+}
+
+// endHeaders decodes a complete header block. Every block is decoded, even
+// for streams about to be refused, to keep the decoder in step with the
+// peer's encoder.
+func (sc *serverConn) endHeaders() error {
+	hb := sc.pending
+	sc.pending = nil
+	fields, err := sc.dec.Decode(hb.block)
+	if err != nil {
+		return ConnectionError{ErrCodeCompression, err.Error()}
+	}
+	if st := sc.stream(hb.streamID); st != nil {
+		if st.state != stateOpen {
+			return StreamError{st.id, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+		}
+		if !hb.endStream {
+			return StreamError{st.id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		if err := addTrailers(st.req, fields); err != nil {
+			return StreamError{st.id, ErrCodeProtocol, err.Error()}
+		}
+		return sc.endRequest(st)
+	}
+	sc.mu.Lock()
+	active := len(sc.streams)
+	sc.mu.Unlock()
+	if active >= int(sc.maxStreams) {
+		return StreamError{hb.streamID, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	req, contentLength, err := newRequest(fields)
+	if err != nil {
+		return StreamError{hb.streamID, ErrCodeProtocol, err.Error()}
+	}
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	st := &stream{
+		id:            hb.streamID,
+		recvWindow:    defaultWindowSize,
+		req:           req,
+		contentLength: contentLength,
+	}
+	sc.addStream(st)
+	if hb.endStream {
+		return sc.endRequest(st)
+	}
+	return nil
+}
+
+// endRequest runs the handler once the client has sent the whole request.
+func (sc *serverConn) endRequest(st *stream) error {
+	if st.contentLength >= 0 && st.contentLength != len(st.req.Body) {
+		return StreamError{st.id, ErrCodeProtocol, "body length does not match content-length"}
+	}
+	sc.startHandler(st)
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnectionError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if f.Length != 4 {
+		return ConnectionError{ErrCodeFrameSize, "RST_STREAM payload is not 4 bytes"}
+	}
+	if f.StreamID > sc.maxStreamID {
+		return ConnectionError{ErrCodeProtocol, fmt.Sprintf("RST_STREAM on idle stream %d", f.StreamID)}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st, ok := sc.streams[f.StreamID]; ok {
+		st.reset = true
+		delete(sc.streams, f.StreamID)
+		sc.cond.Broadcast()
+	}
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnectionError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Flags.Has(FlagAck) {
+		if f.Length != 0 {
+			return ConnectionError{ErrCodeFrameSize, "SETTINGS acknowledgement with a payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	sc.sawSettings = true
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(func(fr *Framer) error { return fr.WriteSettingsAck() })
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	for _, s := range settings {
+		if s.ID == SettingHeaderTableSize {
+			// the peer's decoder can hold this much; more than the default
+			// is not worth the memory
+			sc.writeMu.Lock()
+			sc.enc.SetMaxTableSize(min(int(s.Value), hpack.DefaultTableSize))
+			sc.writeMu.Unlock()
+		}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	defer sc.cond.Broadcast()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return ConnectionError{ErrCodeProtocol, "SETTINGS_ENABLE_PUSH is not 0 or 1"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return ConnectionError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			// the change applies to the windows of open streams too
+			delta := int64(s.Value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnectionError{ErrCodeFlowControl, "stream flow-control window overflow"}
+				}
+			}
+		case SettingMaxFrameSize:
+			if s.Value < defaultMaxFrameSize || s.Value > maxFrameSizeLimit {
+				return ConnectionError{ErrCodeProtocol, "SETTINGS_MAX_FRAME_SIZE out of range"}
+			}
+			sc.peerMaxFrameSize = s.Value
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if f.Length != 4 {
+		return ConnectionError{ErrCodeFrameSize, "WINDOW_UPDATE payload is not 4 bytes"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & (1<<31 - 1))
+	if f.StreamID == 0 {
+		if increment == 0 {
+			return ConnectionError{ErrCodeProtocol, "WINDOW_UPDATE with zero increment"}
+		}
+	} else {
+		if f.StreamID > sc.maxStreamID {
+			return ConnectionError{ErrCodeProtocol, fmt.Sprintf("WINDOW_UPDATE on idle stream %d", f.StreamID)}
+		}
+		if increment == 0 {
+			return StreamError{f.StreamID, ErrCodeProtocol, "WINDOW_UPDATE with zero increment"}
+		}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	defer sc.cond.Broadcast()
+	if f.StreamID == 0 {
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return ConnectionError{ErrCodeFlowControl, "connection flow-control window overflow"}
+		}
+		return nil
+	}
+	st, ok := sc.streams[f.StreamID]
+	if !ok {
+		return nil
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return StreamError{st.id, ErrCodeFlowControl, "stream flow-control window overflow"}
+	}
+	return nil
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+func (sc *serverConn) addStream(st *stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st.sendWindow = sc.peerInitialWindow
+	sc.streams[st.id] = st
+}
+
+func (sc *serverConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.streams, st.id)
+}
+
+// resetStream ends a stream with RST_STREAM. A handler still writing to it
+// gets ErrStreamReset.
+func (sc *serverConn) resetStream(se StreamError) error {
+	sc.mu.Lock()
+	if st, ok := sc.streams[se.StreamID]; ok {
+		st.reset = true
+		delete(sc.streams, se.StreamID)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	return sc.writeFrame(func(fr *Framer) error { return fr.WriteRSTStream(se.StreamID, se.Code) })
+}
+
+// goAway tells the client the connection is ending because of err, and
+// returns err.
+func (sc *serverConn) goAway(err ConnectionError) error {
+	sc.writeFrame(func(fr *Framer) error {
+		return fr.WriteGoAway(sc.maxStreamID, err.Code, []byte(err.Reason))
+	})
+	return err
+}
+
+func (sc *serverConn) writeFrame(write func(fr *Framer) error) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	return write(sc.framer)
+}
+
+func (sc *serverConn) startHandler(st *stream) {
+	st.state = stateHalfClosedRemote
+	sc.handlers.Add(1)
+	go sc.runHandler(st)
+}
+
+func (sc *serverConn) runHandler(st *stream) {
+	defer sc.handlers.Done()
+	f := &streamFramer{sc: sc, st: st}
+	if st.req.RequestLine.Method == "HEAD" {
+		head := response.NewHeadFramer(f)
+		sc.srv.Handler(response.NewFramedWriter(head), st.req)
+		if err := head.Finish(); err != nil {
+			log.Printf("Error writing HEAD response headers: %v", err)
+		}
+	} else {
+		sc.srv.Handler(response.NewFramedWriter(f), st.req)
+	}
+	err := f.finish()
+	if err != nil && !errors.Is(err, ErrStreamReset) && !errors.Is(err, ErrConnClosed) {
+		log.Printf("Error ending stream %d: %v", st.id, err)
+	}
+	sc.closeStream(st)
+}
+
+// streamErr reports why nothing more can be sent on st, if anything.
+func (sc *serverConn) streamErr(st *stream) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return ErrConnClosed
+	}
+	if st.reset {
+		return ErrStreamReset
+	}
+	return nil
+}
+
+// writeHeaders encodes fields and sends them as one header block on st.
+func (sc *serverConn) writeHeaders(st *stream, fields []hpack.HeaderField, endStream bool) error {
+	if err := sc.streamErr(st); err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	maxFrameSize := sc.peerMaxFrameSize
+	sc.mu.Unlock()
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.hbuf = sc.enc.Encode(sc.hbuf[:0], fields)
+	return sc.framer.WriteHeaders(st.id, endStream, sc.hbuf, maxFrameSize)
+}
+
+// reserveWindow waits until st may send data and takes up to want bytes
+// of both the stream and the connection window, limited to one frame.
+func (sc *serverConn) reserveWindow(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if sc.closed {
+			return 0, ErrConnClosed
+		}
+		if st.reset {
+			return 0, ErrStreamReset
+		}
+		if st.sendWindow > 0 && sc.sendWindow > 0 {
+			break
+		}
+		sc.cond.Wait()
+	}
+	n := min(int64(want), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize))
+	st.sendWindow -= n
+	sc.sendWindow -= n
+	return int(n), nil
+}
@@ -0,0 +1,385 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen serves HTTP/2 with prior knowledge on a loopback listener and
+// counts the connections it accepts.
+func listen(t *testing.T, handler Handler) (addr string, conns *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	conns = &atomic.Int32{}
+	s := &Server{Handler: handler}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go s.ServeConn(conn, nil)
+		}
+	}()
+	return l.Addr().String(), conns
+}
+
+func h2cClient(t *testing.T) *http.Client {
+	t.Helper()
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	t.Cleanup(tr.CloseIdleConnections)
+	return &http.Client{Transport: tr}
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := response.GetDefaultHeaders(len(req.Body))
+	h.Set("X-Method", req.RequestLine.Method)
+	h.Set("X-Target", req.RequestLine.RequestTarget)
+	host, _ := req.Headers.Get("Host")
+	h.Set("X-Host", host)
+	w.WriteHeaders(h)
+	w.WriteBody(req.Body)
+}
+
+func TestPriorKnowledge(t *testing.T) {
+	addr, _ := listen(t, echoHandler)
+	client := h2cClient(t)
+
+	// Test: Request line, host and body reach the handler
+	resp, err := client.Post("http://"+addr+"/echo?x=1", "text/plain", strings.NewReader("ping"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ping", string(body))
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	assert.Equal(t, "/echo?x=1", resp.Header.Get("X-Target"))
+	assert.Equal(t, addr, resp.Header.Get("X-Host"))
+	assert.Equal(t, "4", resp.Header.Get("Content-Length"))
+
+	// Test: Connection-specific headers are dropped
+	assert.Empty(t, resp.Header.Get("Connection"))
+
+	// Test: HEAD gets headers only
+	resp, err = client.Head("http://" + addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HEAD", resp.Header.Get("X-Method"))
+}
+
+func TestStreamTrailers(t *testing.T) {
+	addr, _ := listen(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Content-Length")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Content-Length", "11")
+		w.WriteTrailers(trailers)
+	})
+
+	// Test: Chunked bodies become DATA frames and trailers a final HEADERS
+	resp, err := h2cClient(t).Get("http://" + addr + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "hello world", string(body))
+	assert.Empty(t, resp.Header.Get("Transfer-Encoding"))
+	assert.Equal(t, "11", resp.Trailer.Get("X-Content-Length"))
+}
+
+func TestMultiplexing(t *testing.T) {
+	// Test: Large bodies are paced by flow control on both sides
+	big := strings.Repeat("0123456789abcdef", 64<<10)
+	addr, conns := listen(t, func(w *response.Writer, req *request.Request) {
+		body := big
+		if len(req.Body) > 0 {
+			body = strconv.Itoa(len(req.Body))
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	client := h2cClient(t)
+	resp, err := client.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp *http.Response
+			var err error
+			if i%2 == 0 {
+				resp, err = client.Get("http://" + addr + "/")
+			} else {
+				resp, err = client.Post("http://"+addr+"/", "text/plain", strings.NewReader(big))
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if i%2 == 0 {
+				assert.Equal(t, len(big), len(body))
+			} else {
+				assert.Equal(t, strconv.Itoa(len(big)), string(body))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Test: All streams shared one connection
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+// rawConn speaks frames directly to a server connection.
+type rawConn struct {
+	t      *testing.T
+	conn   net.Conn
+	framer *Framer
+	enc    *hpack.Encoder
+	dec    *hpack.Decoder
+}
+
+func dialRaw(t *testing.T, handler Handler) *rawConn {
+	t.Helper()
+	addr, _ := listen(t, handler)
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	c := &rawConn{
+		t:      t,
+		conn:   client,
+		framer: NewFramer(client, client),
+		enc:    hpack.NewEncoder(hpack.DefaultTableSize),
+		dec:    hpack.NewDecoder(hpack.DefaultTableSize),
+	}
+	io.WriteString(client, ClientPreface)
+	c.framer.WriteSettings()
+	// server SETTINGS, then the acknowledgement of ours
+	f := c.read()
+	require.Equal(t, FrameSettings, f.Type)
+	f = c.read()
+	require.Equal(t, FrameSettings, f.Type)
+	require.True(t, f.Flags.Has(FlagAck))
+	return c
+}
+
+func (c *rawConn) read() *Frame {
+	c.t.Helper()
+	f, err := c.framer.ReadFrame()
+	require.NoError(c.t, err)
+	return f
+}
+
+func (c *rawConn) write(t FrameType, flags Flags, streamID uint32, payload []byte) {
+	require.NoError(c.t, c.framer.WriteFrame(t, flags, streamID, payload))
+}
+
+func (c *rawConn) headers(streamID uint32, endStream bool, fields ...string) {
+	var hf []hpack.HeaderField
+	for i := 0; i < len(fields); i += 2 {
+		hf = append(hf, hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	flags := FlagEndHeaders
+	if endStream {
+		flags |= FlagEndStream
+	}
+	c.write(FrameHeaders, flags, streamID, c.enc.Encode(nil, hf))
+}
+
+func (c *rawConn) expectGoAway(code ErrCode) {
+	c.t.Helper()
+	f := c.read()
+	require.Equal(c.t, FrameGoAway, f.Type)
+	assert.Equal(c.t, code, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
+
+func TestControlFrames(t *testing.T) {
+	// Test: PING is echoed with ACK
+	c := dialRaw(t, echoHandler)
+	c.write(FramePing, 0, 0, []byte("12345678"))
+	f := c.read()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Flags.Has(FlagAck))
+	assert.Equal(t, "12345678", string(f.Payload))
+
+	// Test: Zero connection window increment ends the connection
+	c.write(FrameWindowUpdate, 0, 0, []byte{0, 0, 0, 0})
+	c.expectGoAway(ErrCodeProtocol)
+
+	// Test: Client streams must be odd
+	c = dialRaw(t, echoHandler)
+	c.headers(2, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	c.expectGoAway(ErrCodeProtocol)
+
+	// Test: Oversized frames
+	c = dialRaw(t, echoHandler)
+	c.write(FrameData, 0, 1, make([]byte, defaultMaxFrameSize+1))
+	c.expectGoAway(ErrCodeFrameSize)
+
+	// Test: Interleaving a header block is a protocol error
+	c = dialRaw(t, echoHandler)
+	c.write(FrameHeaders, 0, 1, c.enc.Encode(nil, []hpack.HeaderField{{Name: ":method", Value: "GET"}}))
+	c.write(FramePing, 0, 0, []byte("12345678"))
+	c.expectGoAway(ErrCodeProtocol)
+
+	// Test: Malformed requests reset only their stream
+	c = dialRaw(t, echoHandler)
+	c.headers(1, true, ":method", "GET", ":path", "/")
+	f = c.read()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+	c.headers(3, true, ":method", "GET", ":scheme", "http", ":path", "/ok", "Connection", "close")
+	f = c.read()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+}
+
+func TestStreamReset(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	c := dialRaw(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		w.Flush()
+		close(started)
+		for {
+			if _, err := w.WriteChunkedBody([]byte("tick")); err != nil {
+				done <- err
+				return
+			}
+		}
+	})
+
+	// Test: Headers are flushed before any body
+	c.headers(1, true, ":method", "GET", ":scheme", "http", ":path", "/")
+	f := c.read()
+	require.Equal(t, FrameHeaders, f.Type)
+	fields, err := c.dec.Decode(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, hpack.HeaderField{Name: ":status", Value: "200"}, fields[0])
+	<-started
+
+	// Test: The handler stalls on the flow-control window, then fails once reset
+	received := 0
+	for received < defaultWindowSize {
+		f = c.read()
+		require.Equal(t, FrameData, f.Type)
+		received += len(f.Payload)
+	}
+	assert.Equal(t, defaultWindowSize, received)
+	c.write(FrameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	assert.ErrorIs(t, <-done, ErrStreamReset)
+}
+
+func TestUpgrade(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	var got *request.Request
+	go func() {
+		reader := request.NewReader(server)
+		req, err := reader.ReadRequest()
+		if err != nil || !IsUpgradeRequest(req) {
+			server.Close()
+			return
+		}
+		(&Server{Handler: func(w *response.Writer, req *request.Request) {
+			got = req
+			echoHandler(w, req)
+		}}).ServeUpgrade(server, reader.Buffered(), req)
+	}()
+
+	// SETTINGS_MAX_FRAME_SIZE = 16384, base64url without padding
+	settings := base64.RawURLEncoding.EncodeToString([]byte{0, 5, 0, 0, 0x40, 0})
+	go fmt.Fprintf(client, "POST /up HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: %s\r\nContent-Length: 5\r\n\r\nhello", settings)
+
+	// Test: The server switches protocols, then answers the request on stream 1
+	br := bufio.NewReader(client)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	framer := NewFramer(client, br)
+	go func() {
+		io.WriteString(client, ClientPreface)
+		framer.WriteSettings()
+	}()
+	f, err := framer.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, FrameSettings, f.Type)
+
+	dec := hpack.NewDecoder(hpack.DefaultTableSize)
+	var body []byte
+	for {
+		f, err = framer.ReadFrame()
+		require.NoError(t, err)
+		if f.Type == FrameSettings {
+			continue
+		}
+		assert.Equal(t, uint32(1), f.StreamID)
+		if f.Type == FrameHeaders {
+			fields, err := dec.Decode(f.Payload)
+			require.NoError(t, err)
+			assert.Contains(t, fields, hpack.HeaderField{Name: ":status", Value: "200"})
+			assert.Contains(t, fields, hpack.HeaderField{Name: "x-target", Value: "/up"})
+		}
+		if f.Type == FrameData {
+			body = append(body, f.Payload...)
+		}
+		if f.Flags.Has(FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, "hello", string(body))
+
+	// Test: The upgrade headers do not reach the handler
+	require.NotNil(t, got)
+	_, ok := got.Headers.Get("HTTP2-Settings")
+	assert.False(t, ok)
+}
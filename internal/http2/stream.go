@@ -0,0 +1,257 @@
+package http2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// connectionHeaders only make sense for a single HTTP/1.1 hop and are not
+// allowed in HTTP/2 messages.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// streamFramer writes a response as frames on one stream. The final header
+// block is held back until the body starts, so that a response without a
+// body fits in a single HEADERS frame that also ends the stream.
+type streamFramer struct {
+	sc          *serverConn
+	st          *stream
+	status      response.StatusCode
+	header      []hpack.HeaderField
+	headersSent bool
+	ended       bool
+}
+
+func (f *streamFramer) WriteInformational(statusCode response.StatusCode, h headers.Headers) error {
+	return f.sc.writeHeaders(f.st, responseFields(statusCode, h), false)
+}
+
+func (f *streamFramer) WriteStatusLine(statusCode response.StatusCode) error {
+	if statusCode == response.StatusCodeSwitchingProtocols {
+		return fmt.Errorf("http2: 101 Switching Protocols is not allowed")
+	}
+	f.status = statusCode
+	return nil
+}
+
+func (f *streamFramer) WriteHeaders(h headers.Headers) error {
+	f.header = responseFields(f.status, h)
+	return nil
+}
+
+func (f *streamFramer) WriteBody(p []byte) (int, error) {
+	return f.writeData(p)
+}
+
+func (f *streamFramer) WriteChunkedBody(p []byte) (int, error) {
+	return f.writeData(p)
+}
+
+func (f *streamFramer) WriteChunkedBodyDone() (int, error) {
+	// DATA frames carry their own length, there is no last chunk to send
+	return 0, nil
+}
+
+// WriteTrailers sends a non-empty trailer section as a final HEADERS frame,
+// which ends the stream.
+func (f *streamFramer) WriteTrailers(h headers.Headers) error {
+	if err := f.sendHeader(); err != nil {
+		return err
+	}
+	fields := headerFields(h)
+	if len(fields) == 0 {
+		return nil
+	}
+	f.ended = true
+	return f.sc.writeHeaders(f.st, fields, true)
+}
+
+// Flush sends the held back header block, if any. Frames are written as
+// they are produced, so there is nothing else to push out.
+func (f *streamFramer) Flush() error {
+	return f.sendHeader()
+}
+
+func (f *streamFramer) sendHeader() error {
+	if f.header == nil {
+		return nil
+	}
+	fields := f.header
+	f.header = nil
+	f.headersSent = true
+	return f.sc.writeHeaders(f.st, fields, false)
+}
+
+// writeData sends p in as many DATA frames as flow control and the peer's
+// frame size limit call for.
+func (f *streamFramer) writeData(p []byte) (int, error) {
+	if err := f.sendHeader(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		n, err := f.sc.reserveWindow(f.st, len(p))
+		if err != nil {
+			return written, err
+		}
+		err = f.sc.writeFrame(func(fr *Framer) error { return fr.WriteData(f.st.id, false, p[:n]) })
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// finish ends the stream once the handler has returned. A handler that
+// wrote nothing at all gets its stream reset, as an HTTP/1.1 client would
+// see the connection close.
+func (f *streamFramer) finish() error {
+	if f.ended {
+		return nil
+	}
+	f.ended = true
+	switch {
+	case f.header != nil:
+		fields := f.header
+		f.header = nil
+		return f.sc.writeHeaders(f.st, fields, true)
+	case f.headersSent:
+		if err := f.sc.streamErr(f.st); err != nil {
+			return err
+		}
+		return f.sc.writeFrame(func(fr *Framer) error { return fr.WriteData(f.st.id, true, nil) })
+	case f.status != 0:
+		return f.sc.writeHeaders(f.st, responseFields(f.status, nil), true)
+	default:
+		if err := f.sc.streamErr(f.st); err != nil {
+			return err
+		}
+		return f.sc.resetStream(StreamError{f.st.id, ErrCodeInternal, "handler wrote no response"})
+	}
+}
+
+func responseFields(statusCode response.StatusCode, h headers.Headers) []hpack.HeaderField {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(statusCode))}}
+	return append(fields, headerFields(h)...)
+}
+
+// headerFields lists h in name order, leaving out the connection-specific
+// fields HTTP/1.1 handlers tend to set.
+func headerFields(h headers.Headers) []hpack.HeaderField {
+	fields := hpack.FromHeaders(h)
+	kept := fields[:0]
+	for _, f := range fields {
+		if !connectionHeaders[f.Name] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// newRequest builds a request from the decoded header block of a new
+// stream. It also returns the declared content-length, or -1.
+func newRequest(fields []hpack.HeaderField) (*request.Request, int, error) {
+	req := &request.Request{
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+	}
+	pseudo := map[string]string{}
+	for i, f := range fields {
+		if !strings.HasPrefix(f.Name, ":") {
+			if err := addFields(req.Headers, fields[i:]); err != nil {
+				return nil, 0, err
+			}
+			break
+		}
+		switch f.Name {
+		case ":method", ":scheme", ":path", ":authority":
+		default:
+			return nil, 0, fmt.Errorf("unknown pseudo-header %s", f.Name)
+		}
+		if _, ok := pseudo[f.Name]; ok {
+			return nil, 0, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+		}
+		pseudo[f.Name] = f.Value
+	}
+
+	method := pseudo[":method"]
+	if method == "" {
+		return nil, 0, fmt.Errorf("missing :method")
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return nil, 0, fmt.Errorf("invalid method: %s", method)
+		}
+	}
+	authority, hasAuthority := pseudo[":authority"]
+	_, hasScheme := pseudo[":scheme"]
+	path, hasPath := pseudo[":path"]
+	target := path
+	if method == "CONNECT" {
+		if !hasAuthority || hasScheme || hasPath {
+			return nil, 0, fmt.Errorf("CONNECT needs :authority and no :scheme or :path")
+		}
+		target = authority
+	} else if !hasScheme || path == "" {
+		return nil, 0, fmt.Errorf("missing :scheme or :path")
+	}
+	if _, ok := req.Headers.Get("Host"); !ok && authority != "" {
+		req.Headers.Set("Host", authority)
+	}
+	req.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   "2.0",
+	}
+
+	contentLength := -1
+	if _, ok := req.Headers.Get("Content-Length"); ok {
+		n, err := req.Headers.ContentLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		contentLength = int(n)
+	}
+	return req, contentLength, nil
+}
+
+// addTrailers adds the fields of a trailer section to req.
+func addTrailers(req *request.Request, fields []hpack.HeaderField) error {
+	return addFields(req.Trailers, fields)
+}
+
+// addFields checks that fields are valid regular fields and adds them to h.
+func addFields(h headers.Headers, fields []hpack.HeaderField) error {
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return fmt.Errorf("pseudo-header %s after regular fields", f.Name)
+		}
+		if f.Name != strings.ToLower(f.Name) {
+			return fmt.Errorf("uppercase field name: %s", f.Name)
+		}
+		if connectionHeaders[f.Name] {
+			return fmt.Errorf("connection-specific field: %s", f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return fmt.Errorf("te other than trailers: %s", f.Value)
+		}
+	}
+	for name, value := range hpack.ToHeaders(fields) {
+		h.Set(name, value)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package http3
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/qpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// ErrGoAway is returned for requests made after the server sent a GOAWAY
+// that excludes them.
+var ErrGoAway = errors.New("http3: server is going away")
+
+// ClientConn sends requests to a server over one HTTP/3 connection.
+// Requests may be made from several goroutines at once, each on its own
+// stream.
+type ClientConn struct {
+	conn *conn
+}
+
+// Dial connects to the HTTP/3 server at addr. NextProto is offered unless
+// tlsConfig lists application protocols of its own.
+func Dial(ctx context.Context, addr string, tlsConfig *tls.Config) (*ClientConn, error) {
+	tlsConfig = tlsConfig.Clone()
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{NextProto}
+	}
+	qconn, err := quic.Dial(ctx, addr, tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := NewClientConn(qconn)
+	if err != nil {
+		qconn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// NewClientConn speaks HTTP/3 on an established QUIC connection.
+func NewClientConn(qconn *quic.Conn) (*ClientConn, error) {
+	c := newConn(qconn, false)
+	if err := c.openControlStream(); err != nil {
+		return nil, err
+	}
+	go c.acceptUniStreams()
+	go func() {
+		// a server may not open bidirectional streams
+		if _, err := qconn.AcceptStream(context.Background()); err == nil {
+			c.abort(ConnectionError{ErrCodeStreamCreation, "bidirectional stream from a server"})
+		}
+	}()
+	return &ClientConn{conn: c}, nil
+}
+
+// Close closes the connection, abandoning requests in progress.
+func (cc *ClientConn) Close() error {
+	return cc.conn.qconn.CloseWithError(uint64(ErrCodeNo), "")
+}
+
+// RoundTrip sends req and reads the whole response. The request target is
+// the path, or the authority for CONNECT, and the Host header supplies the
+// authority of other requests. Interim 1xx responses are skipped.
+func (cc *ClientConn) RoundTrip(req *request.Request) (*response.Response, error) {
+	fields, err := requestFields(req)
+	if err != nil {
+		return nil, err
+	}
+	st, err := cc.conn.qconn.OpenStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if cc.conn.goingAway(st.ID()) {
+		st.Reset(uint64(ErrCodeRequestCancelled))
+		return nil, ErrGoAway
+	}
+
+	b := appendFrame(nil, frameHeaders, qpack.Encode(nil, fields))
+	if len(req.Body) > 0 {
+		b = appendFrame(b, frameData, req.Body)
+	}
+	if trailers := headerFields(req.Trailers); len(trailers) > 0 {
+		b = appendFrame(b, frameHeaders, qpack.Encode(nil, trailers))
+	}
+	// the response is read while the request is written, as a server may
+	// answer before reading the whole body
+	go func() {
+		if _, err := st.Write(b); err == nil {
+			st.Close()
+		}
+	}()
+
+	resp, err := readResponse(bufio.NewReader(st))
+	if err != nil {
+		var connErr ConnectionError
+		if errors.As(err, &connErr) {
+			cc.conn.abort(connErr)
+		}
+		st.CloseRead(uint64(ErrCodeRequestCancelled))
+		st.Reset(uint64(ErrCodeRequestCancelled))
+		return nil, err
+	}
+	return resp, nil
+}
+
+func requestFields(req *request.Request) ([]hpack.HeaderField, error) {
+	method := req.RequestLine.Method
+	target := req.RequestLine.RequestTarget
+	fields := []hpack.HeaderField{{Name: ":method", Value: method}}
+	host, _ := req.Headers.Get("Host")
+	if method == "CONNECT" {
+		fields = append(fields, hpack.HeaderField{Name: ":authority", Value: target})
+	} else {
+		if !strings.HasPrefix(target, "/") && target != "*" {
+			return nil, fmt.Errorf("http3: request target %q is not a path", target)
+		}
+		fields = append(fields,
+			hpack.HeaderField{Name: ":scheme", Value: "https"},
+			hpack.HeaderField{Name: ":authority", Value: host},
+			hpack.HeaderField{Name: ":path", Value: target},
+		)
+	}
+	for _, f := range headerFields(req.Headers) {
+		if f.Name != "host" {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// readResponse reads a whole response from a request stream.
+func readResponse(r *bufio.Reader) (*response.Response, error) {
+	var resp *response.Response
+	for trailers := false; ; {
+		typ, payload, err := readMessageFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case trailers:
+			return nil, ConnectionError{ErrCodeFrameUnexpected, "frame after trailers"}
+		case typ == frameData && resp == nil:
+			return nil, ConnectionError{ErrCodeFrameUnexpected, "DATA before HEADERS"}
+		case typ == frameData:
+			resp.Body = append(resp.Body, payload...)
+			continue
+		}
+		fields, err := decodeFields(payload)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			trailers = true
+			if err := addFields(resp.Trailers, fields); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		resp, err = newResponse(fields)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusLine.StatusCode.IsInformational() {
+			resp = nil
+		}
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("http3: stream ended before the response")
+	}
+	return resp, nil
+}
+
+func newResponse(fields []hpack.HeaderField) (*response.Response, error) {
+	if len(fields) == 0 || fields[0].Name != ":status" {
+		return nil, fmt.Errorf("http3: response without :status")
+	}
+	code, err := strconv.Atoi(fields[0].Value)
+	if err != nil || code < 100 || code > 999 {
+		return nil, fmt.Errorf("http3: malformed :status %q", fields[0].Value)
+	}
+	if slices.ContainsFunc(fields[1:], func(f hpack.HeaderField) bool { return strings.HasPrefix(f.Name, ":") }) {
+		return nil, fmt.Errorf("http3: unexpected pseudo-header in response")
+	}
+	statusCode := response.StatusCode(code)
+	resp := &response.Response{
+		StatusLine: response.StatusLine{
+			HttpVersion:  "3.0",
+			StatusCode:   statusCode,
+			ReasonPhrase: response.StatusText(statusCode),
+		},
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+	}
+	if err := addFields(resp.Headers, fields[1:]); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
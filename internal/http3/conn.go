@@ -0,0 +1,189 @@
+package http3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+)
+
+// conn holds what the client and the server side of a connection share:
+// the control streams in both directions and the peer's other
+// unidirectional streams.
+type conn struct {
+	qconn    *quic.Conn
+	isServer bool
+
+	mu          sync.Mutex
+	peerStreams map[uint64]bool // unidirectional stream types seen
+	goAwayID    uint64
+	goAway      bool
+}
+
+func newConn(qconn *quic.Conn, isServer bool) *conn {
+	return &conn{qconn: qconn, isServer: isServer, peerStreams: map[uint64]bool{}}
+}
+
+// openControlStream opens our control stream and sends SETTINGS on it. The
+// stream stays open as long as the connection.
+func (c *conn) openControlStream() error {
+	s, err := c.qconn.OpenUniStream(context.Background())
+	if err != nil {
+		return err
+	}
+	b := appendVarint(nil, streamControl)
+	b = appendSettings(b, localSettings...)
+	_, err = s.Write(b)
+	return err
+}
+
+// abort closes the connection because of err. A ConnectionError is sent to
+// the peer with its code, anything else as H3_INTERNAL_ERROR.
+func (c *conn) abort(err error) {
+	var connErr ConnectionError
+	if errors.As(err, &connErr) {
+		c.qconn.CloseWithError(uint64(connErr.Code), connErr.Reason)
+		return
+	}
+	c.qconn.CloseWithError(uint64(ErrCodeInternal), err.Error())
+}
+
+// acceptUniStreams handles the peer's unidirectional streams until the
+// connection ends.
+func (c *conn) acceptUniStreams() {
+	for {
+		s, err := c.qconn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := c.handleUniStream(s); err != nil {
+				c.abort(err)
+			}
+		}()
+	}
+}
+
+func (c *conn) handleUniStream(s *quic.Stream) error {
+	r := bufio.NewReader(s)
+	typ, err := readVarint(r)
+	if err != nil {
+		// a stream that ends or is reset before its type is ignored
+		return nil
+	}
+	switch typ {
+	case streamControl, streamQPACKEncoder, streamQPACKDecoder:
+		c.mu.Lock()
+		dup := c.peerStreams[typ]
+		c.peerStreams[typ] = true
+		c.mu.Unlock()
+		if dup {
+			return ConnectionError{ErrCodeStreamCreation, fmt.Sprintf("second stream of type 0x%x", typ)}
+		}
+	case streamPush:
+		if c.isServer {
+			return ConnectionError{ErrCodeStreamCreation, "push stream from a client"}
+		}
+		// no MAX_PUSH_ID is ever sent, so no push ID is valid
+		return ConnectionError{ErrCodeID, "push stream without MAX_PUSH_ID"}
+	default:
+		// unknown stream types, reserved ones included, are not read
+		s.CloseRead(uint64(ErrCodeStreamCreation))
+		return nil
+	}
+
+	if typ == streamControl {
+		err = c.readControlStream(r)
+	} else {
+		// with a table capacity of zero there is nothing for the encoder
+		// stream to insert, and nothing for the decoder stream to
+		// acknowledge; whatever they carry is read and dropped
+		_, err = io.Copy(io.Discard, r)
+	}
+	var connErr ConnectionError
+	switch {
+	case errors.As(err, &connErr):
+		return err
+	case isStreamError(err) || err == nil || err == io.EOF || err == errTruncatedFrame:
+		return ConnectionError{ErrCodeClosedCriticalStream, fmt.Sprintf("stream of type 0x%x closed", typ)}
+	}
+	// the connection is gone
+	return nil
+}
+
+func isStreamError(err error) bool {
+	var streamErr quic.StreamError
+	return errors.As(err, &streamErr)
+}
+
+// readControlStream reads the peer's control stream. It only returns with
+// an error, io.EOF if the stream ended between frames.
+func (c *conn) readControlStream(r *bufio.Reader) error {
+	typ, payload, err := readFrame(r, maxControlFrameSize)
+	if err != nil {
+		return err
+	}
+	if typ != frameSettings {
+		return ConnectionError{ErrCodeMissingSettings, "control stream does not open with SETTINGS"}
+	}
+	// none of the peer's settings change how we send: field sections are
+	// never compressed with the dynamic table, and are far smaller than
+	// any sensible field section limit
+	if _, err := parseSettings(payload); err != nil {
+		return err
+	}
+	for {
+		typ, payload, err := readFrame(r, maxControlFrameSize)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameGoAway:
+			if err := c.handleGoAway(payload); err != nil {
+				return err
+			}
+		case frameCancelPush:
+			// no push is ever promised, so there is nothing to cancel
+		case frameMaxPushID:
+			if !c.isServer {
+				return ConnectionError{ErrCodeFrameUnexpected, "MAX_PUSH_ID from a server"}
+			}
+			// server push is never used
+		default:
+			return ConnectionError{ErrCodeFrameUnexpected, fmt.Sprintf("frame 0x%x on the control stream", typ)}
+		}
+	}
+}
+
+// handleGoAway records the peer's GOAWAY. A server's names the first
+// request stream it will not process; a GOAWAY may lower it but not raise
+// it.
+func (c *conn) handleGoAway(payload []byte) error {
+	id, n := consumeVarint(payload)
+	if n != len(payload) {
+		return ConnectionError{ErrCodeFrame, "malformed GOAWAY"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isServer && id%4 != 0 {
+		return ConnectionError{ErrCodeID, fmt.Sprintf("GOAWAY with stream ID %d", id)}
+	}
+	if c.goAway && id > c.goAwayID {
+		return ConnectionError{ErrCodeID, "GOAWAY raised its ID"}
+	}
+	c.goAway = true
+	c.goAwayID = id
+	return nil
+}
+
+// goingAway reports whether the peer sent a GOAWAY that rules out stream
+// id.
+func (c *conn) goingAway(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.goAway && id >= c.goAwayID
+}
@@ -0,0 +1,95 @@
+package http3
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+)
+
+// ErrCode is an HTTP/3 error code, carried by QUIC when closing a
+// connection or resetting a stream.
+type ErrCode uint64
+
+const (
+	ErrCodeNo                   ErrCode = 0x100
+	ErrCodeGeneralProtocol      ErrCode = 0x101
+	ErrCodeInternal             ErrCode = 0x102
+	ErrCodeStreamCreation       ErrCode = 0x103
+	ErrCodeClosedCriticalStream ErrCode = 0x104
+	ErrCodeFrameUnexpected      ErrCode = 0x105
+	ErrCodeFrame                ErrCode = 0x106
+	ErrCodeExcessiveLoad        ErrCode = 0x107
+	ErrCodeID                   ErrCode = 0x108
+	ErrCodeSettings             ErrCode = 0x109
+	ErrCodeMissingSettings      ErrCode = 0x10a
+	ErrCodeRequestRejected      ErrCode = 0x10b
+	ErrCodeRequestCancelled     ErrCode = 0x10c
+	ErrCodeRequestIncomplete    ErrCode = 0x10d
+	ErrCodeMessage              ErrCode = 0x10e
+	ErrCodeConnect              ErrCode = 0x10f
+	ErrCodeVersionFallback      ErrCode = 0x110
+	// ErrCodeQPACKDecompressionFailed is defined by QPACK, RFC 9204.
+	ErrCodeQPACKDecompressionFailed ErrCode = 0x200
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                       "H3_NO_ERROR",
+	ErrCodeGeneralProtocol:          "H3_GENERAL_PROTOCOL_ERROR",
+	ErrCodeInternal:                 "H3_INTERNAL_ERROR",
+	ErrCodeStreamCreation:           "H3_STREAM_CREATION_ERROR",
+	ErrCodeClosedCriticalStream:     "H3_CLOSED_CRITICAL_STREAM",
+	ErrCodeFrameUnexpected:          "H3_FRAME_UNEXPECTED",
+	ErrCodeFrame:                    "H3_FRAME_ERROR",
+	ErrCodeExcessiveLoad:            "H3_EXCESSIVE_LOAD",
+	ErrCodeID:                       "H3_ID_ERROR",
+	ErrCodeSettings:                 "H3_SETTINGS_ERROR",
+	ErrCodeMissingSettings:          "H3_MISSING_SETTINGS",
+	ErrCodeRequestRejected:          "H3_REQUEST_REJECTED",
+	ErrCodeRequestCancelled:         "H3_REQUEST_CANCELLED",
+	ErrCodeRequestIncomplete:        "H3_REQUEST_INCOMPLETE",
+	ErrCodeMessage:                  "H3_MESSAGE_ERROR",
+	ErrCodeConnect:                  "H3_CONNECT_ERROR",
+	ErrCodeVersionFallback:          "H3_VERSION_FALLBACK",
+	ErrCodeQPACKDecompressionFailed: "QPACK_DECOMPRESSION_FAILED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(c))
+}
+
+// ConnectionError is an error that closes the whole connection.
+type ConnectionError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnectionError) Error() string {
+	return fmt.Sprintf("http3: connection error: %s: %s", e.Code, e.Reason)
+}
+
+// StreamError is an error that ends a single request stream, resetting it
+// in both directions.
+type StreamError struct {
+	StreamID uint64
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http3: stream %d error: %s: %s", e.StreamID, e.Code, e.Reason)
+}
+
+// isGone reports whether err only says that the peer or the connection
+// went away, which is not worth logging.
+func isGone(err error) bool {
+	var streamErr quic.StreamError
+	var appErr quic.ApplicationError
+	var transportErr quic.TransportError
+	return errors.As(err, &streamErr) || errors.As(err, &appErr) ||
+		errors.As(err, &transportErr) || errors.Is(err, quic.ErrClosed) ||
+		errors.Is(err, quic.ErrIdleTimeout)
+}
@@ -0,0 +1,215 @@
+package http3
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame types, RFC 9114 section 7.2.
+const (
+	frameData        = 0x00
+	frameHeaders     = 0x01
+	frameCancelPush  = 0x03
+	frameSettings    = 0x04
+	framePushPromise = 0x05
+	frameGoAway      = 0x07
+	frameMaxPushID   = 0x0d
+)
+
+func isKnownFrame(typ uint64) bool {
+	switch typ {
+	case frameData, frameHeaders, frameCancelPush, frameSettings, framePushPromise, frameGoAway, frameMaxPushID:
+		return true
+	}
+	return isHTTP2Frame(typ)
+}
+
+// isHTTP2Frame reports whether typ is reserved because HTTP/2 used it for a
+// frame HTTP/3 replaced with something else. Receiving one is an error.
+func isHTTP2Frame(typ uint64) bool {
+	switch typ {
+	case 0x02, 0x06, 0x08, 0x09:
+		return true
+	}
+	return false
+}
+
+// Unidirectional stream types, RFC 9114 section 6.2 and RFC 9204 section 4.2.
+const (
+	streamControl      = 0x00
+	streamPush         = 0x01
+	streamQPACKEncoder = 0x02
+	streamQPACKDecoder = 0x03
+)
+
+// Settings, RFC 9114 section 7.2.4.1 and RFC 9204 section 5.
+const (
+	settingQPACKMaxTableCapacity = 0x01
+	settingMaxFieldSectionSize   = 0x06
+	settingQPACKBlockedStreams   = 0x07
+)
+
+// isHTTP2Setting reports whether id is reserved because it names an HTTP/2
+// setting with no HTTP/3 counterpart.
+func isHTTP2Setting(id uint64) bool {
+	return id >= 0x02 && id <= 0x05
+}
+
+// maxFieldSectionSize bounds an encoded field section, and is announced
+// to the peer as SETTINGS_MAX_FIELD_SECTION_SIZE.
+const maxFieldSectionSize = 1 << 20
+
+// maxControlFrameSize bounds the frames on a control stream, which only
+// ever carry a few integers.
+const maxControlFrameSize = 1 << 14
+
+var errTruncatedFrame = errors.New("http3: truncated frame")
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// truncated turns the end of a stream inside a frame into
+// errTruncatedFrame, leaving other read errors alone.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errTruncatedFrame
+	}
+	return err
+}
+
+// readVarint reads a QUIC variable-length integer. It returns io.EOF only
+// if r ends before the first byte.
+func readVarint(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(b & 0x3f)
+	for range 1<<(b>>6) - 1 {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, truncated(err)
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// consumeVarint reads a variable-length integer from the start of p,
+// returning the number of bytes read, or -1 if p is too short.
+func consumeVarint(p []byte) (uint64, int) {
+	if len(p) == 0 {
+		return 0, -1
+	}
+	n := 1 << (p[0] >> 6)
+	if len(p) < n {
+		return 0, -1
+	}
+	v := uint64(p[0] & 0x3f)
+	for _, c := range p[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// appendFrame appends a frame of type typ carrying payload.
+func appendFrame(b []byte, typ uint64, payload []byte) []byte {
+	b = appendVarint(b, typ)
+	b = appendVarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// readFrame reads the next frame from r, skipping frames of unknown types.
+// Frames other than DATA longer than maxLen are an error, as DATA is the
+// only frame a peer has reason to make large. It returns io.EOF if r ends
+// between frames and errTruncatedFrame if it ends inside one.
+func readFrame(r *bufio.Reader, maxLen uint64) (uint64, []byte, error) {
+	for {
+		typ, err := readVarint(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		length, err := readVarint(r)
+		if err != nil {
+			return 0, nil, truncated(err)
+		}
+		switch {
+		case !isKnownFrame(typ):
+			// unknown types, reserved ones included, are ignored
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+				return 0, nil, truncated(err)
+			}
+			continue
+		case typ != frameData && length > maxLen:
+			return 0, nil, ConnectionError{ErrCodeExcessiveLoad, fmt.Sprintf("frame 0x%x of %d bytes", typ, length)}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, nil, truncated(err)
+		}
+		return typ, payload, nil
+	}
+}
+
+// Setting is one parameter of a SETTINGS frame.
+type Setting struct {
+	ID    uint64
+	Value uint64
+}
+
+func appendSettings(b []byte, settings ...Setting) []byte {
+	var payload []byte
+	for _, s := range settings {
+		payload = appendVarint(payload, s.ID)
+		payload = appendVarint(payload, s.Value)
+	}
+	return appendFrame(b, frameSettings, payload)
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	var settings []Setting
+	seen := map[uint64]bool{}
+	for len(payload) > 0 {
+		id, n := consumeVarint(payload)
+		if n < 0 {
+			return nil, ConnectionError{ErrCodeFrame, "truncated SETTINGS"}
+		}
+		payload = payload[n:]
+		value, n := consumeVarint(payload)
+		if n < 0 {
+			return nil, ConnectionError{ErrCodeFrame, "truncated SETTINGS"}
+		}
+		payload = payload[n:]
+		if seen[id] {
+			return nil, ConnectionError{ErrCodeSettings, fmt.Sprintf("duplicate setting 0x%x", id)}
+		}
+		if isHTTP2Setting(id) {
+			return nil, ConnectionError{ErrCodeSettings, fmt.Sprintf("HTTP/2 setting 0x%x", id)}
+		}
+		seen[id] = true
+		settings = append(settings, Setting{id, value})
+	}
+	return settings, nil
+}
+
+// localSettings are sent by both the server and the client in this
+// package. A QPACK table capacity of zero keeps the peer's encoder from
+// using the dynamic table, which this implementation does not have.
+var localSettings = []Setting{
+	{settingQPACKMaxTableCapacity, 0},
+	{settingQPACKBlockedStreams, 0},
+	{settingMaxFieldSectionSize, maxFieldSectionSize},
+}
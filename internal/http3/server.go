@@ -0,0 +1,198 @@
+// Package http3 is an experimental HTTP/3 server, RFC 9114, on top of the
+// minimal QUIC transport in package quic. Each request stream is handed
+// to a handler with the same shape as server.Handler, writing through a
+// response.Writer whose parts become HEADERS and DATA frames. Field
+// sections are compressed with QPACK using the static table only, and
+// server push is never used.
+//
+// The package also has a client, enough to test the server end to end
+// without depending on another HTTP/3 implementation.
+package http3
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/qpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// NextProto is the ALPN protocol ID of HTTP/3. The TLS configuration of a
+// quic.Listener serving HTTP/3 has to offer it.
+const NextProto = "h3"
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type Server struct {
+	Handler Handler
+}
+
+// Serve accepts connections from l and serves HTTP/3 on each, until l is
+// closed.
+func (s *Server) Serve(l *quic.Listener) error {
+	for {
+		conn, err := l.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				log.Printf("Error serving HTTP/3 connection: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn serves HTTP/3 on conn. It returns once the connection has
+// ended and every handler has returned.
+func (s *Server) ServeConn(qconn *quic.Conn) error {
+	c := newConn(qconn, true)
+	sc := &serverConn{srv: s, conn: c}
+	if err := c.openControlStream(); err != nil {
+		return err
+	}
+	go c.acceptUniStreams()
+	var err error
+	for {
+		var st *quic.Stream
+		st, err = qconn.AcceptStream(context.Background())
+		if err != nil {
+			break
+		}
+		sc.handlers.Add(1)
+		go sc.serveStream(st)
+	}
+	sc.handlers.Wait()
+	var appErr quic.ApplicationError
+	if errors.As(err, &appErr) && ErrCode(appErr.Code) == ErrCodeNo ||
+		errors.Is(err, quic.ErrClosed) || errors.Is(err, quic.ErrIdleTimeout) {
+		return nil
+	}
+	return err
+}
+
+type serverConn struct {
+	srv      *Server
+	conn     *conn
+	handlers sync.WaitGroup
+}
+
+func (sc *serverConn) serveStream(st *quic.Stream) {
+	defer sc.handlers.Done()
+	req, err := sc.readRequest(st)
+	if err != nil {
+		var streamErr StreamError
+		var connErr ConnectionError
+		switch {
+		case errors.As(err, &streamErr):
+			st.CloseRead(uint64(streamErr.Code))
+			st.Reset(uint64(streamErr.Code))
+		case errors.As(err, &connErr):
+			sc.conn.abort(connErr)
+		}
+		return
+	}
+	sc.runHandler(st, req)
+}
+
+// readRequest reads a whole request from st: its field section, the body
+// and any trailers.
+func (sc *serverConn) readRequest(st *quic.Stream) (*request.Request, error) {
+	r := bufio.NewReader(st)
+	typ, payload, err := readMessageFrame(r)
+	if err == io.EOF {
+		return nil, StreamError{st.ID(), ErrCodeRequestIncomplete, "stream ended before HEADERS"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if typ != frameHeaders {
+		return nil, ConnectionError{ErrCodeFrameUnexpected, "DATA before HEADERS"}
+	}
+	fields, err := decodeFields(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, contentLength, err := newRequest(fields)
+	if err != nil {
+		return nil, StreamError{st.ID(), ErrCodeMessage, err.Error()}
+	}
+	req.RemoteAddr = sc.conn.qconn.RemoteAddr().String()
+
+	for trailers := false; ; {
+		typ, payload, err := readMessageFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if trailers {
+			return nil, ConnectionError{ErrCodeFrameUnexpected, "frame after trailers"}
+		}
+		if typ == frameData {
+			req.Body = append(req.Body, payload...)
+			continue
+		}
+		trailers = true
+		fields, err := decodeFields(payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := addFields(req.Trailers, fields); err != nil {
+			return nil, StreamError{st.ID(), ErrCodeMessage, err.Error()}
+		}
+	}
+	if contentLength >= 0 && contentLength != len(req.Body) {
+		return nil, StreamError{st.ID(), ErrCodeMessage, "body length does not match content-length"}
+	}
+	return req, nil
+}
+
+// readMessageFrame reads the next frame of a request or response. Only
+// HEADERS and DATA may appear there.
+func readMessageFrame(r *bufio.Reader) (uint64, []byte, error) {
+	typ, payload, err := readFrame(r, maxFieldSectionSize)
+	if err == errTruncatedFrame {
+		return 0, nil, ConnectionError{ErrCodeFrame, err.Error()}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if typ != frameHeaders && typ != frameData {
+		return 0, nil, ConnectionError{ErrCodeFrameUnexpected, fmt.Sprintf("frame 0x%x on a request stream", typ)}
+	}
+	return typ, payload, nil
+}
+
+func decodeFields(payload []byte) ([]hpack.HeaderField, error) {
+	fields, err := qpack.Decode(payload)
+	if err != nil {
+		return nil, ConnectionError{ErrCodeQPACKDecompressionFailed, err.Error()}
+	}
+	return fields, nil
+}
+
+func (sc *serverConn) runHandler(st *quic.Stream, req *request.Request) {
+	f := &streamFramer{s: st}
+	if req.RequestLine.Method == "HEAD" {
+		head := response.NewHeadFramer(f)
+		sc.srv.Handler(response.NewFramedWriter(head), req)
+		if err := head.Finish(); err != nil {
+			log.Printf("Error writing HEAD response headers: %v", err)
+		}
+	} else {
+		sc.srv.Handler(response.NewFramedWriter(f), req)
+	}
+	if err := f.finish(); err != nil && !isGone(err) {
+		log.Printf("Error ending stream %d: %v", st.ID(), err)
+	}
+}
@@ -0,0 +1,346 @@
+package http3
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/qpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tlsConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{NextProto},
+	}
+	return server, &tls.Config{RootCAs: pool, ServerName: "localhost"}
+}
+
+// listen serves HTTP/3 on a loopback listener and returns its address
+// along with a client TLS configuration that trusts it.
+func listen(t *testing.T, handler Handler) (string, *tls.Config) {
+	t.Helper()
+	serverTLS, clientTLS := tlsConfigs(t)
+	l, err := quic.Listen("127.0.0.1:0", serverTLS, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	s := &Server{Handler: handler}
+	go s.Serve(l)
+	return l.Addr().String(), clientTLS
+}
+
+func dial(t *testing.T, handler Handler) *ClientConn {
+	t.Helper()
+	addr, clientTLS := listen(t, handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := Dial(ctx, addr, clientTLS)
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func newRequestTo(method, target string, body string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "3.0"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte(body),
+		Trailers:    headers.NewHeaders(),
+	}
+	req.Headers.Set("Host", "localhost")
+	return req
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := response.GetDefaultHeaders(len(req.Body))
+	h.Set("X-Method", req.RequestLine.Method)
+	h.Set("X-Target", req.RequestLine.RequestTarget)
+	h.Set("X-Version", req.RequestLine.HttpVersion)
+	host, _ := req.Headers.Get("Host")
+	h.Set("X-Host", host)
+	custom, _ := req.Headers.Get("X-Custom")
+	h.Set("X-Custom", custom)
+	trailer, _ := req.Trailers.Get("X-Checksum")
+	h.Set("X-Trailer", trailer)
+	w.WriteHeaders(h)
+	w.WriteBody(req.Body)
+}
+
+// Test: a request reaches the handler with its method, target, authority
+// and fields, and the response comes back with its body
+func TestRequest(t *testing.T) {
+	cc := dial(t, echoHandler)
+
+	req := newRequestTo("POST", "/echo?x=1", "hello")
+	req.Headers.Set("X-Custom", "value")
+	req.Trailers.Set("X-Checksum", "abc")
+	resp, err := cc.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, "3.0", resp.StatusLine.HttpVersion)
+	assert.Equal(t, "hello", string(resp.Body))
+	for name, want := range map[string]string{
+		"X-Method":  "POST",
+		"X-Target":  "/echo?x=1",
+		"X-Version": "3.0",
+		"X-Host":    "localhost",
+		"X-Custom":  "value",
+		"X-Trailer": "abc",
+	} {
+		got, _ := resp.Headers.Get(name)
+		assert.Equal(t, want, got, name)
+	}
+	_, ok := resp.Headers.Get("Connection")
+	assert.False(t, ok, "connection-specific fields are dropped")
+}
+
+// Test: a HEAD response carries the headers of a GET but no body
+func TestHead(t *testing.T) {
+	cc := dial(t, func(w *response.Writer, req *request.Request) {
+		body := []byte("some body")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	resp, err := cc.RoundTrip(newRequestTo("HEAD", "/", ""))
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body)
+	length, _ := resp.Headers.Get("Content-Length")
+	assert.Equal(t, "9", length)
+}
+
+// Test: a chunked response streams in DATA frames and ends with trailers,
+// after an interim response the client skips
+func TestStreamingResponse(t *testing.T) {
+	cc := dial(t, func(w *response.Writer, req *request.Request) {
+		hints := headers.NewHeaders()
+		hints.Set("Link", "</style.css>; rel=preload")
+		w.WriteInformational(response.StatusCodeEarlyHints, hints)
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		w.WriteHeaders(h)
+		for i := range 100 {
+			w.WriteChunkedBody([]byte(fmt.Sprintf("chunk %d\n", i)))
+		}
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "yes")
+		w.WriteTrailers(trailers)
+	})
+	resp, err := cc.RoundTrip(newRequestTo("GET", "/stream", ""))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, 100, strings.Count(string(resp.Body), "\n"))
+	done, _ := resp.Trailers.Get("X-Done")
+	assert.Equal(t, "yes", done)
+	_, ok := resp.Headers.Get("Transfer-Encoding")
+	assert.False(t, ok)
+}
+
+// Test: large bodies travel both ways, and many requests share the
+// connection at once
+func TestConcurrentRequests(t *testing.T) {
+	cc := dial(t, echoHandler)
+	body := strings.Repeat("0123456789", 50000)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cc.RoundTrip(newRequestTo("PUT", fmt.Sprintf("/%d", i), body))
+			if assert.NoError(t, err) {
+				assert.Equal(t, len(body), len(resp.Body))
+				target, _ := resp.Headers.Get("X-Target")
+				assert.Equal(t, fmt.Sprintf("/%d", i), target)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Test: a handler that writes nothing gets its stream reset
+func TestHandlerWritesNothing(t *testing.T) {
+	cc := dial(t, func(w *response.Writer, req *request.Request) {})
+	_, err := cc.RoundTrip(newRequestTo("GET", "/", ""))
+	var streamErr quic.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, uint64(ErrCodeInternal), streamErr.Code)
+}
+
+// Test: a body that does not match content-length is a malformed request
+func TestContentLengthMismatch(t *testing.T) {
+	cc := dial(t, echoHandler)
+	req := newRequestTo("POST", "/", "four")
+	req.Headers.Set("Content-Length", "10")
+	_, err := cc.RoundTrip(req)
+	var streamErr quic.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, uint64(ErrCodeMessage), streamErr.Code)
+}
+
+// rawConn dials the server with a bare QUIC connection, for tests that send
+// what the client in this package never would.
+func rawConn(t *testing.T) *quic.Conn {
+	t.Helper()
+	addr, clientTLS := listen(t, echoHandler)
+	clientTLS.NextProtos = []string{NextProto}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	qconn, err := quic.Dial(ctx, addr, clientTLS, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { qconn.Close() })
+	return qconn
+}
+
+func sendRaw(t *testing.T, qconn *quic.Conn, b []byte) *quic.Stream {
+	t.Helper()
+	st, err := qconn.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = st.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+	return st
+}
+
+// waitClosed waits for the server to close the connection and returns the
+// application error code it gave.
+func waitClosed(t *testing.T, qconn *quic.Conn) uint64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		st, err := qconn.AcceptStream(ctx)
+		if err != nil {
+			var appErr quic.ApplicationError
+			require.ErrorAs(t, err, &appErr)
+			assert.True(t, appErr.Remote)
+			return appErr.Code
+		}
+		st.Reset(0)
+	}
+}
+
+// Test: the server opens its control stream with SETTINGS announcing a
+// QPACK table capacity of zero
+func TestServerSettings(t *testing.T) {
+	qconn := rawConn(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := qconn.AcceptUniStream(ctx)
+	require.NoError(t, err)
+	r := bufio.NewReader(st)
+	typ, err := readVarint(r)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(streamControl), typ)
+	typ, payload, err := readFrame(r, maxControlFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(frameSettings), typ)
+	settings, err := parseSettings(payload)
+	require.NoError(t, err)
+	assert.Contains(t, settings, Setting{settingQPACKMaxTableCapacity, 0})
+	assert.Contains(t, settings, Setting{settingMaxFieldSectionSize, maxFieldSectionSize})
+}
+
+// Test: frames out of place close the connection with the right code
+func TestProtocolErrors(t *testing.T) {
+	get := qpack.Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/"},
+	})
+	tests := map[string]struct {
+		b    []byte
+		code ErrCode
+	}{
+		"DATA first":          {appendFrame(nil, frameData, []byte("x")), ErrCodeFrameUnexpected},
+		"SETTINGS on request": {appendFrame(appendFrame(nil, frameHeaders, get), frameSettings, nil), ErrCodeFrameUnexpected},
+		"HTTP/2 frame type":   {appendFrame(nil, 0x06, make([]byte, 8)), ErrCodeFrameUnexpected},
+		"truncated frame":     {appendFrame(nil, frameHeaders, get)[:4], ErrCodeFrame},
+		"dynamic table":       {appendFrame(nil, frameHeaders, []byte{0x02, 0x00, 0x80}), ErrCodeQPACKDecompressionFailed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			qconn := rawConn(t)
+			sendRaw(t, qconn, tt.b)
+			assert.Equal(t, uint64(tt.code), waitClosed(t, qconn))
+		})
+	}
+}
+
+// Test: a second control stream from the client closes the connection
+func TestDuplicateControlStream(t *testing.T) {
+	qconn := rawConn(t)
+	for range 2 {
+		st, err := qconn.OpenUniStream(context.Background())
+		require.NoError(t, err)
+		_, err = st.Write(appendSettings(appendVarint(nil, streamControl)))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint64(ErrCodeStreamCreation), waitClosed(t, qconn))
+}
+
+// Test: a malformed request resets its stream but leaves the connection
+// usable, and unknown frame types are skipped
+func TestMalformedRequest(t *testing.T) {
+	qconn := rawConn(t)
+	bad := qpack.Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/"},
+	})
+	st := sendRaw(t, qconn, appendFrame(nil, frameHeaders, bad))
+	_, err := bufio.NewReader(st).ReadByte()
+	var streamErr quic.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, uint64(ErrCodeMessage), streamErr.Code)
+
+	good := qpack.Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/ok"},
+	})
+	b := appendFrame(nil, 0x21, []byte("reserved")) // 0x1f * N + 0x21 is reserved
+	b = appendFrame(b, frameHeaders, good)
+	st = sendRaw(t, qconn, b)
+	resp, err := readResponse(bufio.NewReader(st))
+	require.NoError(t, err)
+	target, _ := resp.Headers.Get("X-Target")
+	assert.Equal(t, "/ok", target)
+}
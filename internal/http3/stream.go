@@ -0,0 +1,257 @@
+package http3
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/qpack"
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// connectionHeaders only make sense for a single HTTP/1.1 hop and are not
+// allowed in HTTP/3 messages.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// streamFramer writes a response as frames on one request stream. The
+// final field section is held back until the body starts, like the
+// HTTP/2 one, so that a response without a body is a single HEADERS frame
+// followed by the end of the stream.
+type streamFramer struct {
+	s           *quic.Stream
+	status      response.StatusCode
+	header      []hpack.HeaderField
+	headersSent bool
+	ended       bool
+	buf         []byte
+}
+
+func (f *streamFramer) WriteInformational(statusCode response.StatusCode, h headers.Headers) error {
+	return f.writeHeaders(responseFields(statusCode, h))
+}
+
+func (f *streamFramer) WriteStatusLine(statusCode response.StatusCode) error {
+	if statusCode == response.StatusCodeSwitchingProtocols {
+		return fmt.Errorf("http3: 101 Switching Protocols is not allowed")
+	}
+	f.status = statusCode
+	return nil
+}
+
+func (f *streamFramer) WriteHeaders(h headers.Headers) error {
+	f.header = responseFields(f.status, h)
+	return nil
+}
+
+func (f *streamFramer) WriteBody(p []byte) (int, error) {
+	return f.writeData(p)
+}
+
+func (f *streamFramer) WriteChunkedBody(p []byte) (int, error) {
+	return f.writeData(p)
+}
+
+func (f *streamFramer) WriteChunkedBodyDone() (int, error) {
+	// DATA frames carry their own length, there is no last chunk to send
+	return 0, nil
+}
+
+// WriteTrailers sends a non-empty trailer section as a final HEADERS frame.
+func (f *streamFramer) WriteTrailers(h headers.Headers) error {
+	if err := f.sendHeader(); err != nil {
+		return err
+	}
+	fields := headerFields(h)
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := f.writeHeaders(fields); err != nil {
+		return err
+	}
+	f.ended = true
+	return f.s.Close()
+}
+
+// Flush sends the held back field section, if any. QUIC sends stream
+// data as soon as it can, so there is nothing else to push out.
+func (f *streamFramer) Flush() error {
+	return f.sendHeader()
+}
+
+func (f *streamFramer) sendHeader() error {
+	if f.header == nil {
+		return nil
+	}
+	fields := f.header
+	f.header = nil
+	f.headersSent = true
+	return f.writeHeaders(fields)
+}
+
+func (f *streamFramer) writeHeaders(fields []hpack.HeaderField) error {
+	f.buf = appendFrame(f.buf[:0], frameHeaders, qpack.Encode(nil, fields))
+	_, err := f.s.Write(f.buf)
+	return err
+}
+
+// writeData sends p as a single DATA frame. QUIC takes care of splitting
+// it into packets and of flow control.
+func (f *streamFramer) writeData(p []byte) (int, error) {
+	if err := f.sendHeader(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	f.buf = appendVarint(f.buf[:0], frameData)
+	f.buf = appendVarint(f.buf, uint64(len(p)))
+	if _, err := f.s.Write(f.buf); err != nil {
+		return 0, err
+	}
+	return f.s.Write(p)
+}
+
+// finish ends the stream once the handler has returned. A handler that
+// wrote nothing at all gets its stream reset, as an HTTP/1.1 client would
+// see the connection close.
+func (f *streamFramer) finish() error {
+	if f.ended {
+		return nil
+	}
+	f.ended = true
+	switch {
+	case f.header != nil:
+		if err := f.sendHeader(); err != nil {
+			return err
+		}
+	case f.status != 0 && !f.headersSent:
+		if err := f.writeHeaders(responseFields(f.status, nil)); err != nil {
+			return err
+		}
+	case !f.headersSent:
+		f.s.Reset(uint64(ErrCodeInternal))
+		return nil
+	}
+	return f.s.Close()
+}
+
+func responseFields(statusCode response.StatusCode, h headers.Headers) []hpack.HeaderField {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(statusCode))}}
+	return append(fields, headerFields(h)...)
+}
+
+// headerFields lists h in name order, leaving out the connection-specific
+// fields HTTP/1.1 handlers tend to set.
+func headerFields(h headers.Headers) []hpack.HeaderField {
+	fields := hpack.FromHeaders(h)
+	kept := fields[:0]
+	for _, f := range fields {
+		if !connectionHeaders[f.Name] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// newRequest builds a request from the field section that opened a
+// request stream. It also returns the declared content-length, or -1.
+func newRequest(fields []hpack.HeaderField) (*request.Request, int, error) {
+	req := &request.Request{
+		Headers:  headers.NewHeaders(),
+		Body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+	}
+	pseudo := map[string]string{}
+	for i, f := range fields {
+		if !strings.HasPrefix(f.Name, ":") {
+			if err := addFields(req.Headers, fields[i:]); err != nil {
+				return nil, 0, err
+			}
+			break
+		}
+		switch f.Name {
+		case ":method", ":scheme", ":path", ":authority":
+		default:
+			return nil, 0, fmt.Errorf("unknown pseudo-header %s", f.Name)
+		}
+		if _, ok := pseudo[f.Name]; ok {
+			return nil, 0, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+		}
+		pseudo[f.Name] = f.Value
+	}
+
+	method := pseudo[":method"]
+	if method == "" {
+		return nil, 0, fmt.Errorf("missing :method")
+	}
+	for _, c := range method {
+		if c < 'A' || c > 'Z' {
+			return nil, 0, fmt.Errorf("invalid method: %s", method)
+		}
+	}
+	authority, hasAuthority := pseudo[":authority"]
+	_, hasScheme := pseudo[":scheme"]
+	path, hasPath := pseudo[":path"]
+	target := path
+	if method == "CONNECT" {
+		if !hasAuthority || hasScheme || hasPath {
+			return nil, 0, fmt.Errorf("CONNECT needs :authority and no :scheme or :path")
+		}
+		target = authority
+	} else if !hasScheme || path == "" {
+		return nil, 0, fmt.Errorf("missing :scheme or :path")
+	}
+	if host, ok := req.Headers.Get("Host"); ok && hasAuthority && host != authority {
+		return nil, 0, fmt.Errorf("host %s does not match :authority %s", host, authority)
+	}
+	if _, ok := req.Headers.Get("Host"); !ok && authority != "" {
+		req.Headers.Set("Host", authority)
+	}
+	req.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   "3.0",
+	}
+
+	contentLength := -1
+	if _, ok := req.Headers.Get("Content-Length"); ok {
+		n, err := req.Headers.ContentLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		contentLength = int(n)
+	}
+	return req, contentLength, nil
+}
+
+// addFields checks that fields are valid regular fields and adds them to h.
+func addFields(h headers.Headers, fields []hpack.HeaderField) error {
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return fmt.Errorf("pseudo-header %s after regular fields", f.Name)
+		}
+		if f.Name != strings.ToLower(f.Name) {
+			return fmt.Errorf("uppercase field name: %s", f.Name)
+		}
+		if connectionHeaders[f.Name] {
+			return fmt.Errorf("connection-specific field: %s", f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return fmt.Errorf("te other than trailers: %s", f.Value)
+		}
+	}
+	for name, value := range hpack.ToHeaders(fields) {
+		h.Set(name, value)
+	}
+	return nil
+}
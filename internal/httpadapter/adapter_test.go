@@ -0,0 +1,204 @@
+package httpadapter
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFromHTTP parses the raw request, runs h through FromHTTP and parses
+// what it wrote.
+func serveFromHTTP(t *testing.T, h http.Handler, raw string) *response.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:5000"
+
+	var out bytes.Buffer
+	FromHTTP(h)(response.NewWriter(&out), req)
+	resp, err := response.ResponseFromReader(&out)
+	require.NoError(t, err, out.String())
+	return resp
+}
+
+func TestFromHTTPRequest(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	})
+	serveFromHTTP(t, h, "POST /submit?a=1&b=two HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n")
+
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.Method)
+	assert.Equal(t, "/submit", got.URL.Path)
+	assert.Equal(t, "two", got.URL.Query().Get("b"))
+	assert.Equal(t, "/submit?a=1&b=two", got.RequestURI)
+	assert.Equal(t, "example.com", got.Host)
+	assert.Empty(t, got.Header.Get("Host"))
+	assert.Equal(t, "text/plain", got.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"chunked"}, got.TransferEncoding)
+	assert.Equal(t, int64(-1), got.ContentLength)
+	assert.Equal(t, "abc", got.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "192.0.2.1:5000", got.RemoteAddr)
+	assert.Equal(t, 1, got.ProtoMajor)
+	assert.Equal(t, 1, got.ProtoMinor)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestFromHTTPResponse(t *testing.T) {
+	// Test: Content-Length set by the handler keeps an identity body
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello")
+	})
+	resp := serveFromHTTP(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.StatusCodeCreated, resp.StatusLine.StatusCode)
+	assert.Equal(t, "5", resp.Headers["content-length"])
+	assert.NotContains(t, resp.Headers, "transfer-encoding")
+	assert.Equal(t, "hello", string(resp.Body))
+
+	// Test: Each Set-Cookie goes on a field line of its own
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+		w.Header().Add("Cache-Control", "no-cache")
+		w.Header().Add("Cache-Control", "no-store")
+	})
+	resp = serveFromHTTP(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, []string{"a=1", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"}, resp.Headers.Values("Set-Cookie"))
+	assert.Equal(t, "no-cache, no-store", resp.Headers["cache-control"])
+
+	// Test: No Content-Length streams chunked, with sniffed Content-Type and trailers
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Declared")
+		io.WriteString(w, "<html>")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "</html>")
+		w.Header().Set("X-Declared", "one")
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "two")
+	})
+	resp = serveFromHTTP(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, "chunked", resp.Headers["transfer-encoding"])
+	assert.Equal(t, "text/html; charset=utf-8", resp.Headers["content-type"])
+	assert.Equal(t, "<html></html>", string(resp.Body))
+	assert.Equal(t, "one", resp.Trailers["x-declared"])
+	assert.Equal(t, "two", resp.Trailers["x-undeclared"])
+
+	// Test: A handler that writes nothing sends an empty 200
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	resp = serveFromHTTP(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, "0", resp.Headers["content-length"])
+	assert.Empty(t, resp.Body)
+
+	// Test: 204 has no body even if the handler writes one
+	var writeErr error
+	h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		_, writeErr = io.WriteString(w, "ignored")
+	})
+	resp = serveFromHTTP(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.StatusCodeNoContent, resp.StatusLine.StatusCode)
+	assert.ErrorIs(t, writeErr, http.ErrBodyNotAllowed)
+	assert.Empty(t, resp.Body)
+}
+
+func TestFromHTTPHijack(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("got " + line)
+		rw.Flush()
+	})
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go FromHTTP(h)(response.NewConnWriter(server, []byte("read ahead\n")), req)
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "got read ahead\n", string(out))
+}
+
+func TestToHTTP(t *testing.T) {
+	var got *request.Request
+	h := func(w *response.Writer, req *request.Request) {
+		got = req
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Content-Length")
+		h.Set("Connection", "close")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello, "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Content-Length", "12")
+		w.WriteTrailers(trailers)
+	}
+	srv := httptest.NewServer(ToHTTP(h))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/echo?x=1", "text/plain", strings.NewReader("payload"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.False(t, resp.Close)
+	assert.Equal(t, "hello, world", string(body))
+	assert.Equal(t, "12", resp.Trailer.Get("X-Content-Length"))
+
+	require.NotNil(t, got)
+	assert.Equal(t, "POST", got.RequestLine.Method)
+	assert.Equal(t, "/echo?x=1", got.RequestLine.RequestTarget)
+	assert.Equal(t, "1.1", got.RequestLine.HttpVersion)
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), got.Headers["host"])
+	assert.Equal(t, "text/plain", got.Headers["content-type"])
+	assert.Equal(t, "payload", string(got.Body))
+}
+
+func TestToHTTPIdentityBody(t *testing.T) {
+	h := func(w *response.Writer, _ *request.Request) {
+		body := []byte("404 Not Found\n")
+		w.WriteStatusLine(response.StatusCodeNotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.ReadFrom(bytes.NewReader(body))
+	}
+	rec := httptest.NewRecorder()
+	ToHTTP(h).ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "14", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Header().Get("Connection"))
+	assert.Equal(t, "404 Not Found\n", rec.Body.String())
+}
@@ -0,0 +1,129 @@
+package httpadapter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+)
+
+// ToHTTP returns an http.Handler that runs h. The request body is read in
+// full before h is called, as RequestFromReader would. Chunks written by h
+// are flushed one by one, and its trailers are sent with the
+// http.TrailerPrefix convention.
+func ToHTTP(h server.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req, err := NewRequest(r)
+		if err != nil {
+			log.Printf("Error converting request: %v", err)
+			http.Error(rw, "400 Bad Request", http.StatusBadRequest)
+			return
+		}
+		h(response.NewFramedWriter(&httpFramer{rw: rw}), req)
+	})
+}
+
+// httpFramerSkip lists the fields net/http manages itself. It frames the
+// body and decides whether the connection stays open.
+var httpFramerSkip = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"transfer-encoding": true,
+}
+
+// httpFramer is a response.Framer that writes to an http.ResponseWriter.
+type httpFramer struct {
+	rw         http.ResponseWriter
+	statusCode response.StatusCode
+}
+
+func (f *httpFramer) WriteInformational(statusCode response.StatusCode, h headers.Headers) error {
+	// net/http sends the current header map with a 1xx response, so the
+	// interim fields are swapped in just for it.
+	header := f.rw.Header()
+	saved := header.Clone()
+	maps.Copy(header, HTTPHeader(h))
+	f.rw.WriteHeader(int(statusCode))
+	clear(header)
+	maps.Copy(header, saved)
+	return nil
+}
+
+func (f *httpFramer) WriteStatusLine(statusCode response.StatusCode) error {
+	f.statusCode = statusCode
+	return nil
+}
+
+func (f *httpFramer) WriteHeaders(h headers.Headers) error {
+	header := f.rw.Header()
+	for k := range h {
+		if httpFramerSkip[k] {
+			continue
+		}
+		header[http.CanonicalHeaderKey(k)] = h.Values(k)
+	}
+	f.rw.WriteHeader(int(f.statusCode))
+	return nil
+}
+
+func (f *httpFramer) WriteBody(p []byte) (int, error) {
+	return f.rw.Write(p)
+}
+
+func (f *httpFramer) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := f.rw.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{f.rw}, r)
+}
+
+func (f *httpFramer) WriteChunkedBody(p []byte) (int, error) {
+	n, err := f.rw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.Flush()
+}
+
+func (f *httpFramer) WriteChunkedBodyDone() (int, error) {
+	return 0, nil
+}
+
+func (f *httpFramer) WriteTrailers(h headers.Headers) error {
+	header := f.rw.Header()
+	for k := range h {
+		header[http.TrailerPrefix+http.CanonicalHeaderKey(k)] = h.Values(k)
+	}
+	return nil
+}
+
+func (f *httpFramer) Flush() error {
+	err := http.NewResponseController(f.rw).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (f *httpFramer) Hijack() (net.Conn, *bufio.Reader, error) {
+	conn, rw, err := http.NewResponseController(f.rw).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil, nil, response.ErrNotHijackable
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, rw.Reader, nil
+}
+
+// writerOnly hides any ReadFrom method so io.Copy does not recurse into it.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,182 @@
+package httpadapter
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+)
+
+// FromHTTP returns a server.Handler that runs h. Responses without a
+// Content-Length are sent chunked, Flush pushes buffered output through the
+// Writer, and trailers declared in the Trailer header or set with the
+// http.TrailerPrefix convention are written after the body.
+func FromHTTP(h http.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		r, err := NewHTTPRequest(req)
+		if err != nil {
+			response.WriteError(w, response.StatusCodeBadRequest, nil)
+			return
+		}
+		rw := &responseWriter{w: w, header: http.Header{}}
+		h.ServeHTTP(rw, r)
+		if err := rw.finish(); err != nil {
+			log.Printf("Error finishing response: %v", err)
+		}
+	}
+}
+
+// responseWriter implements http.ResponseWriter, http.Flusher and
+// http.Hijacker on top of a response.Writer.
+type responseWriter struct {
+	w           *response.Writer
+	header      http.Header
+	wroteHeader bool
+	bodyAllowed bool
+	chunked     bool
+	trailers    []string
+	hijacked    bool
+	err         error
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader || rw.err != nil {
+		return
+	}
+	code := response.StatusCode(statusCode)
+	if code.IsInformational() && code != response.StatusCodeSwitchingProtocols {
+		rw.err = rw.w.WriteInformational(code, Headers(rw.header))
+		return
+	}
+	rw.wroteHeader = true
+
+	h := headers.NewHeaders()
+	for k, values := range rw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) || len(values) == 0 {
+			continue
+		}
+		for _, v := range values {
+			h.Set(k, v)
+		}
+	}
+	for _, names := range rw.header.Values("Trailer") {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				rw.trailers = append(rw.trailers, name)
+			}
+		}
+	}
+	rw.bodyAllowed = !code.IsInformational() &&
+		code != response.StatusCodeNoContent &&
+		code != response.StatusCodeNotModified
+	_, hasLength := h.Get("Content-Length")
+	if rw.bodyAllowed && !hasLength {
+		h.Override("Transfer-Encoding", "chunked")
+		rw.chunked = true
+	}
+
+	if rw.err = rw.w.WriteStatusLine(code); rw.err != nil {
+		return
+	}
+	rw.err = rw.w.WriteHeaders(h)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		if rw.header.Get("Content-Type") == "" && len(p) > 0 {
+			rw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if !rw.bodyAllowed {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if len(p) == 0 {
+		// an empty chunk would end the body
+		return 0, nil
+	}
+	if !rw.chunked {
+		var n int
+		n, rw.err = rw.w.WriteBody(p)
+		return n, rw.err
+	}
+	// the count from WriteChunkedBody includes the chunk framing
+	if _, rw.err = rw.w.WriteChunkedBody(p); rw.err != nil {
+		return 0, rw.err
+	}
+	return len(p), nil
+}
+
+func (rw *responseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError is what http.ResponseController calls to flush.
+func (rw *responseWriter) FlushError() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.err != nil {
+		return rw.err
+	}
+	return rw.w.Flush()
+}
+
+// Hijack lets net/http handlers take over the connection, as they do for
+// WebSocket or CONNECT.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, reader, err := rw.w.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	rw.hijacked = true
+	return conn, bufio.NewReadWriter(reader, bufio.NewWriter(conn)), nil
+}
+
+// finish ends the response once the handler has returned. A handler that
+// wrote nothing gets an empty 200, and a chunked body is terminated with
+// its trailers.
+func (rw *responseWriter) finish() error {
+	if rw.hijacked {
+		return nil
+	}
+	if !rw.wroteHeader {
+		if rw.header.Get("Content-Length") == "" {
+			rw.header.Set("Content-Length", "0")
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.err != nil || !rw.chunked {
+		return rw.err
+	}
+	if _, err := rw.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	trailers := headers.NewHeaders()
+	for _, name := range rw.trailers {
+		for _, v := range rw.header.Values(name) {
+			trailers.Set(name, v)
+		}
+	}
+	for k, values := range rw.header {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			for _, v := range values {
+				trailers.Set(name, v)
+			}
+		}
+	}
+	return rw.w.WriteTrailers(trailers)
+}
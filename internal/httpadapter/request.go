@@ -0,0 +1,129 @@
+// Package httpadapter converts between this server's handlers and the
+// net/http ones, so that net/http handlers and middleware can be mounted on
+// a server.Mux and server.Handler functions can run inside an http.Server.
+package httpadapter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+)
+
+// NewHTTPRequest returns the net/http form of a parsed request, shaped the
+// way an http.Server would hand it to a handler: Host is moved out of the
+// header map, a chunked body is reported with a ContentLength of -1 and
+// trailers end up in Trailer.
+func NewHTTPRequest(req *request.Request) (*http.Request, error) {
+	target := req.RequestLine.RequestTarget
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, fmt.Errorf("malformed request target: %w", err)
+	}
+	proto := "HTTP/" + strings.TrimPrefix(req.RequestLine.HttpVersion, "HTTP/")
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		return nil, fmt.Errorf("malformed HTTP version: %s", req.RequestLine.HttpVersion)
+	}
+
+	h := HTTPHeader(req.Headers)
+	host := h.Get("Host")
+	if host == "" {
+		host = u.Host
+	}
+	h.Del("Host")
+
+	r := &http.Request{
+		Method:        req.RequestLine.Method,
+		URL:           u,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        h,
+		Body:          http.NoBody,
+		ContentLength: int64(len(req.Body)),
+		Host:          host,
+		RemoteAddr:    req.RemoteAddr,
+		RequestURI:    target,
+	}
+	if len(req.Body) > 0 {
+		r.Body = io.NopCloser(bytes.NewReader(req.Body))
+	}
+	if _, chunked := req.Headers.Get("Transfer-Encoding"); chunked {
+		r.TransferEncoding = []string{"chunked"}
+		r.ContentLength = -1
+		h.Del("Transfer-Encoding")
+	}
+	if len(req.Trailers) > 0 {
+		r.Trailer = HTTPHeader(req.Trailers)
+		h.Del("Trailer")
+	}
+	return r, nil
+}
+
+// NewRequest reads the body of r and returns it as a parsed request. Host
+// and Transfer-Encoding, which net/http keeps outside the header map, are
+// put back in.
+func NewRequest(r *http.Request) (*request.Request, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	h := Headers(r.Header)
+	if r.Host != "" {
+		h.Override("Host", r.Host)
+	}
+	if len(r.TransferEncoding) > 0 {
+		h.Override("Transfer-Encoding", strings.Join(r.TransferEncoding, ", "))
+	}
+	target := r.RequestURI
+	if target == "" {
+		target = r.URL.RequestURI()
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        r.Method,
+			RequestTarget: target,
+			HttpVersion:   fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor),
+		},
+		Headers:    h,
+		Body:       body,
+		Trailers:   Headers(r.Trailer),
+		RemoteAddr: r.RemoteAddr,
+	}, nil
+}
+
+// Headers converts an http.Header, joining repeated fields as Headers.Set
+// does. Keys without values, such as trailers announced but never sent,
+// are left out.
+func Headers(hh http.Header) headers.Headers {
+	h := headers.NewHeaders()
+	for k, values := range hh {
+		if len(values) == 0 {
+			continue
+		}
+		for _, v := range values {
+			h.Set(k, v)
+		}
+	}
+	return h
+}
+
+// HTTPHeader converts headers to an http.Header with canonical keys.
+func HTTPHeader(h headers.Headers) http.Header {
+	hh := make(http.Header, len(h))
+	for k := range h {
+		hh[http.CanonicalHeaderKey(k)] = h.Values(k)
+	}
+	return hh
+}
@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+const defaultTunnelDialTimeout = 10 * time.Second
+
+var errDestinationDenied = errors.New("tunnel destination denied")
+
+// Rule matches tunnel destinations by host and port.
+type Rule struct {
+	// Host is an exact host name, a "*.example.com" pattern that matches
+	// any subdomain, "*" for every host, or a CIDR prefix matched against
+	// the addresses the destination resolves to.
+	Host string
+	// MinPort and MaxPort bound the destination port. Zero for both
+	// matches any port.
+	MinPort, MaxPort int
+
+	prefix netip.Prefix
+}
+
+// ParseRule parses a rule written as host, host:port or host:low-high.
+// IPv6 prefixes need brackets when a port is given, as in [fd00::/8]:443.
+func ParseRule(s string) (Rule, error) {
+	host, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return Rule{}, fmt.Errorf("missing ] in rule: %s", s)
+		}
+		host, ports = s[1:end], strings.TrimPrefix(s[end+1:], ":")
+	} else if strings.Count(s, ":") == 1 {
+		host, ports, _ = strings.Cut(s, ":")
+	}
+	if host == "" {
+		return Rule{}, fmt.Errorf("missing host in rule: %s", s)
+	}
+	r := Rule{Host: strings.ToLower(host)}
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		r.prefix = prefix.Masked()
+	} else if addr, err := netip.ParseAddr(host); err == nil {
+		r.prefix = netip.PrefixFrom(addr, addr.BitLen())
+	} else {
+		r.Host = strings.TrimSuffix(r.Host, ".")
+	}
+	if ports == "" || ports == "*" {
+		return r, nil
+	}
+	low, high, isRange := strings.Cut(ports, "-")
+	var err error
+	if r.MinPort, err = strconv.Atoi(low); err != nil {
+		return Rule{}, fmt.Errorf("invalid port in rule: %s", s)
+	}
+	r.MaxPort = r.MinPort
+	if isRange {
+		if r.MaxPort, err = strconv.Atoi(high); err != nil {
+			return Rule{}, fmt.Errorf("invalid port range in rule: %s", s)
+		}
+	}
+	if r.MinPort < 1 || r.MaxPort > 65535 || r.MinPort > r.MaxPort {
+		return Rule{}, fmt.Errorf("invalid port range in rule: %s", s)
+	}
+	return r, nil
+}
+
+// ParseRules parses a comma-separated list of rules.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		r, err := ParseRule(field)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (r Rule) matchPort(port int) bool {
+	return r.MinPort == 0 && r.MaxPort == 0 || port >= r.MinPort && port <= r.MaxPort
+}
+
+// matchName matches host name rules against a lower-case host without a
+// trailing dot. Address rules never match a name.
+func (r Rule) matchName(host string, port int) bool {
+	if r.prefix.IsValid() || !r.matchPort(port) {
+		return false
+	}
+	switch {
+	case r.Host == "*":
+		return true
+	case strings.HasPrefix(r.Host, "*."):
+		return strings.HasSuffix(host, r.Host[1:])
+	}
+	return host == r.Host
+}
+
+func (r Rule) matchAddr(addr netip.Addr, port int) bool {
+	return r.prefix.IsValid() && r.matchPort(port) && r.prefix.Contains(addr.Unmap())
+}
+
+// TunnelStats describes a finished tunnel.
+type TunnelStats struct {
+	Target     string
+	ClientAddr string
+	// BytesUp counts bytes sent from the client to the target, BytesDown
+	// the bytes sent back.
+	BytesUp   int64
+	BytesDown int64
+	Duration  time.Duration
+}
+
+// Tunnel serves CONNECT requests for a forward proxy. It opens a TCP
+// connection to the requested destination and relays bytes in both
+// directions until either side closes.
+type Tunnel struct {
+	// Allow lists the permitted destinations. When empty, every
+	// destination not denied is permitted.
+	Allow []Rule
+	// Deny lists forbidden destinations. It takes precedence over Allow.
+	Deny []Rule
+	// DialTimeout bounds connecting to the destination.
+	DialTimeout time.Duration
+	// OnClose receives the byte counts of every tunnel once both
+	// directions are done. When nil they are logged.
+	OnClose func(TunnelStats)
+	// Resolver looks up destination addresses. Nil means net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// Serve handles a CONNECT request. It has the shape of a server.Handler.
+// Address rules are checked against the resolved addresses, and the tunnel
+// dials the checked address, so a name cannot resolve past a deny rule.
+func (t *Tunnel) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "CONNECT" {
+		h := response.ErrorHeaders(response.StatusCodeMethodNotAllowed)
+		h.Override("Allow", "CONNECT")
+		response.WriteError(w, response.StatusCodeMethodNotAllowed, h)
+		return
+	}
+	target := req.RequestLine.RequestTarget
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		response.WriteError(w, response.StatusCodeBadRequest, nil)
+		return
+	}
+	// rules are checked against, and the tunnel resolves, the same name
+	// without the trailing dot of a fully qualified one
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || strings.HasSuffix(host, ".") {
+		response.WriteError(w, response.StatusCodeBadRequest, nil)
+		return
+	}
+	port, _ := strconv.Atoi(portText)
+	if !t.nameAllowed(host, port) {
+		response.WriteError(w, response.StatusCodeForbidden, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.dialTimeout())
+	defer cancel()
+	addr, err := t.resolve(ctx, host, port)
+	if err != nil {
+		log.Printf("Error resolving tunnel target %s: %v", target, err)
+		response.WriteError(w, tunnelErrorStatus(err), nil)
+		return
+	}
+	var dialer net.Dialer
+	upstream, err := dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(addr, uint16(port)).String())
+	if err != nil {
+		log.Printf("Error connecting tunnel to %s: %v", target, err)
+		response.WriteError(w, upstreamErrorStatus(err), nil)
+		return
+	}
+
+	client, reader, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		log.Printf("Error hijacking connection for tunnel to %s: %v", target, err)
+		response.WriteError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+	// a 2xx response to CONNECT has no body and no framing headers
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	start := time.Now()
+	stats := TunnelStats{Target: target, ClientAddr: req.RemoteAddr}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// the reader starts with anything the client sent before the 200
+		stats.BytesUp, _ = io.Copy(upstream, reader)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		stats.BytesDown, _ = io.Copy(client, upstream)
+		closeWrite(client)
+	}()
+	wg.Wait()
+	client.Close()
+	upstream.Close()
+	stats.Duration = time.Since(start)
+	if t.OnClose != nil {
+		t.OnClose(stats)
+		return
+	}
+	log.Printf("Tunnel %s -> %s closed: %d bytes up, %d bytes down in %s",
+		stats.ClientAddr, stats.Target, stats.BytesUp, stats.BytesDown, stats.Duration)
+}
+
+func (t *Tunnel) nameAllowed(host string, port int) bool {
+	for _, r := range t.Deny {
+		if r.matchName(host, port) {
+			return false
+		}
+	}
+	if len(t.Allow) == 0 {
+		return true
+	}
+	for _, r := range t.Allow {
+		if r.matchName(host, port) {
+			return true
+		}
+	}
+	// an address rule may still allow what the name resolves to
+	for _, r := range t.Allow {
+		if r.prefix.IsValid() {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the first address of host that the address rules permit.
+func (t *Tunnel) resolve(ctx context.Context, host string, port int) (netip.Addr, error) {
+	resolver := t.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		addrs, err = resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	nameAllowed := false
+	for _, r := range t.Allow {
+		nameAllowed = nameAllowed || r.matchName(host, port)
+	}
+	for _, addr := range addrs {
+		if t.addrAllowed(addr, port, nameAllowed) {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, errDestinationDenied
+}
+
+func (t *Tunnel) addrAllowed(addr netip.Addr, port int, nameAllowed bool) bool {
+	for _, r := range t.Deny {
+		if r.matchAddr(addr, port) {
+			return false
+		}
+	}
+	if len(t.Allow) == 0 || nameAllowed {
+		return true
+	}
+	for _, r := range t.Allow {
+		if r.matchAddr(addr, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tunnel) dialTimeout() time.Duration {
+	if t.DialTimeout > 0 {
+		return t.DialTimeout
+	}
+	return defaultTunnelDialTimeout
+}
+
+func tunnelErrorStatus(err error) response.StatusCode {
+	if errors.Is(err, errDestinationDenied) {
+		return response.StatusCodeForbidden
+	}
+	return upstreamErrorStatus(err)
+}
+
+// closeWrite half-closes a TCP connection so the peer sees the end of the
+// stream while the other direction keeps flowing.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer copies every connection's input back to it.
+func echoServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// serveTunnel serves one connection with the tunnel the way the server does.
+func serveTunnel(t *testing.T, tunnel *Tunnel) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		reader := request.NewReader(conn)
+		req, err := reader.ReadRequest()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		req.RemoteAddr = conn.RemoteAddr().String()
+		w := response.NewConnWriter(conn, reader.Buffered())
+		tunnel.Serve(w, req)
+		if !w.Hijacked() {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestTunnel(t *testing.T) {
+	upstream := echoServer(t)
+	stats := make(chan TunnelStats, 1)
+	tunnel := &Tunnel{
+		Allow:   []Rule{mustParseRule(t, "127.0.0.0/8")},
+		OnClose: func(s TunnelStats) { stats <- s },
+	}
+	conn := serveTunnel(t, tunnel)
+	target := upstream.Addr().String()
+
+	// Test: Bytes sent right behind the request go through the tunnel
+	_, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\nearly", target, target)
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = io.WriteString(conn, " data")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	echoed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "early data", string(echoed))
+
+	// Test: Both directions are accounted for
+	s := <-stats
+	assert.Equal(t, target, s.Target)
+	assert.Equal(t, int64(10), s.BytesUp)
+	assert.Equal(t, int64(10), s.BytesDown)
+	assert.Equal(t, conn.LocalAddr().String(), s.ClientAddr)
+}
+
+func connectRequest(t *testing.T, tunnel *Tunnel, method, target string) *response.Response {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		RemoteAddr:  "192.0.2.7:51000",
+	}
+	buf := &bytes.Buffer{}
+	tunnel.Serve(response.NewWriter(buf), req)
+	resp, err := response.ResponseFromReader(buf)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestTunnelRefused(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      string
+		deny       string
+		method     string
+		target     string
+		statusCode response.StatusCode
+	}{
+		{"not CONNECT", "", "", "GET", "/", response.StatusCodeMethodNotAllowed},
+		{"denied prefix", "", "127.0.0.0/8", "CONNECT", "127.0.0.1:443", response.StatusCodeForbidden},
+		{"denied port", "", "*:25", "CONNECT", "mail.example.com:25", response.StatusCodeForbidden},
+		{"deny wins over allow", "*.example.com", "secret.example.com", "CONNECT", "secret.example.com:443", response.StatusCodeForbidden},
+		{"not allowed host", "*.example.com:443", "", "CONNECT", "example.org:443", response.StatusCodeForbidden},
+		{"not allowed port", "*.example.com:443", "", "CONNECT", "www.example.com:8443", response.StatusCodeForbidden},
+		{"not allowed address", "10.0.0.0/8", "", "CONNECT", "127.0.0.1:443", response.StatusCodeForbidden},
+		{"name resolving to a denied address", "localhost", "127.0.0.0/8, ::1", "CONNECT", "localhost:443", response.StatusCodeForbidden},
+		{"denied name with a trailing dot", "", "denied.test,*.blocked.test", "CONNECT", "denied.test.:443", response.StatusCodeForbidden},
+		{"denied subdomain with a trailing dot", "", "denied.test,*.blocked.test", "CONNECT", "a.blocked.test.:443", response.StatusCodeForbidden},
+		{"denied name in upper case", "", "denied.test.", "CONNECT", "Denied.TEST:443", response.StatusCodeForbidden},
+		{"name with a trailing dot resolving to a denied address", "localhost", "127.0.0.0/8, ::1", "CONNECT", "localhost.:443", response.StatusCodeForbidden},
+		{"name with two trailing dots", "", "", "CONNECT", "denied.test..:443", response.StatusCodeBadRequest},
+		{"connection refused", "", "", "CONNECT", closedPort(t), response.StatusCodeBadGateway},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			allow, err := ParseRules(tc.allow)
+			require.NoError(t, err)
+			deny, err := ParseRules(tc.deny)
+			require.NoError(t, err)
+			resp := connectRequest(t, &Tunnel{Allow: allow, Deny: deny}, tc.method, tc.target)
+			assert.Equal(t, tc.statusCode, resp.StatusLine.StatusCode)
+		})
+	}
+}
+
+// closedPort returns a loopback address nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func mustParseRule(t *testing.T, s string) Rule {
+	t.Helper()
+	r, err := ParseRule(s)
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseRule(t *testing.T) {
+	// Test: Names, patterns and ports
+	r := mustParseRule(t, "*.Example.com:443")
+	assert.True(t, r.matchName("www.example.com", 443))
+	assert.False(t, r.matchName("example.com", 443))
+	assert.False(t, r.matchName("www.example.com", 80))
+
+	r = mustParseRule(t, "example.com.:8000-8999")
+	assert.True(t, r.matchName("example.com", 8080))
+	assert.False(t, r.matchName("example.com", 9000))
+
+	r = mustParseRule(t, "*")
+	assert.True(t, r.matchName("anything", 1))
+
+	// Test: Address prefixes match resolved addresses, not names
+	r = mustParseRule(t, "10.0.0.0/8:22")
+	assert.True(t, r.matchAddr(netip.MustParseAddr("10.1.2.3"), 22))
+	assert.False(t, r.matchAddr(netip.MustParseAddr("10.1.2.3"), 23))
+	assert.False(t, r.matchName("10.0.0.0/8", 22))
+
+	r = mustParseRule(t, "[fd00::/8]:443")
+	assert.True(t, r.matchAddr(netip.MustParseAddr("fd12::1"), 443))
+	r = mustParseRule(t, "::1")
+	assert.True(t, r.matchAddr(netip.MustParseAddr("::1"), 8080))
+	r = mustParseRule(t, "127.0.0.1")
+	assert.True(t, r.matchAddr(netip.MustParseAddr("::ffff:127.0.0.1"), 80))
+
+	// Test: Malformed rules
+	for _, s := range []string{"", ":443", "example.com:http", "example.com:0", "example.com:9-1", "[fd00::/8:443"} {
+		_, err := ParseRule(s)
+		assert.Error(t, err, s)
+	}
+}
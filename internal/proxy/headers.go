@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// hopByHopHeaders apply to a single connection and must not be forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHop deletes the standard hop-by-hop headers and any header
+// named in the Connection header.
+func removeHopByHop(h headers.Headers) {
+	if connection, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Remove(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Remove(name)
+	}
+}
+
+// addForwarded records the client hop in Forwarded and the X-Forwarded-*
+// headers, appending to any values set by earlier proxies.
+func addForwarded(h headers.Headers, remoteAddr, host, proto string) {
+	clientIP := remoteAddr
+	if ip, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = ip
+	}
+	node := clientIP
+	if strings.Contains(node, ":") {
+		// IPv6 addresses must be quoted and bracketed
+		node = `"[` + node + `]"`
+	}
+	element := "for=" + node
+	if host != "" {
+		element += ";host=" + quoteIfNeeded(host)
+	}
+	element += ";proto=" + proto
+	if clientIP != "" {
+		h.Set("Forwarded", element)
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if host != "" {
+		h.Override("X-Forwarded-Host", host)
+	}
+	h.Override("X-Forwarded-Proto", proto)
+}
+
+func quoteIfNeeded(value string) string {
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
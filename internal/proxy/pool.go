@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/client"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+)
+
+const (
+	DefaultMaxFailures         = 3
+	DefaultEjectDuration       = 30 * time.Second
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultRetries             = 2
+	healthCheckTimeout         = 2 * time.Second
+	virtualNodes               = 100
+)
+
+// Backend is one upstream replica in a Pool.
+type Backend struct {
+	URL *url.URL
+
+	active       atomic.Int64
+	unhealthy    atomic.Bool
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64
+}
+
+// Active returns the number of requests currently in flight to the backend.
+func (b *Backend) Active() int64 {
+	return b.active.Load()
+}
+
+// Available reports whether the backend passes health checks and is not
+// ejected after recent errors.
+func (b *Backend) Available() bool {
+	return !b.unhealthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// Strategy chooses a backend for a request. usable filters out backends that
+// are down or were already tried for this request.
+type Strategy interface {
+	Pick(backends []*Backend, req *request.Request, usable func(*Backend) bool) *Backend
+}
+
+// RoundRobin cycles through the usable backends in order.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (s *RoundRobin) Pick(backends []*Backend, _ *request.Request, usable func(*Backend) bool) *Backend {
+	start := s.next.Add(1) - 1
+	for i := range backends {
+		b := backends[(start+uint64(i))%uint64(len(backends))]
+		if usable(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// LeastConnections picks the usable backend with the fewest requests in
+// flight, preferring earlier backends on ties.
+type LeastConnections struct{}
+
+func (LeastConnections) Pick(backends []*Backend, _ *request.Request, usable func(*Backend) bool) *Backend {
+	var best *Backend
+	for _, b := range backends {
+		if !usable(b) {
+			continue
+		}
+		if best == nil || b.Active() < best.Active() {
+			best = b
+		}
+	}
+	return best
+}
+
+// ConsistentHash maps requests onto a hash ring so the same key keeps
+// reaching the same backend, and only keys of a backend that goes away move.
+// The key is the value of Header, or the client IP when Header is empty or
+// missing from the request.
+type ConsistentHash struct {
+	Header string
+
+	once sync.Once
+	ring []ringPoint
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+func (s *ConsistentHash) Pick(backends []*Backend, req *request.Request, usable func(*Backend) bool) *Backend {
+	s.once.Do(func() {
+		for _, b := range backends {
+			for i := 0; i < virtualNodes; i++ {
+				s.ring = append(s.ring, ringPoint{hash: hashKey(b.URL.String() + "#" + strconv.Itoa(i)), backend: b})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	})
+	if len(s.ring) == 0 {
+		return nil
+	}
+	h := hashKey(s.key(req))
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	for i := range s.ring {
+		point := s.ring[(start+i)%len(s.ring)]
+		if usable(point.backend) {
+			return point.backend
+		}
+	}
+	return nil
+}
+
+func (s *ConsistentHash) key(req *request.Request) string {
+	if s.Header != "" {
+		if v, ok := req.Headers.Get(s.Header); ok {
+			return v
+		}
+	}
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return ip
+	}
+	return req.RemoteAddr
+}
+
+// hashKey is FNV-1a followed by the MurmurHash3 finalizer. FNV-1a alone
+// barely changes the high bits for keys that differ only at the end, such
+// as backend URLs with different ports, which bunches them on the ring.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Pool is a set of backends sharing a balancing strategy, active health
+// checks and passive ejection of backends that keep failing.
+type Pool struct {
+	Backends []*Backend
+	Strategy Strategy
+	// HealthCheckPath is requested on every backend each
+	// HealthCheckInterval; an empty path disables active checks.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	// MaxFailures consecutive errors eject a backend for EjectDuration.
+	MaxFailures   int
+	EjectDuration time.Duration
+	// Retries is how many other backends an idempotent request is retried
+	// on after a connection error.
+	Retries int
+
+	client *client.Client
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewPool(upstreams []string, strategy Strategy) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+	backends := make([]*Backend, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, &Backend{URL: u})
+	}
+	checker := client.New()
+	checker.Timeout = healthCheckTimeout
+	checker.DialTimeout = healthCheckTimeout
+	return &Pool{
+		Backends:            backends,
+		Strategy:            strategy,
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		MaxFailures:         DefaultMaxFailures,
+		EjectDuration:       DefaultEjectDuration,
+		Retries:             DefaultRetries,
+		client:              checker,
+	}, nil
+}
+
+// pick returns a usable backend that is not in tried, or nil.
+func (p *Pool) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	return p.Strategy.Pick(p.Backends, req, func(b *Backend) bool {
+		return b.Available() && !tried[b]
+	})
+}
+
+// report records the outcome of a request for passive ejection.
+func (p *Pool) report(b *Backend, ok bool) {
+	if ok {
+		b.failures.Store(0)
+		return
+	}
+	if int(b.failures.Add(1)) >= p.MaxFailures {
+		b.failures.Store(0)
+		b.ejectedUntil.Store(time.Now().Add(p.EjectDuration).UnixNano())
+		log.Printf("Ejecting backend %s for %s", b.URL, p.EjectDuration)
+	}
+}
+
+// StartHealthChecks probes every backend immediately and then on each
+// interval until Close is called.
+func (p *Pool) StartHealthChecks() {
+	if p.HealthCheckPath == "" || p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			p.checkAll()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Pool) Close() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	p.stop = nil
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.Backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			healthy := p.check(b)
+			if b.unhealthy.Swap(!healthy) == healthy {
+				log.Printf("Backend %s healthy: %t", b.URL, healthy)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(b *Backend) bool {
+	target := *b.URL
+	target.Path = joinPath(b.URL.Path, p.HealthCheckPath)
+	resp, err := p.client.Get(target.String())
+	if err != nil {
+		return false
+	}
+	return resp.StatusLine.StatusCode >= 200 && resp.StatusLine.StatusCode < 400
+}
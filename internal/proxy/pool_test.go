@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedBackend answers every request with its own name.
+func namedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestPool(t *testing.T, strategy Strategy, urls ...string) *Pool {
+	t.Helper()
+	pool, err := NewPool(urls, strategy)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func backendName(out string) string {
+	_, body, _ := strings.Cut(out, "\r\n\r\n")
+	return body
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, c := namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")
+	p := NewBalanced(newTestPool(t, &RoundRobin{}, a.URL, b.URL, c.URL))
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, backendName(proxyRequest(p, "GET", "/", nil, "")))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	var slowHits atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowHits.Add(1)
+		<-release
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := namedBackend(t, "fast")
+	p := NewBalanced(newTestPool(t, LeastConnections{}, slow.URL, fast.URL))
+
+	// Test: The first request ties and goes to the first backend
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		proxyRequest(p, "GET", "/", nil, "")
+	}()
+	require.Eventually(t, func() bool { return slowHits.Load() == 1 }, time.Second, time.Millisecond)
+
+	// Test: While it is busy, the idle backend gets the traffic
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "fast", backendName(proxyRequest(p, "GET", "/", nil, "")))
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(0), p.Pool.Backends[0].Active())
+}
+
+func TestConsistentHash(t *testing.T) {
+	a, b, c := namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")
+	pool := newTestPool(t, &ConsistentHash{Header: "X-User"}, a.URL, b.URL, c.URL)
+	p := NewBalanced(pool)
+
+	// Test: The same key always reaches the same backend
+	first := map[string]string{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		first[user] = backendName(proxyRequest(p, "GET", "/", map[string]string{"X-User": user}, ""))
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, first[user], backendName(proxyRequest(p, "GET", "/", map[string]string{"X-User": user}, "")))
+		}
+	}
+
+	// Test: Keys spread over more than one backend
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		req := &request.Request{Headers: headers.NewHeaders()}
+		req.Headers.Set("X-User", "user-"+string(rune('a'+i%26))+string(rune('a'+i/26)))
+		seen[pool.pick(req, nil).URL.String()] = true
+	}
+	assert.Greater(t, len(seen), 1)
+
+	// Test: Only the keys of an unavailable backend move
+	ejected := pool.pick(&request.Request{Headers: headers.Headers{"x-user": "alice"}}, nil)
+	ejected.unhealthy.Store(true)
+	assert.NotEqual(t, first["alice"], backendName(proxyRequest(p, "GET", "/", map[string]string{"X-User": "alice"}, "")))
+	for user, name := range first {
+		if name != first["alice"] {
+			assert.Equal(t, name, backendName(proxyRequest(p, "GET", "/", map[string]string{"X-User": user}, "")), user)
+		}
+	}
+
+	// Test: Client IP is the key without the header
+	req := &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "192.0.2.7:1234"}
+	other := &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "192.0.2.7:5678"}
+	assert.Equal(t, pool.pick(req, nil), pool.pick(other, nil))
+}
+
+func TestRetryAndEjection(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := namedBackend(t, "up")
+	pool := newTestPool(t, &RoundRobin{}, down.URL, up.URL)
+	pool.MaxFailures = 2
+	p := NewBalanced(pool)
+
+	// Test: Idempotent requests are retried on another backend
+	assert.Equal(t, "up", backendName(proxyRequest(p, "GET", "/", nil, "")))
+
+	// Test: Non-idempotent requests are not retried
+	out := proxyRequest(p, "POST", "/", nil, "data")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Repeated errors eject the backend
+	assert.False(t, pool.Backends[0].Available())
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "up", backendName(proxyRequest(p, "POST", "/", nil, "data")))
+	}
+
+	// Test: No usable backend
+	pool.Backends[1].unhealthy.Store(true)
+	out = proxyRequest(p, "GET", "/", nil, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/base/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("flaky"))
+	}))
+	defer flaky.Close()
+	stable := namedBackend(t, "stable")
+	pool := newTestPool(t, &RoundRobin{}, flaky.URL+"/base", stable.URL)
+	pool.HealthCheckPath = "/healthz"
+	pool.HealthCheckInterval = 10 * time.Millisecond
+	pool.StartHealthChecks()
+
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return !pool.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+	p := NewBalanced(pool)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "stable", backendName(proxyRequest(p, "GET", "/", nil, "")))
+	}
+
+	healthy.Store(true)
+	require.Eventually(t, func() bool { return pool.Backends[0].Available() }, time.Second, 5*time.Millisecond)
+}
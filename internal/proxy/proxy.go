@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/client"
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+const copyBufferSize = 32 * 1024
+
+// errBadRequest is returned for requests that cannot be forwarded as they
+// are; the client gets 400 Bad Request.
+var errBadRequest = errors.New("malformed request")
+
+const responseHeaderTimeout = 30 * time.Second
+
+func newClient() *client.Client {
+	c := client.New()
+	c.Timeout = responseHeaderTimeout
+	return c
+}
+
+// ReverseProxy forwards requests to an upstream server and streams the
+// upstream response back to the client.
+type ReverseProxy struct {
+	// Upstream is the base URL requests are forwarded to. Its path is
+	// prepended to the request path.
+	Upstream *url.URL
+	// Pool, when set, replaces Upstream with a balanced set of backends.
+	Pool *Pool
+	// StripPrefix is removed from the request path before forwarding.
+	StripPrefix string
+	// Client performs the upstream round trip.
+	Client *client.Client
+	// ChecksumTrailers makes the proxy always stream chunked and append
+	// X-Content-SHA256 and X-Content-Length trailers for the body.
+	ChecksumTrailers bool
+}
+
+func New(upstream string) (*ReverseProxy, error) {
+	u, err := parseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &ReverseProxy{
+		Upstream: u,
+		Client:   newClient(),
+	}, nil
+}
+
+// NewBalanced returns a ReverseProxy that spreads requests over the pool.
+func NewBalanced(pool *Pool) *ReverseProxy {
+	return &ReverseProxy{
+		Pool:   pool,
+		Client: newClient(),
+	}
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("malformed upstream: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upstream has no host: %s", upstream)
+	}
+	return u, nil
+}
+
+// Serve forwards the request upstream. It has the shape of a server.Handler.
+// With a Pool, idempotent requests that fail to connect are retried on
+// another backend.
+func (p *ReverseProxy) Serve(w *response.Writer, req *request.Request) {
+	if p.Pool == nil {
+		p.forward(w, req, p.Upstream)
+		return
+	}
+	attempts := 1
+	if client.Idempotent(req.RequestLine.Method) {
+		attempts += p.Pool.Retries
+	}
+	tried := map[*Backend]bool{}
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		backend := p.Pool.pick(req, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+		backend.active.Add(1)
+		resp, body, err := p.roundTrip(req, backend.URL)
+		if errors.Is(err, errBadRequest) {
+			backend.active.Add(-1)
+			response.WriteError(w, response.StatusCodeBadRequest, nil)
+			return
+		}
+		if err != nil {
+			backend.active.Add(-1)
+			p.Pool.report(backend, false)
+			lastErr = err
+			log.Printf("Error proxying to backend %s: %v", backend.URL, err)
+			continue
+		}
+		p.Pool.report(backend, resp.StatusLine.StatusCode < 500)
+		p.stream(w, resp, body)
+		backend.active.Add(-1)
+		return
+	}
+	if lastErr != nil {
+		response.WriteError(w, upstreamErrorStatus(lastErr), nil)
+		return
+	}
+	response.WriteError(w, response.StatusCodeServiceUnavailable, nil)
+}
+
+func (p *ReverseProxy) forward(w *response.Writer, req *request.Request, upstream *url.URL) {
+	resp, body, err := p.roundTrip(req, upstream)
+	if errors.Is(err, errBadRequest) {
+		response.WriteError(w, response.StatusCodeBadRequest, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error proxying %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		response.WriteError(w, upstreamErrorStatus(err), nil)
+		return
+	}
+	p.stream(w, resp, body)
+}
+
+func (p *ReverseProxy) roundTrip(req *request.Request, upstream *url.URL) (*response.Response, io.ReadCloser, error) {
+	outReq, err := p.outboundRequest(req, upstream)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.Client.Stream(outReq)
+}
+
+func (p *ReverseProxy) stream(w *response.Writer, resp *response.Response, body io.ReadCloser) {
+	defer body.Close()
+	if err := p.writeResponse(w, resp, body); err != nil {
+		log.Printf("Error streaming upstream response: %v", err)
+	}
+}
+
+func (p *ReverseProxy) outboundRequest(req *request.Request, upstream *url.URL) (*request.Request, error) {
+	path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("%w: target not in origin-form: %s", errBadRequest, req.RequestLine.RequestTarget)
+	}
+	// the path stays as the client encoded it, so that an escaped slash
+	// is not confused with a separator and nothing is encoded twice
+	target := *upstream
+	target.RawPath = joinPath(upstream.EscapedPath(), strings.TrimPrefix(path, p.StripPrefix))
+	unescaped, err := url.PathUnescape(target.RawPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	target.Path = unescaped
+	target.RawQuery = query
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, target.String(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	for k, v := range req.Headers {
+		// requests that did not come through the HTTP/1.1 parser, such as
+		// HTTP/2 ones, could otherwise smuggle field lines upstream
+		for _, value := range req.Headers.Values(k) {
+			if !headers.ValidValue(value) {
+				return nil, fmt.Errorf("%w: invalid value for field %s", errBadRequest, k)
+			}
+		}
+		h.Override(k, v)
+	}
+	removeHopByHop(h)
+	host, _ := req.Headers.Get("Host")
+	addForwarded(h, req.RemoteAddr, host, "http")
+	h.Remove("Host")
+	h.Remove("Content-Length")
+	outReq.Headers = h
+	return outReq, nil
+}
+
+func (p *ReverseProxy) writeResponse(w *response.Writer, resp *response.Response, body io.Reader) error {
+	h := headers.NewHeaders()
+	for k, v := range resp.Headers {
+		h.Override(k, v)
+	}
+	contentLength, hasLength := resp.Headers.Get("Content-Length")
+	_, upstreamChunked := resp.Headers.Get("Transfer-Encoding")
+	declaredTrailers, _ := resp.Headers.Get("Trailer")
+	removeHopByHop(h)
+	h.Remove("Content-Length")
+
+	statusCode := resp.StatusLine.StatusCode
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if !bodyAllowed(statusCode) {
+		return w.WriteHeaders(h)
+	}
+
+	chunked := !hasLength || upstreamChunked || p.ChecksumTrailers
+	if !chunked {
+		h.Override("Content-Length", contentLength)
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+		_, err := w.ReadFrom(body)
+		return err
+	}
+
+	trailerNames := make([]string, 0, 2)
+	for _, name := range strings.Split(declaredTrailers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			trailerNames = append(trailerNames, name)
+		}
+	}
+	if p.ChecksumTrailers {
+		trailerNames = append(trailerNames, "X-Content-SHA256", "X-Content-Length")
+	}
+	sort.Strings(trailerNames)
+	h.Override("Transfer-Encoding", "chunked")
+	if len(trailerNames) > 0 {
+		h.Override("Trailer", strings.Join(trailerNames, ", "))
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	bodyLength := 0
+	buffer := make([]byte, copyBufferSize)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, werr := w.WriteChunkedBody(buffer[:n]); werr != nil {
+				return werr
+			}
+			hash.Write(buffer[:n])
+			bodyLength += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	trailers := headers.NewHeaders()
+	for name, v := range resp.Trailers {
+		trailers.Override(name, v)
+	}
+	if p.ChecksumTrailers {
+		trailers.Override("X-Content-SHA256", fmt.Sprintf("%x", hash.Sum(nil)))
+		trailers.Override("X-Content-Length", fmt.Sprintf("%d", bodyLength))
+	}
+	return w.WriteTrailers(trailers)
+}
+
+// bodyAllowed reports whether a response with the status code can carry a
+// body at all.
+func bodyAllowed(statusCode response.StatusCode) bool {
+	return !statusCode.IsInformational() &&
+		statusCode != response.StatusCodeNoContent &&
+		statusCode != response.StatusCodeNotModified
+}
+
+func upstreamErrorStatus(err error) response.StatusCode {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return response.StatusCodeGatewayTimeout
+	}
+	return response.StatusCodeBadGateway
+}
+
+// joinPath joins the upstream base path and the request path with exactly
+// one slash between them.
+func joinPath(base, path string) string {
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyRequest(p *ReverseProxy, method, target string, h map[string]string, body string) string {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte(body),
+		RemoteAddr:  "192.0.2.7:51000",
+	}
+	for k, v := range h {
+		req.Headers.Set(k, v)
+	}
+	buf := &bytes.Buffer{}
+	p.Serve(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func TestForwardRequest(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Content-Length", "7")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL + "/base")
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+
+	out := proxyRequest(p, "PUT", "/api/items/42?force=1", map[string]string{
+		"Host":       "example.com",
+		"X-Custom":   "kept",
+		"Connection": "X-Secret",
+		"X-Secret":   "dropped",
+		"Upgrade":    "websocket",
+	}, "payload")
+
+	// Test: Method, path, query and body are forwarded
+	require.NotNil(t, got)
+	assert.Equal(t, "PUT", got.Method)
+	assert.Equal(t, "/base/items/42", got.URL.Path)
+	assert.Equal(t, "force=1", got.URL.RawQuery)
+	assert.Equal(t, "payload", string(gotBody))
+
+	// Test: End-to-end headers are kept and hop-by-hop ones stripped
+	assert.Equal(t, "kept", got.Header.Get("X-Custom"))
+	assert.Empty(t, got.Header.Get("X-Secret"))
+	assert.Empty(t, got.Header.Get("Upgrade"))
+
+	// Test: Forwarding headers describe the client hop
+	assert.Equal(t, "for=192.0.2.7;host=example.com;proto=http", got.Header.Get("Forwarded"))
+	assert.Equal(t, "192.0.2.7", got.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "example.com", got.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", got.Header.Get("X-Forwarded-Proto"))
+
+	// Test: Status, headers and body come back faithfully
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, out, "x-upstream: yes\r\n")
+	assert.Contains(t, out, "content-length: 7\r\n")
+	assert.NotContains(t, out, "keep-alive")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ncreated"))
+}
+
+func TestForwardEscapedPath(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL + "/base%20dir")
+	require.NoError(t, err)
+	p.StripPrefix = "/httpbin"
+
+	// Test: The path goes upstream encoded as the client sent it
+	out := proxyRequest(p, "GET", "/httpbin/anything/a%20b%2Fc?x=1", nil, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	require.NotNil(t, got)
+	assert.Equal(t, "/base%20dir/anything/a%20b%2Fc?x=1", got.RequestURI)
+
+	// Test: Invalid escapes are refused
+	got = nil
+	out = proxyRequest(p, "GET", "/httpbin/a%zz", nil, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.Nil(t, got)
+}
+
+func TestForwardInvalidHeader(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	// Test: A request the parser would refuse is not forwarded either
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\nX-A: v\nTransfer-Encoding: chunked\r\n\r\n"
+	_, err = request.RequestFromReader(strings.NewReader(raw))
+	require.Error(t, err)
+	out := proxyRequest(p, "GET", "/", map[string]string{"X-A": "v\nTransfer-Encoding: chunked"}, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.False(t, called)
+}
+
+func TestForwardedChain(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	// Test: Values from earlier proxies are appended to
+	proxyRequest(p, "GET", "/", map[string]string{
+		"Forwarded":       "for=198.51.100.1",
+		"X-Forwarded-For": "198.51.100.1",
+	}, "")
+	require.NotNil(t, got)
+	assert.Equal(t, "for=198.51.100.1, for=192.0.2.7;proto=http", got.Header.Get("Forwarded"))
+	assert.Equal(t, "198.51.100.1, 192.0.2.7", got.Header.Get("X-Forwarded-For"))
+}
+
+func TestStreamResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Upstream-Trailer")
+		w.WriteHeader(http.StatusTeapot)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "part %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Upstream-Trailer", "done")
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	// Test: Unknown length streams as chunked with upstream trailers
+	out := proxyRequest(p, "GET", "/stream", nil, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 418 \r\n"))
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, out, "trailer: X-Upstream-Trailer\r\n")
+	for i := 0; i < 3; i++ {
+		assert.Contains(t, out, fmt.Sprintf("7\r\npart %d\n\r\n", i))
+	}
+	assert.True(t, strings.HasSuffix(out, "0\r\nx-upstream-trailer: done\r\n\r\n"))
+
+	// Test: Checksum trailers
+	p.ChecksumTrailers = true
+	out = proxyRequest(p, "GET", "/stream", nil, "")
+	sum := sha256.Sum256([]byte("part 0\npart 1\npart 2\n"))
+	assert.Contains(t, out, "trailer: X-Content-Length, X-Content-SHA256, X-Upstream-Trailer\r\n")
+	assert.Contains(t, out, fmt.Sprintf("x-content-sha256: %x\r\n", sum))
+	assert.Contains(t, out, "x-content-length: 21\r\n")
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	// Test: Unreachable upstream
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	upstream.Close()
+	out := proxyRequest(p, "GET", "/", nil, "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Invalid upstream URLs
+	_, err = New("ftp://example.com")
+	require.Error(t, err)
+	_, err = New("http://")
+	require.Error(t, err)
+}
@@ -0,0 +1,171 @@
+// Package qpack implements QPACK, the header compression format of HTTP/3
+// described in RFC 9204, without a dynamic table. Fields are coded against
+// the static table or as literals, so field sections can be decoded in any
+// order and the encoder and decoder streams stay silent. A peer learns this
+// from a SETTINGS_QPACK_MAX_TABLE_CAPACITY of zero.
+package qpack
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+)
+
+var (
+	ErrTruncated    = errors.New("qpack: truncated field section")
+	ErrDynamicTable = errors.New("qpack: reference to the dynamic table")
+)
+
+// Encode appends the field section for fields to dst.
+func Encode(dst []byte, fields []hpack.HeaderField) []byte {
+	// Required Insert Count and Base are both zero, as nothing is ever
+	// inserted into the dynamic table
+	dst = append(dst, 0, 0)
+	for _, f := range fields {
+		dst = encodeField(dst, f)
+	}
+	return dst
+}
+
+func encodeField(dst []byte, f hpack.HeaderField) []byte {
+	key := hpack.HeaderField{Name: f.Name, Value: f.Value}
+	if index, ok := staticExact[key]; ok && !f.Sensitive {
+		// indexed field line, static table
+		return hpack.AppendInt(dst, 6, 0xc0, index)
+	}
+	// the N bit asks intermediaries to keep the field a literal too
+	var never byte
+	if f.Sensitive {
+		never = 0x20
+	}
+	if index, ok := staticName[f.Name]; ok {
+		// literal field line with a static name reference
+		dst = hpack.AppendInt(dst, 4, 0x50|never, index)
+		return appendString(dst, 7, 0, f.Value)
+	}
+	// literal field line with a literal name
+	dst = appendString(dst, 3, 0x20|never>>1, f.Name)
+	return appendString(dst, 7, 0, f.Value)
+}
+
+// appendString appends s with an n-bit length prefix, Huffman-coded
+// unless that would make it longer. The H flag sits just above the prefix.
+func appendString(dst []byte, n uint, flags byte, s string) []byte {
+	if hn := hpack.HuffmanEncodedLen(s); len(s) > 0 && hn <= len(s) {
+		dst = hpack.AppendInt(dst, n, flags|1<<n, hn)
+		return hpack.AppendHuffman(dst, s)
+	}
+	dst = hpack.AppendInt(dst, n, flags, len(s))
+	return append(dst, s...)
+}
+
+// Decode decodes one encoded field section. Sections that refer to the
+// dynamic table are rejected with ErrDynamicTable, as no peer may insert
+// into a table whose capacity is zero.
+func Decode(p []byte) ([]hpack.HeaderField, error) {
+	requiredInsertCount, p, err := decodeInt(p, 8)
+	if err != nil {
+		return nil, err
+	}
+	if requiredInsertCount != 0 {
+		return nil, ErrDynamicTable
+	}
+	// Base is only meaningful for dynamic table references
+	if _, p, err = decodeInt(p, 7); err != nil {
+		return nil, err
+	}
+
+	var fields []hpack.HeaderField
+	for len(p) > 0 {
+		var f hpack.HeaderField
+		f, p, err = decodeField(p)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func decodeField(p []byte) (hpack.HeaderField, []byte, error) {
+	var f hpack.HeaderField
+	b := p[0]
+	switch {
+	case b&0x80 != 0:
+		// indexed field line
+		if b&0x40 == 0 {
+			return f, nil, ErrDynamicTable
+		}
+		index, p, err := decodeInt(p, 6)
+		if err != nil {
+			return f, nil, err
+		}
+		f, err = staticField(index)
+		return f, p, err
+	case b&0x40 != 0:
+		// literal field line with name reference
+		if b&0x10 == 0 {
+			return f, nil, ErrDynamicTable
+		}
+		index, p, err := decodeInt(p, 4)
+		if err != nil {
+			return f, nil, err
+		}
+		if f, err = staticField(index); err != nil {
+			return f, nil, err
+		}
+		f.Sensitive = b&0x20 != 0
+		f.Value, p, err = decodeString(p, 7)
+		return f, p, err
+	case b&0x20 != 0:
+		// literal field line with literal name
+		var err error
+		f.Sensitive = b&0x10 != 0
+		if f.Name, p, err = decodeString(p, 3); err != nil {
+			return f, nil, err
+		}
+		f.Value, p, err = decodeString(p, 7)
+		return f, p, err
+	default:
+		// the post-base forms only ever refer to the dynamic table
+		return f, nil, ErrDynamicTable
+	}
+}
+
+func staticField(index int) (hpack.HeaderField, error) {
+	if index >= len(staticTable) {
+		return hpack.HeaderField{}, fmt.Errorf("qpack: static table index %d out of range", index)
+	}
+	return staticTable[index], nil
+}
+
+func decodeInt(p []byte, n uint) (int, []byte, error) {
+	i, p, err := hpack.DecodeInt(p, n)
+	if errors.Is(err, hpack.ErrTruncated) {
+		return 0, nil, ErrTruncated
+	}
+	return i, p, err
+}
+
+// decodeString reads a string literal with an n-bit length prefix from the
+// start of p.
+func decodeString(p []byte, n uint) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrTruncated
+	}
+	huffman := p[0]&(1<<n) != 0
+	length, p, err := decodeInt(p, n)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > len(p) {
+		return "", nil, ErrTruncated
+	}
+	data, p := p[:length], p[length:]
+	if !huffman {
+		return string(data), p, nil
+	}
+	s, err := hpack.HuffmanDecode(data)
+	return s, p, err
+}
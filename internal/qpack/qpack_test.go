@@ -0,0 +1,79 @@
+package qpack
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/hpack"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	// Test: RFC 9204 appendix B.1, a literal with a static name reference
+	p, err := hex.DecodeString("0000510b2f696e6465782e68746d6c")
+	require.NoError(t, err)
+	fields, err := Decode(p)
+	require.NoError(t, err)
+	assert.Equal(t, []hpack.HeaderField{{Name: ":path", Value: "/index.html"}}, fields)
+
+	// Test: Indexed static fields
+	fields, err = Decode([]byte{0, 0, 0xd1, 0xd7})
+	require.NoError(t, err)
+	assert.Equal(t, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+	}, fields)
+
+	// Test: An empty field section
+	fields, err = Decode([]byte{0, 0})
+	require.NoError(t, err)
+	assert.Empty(t, fields)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string]struct {
+		p   []byte
+		err error
+	}{
+		"required insert count": {[]byte{0x02, 0x00}, ErrDynamicTable},
+		"dynamic indexed":       {[]byte{0, 0, 0x80}, ErrDynamicTable},
+		"dynamic name":          {[]byte{0, 0, 0x41, 0x00}, ErrDynamicTable},
+		"post-base indexed":     {[]byte{0, 0, 0x10}, ErrDynamicTable},
+		"no prefix":             {[]byte{}, ErrTruncated},
+		"short value":           {[]byte{0, 0, 0x51, 0x05, 'a'}, ErrTruncated},
+		"missing value":         {[]byte{0, 0, 0x51}, ErrTruncated},
+	}
+	for name, tt := range tests {
+		_, err := Decode(tt.p)
+		assert.ErrorIs(t, err, tt.err, name)
+	}
+
+	// Test: Static indices past the end of the table
+	_, err := Decode([]byte{0, 0, 0xff, 0x24})
+	assert.Error(t, err)
+}
+
+func TestEncode(t *testing.T) {
+	// Test: Fields in the static table become a single byte
+	assert.Equal(t, []byte{0, 0, 0xd1, 0xd9}, Encode(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":status", Value: "200"},
+	}))
+
+	// Test: Every form survives a round trip
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: "content-type", Value: "application/json"},
+		{Name: "x-custom", Value: "some value"},
+		{Name: "x-empty", Value: ""},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+		{Name: "x-token", Value: "12345", Sensitive: true},
+		{Name: ":authority", Value: "", Sensitive: true},
+	}
+	fields2, err := Decode(Encode(nil, fields))
+	require.NoError(t, err)
+	assert.Equal(t, fields, fields2)
+}
@@ -0,0 +1,1158 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+type spaceID int
+
+const (
+	spaceInitial spaceID = iota
+	spaceHandshake
+	spaceApp
+	numSpaces
+)
+
+func (id spaceID) String() string {
+	switch id {
+	case spaceInitial:
+		return "Initial"
+	case spaceHandshake:
+		return "Handshake"
+	}
+	return "1-RTT"
+}
+
+// aeadOverhead is the length of the tag every payload is sealed with.
+const aeadOverhead = 16
+
+// packetSpace holds the state of one packet number space, each of which
+// has its own keys, packet numbers, acknowledgments and crypto stream.
+type packetSpace struct {
+	sendKeys  *keys
+	recvKeys  *keys
+	discarded bool
+
+	// sending
+	nextPN           int64
+	sent             []*sentPacket
+	largestAcked     int64
+	lossTime         time.Time
+	lastAckEliciting time.Time
+	// ping asks for an ack-eliciting packet to be sent as a probe
+	ping bool
+
+	// receiving
+	received        rangeSet
+	largestRecv     int64
+	largestRecvTime time.Time
+	ackPending      bool
+
+	// the crypto stream carrying the TLS handshake
+	cryptoOut    []byte
+	cryptoOutOff uint64
+	cryptoLost   []cryptoFrame
+	cryptoIn     recvBuffer
+}
+
+// stream kinds, which index the per-kind stream counts of a Conn
+const (
+	bidi = 0
+	uni  = 1
+)
+
+// Conn is a QUIC connection. Its state is guarded by mu and driven by a
+// goroutine that handles incoming datagrams and timers, and sends whatever
+// the connection and its streams have queued.
+type Conn struct {
+	ep       *endpoint
+	isServer bool
+	config   *Config
+	peerAddr net.Addr
+	created  time.Time
+
+	recv chan []byte
+	// wakeup asks the connection goroutine to send what was queued
+	wakeup        chan struct{}
+	handshakeDone chan struct{}
+	// closed is closed once err is set, and closeSent once a closing
+	// connection has sent its CONNECTION_CLOSE. done is closed when the
+	// connection goroutine has returned.
+	closed    chan struct{}
+	closeSent chan struct{}
+	done      chan struct{}
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	tls  *tls.QUICConn
+
+	localCID  []byte
+	remoteCID []byte
+	// peerCIDKnown is set once the peer's chosen ID replaces the one a
+	// client starts out with
+	peerCIDKnown bool
+	// origDCID is the destination ID of the client's first Initial packet,
+	// from which the Initial keys derive
+	origDCID []byte
+
+	spaces             [numSpaces]packetSpace
+	handshakeComplete  bool
+	handshakeConfirmed bool
+	sendHandshakeDone  bool
+	localParams        transportParams
+	peerParams         transportParams
+	idleTimeout        time.Duration
+	lastActivity       time.Time
+
+	rtt      rttStats
+	cc       congestion
+	ptoCount int
+
+	// a server may send no more than three times what it has received
+	// until the client proves it owns its address
+	addressValidated bool
+	bytesRecv        int
+	bytesSent        int
+
+	streams map[uint64]*Stream
+	// sendQueue lists streams that may have frames to send
+	sendQueue []*Stream
+	// nextStream counts the streams opened locally, peerMaxStreams is how
+	// many the peer allows. nextPeerStream and maxPeerStreams are the same
+	// the other way round.
+	nextStream     [2]uint64
+	peerMaxStreams [2]uint64
+	nextPeerStream [2]uint64
+	maxPeerStreams [2]uint64
+	sendMaxStreams [2]bool
+	acceptQueue    [2][]*Stream
+
+	// connection flow control, receiving and sending
+	inLimit     uint64
+	inTotal     uint64
+	inConsumed  uint64
+	sendMaxData bool
+	outLimit    uint64
+	outTotal    uint64
+
+	// control holds frames that are sent once and again if lost
+	control []frame
+
+	err           error
+	closeFrame    *connectionCloseFrame
+	sendClose     bool
+	closeDeadline time.Time
+	stopped       bool
+}
+
+func newConn(ep *endpoint, isServer bool, peerAddr net.Addr, origDCID []byte, tlsConfig *tls.Config, config *Config) (*Conn, error) {
+	now := time.Now()
+	c := &Conn{
+		ep:            ep,
+		isServer:      isServer,
+		config:        config,
+		peerAddr:      peerAddr,
+		created:       now,
+		recv:          make(chan []byte, 64),
+		wakeup:        make(chan struct{}, 1),
+		handshakeDone: make(chan struct{}),
+		closed:        make(chan struct{}),
+		closeSent:     make(chan struct{}),
+		done:          make(chan struct{}),
+		localCID:      newConnID(),
+		origDCID:      origDCID,
+		idleTimeout:   config.maxIdleTimeout(),
+		lastActivity:  now,
+		rtt:           newRTTStats(),
+		cc:            newCongestion(),
+		streams:       map[uint64]*Stream{},
+		inLimit:       connWindow,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	for i := range c.spaces {
+		c.spaces[i].largestAcked = -1
+		c.spaces[i].largestRecv = -1
+	}
+	client, server := initialKeys(origDCID)
+	if isServer {
+		c.spaces[spaceInitial].sendKeys, c.spaces[spaceInitial].recvKeys = server, client
+	} else {
+		c.spaces[spaceInitial].sendKeys, c.spaces[spaceInitial].recvKeys = client, server
+		c.remoteCID = origDCID
+	}
+
+	maxStreams := uint64(config.maxIncomingStreams())
+	c.maxPeerStreams = [2]uint64{maxStreams, maxStreams}
+	c.localParams = transportParams{
+		initialSCID:                    c.localCID,
+		maxIdleTimeout:                 c.idleTimeout,
+		maxUDPPayloadSize:              65527,
+		initialMaxData:                 connWindow,
+		initialMaxStreamDataBidiLocal:  streamWindow,
+		initialMaxStreamDataBidiRemote: streamWindow,
+		initialMaxStreamDataUni:        streamWindow,
+		initialMaxStreamsBidi:          maxStreams,
+		initialMaxStreamsUni:           maxStreams,
+		maxAckDelay:                    maxAckDelay,
+		disableActiveMigration:         true,
+	}
+	if isServer {
+		c.localParams.originalDCID = origDCID
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	qconf := &tls.QUICConfig{TLSConfig: tlsConfig}
+	if isServer {
+		c.tls = tls.QUICServer(qconf)
+	} else {
+		c.tls = tls.QUICClient(qconf)
+	}
+	c.tls.SetTransportParameters(c.localParams.marshal())
+	if err := c.tls.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := c.handleTLSEvents(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConnID() []byte {
+	id := make([]byte, connIDLen)
+	rand.Read(id)
+	return id
+}
+
+// run is the connection goroutine.
+func (c *Conn) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		select {
+		case d := <-c.recv:
+			c.mu.Lock()
+			c.handleDatagram(d, time.Now())
+			// take whatever else has arrived, so that one packet can
+			// acknowledge all of it
+			for more := true; more && !c.stopped; {
+				select {
+				case d := <-c.recv:
+					c.handleDatagram(d, time.Now())
+				default:
+					more = false
+				}
+			}
+		case <-c.wakeup:
+			c.mu.Lock()
+		case <-timer.C:
+			c.mu.Lock()
+			c.handleTimeout(time.Now())
+		}
+		now := time.Now()
+		if !c.stopped {
+			c.flush(now)
+		}
+		stopped := c.stopped
+		next := c.nextTimeout()
+		c.mu.Unlock()
+
+		if stopped {
+			c.ep.removeConn(c)
+			close(c.done)
+			return
+		}
+		timer.Reset(max(time.Until(next), 0))
+	}
+}
+
+// wake asks the connection goroutine to send what was queued.
+func (c *Conn) wake() {
+	select {
+	case c.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// setErr closes the connection for its users, waking everything blocked
+// on it. Only the first error is kept.
+func (c *Conn) setErr(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.closed)
+	c.cond.Broadcast()
+}
+
+// abort starts closing the connection with a CONNECTION_CLOSE frame, after
+// which it lingers for three probe timeouts to answer any packets still
+// arriving with another one. err is what users of the connection see.
+func (c *Conn) abort(f connectionCloseFrame, err error) {
+	if c.closeFrame != nil || c.stopped {
+		return
+	}
+	c.setErr(err)
+	c.closeFrame = &f
+	c.sendClose = true
+	c.closeDeadline = time.Now().Add(3 * c.rtt.pto(c.peerParams.maxAckDelay))
+	c.wake()
+}
+
+// abortWithError closes the connection because of a local transport error.
+func (c *Conn) abortWithError(err error) {
+	te, ok := err.(TransportError)
+	if !ok {
+		te = transportError(ErrCodeInternal, "%v", err)
+	}
+	c.abort(connectionCloseFrame{code: uint64(te.Code), reason: te.Reason}, te)
+}
+
+// stop ends the connection at once, without telling the peer.
+func (c *Conn) stop(err error) {
+	c.setErr(err)
+	c.stopped = true
+}
+
+// Close closes the connection with an application error code of zero.
+func (c *Conn) Close() error {
+	return c.CloseWithError(0, "")
+}
+
+// CloseWithError closes the connection, telling the peer code and reason.
+// It returns once the CONNECTION_CLOSE frame has been sent.
+func (c *Conn) CloseWithError(code uint64, reason string) error {
+	c.mu.Lock()
+	c.abort(connectionCloseFrame{app: true, code: code, reason: reason}, ErrClosed)
+	c.mu.Unlock()
+	select {
+	case <-c.closeSent:
+	case <-c.done:
+	}
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ep.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.peerAddr
+}
+
+// ConnectionState returns the state of the TLS handshake, such as the
+// negotiated application protocol.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tls.ConnectionState()
+}
+
+// handleDatagram processes every packet coalesced into one datagram.
+func (c *Conn) handleDatagram(d []byte, now time.Time) {
+	if c.stopped {
+		return
+	}
+	if c.closeFrame != nil {
+		c.sendClose = true
+		return
+	}
+	c.bytesRecv += len(d)
+	for len(d) > 0 {
+		h, err := parseHeader(d)
+		if err != nil {
+			return
+		}
+		pkt := d[:h.length]
+		d = d[h.length:]
+		if h.typ != packet1RTT && h.version != version1 {
+			continue
+		}
+		c.handlePacket(h, pkt, now)
+		if c.err != nil {
+			return
+		}
+	}
+}
+
+func (c *Conn) handlePacket(h header, pkt []byte, now time.Time) {
+	var id spaceID
+	switch h.typ {
+	case packetInitial:
+		id = spaceInitial
+	case packetHandshake:
+		id = spaceHandshake
+	case packet1RTT:
+		id = spaceApp
+	default:
+		// neither 0-RTT nor Retry is supported
+		return
+	}
+	if !bytes.Equal(h.dcid, c.localCID) && !(c.isServer && id == spaceInitial && bytes.Equal(h.dcid, c.origDCID)) {
+		return
+	}
+	sp := &c.spaces[id]
+	if sp.recvKeys == nil || sp.discarded {
+		return
+	}
+	pn, payload, err := sp.recvKeys.unprotect(pkt, h.pnOffset, sp.largestRecv)
+	if err != nil {
+		return
+	}
+	reserved := byte(0x0c)
+	if h.typ == packet1RTT {
+		reserved = 0x18
+	}
+	if pkt[0]&reserved != 0 {
+		c.abortWithError(transportError(ErrCodeProtocolViolation, "reserved header bits set"))
+		return
+	}
+	if sp.received.contains(pn) {
+		return
+	}
+
+	if id == spaceInitial && !c.peerCIDKnown {
+		// the peer's first Initial packet carries the ID it wants to be
+		// addressed by
+		c.remoteCID = bytes.Clone(h.scid)
+		c.peerCIDKnown = true
+	}
+	if c.isServer && id == spaceHandshake && !c.addressValidated {
+		// only a client that got our Initial packet could send this
+		c.addressValidated = true
+		c.discardSpace(spaceInitial)
+	}
+	c.lastActivity = now
+
+	eliciting, err := c.handleFrames(id, payload, now)
+	if err != nil {
+		c.abortWithError(err)
+		return
+	}
+	sp.received.add(pn)
+	if pn > sp.largestRecv {
+		sp.largestRecv = pn
+		sp.largestRecvTime = now
+	}
+	if eliciting {
+		sp.ackPending = true
+	}
+}
+
+func (c *Conn) handleFrames(id spaceID, p []byte, now time.Time) (bool, error) {
+	if len(p) == 0 {
+		return false, transportError(ErrCodeProtocolViolation, "packet without frames")
+	}
+	eliciting := false
+	for len(p) > 0 {
+		f, typ, n, err := parseFrame(p)
+		if err != nil {
+			return false, err
+		}
+		p = p[n:]
+		if id != spaceApp && !allowedInHandshake(typ) {
+			return false, transportError(ErrCodeProtocolViolation, "frame type 0x%x in %s packet", typ, id)
+		}
+		if ackEliciting(typ) {
+			eliciting = true
+		}
+		if err := c.handleFrame(id, f, now); err != nil {
+			return false, err
+		}
+		if c.err != nil {
+			break
+		}
+	}
+	return eliciting, nil
+}
+
+func (c *Conn) handleFrame(id spaceID, f frame, now time.Time) error {
+	switch f := f.(type) {
+	case ackFrame:
+		return c.handleAck(id, f, now)
+	case cryptoFrame:
+		return c.handleCrypto(id, f)
+	case streamFrame:
+		return c.handleStream(f)
+	case resetStreamFrame:
+		return c.handleResetStream(f)
+	case stopSendingFrame:
+		return c.handleStopSending(f)
+	case maxDataFrame:
+		if f.max > c.outLimit {
+			c.outLimit = f.max
+			for _, st := range c.streams {
+				c.queueStream(st)
+			}
+		}
+	case maxStreamDataFrame:
+		return c.handleMaxStreamData(f)
+	case maxStreamsFrame:
+		if f.max > 1<<60 {
+			return transportError(ErrCodeFrameEncoding, "stream limit above 2^60")
+		}
+		kind := bidi
+		if f.uni {
+			kind = uni
+		}
+		if f.max > c.peerMaxStreams[kind] {
+			c.peerMaxStreams[kind] = f.max
+			c.cond.Broadcast()
+		}
+	case newTokenFrame:
+		if c.isServer {
+			return transportError(ErrCodeProtocolViolation, "NEW_TOKEN from a client")
+		}
+	case newConnectionIDFrame:
+		// the peer's first ID is kept for the whole connection
+		if len(f.connID) < 1 || len(f.connID) > 20 {
+			return transportError(ErrCodeFrameEncoding, "connection ID of %d bytes", len(f.connID))
+		}
+	case pathChallengeFrame:
+		c.control = append(c.control, pathResponseFrame{data: f.data})
+	case handshakeDoneFrame:
+		if c.isServer {
+			return transportError(ErrCodeProtocolViolation, "HANDSHAKE_DONE from a client")
+		}
+		if !c.handshakeConfirmed {
+			c.handshakeConfirmed = true
+			c.discardSpace(spaceHandshake)
+		}
+	case connectionCloseFrame:
+		// the peer is draining, nothing more needs to be sent
+		if f.app {
+			c.stop(ApplicationError{Code: f.code, Reason: f.reason, Remote: true})
+		} else {
+			c.stop(TransportError{Code: ErrCode(f.code), Reason: f.reason, Remote: true})
+		}
+	}
+	return nil
+}
+
+func (c *Conn) handleCrypto(id spaceID, f cryptoFrame) error {
+	sp := &c.spaces[id]
+	end := f.offset + uint64(len(f.data))
+	if end > sp.cryptoIn.off+maxCryptoBuffer {
+		return transportError(ErrCodeCryptoBufferExceeded, "%d bytes of handshake data buffered", end-sp.cryptoIn.off)
+	}
+	sp.cryptoIn.insert(f.offset, f.data)
+	if len(sp.cryptoIn.data) == 0 {
+		return nil
+	}
+	data := sp.cryptoIn.data
+	sp.cryptoIn.off += uint64(len(data))
+	sp.cryptoIn.data = nil
+	if err := c.tls.HandleData(tlsLevel(id), data); err != nil {
+		return tlsError(err)
+	}
+	return c.handleTLSEvents()
+}
+
+func tlsLevel(id spaceID) tls.QUICEncryptionLevel {
+	switch id {
+	case spaceInitial:
+		return tls.QUICEncryptionLevelInitial
+	case spaceHandshake:
+		return tls.QUICEncryptionLevelHandshake
+	}
+	return tls.QUICEncryptionLevelApplication
+}
+
+// handleTLSEvents acts on what the TLS handshake produced: new keys,
+// handshake messages to send and the peer's transport parameters.
+func (c *Conn) handleTLSEvents() error {
+	for {
+		e := c.tls.NextEvent()
+		var sp *packetSpace
+		switch e.Level {
+		case tls.QUICEncryptionLevelInitial:
+			sp = &c.spaces[spaceInitial]
+		case tls.QUICEncryptionLevelHandshake:
+			sp = &c.spaces[spaceHandshake]
+		case tls.QUICEncryptionLevelApplication:
+			sp = &c.spaces[spaceApp]
+		}
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			if sp == nil {
+				// 0-RTT is never offered or accepted
+				continue
+			}
+			k, err := newKeys(e.Suite, e.Data)
+			if err != nil {
+				return transportError(ErrCodeCrypto+40, "%v", err) // handshake_failure
+			}
+			if e.Kind == tls.QUICSetReadSecret {
+				sp.recvKeys = k
+			} else {
+				sp.sendKeys = k
+			}
+		case tls.QUICWriteData:
+			sp.cryptoOut = append(sp.cryptoOut, e.Data...)
+		case tls.QUICTransportParameters:
+			if err := c.setPeerParams(e.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			c.handshakeComplete = true
+			close(c.handshakeDone)
+			if c.isServer {
+				// a server's handshake is confirmed as soon as it is
+				// complete, and it tells the client so
+				c.handshakeConfirmed = true
+				c.sendHandshakeDone = true
+				c.discardSpace(spaceHandshake)
+				c.ep.accept(c)
+			}
+		}
+	}
+}
+
+func (c *Conn) setPeerParams(b []byte) error {
+	p, err := parseTransportParams(b, !c.isServer)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p.initialSCID, c.remoteCID) {
+		return transportError(ErrCodeProtocolViolation, "initial_source_connection_id does not match")
+	}
+	if !c.isServer && !bytes.Equal(p.originalDCID, c.origDCID) {
+		return transportError(ErrCodeProtocolViolation, "original_destination_connection_id does not match")
+	}
+	c.peerParams = p
+	c.outLimit = p.initialMaxData
+	c.peerMaxStreams = [2]uint64{p.initialMaxStreamsBidi, p.initialMaxStreamsUni}
+	if p.maxIdleTimeout > 0 && p.maxIdleTimeout < c.idleTimeout {
+		c.idleTimeout = p.maxIdleTimeout
+	}
+	return nil
+}
+
+// discardSpace drops the keys of a space no longer needed, along with
+// everything waiting to be sent or acknowledged in it.
+func (c *Conn) discardSpace(id spaceID) {
+	sp := &c.spaces[id]
+	if sp.discarded {
+		return
+	}
+	for _, p := range sp.sent {
+		if p.inFlight {
+			c.cc.discard(p)
+		}
+	}
+	*sp = packetSpace{discarded: true, largestAcked: -1, largestRecv: -1}
+	c.ptoCount = 0
+}
+
+func (c *Conn) handleAck(id spaceID, f ackFrame, now time.Time) error {
+	sp := &c.spaces[id]
+	largest := f.ranges[0].hi
+	if largest >= sp.nextPN {
+		return transportError(ErrCodeProtocolViolation, "ACK of unsent packet %d", largest)
+	}
+	var acked []*sentPacket
+	kept := sp.sent[:0]
+	for _, p := range sp.sent {
+		if inRanges(f.ranges, p.pn) {
+			acked = append(acked, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	clear(sp.sent[len(kept):])
+	sp.sent = kept
+	if len(acked) == 0 {
+		return nil
+	}
+	sp.largestAcked = max(sp.largestAcked, largest)
+
+	newest := acked[len(acked)-1]
+	eliciting := false
+	for _, p := range acked {
+		eliciting = eliciting || p.ackEliciting
+	}
+	if newest.pn == largest && eliciting {
+		var ackDelay time.Duration
+		if id == spaceApp {
+			ackDelay = time.Duration(f.delay<<c.peerParams.ackDelayExponent) * time.Microsecond
+			if c.handshakeConfirmed {
+				ackDelay = min(ackDelay, c.peerParams.maxAckDelay)
+			}
+		}
+		c.rtt.update(now.Sub(newest.time), ackDelay)
+	}
+	for _, p := range acked {
+		if p.inFlight {
+			c.cc.onAcked(p)
+		}
+		c.framesAcked(p.frames)
+	}
+	c.detectLoss(id, now)
+	c.ptoCount = 0
+	return nil
+}
+
+func inRanges(ranges []pnRange, pn int64) bool {
+	for _, r := range ranges {
+		if pn >= r.lo && pn <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLoss declares packets lost that were sent well before one that was
+// acknowledged, RFC 9002 section 6.1, and queues their frames again.
+func (c *Conn) detectLoss(id spaceID, now time.Time) {
+	sp := &c.spaces[id]
+	sp.lossTime = time.Time{}
+	delay := c.rtt.lossDelay()
+	var lost []*sentPacket
+	kept := sp.sent[:0]
+	for _, p := range sp.sent {
+		if p.pn > sp.largestAcked {
+			kept = append(kept, p)
+			continue
+		}
+		if !p.time.After(now.Add(-delay)) || sp.largestAcked-p.pn >= packetThreshold {
+			if p.inFlight {
+				lost = append(lost, p)
+			}
+			c.requeue(id, p.frames)
+			continue
+		}
+		if t := p.time.Add(delay); sp.lossTime.IsZero() || t.Before(sp.lossTime) {
+			sp.lossTime = t
+		}
+		kept = append(kept, p)
+	}
+	clear(sp.sent[len(kept):])
+	sp.sent = kept
+	c.cc.onLost(lost, now)
+}
+
+// requeue queues the frames of a lost packet to be sent again. Frames that
+// carry state, such as flow control limits, are sent with the latest value.
+func (c *Conn) requeue(id spaceID, frames []frame) {
+	for _, f := range frames {
+		switch f := f.(type) {
+		case cryptoFrame:
+			c.spaces[id].cryptoLost = append(c.spaces[id].cryptoLost, f)
+		case streamFrame:
+			if st := c.streams[f.streamID]; st != nil {
+				st.unacked--
+				if !st.resetSent {
+					st.lost = append(st.lost, f)
+					c.queueStream(st)
+				}
+				c.maybeRemoveStream(st)
+			}
+		case maxDataFrame:
+			c.sendMaxData = true
+		case maxStreamDataFrame:
+			if st := c.streams[f.streamID]; st != nil && !st.inFin && st.inErr == nil {
+				st.sendMaxStreamData = true
+			}
+		case maxStreamsFrame:
+			if f.uni {
+				c.sendMaxStreams[uni] = true
+			} else {
+				c.sendMaxStreams[bidi] = true
+			}
+		case handshakeDoneFrame:
+			c.sendHandshakeDone = true
+		case resetStreamFrame, stopSendingFrame:
+			c.control = append(c.control, f)
+		}
+	}
+}
+
+func (c *Conn) framesAcked(frames []frame) {
+	for _, f := range frames {
+		if f, ok := f.(streamFrame); ok {
+			if st := c.streams[f.streamID]; st != nil {
+				st.unacked--
+				c.maybeRemoveStream(st)
+			}
+		}
+	}
+}
+
+// lossTimer returns when the loss detection timer of RFC 9002 appendix A.8
+// fires next, and for which space.
+func (c *Conn) lossTimer() (time.Time, spaceID) {
+	var t time.Time
+	var sid spaceID
+	for id := range numSpaces {
+		sp := &c.spaces[id]
+		if !sp.lossTime.IsZero() && (t.IsZero() || sp.lossTime.Before(t)) {
+			t, sid = sp.lossTime, id
+		}
+	}
+	if !t.IsZero() {
+		return t, sid
+	}
+
+	backoff := time.Duration(1) << min(c.ptoCount, 16)
+	inFlight := false
+	for id := range numSpaces {
+		sp := &c.spaces[id]
+		if !sp.hasInFlight() {
+			continue
+		}
+		inFlight = true
+		if id == spaceApp && !c.handshakeConfirmed {
+			continue
+		}
+		var maxAckDelay time.Duration
+		if id == spaceApp {
+			maxAckDelay = c.peerParams.maxAckDelay
+		}
+		pto := sp.lastAckEliciting.Add(c.rtt.pto(maxAckDelay) * backoff)
+		if t.IsZero() || pto.Before(t) {
+			t, sid = pto, id
+		}
+	}
+	if !inFlight && !c.isServer && !c.handshakeConfirmed {
+		// a server limited by the amplification limit may be waiting for
+		// the client to send anything at all
+		sid = spaceInitial
+		if c.spaces[spaceHandshake].sendKeys != nil {
+			sid = spaceHandshake
+		}
+		last := c.created
+		for _, id := range []spaceID{spaceInitial, spaceHandshake} {
+			if c.spaces[id].lastAckEliciting.After(last) {
+				last = c.spaces[id].lastAckEliciting
+			}
+		}
+		t = last.Add(c.rtt.pto(0) * backoff)
+	}
+	return t, sid
+}
+
+func (sp *packetSpace) hasInFlight() bool {
+	for _, p := range sp.sent {
+		if p.inFlight && p.ackEliciting {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conn) handleTimeout(now time.Time) {
+	if c.closeFrame != nil {
+		if !now.Before(c.closeDeadline) {
+			c.stopped = true
+		}
+		return
+	}
+	if now.Sub(c.lastActivity) >= c.idleTimeout {
+		c.stop(ErrIdleTimeout)
+		return
+	}
+	if !c.handshakeComplete && now.Sub(c.created) >= c.config.handshakeTimeout() {
+		c.stop(transportError(ErrCodeConnectionRefused, "handshake timed out"))
+		return
+	}
+	t, id := c.lossTimer()
+	if t.IsZero() || now.Before(t) {
+		return
+	}
+	if !c.spaces[id].lossTime.IsZero() {
+		c.detectLoss(id, now)
+		return
+	}
+	c.ptoCount++
+	c.probe(id)
+}
+
+// probe handles a probe timeout by sending everything in flight in the
+// space again, or a PING if nothing is.
+func (c *Conn) probe(id spaceID) {
+	sp := &c.spaces[id]
+	for _, p := range sp.sent {
+		if !p.inFlight || !p.ackEliciting {
+			continue
+		}
+		c.cc.discard(p)
+		p.inFlight = false
+		c.requeue(id, p.frames)
+		p.frames = nil
+	}
+	sp.ping = true
+}
+
+func (c *Conn) nextTimeout() time.Time {
+	if c.closeFrame != nil {
+		return c.closeDeadline
+	}
+	next := c.lastActivity.Add(c.idleTimeout)
+	if t := c.created.Add(c.config.handshakeTimeout()); !c.handshakeComplete && t.Before(next) {
+		next = t
+	}
+	if t, _ := c.lossTimer(); !t.IsZero() && t.Before(next) {
+		next = t
+	}
+	return next
+}
+
+// packetBuilder assembles one packet.
+type packetBuilder struct {
+	id           spaceID
+	pn           int64
+	buf          []byte
+	pnOffset     int
+	payload      int
+	frames       []frame
+	ackEliciting bool
+}
+
+func (c *Conn) newPacket(id spaceID) *packetBuilder {
+	sp := &c.spaces[id]
+	b := &packetBuilder{id: id, pn: sp.nextPN}
+	buf := make([]byte, 0, maxDatagramSize)
+	switch id {
+	case spaceInitial:
+		b.buf, b.pnOffset = appendLongHeader(buf, packetInitial, c.remoteCID, c.localCID, b.pn)
+	case spaceHandshake:
+		b.buf, b.pnOffset = appendLongHeader(buf, packetHandshake, c.remoteCID, c.localCID, b.pn)
+	default:
+		b.buf, b.pnOffset = appendShortHeader(buf, c.remoteCID, b.pn)
+	}
+	b.payload = len(b.buf)
+	return b
+}
+
+// room returns how many more payload bytes fit in the packet.
+func (b *packetBuilder) room() int {
+	return maxDatagramSize - aeadOverhead - len(b.buf)
+}
+
+// add appends f if it fits, to be sent again should the packet be lost.
+func (b *packetBuilder) add(f frame) bool {
+	if frameLen(f) > b.room() {
+		return false
+	}
+	b.buf = f.appendTo(b.buf)
+	b.frames = append(b.frames, f)
+	b.ackEliciting = true
+	return true
+}
+
+func (b *packetBuilder) empty() bool {
+	return len(b.buf) == b.payload
+}
+
+// finish pads, seals and records the packet, returning the datagram.
+func (c *Conn) finish(b *packetBuilder, now time.Time) []byte {
+	sp := &c.spaces[b.id]
+	if b.id == spaceInitial && (!c.isServer || b.ackEliciting) {
+		b.buf = append(b.buf, make([]byte, b.room())...)
+	}
+	if b.id != spaceApp {
+		setLength(b.buf, b.pnOffset, aeadOverhead)
+	}
+	pkt := sp.sendKeys.protect(b.buf, b.pnOffset, b.pn)
+	sp.nextPN++
+	c.bytesSent += len(pkt)
+	if b.ackEliciting {
+		sp.sent = append(sp.sent, &sentPacket{
+			pn:           b.pn,
+			time:         now,
+			size:         len(pkt),
+			ackEliciting: true,
+			inFlight:     true,
+			frames:       b.frames,
+		})
+		c.cc.onSent(len(pkt))
+		sp.lastAckEliciting = now
+		sp.ping = false
+	}
+	return pkt
+}
+
+// flush sends packets until nothing is left that may be sent.
+func (c *Conn) flush(now time.Time) {
+	if c.closeFrame != nil {
+		if c.sendClose {
+			c.sendClose = false
+			c.writeClose(now)
+		}
+		return
+	}
+	for {
+		if c.isServer && !c.addressValidated && c.bytesSent+maxDatagramSize > 3*c.bytesRecv {
+			return
+		}
+		var pkt []byte
+		for id := range numSpaces {
+			if pkt = c.buildPacket(id, now); pkt != nil {
+				break
+			}
+		}
+		if pkt == nil {
+			return
+		}
+		c.write(pkt)
+	}
+}
+
+func (c *Conn) write(pkt []byte) {
+	// a failed write is no different from a packet lost on the way
+	c.ep.pc.WriteTo(pkt, c.peerAddr)
+}
+
+// buildPacket returns the next packet to send in a space, if any.
+func (c *Conn) buildPacket(id spaceID, now time.Time) []byte {
+	sp := &c.spaces[id]
+	if sp.sendKeys == nil || sp.discarded || (id == spaceApp && !c.handshakeComplete) {
+		return nil
+	}
+	b := c.newPacket(id)
+	if sp.ackPending {
+		f := ackFrame{ranges: sp.received.descending()}
+		if id == spaceApp {
+			f.delay = uint64(now.Sub(sp.largestRecvTime).Microseconds()) >> ackDelayExponent
+		}
+		b.buf = f.appendTo(b.buf)
+		sp.ackPending = false
+	}
+	if c.cc.canSend(maxDatagramSize) || sp.ping {
+		c.addCrypto(b, sp)
+		if id == spaceApp {
+			c.addControl(b)
+			c.addStreams(b)
+		}
+		if !b.ackEliciting && sp.ping {
+			b.buf = append(b.buf, frameTypePing)
+			b.ackEliciting = true
+		}
+	}
+	if b.empty() {
+		return nil
+	}
+	pkt := c.finish(b, now)
+	if !c.isServer && id == spaceHandshake {
+		// a client is done with Initial packets once it sends a
+		// Handshake packet
+		c.discardSpace(spaceInitial)
+	}
+	return pkt
+}
+
+// cryptoFrameOverhead bounds the bytes a CRYPTO frame adds to its data.
+const cryptoFrameOverhead = 1 + 8 + 2
+
+func (c *Conn) addCrypto(b *packetBuilder, sp *packetSpace) {
+	for len(sp.cryptoLost) > 0 {
+		f := sp.cryptoLost[0]
+		n := min(len(f.data), b.room()-cryptoFrameOverhead)
+		if n <= 0 {
+			return
+		}
+		b.add(cryptoFrame{offset: f.offset, data: f.data[:n]})
+		if n < len(f.data) {
+			sp.cryptoLost[0] = cryptoFrame{offset: f.offset + uint64(n), data: f.data[n:]}
+			return
+		}
+		sp.cryptoLost = sp.cryptoLost[1:]
+	}
+	for len(sp.cryptoOut) > 0 {
+		n := min(len(sp.cryptoOut), b.room()-cryptoFrameOverhead)
+		if n <= 0 {
+			return
+		}
+		b.add(cryptoFrame{offset: sp.cryptoOutOff, data: sp.cryptoOut[:n]})
+		sp.cryptoOut = sp.cryptoOut[n:]
+		sp.cryptoOutOff += uint64(n)
+	}
+}
+
+// addControl adds the frames that manage the connection and its streams
+// rather than carry data.
+func (c *Conn) addControl(b *packetBuilder) {
+	if c.sendHandshakeDone && b.add(handshakeDoneFrame{}) {
+		c.sendHandshakeDone = false
+	}
+	if c.sendMaxData && b.add(maxDataFrame{max: c.inLimit}) {
+		c.sendMaxData = false
+	}
+	for kind, uniStream := range []bool{false, true} {
+		if c.sendMaxStreams[kind] && b.add(maxStreamsFrame{uni: uniStream, max: c.maxPeerStreams[kind]}) {
+			c.sendMaxStreams[kind] = false
+		}
+	}
+	for _, st := range c.streams {
+		if st.sendMaxStreamData && b.add(maxStreamDataFrame{streamID: st.id, max: st.inLimit}) {
+			st.sendMaxStreamData = false
+		}
+	}
+	for len(c.control) > 0 && b.add(c.control[0]) {
+		c.control = c.control[1:]
+	}
+}
+
+// addStreams fills the rest of the packet with stream data, taking turns
+// between the streams that have some.
+func (c *Conn) addStreams(b *packetBuilder) {
+	for len(c.sendQueue) > 0 {
+		st := c.sendQueue[0]
+		f, ok := st.nextFrame(b.room())
+		if !ok {
+			// nothing to send, or blocked by flow control until the
+			// limit is raised and the stream queued again
+			st.queued = false
+			c.sendQueue = c.sendQueue[1:]
+			continue
+		}
+		if f == nil {
+			return
+		}
+		b.add(*f)
+		c.sendQueue = append(c.sendQueue[1:], st)
+	}
+}
+
+// queueStream queues st to be considered when stream data is next sent.
+func (c *Conn) queueStream(st *Stream) {
+	if !st.queued && st.canSend {
+		st.queued = true
+		c.sendQueue = append(c.sendQueue, st)
+	}
+}
+
+// writeClose sends the CONNECTION_CLOSE frame in every space the peer may
+// be able to read.
+func (c *Conn) writeClose(now time.Time) {
+	for id := range numSpaces {
+		sp := &c.spaces[id]
+		if sp.sendKeys == nil || sp.discarded || (id == spaceApp && !c.handshakeComplete) {
+			continue
+		}
+		f := *c.closeFrame
+		if f.app && id != spaceApp {
+			// the reason for an application close is not meant for
+			// whoever can read Initial packets
+			f = connectionCloseFrame{code: uint64(ErrCodeApplication)}
+		}
+		b := c.newPacket(id)
+		b.buf = f.appendTo(b.buf)
+		c.write(c.finish(b, now))
+	}
+	select {
+	case <-c.closeSent:
+	default:
+		close(c.closeSent)
+	}
+}
@@ -0,0 +1,236 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// acceptBacklog bounds the connections a Listener holds that completed
+// their handshake but were not yet accepted.
+const acceptBacklog = 64
+
+// endpoint reads datagrams from a socket and routes them to connections by
+// their destination connection ID.
+type endpoint struct {
+	pc        net.PacketConn
+	tlsConfig *tls.Config
+	config    *Config
+	// listener is set on the server side, where unknown Initial packets
+	// start new connections
+	listener *Listener
+	// ownsConn closes pc when the only connection is gone, for Dial
+	ownsConn bool
+
+	mu     sync.Mutex
+	conns  map[string]*Conn
+	closed bool
+}
+
+func newEndpoint(pc net.PacketConn, tlsConfig *tls.Config, config *Config) *endpoint {
+	return &endpoint{
+		pc:        pc,
+		tlsConfig: tlsConfig,
+		config:    config,
+		conns:     map[string]*Conn{},
+	}
+}
+
+func (e *endpoint) readLoop() {
+	buf := make([]byte, 1<<16)
+	for {
+		n, addr, err := e.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		e.route(bytes.Clone(buf[:n]), addr)
+	}
+}
+
+func (e *endpoint) route(d []byte, addr net.Addr) {
+	dcid, ok := peekDCID(d)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	c := e.conns[string(dcid)]
+	if c == nil && e.listener != nil && !e.closed && isNewConnection(d) {
+		var err error
+		c, err = newConn(e, true, addr, bytes.Clone(dcid), e.tlsConfig, e.config)
+		if err == nil {
+			e.conns[string(c.origDCID)] = c
+			e.conns[string(c.localCID)] = c
+			go c.run()
+		}
+	}
+	e.mu.Unlock()
+	if c == nil {
+		return
+	}
+	select {
+	case c.recv <- d:
+	default:
+		// the connection is falling behind, as if the datagram was lost
+	}
+}
+
+// isNewConnection reports whether d may start a connection: a datagram
+// holding a QUIC version 1 Initial packet, padded as clients must pad
+// theirs, addressed to a connection ID at least 8 bytes long.
+func isNewConnection(d []byte) bool {
+	if len(d) < maxDatagramSize || d[0]&0xf0 != 0xc0 {
+		return false
+	}
+	if binary.BigEndian.Uint32(d[1:5]) != version1 {
+		return false
+	}
+	return d[5] >= 8
+}
+
+func (e *endpoint) removeConn(c *Conn) {
+	e.mu.Lock()
+	for id, other := range e.conns {
+		if other == c {
+			delete(e.conns, id)
+		}
+	}
+	last := len(e.conns) == 0
+	e.mu.Unlock()
+	if last && e.ownsConn {
+		e.pc.Close()
+	}
+}
+
+// accept hands a server connection whose handshake completed to the
+// listener, refusing it when too many are waiting.
+func (e *endpoint) accept(c *Conn) {
+	select {
+	case e.listener.conns <- c:
+	default:
+		c.abortWithError(transportError(ErrCodeConnectionRefused, "too many connections waiting"))
+	}
+}
+
+// Listener accepts QUIC connections on a socket.
+type Listener struct {
+	ep     *endpoint
+	conns  chan *Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Listen listens for QUIC connections on a UDP address. tlsConfig needs a
+// certificate and the application protocols offered through ALPN.
+func Listen(addr string, tlsConfig *tls.Config, config *Config) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc, tlsConfig, config), nil
+}
+
+// NewListener accepts QUIC connections on pc, which the Listener takes
+// over and closes when it is closed.
+func NewListener(pc net.PacketConn, tlsConfig *tls.Config, config *Config) *Listener {
+	l := &Listener{
+		ep:     newEndpoint(pc, tlsConfig, config),
+		conns:  make(chan *Conn, acceptBacklog),
+		closed: make(chan struct{}),
+	}
+	l.ep.listener = l
+	go l.ep.readLoop()
+	return l
+}
+
+// Accept waits for a connection to complete its handshake.
+func (l *Listener) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the address the listener receives on.
+func (l *Listener) Addr() net.Addr {
+	return l.ep.pc.LocalAddr()
+}
+
+// Close stops accepting connections and closes the ones open, telling
+// their peers.
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		l.ep.mu.Lock()
+		l.ep.closed = true
+		var conns []*Conn
+		for id, c := range l.ep.conns {
+			if id == string(c.localCID) {
+				conns = append(conns, c)
+			}
+		}
+		l.ep.mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		err = l.ep.pc.Close()
+	})
+	return err
+}
+
+// Dial connects to a QUIC server at a UDP address, from a socket of its
+// own. It returns once the handshake is complete.
+func Dial(ctx context.Context, addr string, tlsConfig *tls.Config, config *Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dial(ctx, pc, raddr, tlsConfig, config, true)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialPacketConn connects to a QUIC server at addr through pc. The
+// connection does not close pc.
+func DialPacketConn(ctx context.Context, pc net.PacketConn, addr net.Addr, tlsConfig *tls.Config, config *Config) (*Conn, error) {
+	return dial(ctx, pc, addr, tlsConfig, config, false)
+}
+
+func dial(ctx context.Context, pc net.PacketConn, addr net.Addr, tlsConfig *tls.Config, config *Config, ownsConn bool) (*Conn, error) {
+	ep := newEndpoint(pc, tlsConfig, config)
+	ep.ownsConn = ownsConn
+	c, err := newConn(ep, false, addr, newConnID(), tlsConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	ep.conns[string(c.localCID)] = c
+	go ep.readLoop()
+	go c.run()
+	c.wake()
+
+	select {
+	case <-c.handshakeDone:
+		return c, nil
+	case <-c.closed:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,121 @@
+package quic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// ErrCode is a transport error code carried by CONNECTION_CLOSE frames.
+type ErrCode uint64
+
+const (
+	ErrCodeNo                   ErrCode = 0x0
+	ErrCodeInternal             ErrCode = 0x1
+	ErrCodeConnectionRefused    ErrCode = 0x2
+	ErrCodeFlowControl          ErrCode = 0x3
+	ErrCodeStreamLimit          ErrCode = 0x4
+	ErrCodeStreamState          ErrCode = 0x5
+	ErrCodeFinalSize            ErrCode = 0x6
+	ErrCodeFrameEncoding        ErrCode = 0x7
+	ErrCodeTransportParameter   ErrCode = 0x8
+	ErrCodeConnectionIDLimit    ErrCode = 0x9
+	ErrCodeProtocolViolation    ErrCode = 0xa
+	ErrCodeInvalidToken         ErrCode = 0xb
+	ErrCodeApplication          ErrCode = 0xc
+	ErrCodeCryptoBufferExceeded ErrCode = 0xd
+	ErrCodeKeyUpdate            ErrCode = 0xe
+	ErrCodeAEADLimitReached     ErrCode = 0xf
+	ErrCodeNoViablePath         ErrCode = 0x10
+	ErrCodeCrypto               ErrCode = 0x100
+	maxCryptoErrCode                    = 0x1ff
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                   "NO_ERROR",
+	ErrCodeInternal:             "INTERNAL_ERROR",
+	ErrCodeConnectionRefused:    "CONNECTION_REFUSED",
+	ErrCodeFlowControl:          "FLOW_CONTROL_ERROR",
+	ErrCodeStreamLimit:          "STREAM_LIMIT_ERROR",
+	ErrCodeStreamState:          "STREAM_STATE_ERROR",
+	ErrCodeFinalSize:            "FINAL_SIZE_ERROR",
+	ErrCodeFrameEncoding:        "FRAME_ENCODING_ERROR",
+	ErrCodeTransportParameter:   "TRANSPORT_PARAMETER_ERROR",
+	ErrCodeConnectionIDLimit:    "CONNECTION_ID_LIMIT_ERROR",
+	ErrCodeProtocolViolation:    "PROTOCOL_VIOLATION",
+	ErrCodeInvalidToken:         "INVALID_TOKEN",
+	ErrCodeApplication:          "APPLICATION_ERROR",
+	ErrCodeCryptoBufferExceeded: "CRYPTO_BUFFER_EXCEEDED",
+	ErrCodeKeyUpdate:            "KEY_UPDATE_ERROR",
+	ErrCodeAEADLimitReached:     "AEAD_LIMIT_REACHED",
+	ErrCodeNoViablePath:         "NO_VIABLE_PATH",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	if c >= ErrCodeCrypto && c <= maxCryptoErrCode {
+		return fmt.Sprintf("CRYPTO_ERROR(%v)", tls.AlertError(c-ErrCodeCrypto))
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(c))
+}
+
+// TransportError closes a connection because of a failure in QUIC itself,
+// detected by either peer.
+type TransportError struct {
+	Code   ErrCode
+	Reason string
+	// Remote is set when the peer closed the connection.
+	Remote bool
+}
+
+func (e TransportError) Error() string {
+	side := "local"
+	if e.Remote {
+		side = "remote"
+	}
+	return fmt.Sprintf("quic: %s transport error: %s: %s", side, e.Code, e.Reason)
+}
+
+// ApplicationError closes a connection, or resets one direction of a
+// stream, with a code the application protocol defines.
+type ApplicationError struct {
+	Code   uint64
+	Reason string
+	Remote bool
+}
+
+func (e ApplicationError) Error() string {
+	side := "local"
+	if e.Remote {
+		side = "remote"
+	}
+	return fmt.Sprintf("quic: %s application error 0x%x: %s", side, e.Code, e.Reason)
+}
+
+// StreamError reports that the peer reset a stream it was sending on, or
+// asked us to stop sending on one.
+type StreamError struct {
+	StreamID uint64
+	Code     uint64
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("quic: stream %d reset with code 0x%x", e.StreamID, e.Code)
+}
+
+func transportError(code ErrCode, format string, args ...any) TransportError {
+	return TransportError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// tlsError turns a handshake failure into the CRYPTO_ERROR that carries
+// its TLS alert.
+func tlsError(err error) TransportError {
+	code := ErrCodeCrypto + 80 // internal_error
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		code = ErrCodeCrypto + ErrCode(alert)
+	}
+	return TransportError{Code: code, Reason: err.Error()}
+}
@@ -0,0 +1,461 @@
+package quic
+
+// Frame types, RFC 9000 section 19.
+const (
+	frameTypePadding            = 0x00
+	frameTypePing               = 0x01
+	frameTypeAck                = 0x02
+	frameTypeAckECN             = 0x03
+	frameTypeResetStream        = 0x04
+	frameTypeStopSending        = 0x05
+	frameTypeCrypto             = 0x06
+	frameTypeNewToken           = 0x07
+	frameTypeStream             = 0x08 // through 0x0f
+	frameTypeMaxData            = 0x10
+	frameTypeMaxStreamData      = 0x11
+	frameTypeMaxStreamsBidi     = 0x12
+	frameTypeMaxStreamsUni      = 0x13
+	frameTypeDataBlocked        = 0x14
+	frameTypeStreamDataBlocked  = 0x15
+	frameTypeStreamsBlockedBidi = 0x16
+	frameTypeStreamsBlockedUni  = 0x17
+	frameTypeNewConnectionID    = 0x18
+	frameTypeRetireConnectionID = 0x19
+	frameTypePathChallenge      = 0x1a
+	frameTypePathResponse       = 0x1b
+	frameTypeConnectionClose    = 0x1c
+	frameTypeConnectionCloseApp = 0x1d
+	frameTypeHandshakeDone      = 0x1e
+)
+
+// STREAM frame flags, in the low bits of the frame type.
+const (
+	streamFlagFin = 0x01
+	streamFlagLen = 0x02
+	streamFlagOff = 0x04
+)
+
+type frame interface {
+	appendTo(b []byte) []byte
+}
+
+type paddingFrame struct{ n int }
+
+type pingFrame struct{}
+
+// pnRange is an inclusive range of packet numbers.
+type pnRange struct{ lo, hi int64 }
+
+type ackFrame struct {
+	// ranges are in descending order, as they appear in the frame
+	ranges []pnRange
+	// delay is in units of 2^ack_delay_exponent microseconds
+	delay uint64
+}
+
+type resetStreamFrame struct {
+	streamID  uint64
+	code      uint64
+	finalSize uint64
+}
+
+type stopSendingFrame struct {
+	streamID uint64
+	code     uint64
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+type newTokenFrame struct{ token []byte }
+
+type streamFrame struct {
+	streamID uint64
+	offset   uint64
+	data     []byte
+	fin      bool
+}
+
+type maxDataFrame struct{ max uint64 }
+
+type maxStreamDataFrame struct {
+	streamID uint64
+	max      uint64
+}
+
+type maxStreamsFrame struct {
+	uni bool
+	max uint64
+}
+
+type dataBlockedFrame struct{ limit uint64 }
+
+type streamDataBlockedFrame struct {
+	streamID uint64
+	limit    uint64
+}
+
+type streamsBlockedFrame struct {
+	uni   bool
+	limit uint64
+}
+
+type newConnectionIDFrame struct {
+	seq           uint64
+	retirePriorTo uint64
+	connID        []byte
+	resetToken    [16]byte
+}
+
+type retireConnectionIDFrame struct{ seq uint64 }
+
+type pathChallengeFrame struct{ data [8]byte }
+
+type pathResponseFrame struct{ data [8]byte }
+
+type connectionCloseFrame struct {
+	app       bool
+	code      uint64
+	frameType uint64
+	reason    string
+}
+
+type handshakeDoneFrame struct{}
+
+func (f paddingFrame) appendTo(b []byte) []byte {
+	return append(b, make([]byte, f.n)...)
+}
+
+func (pingFrame) appendTo(b []byte) []byte {
+	return append(b, frameTypePing)
+}
+
+func (f ackFrame) appendTo(b []byte) []byte {
+	first := f.ranges[0]
+	b = append(b, frameTypeAck)
+	b = appendVarint(b, uint64(first.hi))
+	b = appendVarint(b, f.delay)
+	b = appendVarint(b, uint64(len(f.ranges)-1))
+	b = appendVarint(b, uint64(first.hi-first.lo))
+	prev := first
+	for _, r := range f.ranges[1:] {
+		b = appendVarint(b, uint64(prev.lo-r.hi-2))
+		b = appendVarint(b, uint64(r.hi-r.lo))
+		prev = r
+	}
+	return b
+}
+
+func (f resetStreamFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeResetStream)
+	b = appendVarint(b, f.streamID)
+	b = appendVarint(b, f.code)
+	return appendVarint(b, f.finalSize)
+}
+
+func (f stopSendingFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeStopSending)
+	b = appendVarint(b, f.streamID)
+	return appendVarint(b, f.code)
+}
+
+func (f cryptoFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeCrypto)
+	b = appendVarint(b, f.offset)
+	b = appendVarint(b, uint64(len(f.data)))
+	return append(b, f.data...)
+}
+
+func (f newTokenFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeNewToken)
+	b = appendVarint(b, uint64(len(f.token)))
+	return append(b, f.token...)
+}
+
+// appendTo always writes the offset and length, so that a STREAM frame
+// never has to be the last frame of its packet.
+func (f streamFrame) appendTo(b []byte) []byte {
+	typ := byte(frameTypeStream | streamFlagOff | streamFlagLen)
+	if f.fin {
+		typ |= streamFlagFin
+	}
+	b = append(b, typ)
+	b = appendVarint(b, f.streamID)
+	b = appendVarint(b, f.offset)
+	b = appendVarint(b, uint64(len(f.data)))
+	return append(b, f.data...)
+}
+
+func (f maxDataFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeMaxData)
+	return appendVarint(b, f.max)
+}
+
+func (f maxStreamDataFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeMaxStreamData)
+	b = appendVarint(b, f.streamID)
+	return appendVarint(b, f.max)
+}
+
+func (f maxStreamsFrame) appendTo(b []byte) []byte {
+	if f.uni {
+		b = append(b, frameTypeMaxStreamsUni)
+	} else {
+		b = append(b, frameTypeMaxStreamsBidi)
+	}
+	return appendVarint(b, f.max)
+}
+
+func (f dataBlockedFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeDataBlocked)
+	return appendVarint(b, f.limit)
+}
+
+func (f streamDataBlockedFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeStreamDataBlocked)
+	b = appendVarint(b, f.streamID)
+	return appendVarint(b, f.limit)
+}
+
+func (f streamsBlockedFrame) appendTo(b []byte) []byte {
+	if f.uni {
+		b = append(b, frameTypeStreamsBlockedUni)
+	} else {
+		b = append(b, frameTypeStreamsBlockedBidi)
+	}
+	return appendVarint(b, f.limit)
+}
+
+func (f newConnectionIDFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeNewConnectionID)
+	b = appendVarint(b, f.seq)
+	b = appendVarint(b, f.retirePriorTo)
+	b = append(b, byte(len(f.connID)))
+	b = append(b, f.connID...)
+	return append(b, f.resetToken[:]...)
+}
+
+func (f retireConnectionIDFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypeRetireConnectionID)
+	return appendVarint(b, f.seq)
+}
+
+func (f pathChallengeFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypePathChallenge)
+	return append(b, f.data[:]...)
+}
+
+func (f pathResponseFrame) appendTo(b []byte) []byte {
+	b = append(b, frameTypePathResponse)
+	return append(b, f.data[:]...)
+}
+
+func (f connectionCloseFrame) appendTo(b []byte) []byte {
+	if f.app {
+		b = append(b, frameTypeConnectionCloseApp)
+		b = appendVarint(b, f.code)
+	} else {
+		b = append(b, frameTypeConnectionClose)
+		b = appendVarint(b, f.code)
+		b = appendVarint(b, f.frameType)
+	}
+	b = appendVarint(b, uint64(len(f.reason)))
+	return append(b, f.reason...)
+}
+
+func (handshakeDoneFrame) appendTo(b []byte) []byte {
+	return append(b, frameTypeHandshakeDone)
+}
+
+// frameLen returns the encoded length of f.
+func frameLen(f frame) int {
+	return len(f.appendTo(nil))
+}
+
+// streamFrameOverhead is the most a STREAM frame can add to its data.
+const streamFrameOverhead = 1 + 8 + 8 + 8
+
+// frameReader parses the frames of one packet payload.
+type frameReader struct {
+	p   []byte
+	err error
+}
+
+func (r *frameReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := consumeVarint(r.p)
+	if n < 0 {
+		r.err = transportError(ErrCodeFrameEncoding, "truncated frame")
+		return 0
+	}
+	r.p = r.p[n:]
+	return v
+}
+
+func (r *frameReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.p)) < n {
+		r.err = transportError(ErrCodeFrameEncoding, "truncated frame")
+		return nil
+	}
+	b := r.p[:n]
+	r.p = r.p[n:]
+	return b
+}
+
+// parseFrame parses the frame at the start of p, returning it along with
+// its type and length.
+func parseFrame(p []byte) (frame, uint64, int, error) {
+	r := &frameReader{p: p}
+	typ := r.varint()
+	var f frame
+	switch {
+	case typ == frameTypePadding:
+		n := 1
+		for n < len(p) && p[n] == 0 {
+			n++
+		}
+		return paddingFrame{n: n}, typ, n, nil
+	case typ == frameTypePing:
+		f = pingFrame{}
+	case typ == frameTypeAck || typ == frameTypeAckECN:
+		f = r.ack(typ == frameTypeAckECN)
+	case typ == frameTypeResetStream:
+		f = resetStreamFrame{streamID: r.varint(), code: r.varint(), finalSize: r.varint()}
+	case typ == frameTypeStopSending:
+		f = stopSendingFrame{streamID: r.varint(), code: r.varint()}
+	case typ == frameTypeCrypto:
+		offset := r.varint()
+		f = cryptoFrame{offset: offset, data: r.bytes(r.varint())}
+	case typ == frameTypeNewToken:
+		token := r.bytes(r.varint())
+		if r.err == nil && len(token) == 0 {
+			r.err = transportError(ErrCodeFrameEncoding, "empty NEW_TOKEN")
+		}
+		f = newTokenFrame{token: token}
+	case typ >= frameTypeStream && typ <= frameTypeStream|0x07:
+		sf := streamFrame{streamID: r.varint(), fin: typ&streamFlagFin != 0}
+		if typ&streamFlagOff != 0 {
+			sf.offset = r.varint()
+		}
+		if typ&streamFlagLen != 0 {
+			sf.data = r.bytes(r.varint())
+		} else {
+			sf.data = r.bytes(uint64(len(r.p)))
+		}
+		if sf.offset+uint64(len(sf.data)) > maxVarint {
+			return nil, typ, 0, transportError(ErrCodeFrameEncoding, "stream data beyond 2^62")
+		}
+		f = sf
+	case typ == frameTypeMaxData:
+		f = maxDataFrame{max: r.varint()}
+	case typ == frameTypeMaxStreamData:
+		f = maxStreamDataFrame{streamID: r.varint(), max: r.varint()}
+	case typ == frameTypeMaxStreamsBidi || typ == frameTypeMaxStreamsUni:
+		f = maxStreamsFrame{uni: typ == frameTypeMaxStreamsUni, max: r.varint()}
+	case typ == frameTypeDataBlocked:
+		f = dataBlockedFrame{limit: r.varint()}
+	case typ == frameTypeStreamDataBlocked:
+		f = streamDataBlockedFrame{streamID: r.varint(), limit: r.varint()}
+	case typ == frameTypeStreamsBlockedBidi || typ == frameTypeStreamsBlockedUni:
+		f = streamsBlockedFrame{uni: typ == frameTypeStreamsBlockedUni, limit: r.varint()}
+	case typ == frameTypeNewConnectionID:
+		nf := newConnectionIDFrame{seq: r.varint(), retirePriorTo: r.varint()}
+		n := r.bytes(1)
+		if len(n) == 1 && (n[0] == 0 || n[0] > 20) {
+			r.err = transportError(ErrCodeFrameEncoding, "connection ID length %d", n[0])
+		}
+		if len(n) == 1 {
+			nf.connID = r.bytes(uint64(n[0]))
+		}
+		copy(nf.resetToken[:], r.bytes(16))
+		f = nf
+	case typ == frameTypeRetireConnectionID:
+		f = retireConnectionIDFrame{seq: r.varint()}
+	case typ == frameTypePathChallenge:
+		var pf pathChallengeFrame
+		copy(pf.data[:], r.bytes(8))
+		f = pf
+	case typ == frameTypePathResponse:
+		var pf pathResponseFrame
+		copy(pf.data[:], r.bytes(8))
+		f = pf
+	case typ == frameTypeConnectionClose || typ == frameTypeConnectionCloseApp:
+		cf := connectionCloseFrame{app: typ == frameTypeConnectionCloseApp, code: r.varint()}
+		if !cf.app {
+			cf.frameType = r.varint()
+		}
+		cf.reason = string(r.bytes(r.varint()))
+		f = cf
+	case typ == frameTypeHandshakeDone:
+		f = handshakeDoneFrame{}
+	default:
+		if r.err == nil {
+			r.err = transportError(ErrCodeFrameEncoding, "unknown frame type 0x%x", typ)
+		}
+	}
+	if r.err != nil {
+		return nil, typ, 0, r.err
+	}
+	return f, typ, len(p) - len(r.p), nil
+}
+
+func (r *frameReader) ack(ecn bool) frame {
+	largest := int64(r.varint())
+	f := ackFrame{delay: r.varint()}
+	count := r.varint()
+	first := int64(r.varint())
+	if r.err != nil {
+		return nil
+	}
+	if first > largest {
+		r.err = transportError(ErrCodeFrameEncoding, "ACK range below zero")
+		return nil
+	}
+	f.ranges = append(f.ranges, pnRange{lo: largest - first, hi: largest})
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		gap := int64(r.varint())
+		length := int64(r.varint())
+		hi := f.ranges[len(f.ranges)-1].lo - gap - 2
+		if hi < 0 || length > hi {
+			r.err = transportError(ErrCodeFrameEncoding, "ACK range below zero")
+			return nil
+		}
+		f.ranges = append(f.ranges, pnRange{lo: hi - length, hi: hi})
+	}
+	if ecn {
+		// the ECN counts are not used
+		r.varint()
+		r.varint()
+		r.varint()
+	}
+	return f
+}
+
+// ackEliciting reports whether receiving a frame of type typ calls for an
+// acknowledgment.
+func ackEliciting(typ uint64) bool {
+	switch typ {
+	case frameTypePadding, frameTypeAck, frameTypeAckECN,
+		frameTypeConnectionClose, frameTypeConnectionCloseApp:
+		return false
+	}
+	return true
+}
+
+// allowedInHandshake reports whether a frame of type typ may appear in an
+// Initial or Handshake packet.
+func allowedInHandshake(typ uint64) bool {
+	switch typ {
+	case frameTypePadding, frameTypePing, frameTypeAck, frameTypeAckECN,
+		frameTypeCrypto, frameTypeConnectionClose:
+		return true
+	}
+	return false
+}
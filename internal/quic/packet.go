@@ -0,0 +1,146 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+type packetType int
+
+const (
+	packetInitial packetType = iota
+	packet0RTT
+	packetHandshake
+	packetRetry
+	packet1RTT
+)
+
+var errInvalidPacket = errors.New("quic: invalid packet")
+
+// header is the unprotected part of a packet header.
+type header struct {
+	typ     packetType
+	version uint32
+	dcid    []byte
+	scid    []byte
+	// pnOffset is where the protected packet number starts, and length
+	// where the packet ends within its datagram.
+	pnOffset int
+	length   int
+}
+
+// parseHeader parses the header of the packet at the start of a datagram.
+// Long header packets give their own length, so that several may be
+// coalesced into one datagram. A short header packet takes up the rest of
+// the datagram, and its connection ID is connIDLen bytes long.
+func parseHeader(b []byte) (header, error) {
+	var h header
+	if len(b) == 0 || b[0]&0x40 == 0 {
+		return h, errInvalidPacket
+	}
+	if b[0]&0x80 == 0 {
+		if len(b) < 1+connIDLen {
+			return h, errInvalidPacket
+		}
+		h.typ = packet1RTT
+		h.dcid = b[1 : 1+connIDLen]
+		h.pnOffset = 1 + connIDLen
+		h.length = len(b)
+		return h, nil
+	}
+
+	if len(b) < 6 {
+		return h, errInvalidPacket
+	}
+	h.typ = packetType(b[0] >> 4 & 0x3)
+	h.version = binary.BigEndian.Uint32(b[1:5])
+	p := b[5:]
+	var ok bool
+	if h.dcid, p, ok = consumeConnID(p); !ok {
+		return h, errInvalidPacket
+	}
+	if h.scid, p, ok = consumeConnID(p); !ok {
+		return h, errInvalidPacket
+	}
+	if h.version != version1 || h.typ == packetRetry {
+		h.length = len(b)
+		return h, nil
+	}
+	if h.typ == packetInitial {
+		// tokens only come from Retry or NEW_TOKEN, neither of which is
+		// sent, but a client may still present one
+		tokenLen, n := consumeVarint(p)
+		if n < 0 || uint64(len(p)-n) < tokenLen {
+			return h, errInvalidPacket
+		}
+		p = p[n+int(tokenLen):]
+	}
+	length, n := consumeVarint(p)
+	if n < 0 || uint64(len(p)-n) < length {
+		return h, errInvalidPacket
+	}
+	h.pnOffset = len(b) - len(p) + n
+	h.length = h.pnOffset + int(length)
+	return h, nil
+}
+
+func consumeConnID(p []byte) ([]byte, []byte, bool) {
+	if len(p) == 0 || p[0] > 20 || len(p) < 1+int(p[0]) {
+		return nil, nil, false
+	}
+	return p[1 : 1+p[0]], p[1+p[0]:], true
+}
+
+// appendLongHeader appends an Initial or Handshake packet header up to and
+// including the packet number. The Length field is left as a two byte
+// placeholder for setLength, two bytes being enough for any datagram sent.
+func appendLongHeader(b []byte, typ packetType, dcid, scid []byte, pn int64) (pkt []byte, pnOffset int) {
+	b = append(b, 0xc0|byte(typ)<<4|(pnLen-1))
+	b = binary.BigEndian.AppendUint32(b, version1)
+	b = append(b, byte(len(dcid)))
+	b = append(b, dcid...)
+	b = append(b, byte(len(scid)))
+	b = append(b, scid...)
+	if typ == packetInitial {
+		b = append(b, 0) // no token
+	}
+	b = append(b, 0x40, 0)
+	pnOffset = len(b)
+	return binary.BigEndian.AppendUint32(b, uint32(pn)), pnOffset
+}
+
+// appendShortHeader appends a 1-RTT packet header up to and including the
+// packet number.
+func appendShortHeader(b []byte, dcid []byte, pn int64) (pkt []byte, pnOffset int) {
+	b = append(b, 0x40|(pnLen-1))
+	b = append(b, dcid...)
+	pnOffset = len(b)
+	return binary.BigEndian.AppendUint32(b, uint32(pn)), pnOffset
+}
+
+// setLength fills in the Length field of a long header packet once its
+// payload is known, counting the AEAD tag still to be added.
+func setLength(pkt []byte, pnOffset, overhead int) {
+	length := len(pkt) - pnOffset + overhead
+	pkt[pnOffset-2] = 0x40 | byte(length>>8)
+	pkt[pnOffset-1] = byte(length)
+}
+
+// peekDCID returns the destination connection ID of the first packet in a
+// datagram, which is all the endpoint needs to route it.
+func peekDCID(b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	if b[0]&0x80 == 0 {
+		if len(b) < 1+connIDLen {
+			return nil, false
+		}
+		return b[1 : 1+connIDLen], true
+	}
+	if len(b) < 6 {
+		return nil, false
+	}
+	dcid, _, ok := consumeConnID(b[5:])
+	return dcid, ok
+}
@@ -0,0 +1,179 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// initialSalt derives the Initial secrets of QUIC version 1, RFC 9001
+// section 5.2.
+var initialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+// pnLen is the length of every packet number sent. Always using four
+// bytes keeps the header protection sample within even the smallest
+// packet, at the cost of a few bytes per packet.
+const pnLen = 4
+
+var errDecrypt = errors.New("quic: packet decryption failed")
+
+// keys protect packets in one direction at one encryption level.
+type keys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// newKeys derives packet protection keys from a traffic secret.
+func newKeys(suite uint16, secret []byte) (*keys, error) {
+	var h func() hash.Hash
+	var keyLen int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	default:
+		return nil, fmt.Errorf("quic: unsupported cipher suite %s", tls.CipherSuiteName(suite))
+	}
+	key := expandLabel(h, secret, "quic key", keyLen)
+	iv := expandLabel(h, secret, "quic iv", 12)
+	hpKey := expandLabel(h, secret, "quic hp", keyLen)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keys{aead: aead, iv: iv, hp: hp}, nil
+}
+
+// initialKeys derives the Initial keys of both directions from the
+// destination connection ID of the client's first packet.
+func initialKeys(dcid []byte) (client, server *keys) {
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, initialSalt)
+	if err != nil {
+		panic(err)
+	}
+	client, err = newKeys(tls.TLS_AES_128_GCM_SHA256, expandLabel(sha256.New, initialSecret, "client in", 32))
+	if err != nil {
+		panic(err)
+	}
+	server, err = newKeys(tls.TLS_AES_128_GCM_SHA256, expandLabel(sha256.New, initialSecret, "server in", 32))
+	if err != nil {
+		panic(err)
+	}
+	return client, server
+}
+
+// expandLabel is HKDF-Expand-Label from TLS 1.3, RFC 8446 section 7.1,
+// with an empty context.
+func expandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out, err := hkdf.Expand(h, secret, string(info), length)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (k *keys) nonce(pn int64) []byte {
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return nonce
+}
+
+// protect encrypts pkt, a header whose packet number starts at pnOffset
+// followed by the plaintext payload, and then masks the header.
+func (k *keys) protect(pkt []byte, pnOffset int, pn int64) []byte {
+	hdr := pkt[:pnOffset+pnLen]
+	payload := pkt[len(hdr):]
+	sealed := k.aead.Seal(payload[:0], k.nonce(pn), payload, hdr)
+	pkt = append(hdr, sealed...)
+
+	mask := k.mask(pkt[pnOffset+4 : pnOffset+20])
+	if pkt[0]&0x80 != 0 {
+		pkt[0] ^= mask[0] & 0x0f
+	} else {
+		pkt[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		pkt[pnOffset+i] ^= mask[1+i]
+	}
+	return pkt
+}
+
+// unprotect removes header protection from pkt in place and decrypts its
+// payload. largest is the largest packet number received so far at this
+// level, from which the full packet number is recovered.
+func (k *keys) unprotect(pkt []byte, pnOffset int, largest int64) (int64, []byte, error) {
+	if len(pkt) < pnOffset+20 {
+		return 0, nil, errDecrypt
+	}
+	mask := k.mask(pkt[pnOffset+4 : pnOffset+20])
+	if pkt[0]&0x80 != 0 {
+		pkt[0] ^= mask[0] & 0x0f
+	} else {
+		pkt[0] ^= mask[0] & 0x1f
+	}
+	n := int(pkt[0]&0x03) + 1
+	var truncated uint64
+	for i := 0; i < n; i++ {
+		pkt[pnOffset+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(pkt[pnOffset+i])
+	}
+	pn := decodePacketNumber(largest, truncated, n)
+	hdr := pkt[:pnOffset+n]
+	payload, err := k.aead.Open(pkt[len(hdr):len(hdr)], k.nonce(pn), pkt[len(hdr):], hdr)
+	if err != nil {
+		return 0, nil, errDecrypt
+	}
+	return pn, payload, nil
+}
+
+func (k *keys) mask(sample []byte) []byte {
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, sample)
+	return mask
+}
+
+// decodePacketNumber recovers a packet number truncated to n bytes, as in
+// RFC 9000 appendix A.3.
+func decodePacketNumber(largest int64, truncated uint64, n int) int64 {
+	expected := largest + 1
+	win := int64(1) << (8 * n)
+	hwin := win / 2
+	candidate := expected&^(win-1) | int64(truncated)
+	switch {
+	case candidate <= expected-hwin && candidate < 1<<62-win:
+		return candidate + win
+	case candidate > expected+hwin && candidate >= win:
+		return candidate - win
+	}
+	return candidate
+}
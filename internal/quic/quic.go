@@ -0,0 +1,139 @@
+// Package quic is a minimal QUIC version 1 transport (RFC 9000) for the
+// experimental HTTP/3 listener. The TLS handshake is run by crypto/tls
+// through its QUIC API, packets are protected as in RFC 9001, and lost
+// packets are detected and their frames sent again as in RFC 9002.
+//
+// Much of QUIC is left out: there is no version negotiation, Retry, 0-RTT,
+// key update, connection migration or path MTU discovery, and every
+// datagram stays within the 1200 bytes any path must carry. Only the
+// AES-GCM cipher suites are supported.
+package quic
+
+import (
+	"errors"
+	"time"
+)
+
+// version1 is the only QUIC version spoken.
+const version1 = 0x00000001
+
+const (
+	// maxDatagramSize is the largest datagram sent. Datagrams carrying
+	// Initial packets are padded to this size.
+	maxDatagramSize = 1200
+	// connIDLen is the length of every connection ID this package picks,
+	// which lets short headers be parsed without knowing the connection.
+	connIDLen = 8
+)
+
+const (
+	DefaultMaxIdleTimeout     = 30 * time.Second
+	DefaultMaxIncomingStreams = 100
+	DefaultHandshakeTimeout   = 10 * time.Second
+)
+
+const (
+	// streamWindow and connWindow are the receive windows offered for
+	// each stream and for the connection as a whole.
+	streamWindow = 1 << 20
+	connWindow   = 4 << 20
+	// maxStreamBuffer bounds the data a stream holds before it is sent,
+	// beyond which Write blocks.
+	maxStreamBuffer = 64 << 10
+	// maxCryptoBuffer bounds out of order handshake data.
+	maxCryptoBuffer = 64 << 10
+	// maxAckDelay is how long we may hold back acknowledgments, as told to
+	// the peer. Acknowledgments are in fact sent right away.
+	maxAckDelay = 25 * time.Millisecond
+	// ackDelayExponent scales the delay in our ACK frames. It is the
+	// default, so it is not sent as a transport parameter.
+	ackDelayExponent = 3
+)
+
+// Config holds the settings of a connection. A nil Config and zero fields
+// take the defaults.
+type Config struct {
+	// MaxIdleTimeout closes a connection that has not heard from its peer
+	// for this long. The smaller of both peers' timeouts applies.
+	MaxIdleTimeout time.Duration
+	// MaxIncomingStreams limits the number of streams of each kind, bidi
+	// and uni, the peer may have open at once.
+	MaxIncomingStreams int64
+	// HandshakeTimeout bounds the time a server waits for a handshake to
+	// complete, and Dial waits unless its context ends first.
+	HandshakeTimeout time.Duration
+}
+
+func (c *Config) maxIdleTimeout() time.Duration {
+	if c == nil || c.MaxIdleTimeout == 0 {
+		return DefaultMaxIdleTimeout
+	}
+	return c.MaxIdleTimeout
+}
+
+func (c *Config) maxIncomingStreams() int64 {
+	if c == nil || c.MaxIncomingStreams == 0 {
+		return DefaultMaxIncomingStreams
+	}
+	return c.MaxIncomingStreams
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	if c == nil || c.HandshakeTimeout == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+var (
+	ErrClosed      = errors.New("quic: connection closed")
+	ErrIdleTimeout = errors.New("quic: idle timeout")
+)
+
+const maxVarint = 1<<62 - 1
+
+// appendVarint appends v as a variable-length integer, RFC 9000 section 16.
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// varintLen returns the number of bytes appendVarint takes for v.
+func varintLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// consumeVarint reads a variable-length integer from the start of b. It
+// returns the number of bytes read, or -1 if b is too short.
+func consumeVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, -1
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, -1
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
@@ -0,0 +1,442 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// Test: Initial secrets, keys and header protection masks match RFC 9001
+// appendix A
+func TestInitialKeys(t *testing.T) {
+	dcid := unhex(t, "8394c8f03e515708")
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, initialSalt)
+	require.NoError(t, err)
+
+	clientSecret := expandLabel(sha256.New, initialSecret, "client in", 32)
+	assert.Equal(t, unhex(t, "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea"), clientSecret)
+	assert.Equal(t, unhex(t, "1f369613dd76d5467730efcbe3b1a22d"), expandLabel(sha256.New, clientSecret, "quic key", 16))
+	assert.Equal(t, unhex(t, "9f50449e04a0e810283a1e9933adedd2"), expandLabel(sha256.New, clientSecret, "quic hp", 16))
+
+	serverSecret := expandLabel(sha256.New, initialSecret, "server in", 32)
+	assert.Equal(t, unhex(t, "3c199828fd139efd216c155ad844cc81fb82fa8d7446fa7d78be803acdda951b"), serverSecret)
+	assert.Equal(t, unhex(t, "cf3a5331653c364c88f0f379b6067e37"), expandLabel(sha256.New, serverSecret, "quic key", 16))
+	assert.Equal(t, unhex(t, "c206b8d9b9f0f37644430b490eeaa314"), expandLabel(sha256.New, serverSecret, "quic hp", 16))
+
+	client, server := initialKeys(dcid)
+	assert.Equal(t, unhex(t, "fa044b2f42a3fd3b46fb255c"), client.iv)
+	assert.Equal(t, unhex(t, "0ac1493ca1905853b0bba03e"), server.iv)
+	assert.Equal(t, unhex(t, "437b9aec36"), client.mask(unhex(t, "d1b1c98dd7689fb8ec11d242b123dc9b"))[:5])
+	assert.Equal(t, unhex(t, "2ec0d8356a"), server.mask(unhex(t, "2cd0991cd25b0aac406a5816b6394100"))[:5])
+}
+
+// Test: a protected packet is recovered by the other side's keys, and a
+// modified one is rejected
+func TestProtectUnprotect(t *testing.T) {
+	client, _ := initialKeys(unhex(t, "0001020304050607"))
+	dcid, scid := newConnID(), newConnID()
+	payload := pingFrame{}.appendTo(nil)
+	payload = paddingFrame{n: 40}.appendTo(payload)
+
+	seal := func() ([]byte, int) {
+		pkt, pnOffset := appendLongHeader(nil, packetInitial, dcid, scid, 1000)
+		pkt = append(pkt, payload...)
+		setLength(pkt, pnOffset, aeadOverhead)
+		return client.protect(pkt, pnOffset, 1000), pnOffset
+	}
+
+	pkt, pnOffset := seal()
+	h, err := parseHeader(pkt)
+	require.NoError(t, err)
+	assert.Equal(t, packetInitial, h.typ)
+	assert.Equal(t, dcid, h.dcid)
+	assert.Equal(t, scid, h.scid)
+	assert.Equal(t, pnOffset, h.pnOffset)
+	pn, got, err := client.unprotect(pkt[:h.length], h.pnOffset, 998)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), pn)
+	assert.Equal(t, payload, got)
+
+	pkt, pnOffset = seal()
+	pkt[len(pkt)-1] ^= 1
+	_, _, err = client.unprotect(pkt, pnOffset, 998)
+	assert.ErrorIs(t, err, errDecrypt)
+}
+
+// Test: variable-length integers from RFC 9000 appendix A.1
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		enc string
+		v   uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652},
+		{"9d7f3e7d", 494878333},
+		{"7bbd", 15293},
+		{"25", 37},
+	}
+	for _, tt := range tests {
+		b := unhex(t, tt.enc)
+		v, n := consumeVarint(b)
+		assert.Equal(t, tt.v, v)
+		assert.Equal(t, len(b), n)
+		assert.Equal(t, b, appendVarint(nil, tt.v))
+		assert.Equal(t, len(b), varintLen(tt.v))
+
+		_, n = consumeVarint(b[:len(b)-1])
+		assert.Equal(t, -1, n)
+	}
+}
+
+// Test: packet numbers are recovered from their truncated form, RFC 9000
+// appendix A.3
+func TestDecodePacketNumber(t *testing.T) {
+	assert.Equal(t, int64(0xa82f9b32), decodePacketNumber(0xa82f30ea, 0x9b32, 2))
+	assert.Equal(t, int64(0), decodePacketNumber(-1, 0, 4))
+	assert.Equal(t, int64(256), decodePacketNumber(250, 0, 1))
+}
+
+// Test: frames survive a round trip through their wire form
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []frame{
+		pingFrame{},
+		ackFrame{ranges: []pnRange{{lo: 10, hi: 12}, {lo: 3, hi: 5}, {lo: 0, hi: 0}}, delay: 7},
+		resetStreamFrame{streamID: 4, code: 9, finalSize: 100},
+		stopSendingFrame{streamID: 8, code: 2},
+		cryptoFrame{offset: 5, data: []byte("hello")},
+		streamFrame{streamID: 4, offset: 1 << 20, data: []byte("body"), fin: true},
+		streamFrame{streamID: 0, data: []byte{}},
+		maxDataFrame{max: 1 << 30},
+		maxStreamDataFrame{streamID: 3, max: 99},
+		maxStreamsFrame{uni: true, max: 12},
+		dataBlockedFrame{limit: 4},
+		streamDataBlockedFrame{streamID: 4, limit: 5},
+		newConnectionIDFrame{seq: 1, retirePriorTo: 0, connID: newConnID(), resetToken: [16]byte{1}},
+		retireConnectionIDFrame{seq: 1},
+		pathChallengeFrame{data: [8]byte{1, 2, 3}},
+		pathResponseFrame{data: [8]byte{4, 5, 6}},
+		connectionCloseFrame{code: 0x0a, frameType: 0x08, reason: "bad"},
+		connectionCloseFrame{app: true, code: 0x101, reason: "app"},
+		handshakeDoneFrame{},
+	}
+	for _, f := range frames {
+		b := f.appendTo(nil)
+		assert.Equal(t, len(b), frameLen(f))
+		got, _, n, err := parseFrame(b)
+		require.NoError(t, err, "%#v", f)
+		assert.Equal(t, len(b), n)
+		assert.Equal(t, f, got)
+	}
+}
+
+// Test: malformed frames are rejected with FRAME_ENCODING_ERROR
+func TestFrameErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated stream":  streamFrame{streamID: 4, data: []byte("abc")}.appendTo(nil)[:5],
+		"ack below zero":    {frameTypeAck, 2, 0, 0, 5},
+		"gap below zero":    {frameTypeAck, 5, 0, 1, 1, 3, 0},
+		"unknown frame":     {0x40, 0x40},
+		"truncated varint":  {frameTypeMaxData, 0x80},
+		"new token missing": {frameTypeNewToken, 0},
+	}
+	for name, b := range tests {
+		_, _, _, err := parseFrame(b)
+		var te TransportError
+		require.ErrorAs(t, err, &te, name)
+		assert.Equal(t, ErrCodeFrameEncoding, te.Code, name)
+	}
+}
+
+// Test: transport parameters survive a round trip, and a client may not
+// send those only a server sends
+func TestTransportParams(t *testing.T) {
+	p := defaultTransportParams()
+	p.initialSCID = newConnID()
+	p.originalDCID = newConnID()
+	p.initialMaxData = 1000
+	p.initialMaxStreamsBidi = 10
+	p.maxIdleTimeout = 5 * time.Second
+
+	got, err := parseTransportParams(p.marshal(), true)
+	require.NoError(t, err)
+	assert.Equal(t, p, got)
+
+	_, err = parseTransportParams(p.marshal(), false)
+	assert.Error(t, err)
+}
+
+// Test: received packet numbers merge into ranges
+func TestRangeSet(t *testing.T) {
+	var s rangeSet
+	for _, pn := range []int64{0, 1, 5, 3, 2, 9} {
+		s.add(pn)
+	}
+	assert.Equal(t, []pnRange{{lo: 9, hi: 9}, {lo: 5, hi: 5}, {lo: 0, hi: 3}}, s.descending())
+	s.add(4)
+	assert.Equal(t, []pnRange{{lo: 9, hi: 9}, {lo: 0, hi: 5}}, s.descending())
+	assert.True(t, s.contains(4))
+	assert.False(t, s.contains(7))
+}
+
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"test"},
+	}
+	client = &tls.Config{RootCAs: pool, ServerName: "localhost", NextProtos: []string{"test"}}
+	return server, client
+}
+
+// lossyConn drops every nth datagram it is asked to send.
+type lossyConn struct {
+	net.PacketConn
+	n int
+
+	mu    sync.Mutex
+	count int
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.count++
+	drop := c.count%c.n == 0
+	c.mu.Unlock()
+	if drop {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// pair connects a client to a loopback listener, dropping every nth
+// datagram in both directions unless n is zero.
+func pair(t *testing.T, n int) (client, server *Conn) {
+	t.Helper()
+	serverTLS, clientTLS := testTLSConfigs(t)
+	wrap := func(pc net.PacketConn) net.PacketConn {
+		if n == 0 {
+			return pc
+		}
+		return &lossyConn{PacketConn: pc, n: n}
+	}
+
+	spc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := NewListener(wrap(spc), serverTLS, nil)
+	t.Cleanup(func() { l.Close() })
+
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { cpc.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := l.Accept(ctx)
+		if err == nil {
+			accepted <- c
+		}
+		close(accepted)
+	}()
+	client, err = DialPacketConn(ctx, wrap(cpc), spc.LocalAddr(), clientTLS, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	server = <-accepted
+	require.NotNil(t, server)
+	assert.Equal(t, "test", client.ConnectionState().NegotiatedProtocol)
+	return client, server
+}
+
+// echo copies each stream the server accepts back to the client.
+func echo(server *Conn) {
+	for {
+		s, err := server.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(s, s)
+			s.Close()
+		}()
+	}
+}
+
+func roundTrip(t *testing.T, c *Conn, data []byte) []byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	s, err := c.OpenStream(ctx)
+	require.NoError(t, err)
+	go func() {
+		s.Write(data)
+		s.Close()
+	}()
+	got, err := io.ReadAll(s)
+	require.NoError(t, err)
+	return got
+}
+
+// Test: data written on a stream comes back from an echo server,
+// including more than the flow control windows allow at once
+func TestStreamEcho(t *testing.T) {
+	client, server := pair(t, 0)
+	go echo(server)
+
+	assert.Equal(t, []byte("hello"), roundTrip(t, client, []byte("hello")))
+	big := bytes.Repeat([]byte("0123456789abcdef"), 2<<20/16)
+	assert.Equal(t, big, roundTrip(t, client, big))
+}
+
+// Test: several streams are carried at once
+func TestConcurrentStreams(t *testing.T) {
+	client, server := pair(t, 0)
+	go echo(server)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte(i)}, 10000+i)
+			assert.Equal(t, data, roundTrip(t, client, data))
+		}()
+	}
+	wg.Wait()
+}
+
+// Test: the handshake and a transfer complete when datagrams are lost
+func TestLoss(t *testing.T) {
+	client, server := pair(t, 5)
+	go echo(server)
+
+	data := bytes.Repeat([]byte("lossy"), 100000)
+	assert.Equal(t, data, roundTrip(t, client, data))
+}
+
+// Test: unidirectional streams carry data one way
+func TestUniStream(t *testing.T) {
+	client, server := pair(t, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := client.OpenUniStream(ctx)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("one way"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = s.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	r, err := server.AcceptUniStream(ctx)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "one way", string(got))
+}
+
+// Test: a reset stream fails the peer's reads with the code given
+func TestStreamReset(t *testing.T) {
+	client, server := pair(t, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := client.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("partial"))
+	require.NoError(t, err)
+	s.Reset(42)
+
+	r, err := server.AcceptStream(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	var se StreamError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, uint64(42), se.Code)
+}
+
+// Test: closing a connection with an error reaches the peer
+func TestCloseWithError(t *testing.T) {
+	client, server := pair(t, 0)
+	require.NoError(t, server.CloseWithError(0x101, "going away"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.AcceptStream(ctx)
+	var ae ApplicationError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, uint64(0x101), ae.Code)
+	assert.Equal(t, "going away", ae.Reason)
+	assert.True(t, ae.Remote)
+}
+
+// Test: a connection closes after the idle timeout
+func TestIdleTimeout(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen("127.0.0.1:0", serverTLS, &Config{MaxIdleTimeout: 200 * time.Millisecond})
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, l.Addr().String(), clientTLS, nil)
+	require.NoError(t, err)
+	_, err = c.AcceptStream(ctx)
+	assert.True(t, errors.Is(err, ErrIdleTimeout), "%v", err)
+}
+
+// Test: a handshake with a server offering no common protocol fails
+func TestHandshakeFailure(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	l, err := Listen("127.0.0.1:0", serverTLS, nil)
+	require.NoError(t, err)
+	defer l.Close()
+
+	clientTLS.NextProtos = []string{"other"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = Dial(ctx, l.Addr().String(), clientTLS, nil)
+	var te TransportError
+	require.ErrorAs(t, err, &te)
+	assert.True(t, te.Code >= ErrCodeCrypto, "%v", te)
+}
@@ -0,0 +1,196 @@
+package quic
+
+import "time"
+
+// Loss detection constants, RFC 9002 section 6.
+const (
+	packetThreshold = 3
+	timeThresholdN  = 9 // time threshold of 9/8 of the RTT
+	timeThresholdD  = 8
+	granularity     = time.Millisecond
+	initialRTT      = 333 * time.Millisecond
+)
+
+// Congestion control constants, RFC 9002 section 7.
+const (
+	initialWindow = 10 * maxDatagramSize
+	minimumWindow = 2 * maxDatagramSize
+)
+
+// sentPacket records a packet until it is acknowledged or declared lost.
+type sentPacket struct {
+	pn           int64
+	time         time.Time
+	size         int
+	ackEliciting bool
+	// inFlight is cleared once the frames have been queued again after a
+	// probe timeout, while the packet may still be acknowledged.
+	inFlight bool
+	// frames are those that are sent again if the packet is lost
+	frames []frame
+}
+
+// rttStats estimates the round-trip time, RFC 9002 section 5.
+type rttStats struct {
+	latest   time.Duration
+	smoothed time.Duration
+	variance time.Duration
+	min      time.Duration
+	sampled  bool
+}
+
+func newRTTStats() rttStats {
+	return rttStats{smoothed: initialRTT, variance: initialRTT / 2}
+}
+
+// update takes an RTT sample. ackDelay is the delay the peer reports,
+// already limited to its max_ack_delay once the handshake is confirmed.
+func (r *rttStats) update(latest, ackDelay time.Duration) {
+	r.latest = latest
+	if !r.sampled {
+		r.sampled = true
+		r.min = latest
+		r.smoothed = latest
+		r.variance = latest / 2
+		return
+	}
+	r.min = min(r.min, latest)
+	adjusted := latest
+	if latest >= r.min+ackDelay {
+		adjusted = latest - ackDelay
+	}
+	diff := r.smoothed - adjusted
+	if diff < 0 {
+		diff = -diff
+	}
+	r.variance = (3*r.variance + diff) / 4
+	r.smoothed = (7*r.smoothed + adjusted) / 8
+}
+
+// pto returns the probe timeout before any backoff. maxAckDelay is added
+// for the application data space only.
+func (r *rttStats) pto(maxAckDelay time.Duration) time.Duration {
+	return r.smoothed + max(4*r.variance, granularity) + maxAckDelay
+}
+
+// lossDelay is how long after a later packet was acknowledged an
+// unacknowledged packet is declared lost.
+func (r *rttStats) lossDelay() time.Duration {
+	return max(max(r.latest, r.smoothed)*timeThresholdN/timeThresholdD, granularity)
+}
+
+// congestion is the NewReno controller of RFC 9002 section 7.
+type congestion struct {
+	window        int
+	ssthresh      int
+	bytesInFlight int
+	recoveryStart time.Time
+}
+
+func newCongestion() congestion {
+	return congestion{window: initialWindow, ssthresh: 1 << 62}
+}
+
+func (c *congestion) canSend(size int) bool {
+	return c.bytesInFlight+size <= c.window
+}
+
+func (c *congestion) onSent(size int) {
+	c.bytesInFlight += size
+}
+
+func (c *congestion) onAcked(p *sentPacket) {
+	c.bytesInFlight -= p.size
+	if !p.time.After(c.recoveryStart) {
+		// no growth for packets sent before the last loss
+		return
+	}
+	if c.window < c.ssthresh {
+		c.window += p.size
+	} else {
+		c.window += maxDatagramSize * p.size / c.window
+	}
+}
+
+// onLost handles packets declared lost by a single acknowledgment. A
+// loss among packets sent before the current recovery period began does
+// not shrink the window again.
+func (c *congestion) onLost(lost []*sentPacket, now time.Time) {
+	var latest time.Time
+	for _, p := range lost {
+		c.bytesInFlight -= p.size
+		if p.time.After(latest) {
+			latest = p.time
+		}
+	}
+	if len(lost) == 0 || !latest.After(c.recoveryStart) {
+		return
+	}
+	c.recoveryStart = now
+	c.ssthresh = max(c.window/2, minimumWindow)
+	c.window = c.ssthresh
+}
+
+// discard forgets packets that will never be acknowledged, when their
+// keys are dropped or after a probe timeout.
+func (c *congestion) discard(p *sentPacket) {
+	c.bytesInFlight -= p.size
+}
+
+// maxAckRanges bounds the ranges of received packet numbers remembered,
+// and so the size of an ACK frame.
+const maxAckRanges = 32
+
+// rangeSet holds received packet numbers as ranges in ascending order.
+// Numbers below floor were forgotten to stay within maxAckRanges, and are
+// treated as received.
+type rangeSet struct {
+	ranges []pnRange
+	floor  int64
+}
+
+func (s *rangeSet) contains(pn int64) bool {
+	if pn < s.floor {
+		return true
+	}
+	for _, r := range s.ranges {
+		if pn >= r.lo && pn <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *rangeSet) add(pn int64) {
+	i := 0
+	for i < len(s.ranges) && s.ranges[i].hi+1 < pn {
+		i++
+	}
+	switch {
+	case i == len(s.ranges) || pn+1 < s.ranges[i].lo:
+		s.ranges = append(s.ranges, pnRange{})
+		copy(s.ranges[i+1:], s.ranges[i:])
+		s.ranges[i] = pnRange{lo: pn, hi: pn}
+	case pn+1 == s.ranges[i].lo:
+		s.ranges[i].lo = pn
+	case pn == s.ranges[i].hi+1:
+		s.ranges[i].hi = pn
+		if i+1 < len(s.ranges) && s.ranges[i+1].lo == pn+1 {
+			s.ranges[i].hi = s.ranges[i+1].hi
+			s.ranges = append(s.ranges[:i+1], s.ranges[i+2:]...)
+		}
+	}
+	if len(s.ranges) > maxAckRanges {
+		s.floor = s.ranges[0].hi + 1
+		s.ranges = s.ranges[1:]
+	}
+}
+
+// descending returns the ranges largest first, as an ACK frame lists them.
+func (s *rangeSet) descending() []pnRange {
+	out := make([]pnRange, len(s.ranges))
+	for i, r := range s.ranges {
+		out[len(out)-1-i] = r
+	}
+	return out
+}
@@ -0,0 +1,567 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	errStreamClosed    = errors.New("quic: write to closed stream")
+	errStreamReset     = errors.New("quic: stream reset")
+	errStreamReadClose = errors.New("quic: read from stream closed for reading")
+)
+
+// Stream is one stream of a connection. A bidirectional stream can be read
+// and written, a unidirectional one only by the side that did not open it,
+// or only by the side that did.
+type Stream struct {
+	conn *Conn
+	id   uint64
+
+	// everything below is guarded by conn.mu
+
+	// receiving
+	canRecv           bool
+	in                recvBuffer
+	inLimit           uint64
+	inHighest         uint64
+	inFin             bool
+	inFinal           uint64
+	inErr             error
+	sendMaxStreamData bool
+
+	// sending
+	canSend   bool
+	out       []byte
+	outOff    uint64
+	outLimit  uint64
+	outFin    bool
+	finSent   bool
+	lost      []streamFrame
+	unacked   int
+	outErr    error
+	resetSent bool
+	queued    bool
+
+	// accepted is set once a peer's stream has been handed out
+	accepted bool
+}
+
+// ID returns the stream ID.
+func (s *Stream) ID() uint64 {
+	return s.id
+}
+
+// Read reads data the peer sent, returning io.EOF once the peer ended
+// the stream and everything has been read.
+func (s *Stream) Read(p []byte) (int, error) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !s.canRecv {
+		return 0, fmt.Errorf("quic: read from send-only stream %d", s.id)
+	}
+	for {
+		if s.inErr != nil {
+			return 0, s.inErr
+		}
+		if len(s.in.data) > 0 {
+			n := s.in.read(p)
+			c.consumed(s, n)
+			return n, nil
+		}
+		if s.inFin && s.in.off == s.inFinal {
+			c.maybeRemoveStream(s)
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+}
+
+// Write queues p to be sent. It blocks while the stream holds more unsent
+// data than it may buffer.
+func (s *Stream) Write(p []byte) (int, error) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !s.canSend {
+		return 0, fmt.Errorf("quic: write to receive-only stream %d", s.id)
+	}
+	n := 0
+	for len(p) > 0 {
+		switch {
+		case s.outErr != nil:
+			return n, s.outErr
+		case c.err != nil:
+			return n, c.err
+		case s.outFin:
+			return n, errStreamClosed
+		}
+		room := maxStreamBuffer - len(s.out)
+		if room <= 0 {
+			c.cond.Wait()
+			continue
+		}
+		k := min(room, len(p))
+		s.out = append(s.out, p[:k]...)
+		p = p[k:]
+		n += k
+		c.queueStream(s)
+		c.wake()
+	}
+	return n, nil
+}
+
+// Close ends the sending side of the stream once the data written so far
+// has been sent. It does not wait for that to happen.
+func (s *Stream) Close() error {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !s.canSend || s.outFin || s.resetSent {
+		return nil
+	}
+	s.outFin = true
+	c.queueStream(s)
+	c.wake()
+	return nil
+}
+
+// Reset abandons the sending side of the stream, telling the peer code.
+// Data not yet delivered may never be.
+func (s *Stream) Reset(code uint64) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetStream(s, code, errStreamReset)
+}
+
+// CloseRead tells the peer to stop sending on the stream with code, and
+// discards anything it has sent that was not read.
+func (s *Stream) CloseRead(code uint64) {
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !s.canRecv || s.inErr != nil {
+		return
+	}
+	if !s.inFin {
+		c.control = append(c.control, stopSendingFrame{streamID: s.id, code: code})
+		c.wake()
+	}
+	s.inErr = errStreamReadClose
+	c.consumed(s, int(s.inHighest-s.in.off))
+	s.in = recvBuffer{off: s.inHighest}
+	c.cond.Broadcast()
+	c.maybeRemoveStream(s)
+}
+
+// resetStream sends RESET_STREAM unless the stream is already done sending.
+// err is what later writes return.
+func (c *Conn) resetStream(s *Stream, code uint64, err error) {
+	if !s.canSend || s.resetSent || (s.finSent && s.unacked == 0 && len(s.lost) == 0) {
+		return
+	}
+	s.resetSent = true
+	s.outErr = err
+	s.out = nil
+	s.lost = nil
+	c.control = append(c.control, resetStreamFrame{streamID: s.id, code: code, finalSize: s.outOff})
+	c.cond.Broadcast()
+	c.maybeRemoveStream(s)
+	c.wake()
+}
+
+// nextFrame takes the next STREAM frame to send that fits in room bytes.
+// It returns false if the stream has nothing it may send, and a nil frame
+// if it does but not in so little room.
+func (s *Stream) nextFrame(room int) (*streamFrame, bool) {
+	c := s.conn
+	if s.resetSent {
+		return nil, false
+	}
+	overhead := 1 + varintLen(s.id) + varintLen(s.outOff) + 2
+	if len(s.lost) > 0 {
+		f := s.lost[0]
+		overhead = 1 + varintLen(s.id) + varintLen(f.offset) + 2
+		n := min(len(f.data), room-overhead)
+		if n < 0 || (n == 0 && len(f.data) > 0) {
+			return nil, true
+		}
+		part := streamFrame{streamID: s.id, offset: f.offset, data: f.data[:n], fin: f.fin && n == len(f.data)}
+		if n < len(f.data) {
+			s.lost[0] = streamFrame{streamID: s.id, offset: f.offset + uint64(n), data: f.data[n:], fin: f.fin}
+		} else {
+			s.lost = s.lost[1:]
+		}
+		s.unacked++
+		return &part, true
+	}
+
+	allowed := min(s.outLimit-s.outOff, c.outLimit-c.outTotal)
+	n := int(min(uint64(len(s.out)), allowed))
+	fin := s.outFin && !s.finSent && n == len(s.out)
+	if n == 0 && !fin {
+		return nil, false
+	}
+	if room-overhead < min(n, 1) {
+		return nil, true
+	}
+	n = min(n, room-overhead)
+	fin = fin && n == len(s.out)
+	f := &streamFrame{streamID: s.id, offset: s.outOff, data: s.out[:n], fin: fin}
+	s.out = s.out[n:]
+	if len(s.out) == 0 {
+		s.out = nil
+	}
+	s.outOff += uint64(n)
+	c.outTotal += uint64(n)
+	s.finSent = s.finSent || fin
+	s.unacked++
+	c.cond.Broadcast()
+	return f, true
+}
+
+// consumed returns n bytes read from s to the flow control windows,
+// raising the limits the peer is given once half a window has been used.
+func (c *Conn) consumed(s *Stream, n int) {
+	c.inConsumed += uint64(n)
+	if !s.inFin && s.inErr == nil && s.inLimit-s.in.off < streamWindow/2 {
+		s.inLimit = s.in.off + streamWindow
+		s.sendMaxStreamData = true
+		c.wake()
+	}
+	if c.inLimit-c.inConsumed < connWindow/2 {
+		c.inLimit = c.inConsumed + connWindow
+		c.sendMaxData = true
+		c.wake()
+	}
+}
+
+// OpenStream opens a bidirectional stream, waiting for the peer to allow
+// another one if need be. The peer learns of the stream once something is
+// sent on it.
+func (c *Conn) OpenStream(ctx context.Context) (*Stream, error) {
+	return c.openStream(ctx, bidi)
+}
+
+// OpenUniStream opens a unidirectional stream for sending.
+func (c *Conn) OpenUniStream(ctx context.Context) (*Stream, error) {
+	return c.openStream(ctx, uni)
+}
+
+func (c *Conn) openStream(ctx context.Context, kind int) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stop := context.AfterFunc(ctx, c.broadcast)
+	defer stop()
+	for c.nextStream[kind] >= c.peerMaxStreams[kind] {
+		if c.err != nil {
+			return nil, c.err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	id := c.nextStream[kind]<<2 | uint64(kind)<<1
+	if c.isServer {
+		id |= 1
+	}
+	c.nextStream[kind]++
+	s := c.newStream(id)
+	c.streams[id] = s
+	return s, nil
+}
+
+// AcceptStream waits for the peer to open a bidirectional stream.
+func (c *Conn) AcceptStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, bidi)
+}
+
+// AcceptUniStream waits for the peer to open a unidirectional stream.
+func (c *Conn) AcceptUniStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, uni)
+}
+
+func (c *Conn) acceptStream(ctx context.Context, kind int) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stop := context.AfterFunc(ctx, c.broadcast)
+	defer stop()
+	for len(c.acceptQueue[kind]) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.cond.Wait()
+	}
+	s := c.acceptQueue[kind][0]
+	c.acceptQueue[kind] = c.acceptQueue[kind][1:]
+	s.accepted = true
+	c.maybeRemoveStream(s)
+	return s, nil
+}
+
+// isLocal reports whether a stream ID belongs to a stream we opened.
+func (c *Conn) isLocal(id uint64) bool {
+	return (id&1 == 1) == c.isServer
+}
+
+func (c *Conn) broadcast() {
+	c.mu.Lock()
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+func (c *Conn) newStream(id uint64) *Stream {
+	local := c.isLocal(id)
+	isUni := id&2 != 0
+	s := &Stream{
+		conn:    c,
+		id:      id,
+		canRecv: !isUni || !local,
+		canSend: !isUni || local,
+		inLimit: streamWindow,
+	}
+	switch {
+	case isUni:
+		s.outLimit = c.peerParams.initialMaxStreamDataUni
+	case local:
+		s.outLimit = c.peerParams.initialMaxStreamDataBidiRemote
+	default:
+		s.outLimit = c.peerParams.initialMaxStreamDataBidiLocal
+	}
+	return s
+}
+
+// stream returns the stream a frame refers to. A frame for a new stream of
+// the peer opens it, along with any lower-numbered ones of the same kind
+// not yet seen. It returns nil for a stream that is already gone.
+func (c *Conn) stream(id uint64) (*Stream, error) {
+	if s := c.streams[id]; s != nil {
+		return s, nil
+	}
+	kind := bidi
+	if id&2 != 0 {
+		kind = uni
+	}
+	index := id >> 2
+	if c.isLocal(id) {
+		if index >= c.nextStream[kind] {
+			return nil, transportError(ErrCodeStreamState, "stream %d not yet opened", id)
+		}
+		return nil, nil
+	}
+	if index < c.nextPeerStream[kind] {
+		return nil, nil
+	}
+	if index >= c.maxPeerStreams[kind] {
+		return nil, transportError(ErrCodeStreamLimit, "stream %d beyond the limit of %d", id, c.maxPeerStreams[kind])
+	}
+	for c.nextPeerStream[kind] <= index {
+		s := c.newStream(c.nextPeerStream[kind]<<2 | id&3)
+		c.streams[s.id] = s
+		c.nextPeerStream[kind]++
+		c.acceptQueue[kind] = append(c.acceptQueue[kind], s)
+	}
+	c.cond.Broadcast()
+	return c.streams[id], nil
+}
+
+// maybeRemoveStream forgets a stream once both of its directions are done.
+// A stream the peer opened makes room for another one.
+func (c *Conn) maybeRemoveStream(s *Stream) {
+	recvDone := !s.canRecv || s.inErr != nil || (s.inFin && s.in.off == s.inFinal)
+	sendDone := !s.canSend || s.resetSent || (s.finSent && s.unacked == 0 && len(s.lost) == 0)
+	local := c.isLocal(s.id)
+	if !recvDone || !sendDone || (!local && !s.accepted) || c.streams[s.id] != s {
+		return
+	}
+	delete(c.streams, s.id)
+	if !local {
+		kind := bidi
+		if s.id&2 != 0 {
+			kind = uni
+		}
+		c.maxPeerStreams[kind]++
+		c.sendMaxStreams[kind] = true
+		c.wake()
+	}
+}
+
+func (c *Conn) handleStream(f streamFrame) error {
+	s, err := c.stream(f.streamID)
+	if err != nil || s == nil {
+		return err
+	}
+	if !s.canRecv {
+		return transportError(ErrCodeStreamState, "STREAM frame for send-only stream %d", s.id)
+	}
+	end := f.offset + uint64(len(f.data))
+	if err := s.checkFinalSize(end, f.fin); err != nil {
+		return err
+	}
+	if end > s.inLimit {
+		return transportError(ErrCodeFlowControl, "stream %d data beyond its limit", s.id)
+	}
+	if err := c.received(s, end); err != nil {
+		return err
+	}
+	if f.fin {
+		s.inFin = true
+		s.inFinal = end
+	}
+	if s.inErr == nil {
+		s.in.insert(f.offset, f.data)
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+// received accounts for stream data up to end against the connection's
+// flow control limit. Data for a stream no longer read counts as consumed
+// right away.
+func (c *Conn) received(s *Stream, end uint64) error {
+	if end <= s.inHighest {
+		return nil
+	}
+	grown := end - s.inHighest
+	s.inHighest = end
+	c.inTotal += grown
+	if c.inTotal > c.inLimit {
+		return transportError(ErrCodeFlowControl, "connection data beyond its limit")
+	}
+	if s.inErr != nil {
+		c.consumed(s, int(grown))
+	}
+	return nil
+}
+
+func (s *Stream) checkFinalSize(end uint64, fin bool) error {
+	if s.inFin && (end > s.inFinal || fin && end != s.inFinal) {
+		return transportError(ErrCodeFinalSize, "stream %d final size changed", s.id)
+	}
+	if fin && end < s.inHighest {
+		return transportError(ErrCodeFinalSize, "stream %d final size below data received", s.id)
+	}
+	return nil
+}
+
+func (c *Conn) handleResetStream(f resetStreamFrame) error {
+	s, err := c.stream(f.streamID)
+	if err != nil || s == nil {
+		return err
+	}
+	if !s.canRecv {
+		return transportError(ErrCodeStreamState, "RESET_STREAM for send-only stream %d", s.id)
+	}
+	if err := s.checkFinalSize(f.finalSize, true); err != nil {
+		return err
+	}
+	if err := c.received(s, f.finalSize); err != nil {
+		return err
+	}
+	s.inFin = true
+	s.inFinal = f.finalSize
+	if s.inErr == nil {
+		s.inErr = StreamError{StreamID: s.id, Code: f.code}
+		c.consumed(s, int(f.finalSize-s.in.off))
+		s.in = recvBuffer{off: f.finalSize}
+	}
+	c.cond.Broadcast()
+	c.maybeRemoveStream(s)
+	return nil
+}
+
+func (c *Conn) handleStopSending(f stopSendingFrame) error {
+	s, err := c.stream(f.streamID)
+	if err != nil || s == nil {
+		return err
+	}
+	if !s.canSend {
+		return transportError(ErrCodeStreamState, "STOP_SENDING for receive-only stream %d", s.id)
+	}
+	c.resetStream(s, f.code, StreamError{StreamID: s.id, Code: f.code})
+	return nil
+}
+
+func (c *Conn) handleMaxStreamData(f maxStreamDataFrame) error {
+	s, err := c.stream(f.streamID)
+	if err != nil || s == nil {
+		return err
+	}
+	if !s.canSend {
+		return transportError(ErrCodeStreamState, "MAX_STREAM_DATA for receive-only stream %d", s.id)
+	}
+	if f.max > s.outLimit {
+		s.outLimit = f.max
+		c.queueStream(s)
+	}
+	return nil
+}
+
+// recvBuffer reassembles data that may arrive out of order, and more than
+// once.
+type recvBuffer struct {
+	// off is the offset of data, the contiguous bytes not yet read
+	off  uint64
+	data []byte
+	// pending holds data beyond a gap
+	pending []chunk
+}
+
+type chunk struct {
+	off  uint64
+	data []byte
+}
+
+func (b *recvBuffer) insert(off uint64, p []byte) {
+	end := b.off + uint64(len(b.data))
+	if off+uint64(len(p)) <= end {
+		return
+	}
+	if off > end {
+		b.pending = append(b.pending, chunk{off: off, data: bytes.Clone(p)})
+		return
+	}
+	b.data = append(b.data, p[end-off:]...)
+	for progress := true; progress; {
+		progress = false
+		end = b.off + uint64(len(b.data))
+		kept := b.pending[:0]
+		for _, ch := range b.pending {
+			switch {
+			case ch.off+uint64(len(ch.data)) <= end:
+			case ch.off <= end:
+				b.data = append(b.data, ch.data[end-ch.off:]...)
+				end = b.off + uint64(len(b.data))
+				progress = true
+			default:
+				kept = append(kept, ch)
+			}
+		}
+		b.pending = kept
+	}
+}
+
+func (b *recvBuffer) read(p []byte) int {
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	b.off += uint64(n)
+	if len(b.data) == 0 {
+		b.data = nil
+	}
+	return n
+}
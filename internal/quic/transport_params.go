@@ -0,0 +1,191 @@
+package quic
+
+import (
+	"bytes"
+	"time"
+)
+
+// Transport parameter IDs, RFC 9000 section 18.2.
+const (
+	paramOriginalDCID            = 0x00
+	paramMaxIdleTimeout          = 0x01
+	paramStatelessResetToken     = 0x02
+	paramMaxUDPPayloadSize       = 0x03
+	paramInitialMaxData          = 0x04
+	paramInitialMaxStreamDataBL  = 0x05
+	paramInitialMaxStreamDataBR  = 0x06
+	paramInitialMaxStreamDataUni = 0x07
+	paramInitialMaxStreamsBidi   = 0x08
+	paramInitialMaxStreamsUni    = 0x09
+	paramAckDelayExponent        = 0x0a
+	paramMaxAckDelay             = 0x0b
+	paramDisableActiveMigration  = 0x0c
+	paramPreferredAddress        = 0x0d
+	paramActiveConnIDLimit       = 0x0e
+	paramInitialSCID             = 0x0f
+	paramRetrySCID               = 0x10
+)
+
+// transportParams are the limits and connection IDs each endpoint declares
+// in its handshake.
+type transportParams struct {
+	originalDCID                   []byte
+	initialSCID                    []byte
+	maxIdleTimeout                 time.Duration
+	maxUDPPayloadSize              uint64
+	initialMaxData                 uint64
+	initialMaxStreamDataBidiLocal  uint64
+	initialMaxStreamDataBidiRemote uint64
+	initialMaxStreamDataUni        uint64
+	initialMaxStreamsBidi          uint64
+	initialMaxStreamsUni           uint64
+	ackDelayExponent               uint64
+	maxAckDelay                    time.Duration
+	disableActiveMigration         bool
+	activeConnIDLimit              uint64
+}
+
+// defaultTransportParams holds the values of parameters a peer leaves out.
+func defaultTransportParams() transportParams {
+	return transportParams{
+		maxUDPPayloadSize: 65527,
+		ackDelayExponent:  3,
+		maxAckDelay:       25 * time.Millisecond,
+		activeConnIDLimit: 2,
+	}
+}
+
+func (p *transportParams) marshal() []byte {
+	var b []byte
+	appendInt := func(id, v uint64) {
+		b = appendVarint(b, id)
+		b = appendVarint(b, uint64(varintLen(v)))
+		b = appendVarint(b, v)
+	}
+	appendBytes := func(id uint64, v []byte) {
+		b = appendVarint(b, id)
+		b = appendVarint(b, uint64(len(v)))
+		b = append(b, v...)
+	}
+	if p.originalDCID != nil {
+		appendBytes(paramOriginalDCID, p.originalDCID)
+	}
+	appendBytes(paramInitialSCID, p.initialSCID)
+	if p.maxIdleTimeout > 0 {
+		appendInt(paramMaxIdleTimeout, uint64(p.maxIdleTimeout/time.Millisecond))
+	}
+	appendInt(paramMaxUDPPayloadSize, p.maxUDPPayloadSize)
+	appendInt(paramInitialMaxData, p.initialMaxData)
+	appendInt(paramInitialMaxStreamDataBL, p.initialMaxStreamDataBidiLocal)
+	appendInt(paramInitialMaxStreamDataBR, p.initialMaxStreamDataBidiRemote)
+	appendInt(paramInitialMaxStreamDataUni, p.initialMaxStreamDataUni)
+	appendInt(paramInitialMaxStreamsBidi, p.initialMaxStreamsBidi)
+	appendInt(paramInitialMaxStreamsUni, p.initialMaxStreamsUni)
+	appendInt(paramMaxAckDelay, uint64(p.maxAckDelay/time.Millisecond))
+	if p.disableActiveMigration {
+		appendBytes(paramDisableActiveMigration, nil)
+	}
+	return b
+}
+
+// parseTransportParams parses the parameters sent by a peer. Parameters
+// only a server may send are rejected unless fromServer is set.
+func parseTransportParams(b []byte, fromServer bool) (transportParams, error) {
+	p := defaultTransportParams()
+	seen := map[uint64]bool{}
+	for len(b) > 0 {
+		id, n := consumeVarint(b)
+		if n < 0 {
+			return p, transportError(ErrCodeTransportParameter, "truncated parameter")
+		}
+		b = b[n:]
+		length, n := consumeVarint(b)
+		if n < 0 || uint64(len(b)-n) < length {
+			return p, transportError(ErrCodeTransportParameter, "truncated parameter")
+		}
+		value := b[n : n+int(length)]
+		b = b[n+int(length):]
+		if seen[id] {
+			return p, transportError(ErrCodeTransportParameter, "duplicate parameter 0x%x", id)
+		}
+		seen[id] = true
+
+		switch id {
+		case paramOriginalDCID, paramStatelessResetToken, paramPreferredAddress, paramRetrySCID:
+			if !fromServer {
+				return p, transportError(ErrCodeTransportParameter, "client sent server parameter 0x%x", id)
+			}
+			if id == paramOriginalDCID {
+				p.originalDCID = bytes.Clone(value)
+			}
+			// a preferred address would only be of use for migration
+			continue
+		case paramInitialSCID:
+			p.initialSCID = bytes.Clone(value)
+			continue
+		case paramDisableActiveMigration:
+			if len(value) != 0 {
+				return p, transportError(ErrCodeTransportParameter, "disable_active_migration with a value")
+			}
+			p.disableActiveMigration = true
+			continue
+		}
+
+		v, n := consumeVarint(value)
+		if n < 0 || n != len(value) {
+			// unknown parameters may hold anything
+			if id <= paramRetrySCID {
+				return p, transportError(ErrCodeTransportParameter, "malformed parameter 0x%x", id)
+			}
+			continue
+		}
+		switch id {
+		case paramMaxIdleTimeout:
+			p.maxIdleTimeout = time.Duration(v) * time.Millisecond
+		case paramMaxUDPPayloadSize:
+			if v < 1200 {
+				return p, transportError(ErrCodeTransportParameter, "max_udp_payload_size below 1200")
+			}
+			p.maxUDPPayloadSize = v
+		case paramInitialMaxData:
+			p.initialMaxData = v
+		case paramInitialMaxStreamDataBL:
+			p.initialMaxStreamDataBidiLocal = v
+		case paramInitialMaxStreamDataBR:
+			p.initialMaxStreamDataBidiRemote = v
+		case paramInitialMaxStreamDataUni:
+			p.initialMaxStreamDataUni = v
+		case paramInitialMaxStreamsBidi, paramInitialMaxStreamsUni:
+			if v > 1<<60 {
+				return p, transportError(ErrCodeTransportParameter, "stream limit above 2^60")
+			}
+			if id == paramInitialMaxStreamsBidi {
+				p.initialMaxStreamsBidi = v
+			} else {
+				p.initialMaxStreamsUni = v
+			}
+		case paramAckDelayExponent:
+			if v > 20 {
+				return p, transportError(ErrCodeTransportParameter, "ack_delay_exponent above 20")
+			}
+			p.ackDelayExponent = v
+		case paramMaxAckDelay:
+			if v >= 1<<14 {
+				return p, transportError(ErrCodeTransportParameter, "max_ack_delay of 2^14 or more")
+			}
+			p.maxAckDelay = time.Duration(v) * time.Millisecond
+		case paramActiveConnIDLimit:
+			if v < 2 {
+				return p, transportError(ErrCodeTransportParameter, "active_connection_id_limit below 2")
+			}
+			p.activeConnIDLimit = v
+		}
+	}
+	if !seen[paramInitialSCID] {
+		return p, transportError(ErrCodeTransportParameter, "missing initial_source_connection_id")
+	}
+	if fromServer && !seen[paramOriginalDCID] {
+		return p, transportError(ErrCodeTransportParameter, "missing original_destination_connection_id")
+	}
+	return p, nil
+}
@@ -0,0 +1,20 @@
+package request
+
+import "github.com/KrishKoria/HTTPfromTCP/internal/headers"
+
+// Cookies returns the cookies sent in the Cookie header, leaving out
+// malformed ones.
+func (r *Request) Cookies() []headers.Cookie {
+	value, _ := r.Headers.Get("Cookie")
+	return headers.ParseCookie(value)
+}
+
+// Cookie returns the value of the first cookie with the given name.
+func (r *Request) Cookie(name string) (string, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxFormSize bounds a urlencoded body, which is parsed all at once.
+	maxFormSize = 10 << 20
+	// defaultMaxMemory is what FormValue and FormFile let a multipart form
+	// keep in memory.
+	defaultMaxMemory = 32 << 20
+)
+
+var (
+	ErrNotMultipart = errors.New("request: Content-Type is not multipart/form-data")
+	ErrFormTooLarge = errors.New("request: form too large")
+	ErrMissingFile  = errors.New("request: no such file")
+)
+
+// ParseForm fills PostForm with the fields of an
+// application/x-www-form-urlencoded body and Form with those and the query
+// parameters of the request target, body fields first. Bodies of other
+// types leave PostForm empty. It does nothing when called again.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	r.Form = url.Values{}
+	r.PostForm = url.Values{}
+	if mediaType, _, _ := mime.ParseMediaType(r.contentType()); mediaType == "application/x-www-form-urlencoded" {
+		if len(r.Body) > maxFormSize {
+			return fmt.Errorf("urlencoded body over %d bytes: %w", maxFormSize, ErrFormTooLarge)
+		}
+		values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			return fmt.Errorf("malformed urlencoded body: %w", err)
+		}
+		r.PostForm = values
+		maps.Copy(r.Form, values)
+	}
+	if _, query, ok := strings.Cut(r.RequestLine.RequestTarget, "?"); ok {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return fmt.Errorf("malformed query: %w", err)
+		}
+		for name, v := range values {
+			r.Form[name] = append(r.Form[name], v...)
+		}
+	}
+	return nil
+}
+
+// MultipartReader returns a reader over the parts of a
+// multipart/form-data body, for handlers that process parts as they go
+// instead of calling ParseMultipartForm. The parts are read from Body,
+// which is already in memory in full, bounded by the MaxBodySize of the
+// Reader that parsed the request.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.contentType())
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return nil, fmt.Errorf("request: invalid multipart boundary %q", boundary)
+	}
+	return NewMultipartReader(bytes.NewReader(r.Body), boundary), nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into MultipartForm
+// and adds its values to Form and PostForm as ParseForm does. maxMemory
+// bounds the values and file contents copied out of Body, on top of Body
+// itself; files that do not fit go to temporary files, left for the
+// handler to remove with MultipartForm.RemoveAll. It does nothing when
+// called again.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	form, err := mr.ReadForm(maxMemory)
+	if err != nil {
+		return err
+	}
+	for name, v := range form.Value {
+		r.Form[name] = slices.Concat(v, r.Form[name])
+		r.PostForm[name] = append(r.PostForm[name], v...)
+	}
+	r.MultipartForm = form
+	return nil
+}
+
+// FormValue returns the first value of the named field in the body or the
+// query, parsing them first if needed. Errors are ignored; a missing
+// field is "".
+func (r *Request) FormValue(name string) string {
+	if r.MultipartForm == nil {
+		r.ParseMultipartForm(defaultMaxMemory)
+	}
+	return r.Form.Get(name)
+}
+
+// FormFile returns the first file uploaded as the named field of a
+// multipart/form-data body, parsing the body first if needed.
+func (r *Request) FormFile(name string) (*FileHeader, error) {
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(defaultMaxMemory); err != nil {
+			return nil, err
+		}
+	}
+	if fhs := r.MultipartForm.File[name]; len(fhs) > 0 {
+		return fhs[0], nil
+	}
+	return nil, ErrMissingFile
+}
+
+func (r *Request) contentType() string {
+	contentType, _ := r.Headers.Get("Content-Type")
+	return contentType
+}
@@ -0,0 +1,132 @@
+package request
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	// Test: Body fields come before query parameters in Form
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /submit?name=query&page=2 HTTP/1.1\r\n" +
+			"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+			"Content-Length: 34\r\n\r\n" +
+			"name=Krish+Koria&lang=go&lang=c%2B",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"Krish Koria", "query"}, r.Form["name"])
+	assert.Equal(t, []string{"go", "c+"}, r.Form["lang"])
+	assert.Equal(t, "2", r.Form.Get("page"))
+	assert.Equal(t, "Krish Koria", r.PostForm.Get("name"))
+	assert.Empty(t, r.PostForm["page"])
+	assert.Equal(t, "Krish Koria", r.FormValue("name"))
+
+	// Test: Other bodies only give the query
+	r = formRequest("GET /search?q=tcp HTTP/1.1", "text/plain", "q=udp")
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"tcp"}, r.Form["q"])
+	assert.Empty(t, r.PostForm)
+
+	// Test: Malformed encodings are errors
+	r = formRequest("POST / HTTP/1.1", "application/x-www-form-urlencoded", "a=%zz")
+	assert.Error(t, r.ParseForm())
+	r = formRequest("GET /?a=%zz HTTP/1.1", "", "")
+	assert.Error(t, r.ParseForm())
+
+	// Test: Urlencoded bodies are bounded
+	r = formRequest("POST / HTTP/1.1", "application/x-www-form-urlencoded", strings.Repeat("a", maxFormSize+1))
+	assert.ErrorIs(t, r.ParseForm(), ErrFormTooLarge)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	body := "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"Report\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"../../etc/a.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"small file\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"b.bin\"\r\n\r\n" +
+		strings.Repeat("0123456789", 10) + "\r\n" +
+		"--xyz--\r\n"
+
+	// Test: Values and files are read, large files spilled to disk
+	r := formRequest("POST /upload?title=q HTTP/1.1", "multipart/form-data; boundary=xyz", body)
+	require.NoError(t, r.ParseMultipartForm(32))
+	defer r.MultipartForm.RemoveAll()
+	assert.Equal(t, []string{"Report", "q"}, r.Form["title"])
+	assert.Equal(t, []string{"Report"}, r.PostForm["title"])
+	files := r.MultipartForm.File["upload"]
+	require.Len(t, files, 2)
+
+	assert.Equal(t, "a.txt", files[0].Filename)
+	assert.Empty(t, files[0].tmpfile)
+	ct, _ := files[0].Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", ct)
+	assert.Equal(t, "small file", readFile(t, files[0]))
+
+	assert.Equal(t, "b.bin", files[1].Filename)
+	assert.NotEmpty(t, files[1].tmpfile)
+	assert.Equal(t, int64(100), files[1].Size)
+	assert.Equal(t, strings.Repeat("0123456789", 10), readFile(t, files[1]))
+	tmpfile := files[1].tmpfile
+	require.NoError(t, r.MultipartForm.RemoveAll())
+	assert.NoFileExists(t, tmpfile)
+
+	// Test: FormFile parses the body on demand
+	r = formRequest("POST / HTTP/1.1", "multipart/form-data; boundary=xyz", body)
+	fh, err := r.FormFile("upload")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", fh.Filename)
+	_, err = r.FormFile("missing")
+	assert.ErrorIs(t, err, ErrMissingFile)
+
+	// Test: Values that do not fit in memory are an error
+	r = formRequest("POST / HTTP/1.1", "multipart/form-data; boundary=xyz", body)
+	assert.ErrorIs(t, r.ParseMultipartForm(3), ErrFormTooLarge)
+
+	// Test: Files over the size limit are an error
+	r = formRequest("POST / HTTP/1.1", "multipart/form-data; boundary=xyz", body)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	mr.MaxFileSize = 50
+	_, err = mr.ReadForm(16)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+
+	// Test: Other bodies are not multipart
+	r = formRequest("POST / HTTP/1.1", "application/x-www-form-urlencoded", "a=b")
+	assert.ErrorIs(t, r.ParseMultipartForm(1024), ErrNotMultipart)
+	r = formRequest("POST / HTTP/1.1", "multipart/form-data", body)
+	_, err = r.MultipartReader()
+	assert.Error(t, err)
+}
+
+func formRequest(requestLine, contentType, body string) *Request {
+	parts := strings.Fields(requestLine)
+	r := &Request{
+		RequestLine: RequestLine{Method: parts[0], RequestTarget: parts[1], HttpVersion: "1.1"},
+		Headers:     map[string]string{},
+		Body:        []byte(body),
+	}
+	if contentType != "" {
+		r.Headers.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func readFile(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
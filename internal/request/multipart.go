@@ -0,0 +1,367 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+const (
+	defaultMaxParts          = 1000
+	defaultMaxPartHeaderSize = 10 << 10
+)
+
+// MultipartReader reads the parts of a multipart body, RFC 2046 section
+// 5.1, one at a time. Part bodies are streamed, so a part of any size
+// goes through a buffer of a few kilobytes.
+type MultipartReader struct {
+	// MaxParts is how many parts the body may have, 1000 by default.
+	MaxParts int
+	// MaxHeaderSize bounds the header block of each part, 10 KiB by
+	// default.
+	MaxHeaderSize int
+	// MaxFileSize bounds each file ReadForm reads, 0 meaning no limit.
+	MaxFileSize int64
+
+	r            *bufio.Reader
+	dashBoundary []byte // "--boundary" opening the first part
+	delim        []byte // "\r\n--boundary" ending every part
+	part         *Part
+	parts        int
+	done         bool
+}
+
+// NewMultipartReader returns a MultipartReader for the body in r, whose
+// parts are separated by boundary.
+func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		MaxParts:      defaultMaxParts,
+		MaxHeaderSize: defaultMaxPartHeaderSize,
+		r:             bufio.NewReaderSize(r, 4096+len(boundary)),
+		dashBoundary:  []byte("--" + boundary),
+		delim:         []byte("\r\n--" + boundary),
+	}
+}
+
+// NextPart skips what is left of the current part and returns the next
+// one. It returns io.EOF after the last part.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.part == nil {
+		if err := mr.skipPreamble(); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.Copy(io.Discard, mr.part); err != nil {
+			return nil, err
+		}
+		if err := mr.readDelimiter(); err != nil {
+			return nil, err
+		}
+	}
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.parts >= mr.MaxParts {
+		return nil, fmt.Errorf("more than %d parts: %w", mr.MaxParts, ErrFormTooLarge)
+	}
+	mr.parts++
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	mr.part = &Part{Headers: h, mr: mr}
+	return mr.part, nil
+}
+
+// skipPreamble reads up to and including the line of the first boundary.
+// Whatever comes before it is ignored.
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// a long preamble line, the boundary is at the start of one
+			for err == bufio.ErrBufferFull {
+				_, err = mr.r.ReadSlice('\n')
+			}
+			continue
+		}
+		if err == io.EOF {
+			return fmt.Errorf("multipart boundary not found: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return err
+		}
+		rest, ok := bytes.CutPrefix(line, mr.dashBoundary)
+		if !ok {
+			continue
+		}
+		if bytes.HasPrefix(rest, []byte("--")) {
+			mr.done = true
+			return nil
+		}
+		if isLineEnd(rest) {
+			return nil
+		}
+	}
+}
+
+// readDelimiter reads the boundary line that ended the current part,
+// noting whether it was the last.
+func (mr *MultipartReader) readDelimiter() error {
+	if _, err := mr.r.Discard(len(mr.delim)); err != nil {
+		return err
+	}
+	if b, _ := mr.r.Peek(2); bytes.Equal(b, []byte("--")) {
+		// the epilogue after the close delimiter is ignored
+		mr.done = true
+		return nil
+	}
+	line, err := mr.r.ReadSlice('\n')
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil || !isLineEnd(line) {
+		return fmt.Errorf("malformed multipart boundary line")
+	}
+	return nil
+}
+
+// isLineEnd reports whether rest, following a boundary, is only transport
+// padding and the CRLF.
+func isLineEnd(rest []byte) bool {
+	rest, ok := bytes.CutSuffix(rest, []byte(crlf))
+	return ok && len(bytes.Trim(rest, " \t")) == 0
+}
+
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	h := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := mr.r.ReadSlice('\n')
+		size += len(line)
+		if err == bufio.ErrBufferFull || size > mr.MaxHeaderSize {
+			return nil, fmt.Errorf("part header over %d bytes: %w", mr.MaxHeaderSize, ErrFormTooLarge)
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("incomplete part header: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(line, []byte(crlf)) {
+			return nil, fmt.Errorf("part header line without CRLF: %q", line)
+		}
+		_, done, err := h.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// Part is one part of a multipart body. Reading it yields the part body,
+// up to the next boundary.
+type Part struct {
+	Headers headers.Headers
+
+	mr  *MultipartReader
+	eof bool
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	mr := p.mr
+	if _, err := mr.r.Peek(len(mr.delim)); err != nil {
+		// not even a boundary left
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	buf, _ := mr.r.Peek(mr.r.Buffered())
+	// the end of buf may be the start of a boundary still to arrive
+	safe := len(buf) - len(mr.delim) + 1
+	if i := bytes.Index(buf, mr.delim); i >= 0 {
+		safe = i
+	}
+	if safe == 0 {
+		p.eof = true
+		return 0, io.EOF
+	}
+	n := copy(b, buf[:safe])
+	mr.r.Discard(n)
+	return n, nil
+}
+
+// FormName returns the name parameter of a form-data Content-Disposition,
+// or "".
+func (p *Part) FormName() string {
+	return p.dispositionParams()["name"]
+}
+
+// FileName returns the filename parameter of the Content-Disposition
+// without any directories, or "" if the part is not a file.
+func (p *Part) FileName() string {
+	if filename := p.dispositionParams()["filename"]; filename != "" {
+		return filepath.Base(filename)
+	}
+	return ""
+}
+
+func (p *Part) dispositionParams() map[string]string {
+	value, _ := p.Headers.Get("Content-Disposition")
+	disposition, params, err := mime.ParseMediaType(value)
+	if err != nil || disposition != "form-data" {
+		return nil
+	}
+	return params
+}
+
+// MultipartForm is a whole multipart/form-data body. Files over the memory
+// limit given to ReadForm are kept in temporary files until RemoveAll.
+type MultipartForm struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part of a multipart form.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is an uploaded file, in memory or on disk.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memoryFile struct {
+	*io.SectionReader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// Open opens the file contents for reading.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return memoryFile{io.NewSectionReader(bytes.NewReader(fh.content), 0, int64(len(fh.content)))}, nil
+}
+
+// RemoveAll removes the temporary files of the form.
+func (f *MultipartForm) RemoveAll() error {
+	var err error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if e := os.Remove(fh.tmpfile); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// ReadForm reads the whole body as multipart/form-data. Field values and
+// files together get maxMemory bytes of memory; a file that does not fit
+// is written to a temporary file instead, while values that do not fit
+// are an error. Parts without a form name are skipped. maxMemory only
+// bounds what the form keeps, not whatever the body is read from.
+func (mr *MultipartReader) ReadForm(maxMemory int64) (_ *MultipartForm, err error) {
+	form := &MultipartForm{Value: map[string][]string{}, File: map[string][]*FileHeader{}}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+	memory := maxMemory
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+
+		var b bytes.Buffer
+		filename := p.FileName()
+		if filename == "" {
+			n, err := io.CopyN(&b, p, memory+1)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if memory -= n; memory < 0 {
+				return nil, fmt.Errorf("form values over %d bytes: %w", maxMemory, ErrFormTooLarge)
+			}
+			form.Value[name] = append(form.Value[name], b.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: filename, Headers: p.Headers}
+		form.File[name] = append(form.File[name], fh)
+		var r io.Reader = p
+		if mr.MaxFileSize > 0 {
+			r = io.LimitReader(p, mr.MaxFileSize+1)
+		}
+		n, err := io.CopyN(&b, r, memory+1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if n <= memory {
+			fh.content = b.Bytes()
+			fh.Size = n
+			memory -= n
+		} else if fh.Size, err = spill(fh, io.MultiReader(&b, r)); err != nil {
+			return nil, err
+		}
+		if mr.MaxFileSize > 0 && fh.Size > mr.MaxFileSize {
+			return nil, fmt.Errorf("file %s over %d bytes: %w", filename, mr.MaxFileSize, ErrFormTooLarge)
+		}
+	}
+}
+
+// spill writes the contents of a file too large for memory to a temporary
+// file.
+func spill(fh *FileHeader, r io.Reader) (int64, error) {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	fh.tmpfile = f.Name()
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
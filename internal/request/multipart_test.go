@@ -0,0 +1,111 @@
+package request
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readParts lists every part as its form name and body.
+func readParts(t *testing.T, mr *MultipartReader) ([]string, error) {
+	t.Helper()
+	var bodies []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return bodies, nil
+		}
+		if err != nil {
+			return bodies, err
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return bodies, err
+		}
+		bodies = append(bodies, p.FormName()+"="+string(b))
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := "preamble line\r\n" +
+		"--b0und\r\n" +
+		"Content-Disposition: form-data; name=a\r\n\r\n" +
+		"line one\r\n--b0un almost a boundary\r\n" +
+		"--b0und \t\r\n" +
+		"Content-Disposition: form-data; name=empty\r\n\r\n" +
+		"\r\n" +
+		"--b0und\r\n" +
+		"Content-Disposition: form-data; name=\"c\"\r\n" +
+		"X-Extra: 1\r\n\r\n" +
+		"last\r\n" +
+		"--b0und--\r\n" +
+		"epilogue\r\n"
+
+	// Test: Parts are found whatever the size of the reads
+	for _, r := range []io.Reader{strings.NewReader(body), iotest.OneByteReader(strings.NewReader(body)), iotest.HalfReader(strings.NewReader(body))} {
+		bodies, err := readParts(t, NewMultipartReader(r, "b0und"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a=line one\r\n--b0un almost a boundary", "empty=", "c=last"}, bodies)
+	}
+
+	// Test: Unread part bodies are skipped
+	mr := NewMultipartReader(strings.NewReader(body), "b0und")
+	p, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "a", p.FormName())
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "empty", p.FormName())
+	p, err = mr.NextPart()
+	require.NoError(t, err)
+	extra, _ := p.Headers.Get("X-Extra")
+	assert.Equal(t, "1", extra)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Large part bodies stream through
+	large := strings.Repeat("x", 100000)
+	bodies, err := readParts(t, NewMultipartReader(iotest.HalfReader(strings.NewReader(
+		"--b\r\nContent-Disposition: form-data; name=big\r\n\r\n"+large+"\r\n--b--")), "b"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"big=" + large}, bodies)
+}
+
+func TestMultipartReaderErrors(t *testing.T) {
+	part := "--b\r\nContent-Disposition: form-data; name=x\r\n\r\nvalue\r\n"
+
+	// Test: A body cut short is an error
+	_, err := readParts(t, NewMultipartReader(strings.NewReader(part), "b"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = readParts(t, NewMultipartReader(strings.NewReader("no boundary here\r\n"), "b"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = readParts(t, NewMultipartReader(strings.NewReader("--b\r\nContent-Disposition: form-data"), "b"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Garbage after a boundary is an error
+	_, err = readParts(t, NewMultipartReader(strings.NewReader(part+"--bX\r\n\r\n"), "b"))
+	assert.Error(t, err)
+
+	// Test: Malformed part headers are errors
+	_, err = readParts(t, NewMultipartReader(strings.NewReader("--b\r\nno colon\r\n\r\n\r\n--b--"), "b"))
+	assert.Error(t, err)
+	_, err = readParts(t, NewMultipartReader(strings.NewReader("--b\r\nA: b\n\r\n\r\n--b--"), "b"))
+	assert.Error(t, err)
+
+	// Test: The number of parts and the part header size are bounded
+	mr := NewMultipartReader(strings.NewReader(strings.Repeat(part, 3)+"--b--"), "b")
+	mr.MaxParts = 2
+	bodies, err := readParts(t, mr)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	assert.Len(t, bodies, 2)
+	mr = NewMultipartReader(strings.NewReader("--b\r\nX-Long: "+strings.Repeat("a", 100)+"\r\n\r\n\r\n--b--"), "b")
+	mr.MaxHeaderSize = 64
+	_, err = readParts(t, mr)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+}
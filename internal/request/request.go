@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/KrishKoria/HTTPfromTCP/internal/chunked"
 	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
 )
 
@@ -15,9 +18,21 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// Trailers holds the trailer section of a chunked body.
+	Trailers headers.Headers
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// Form holds the query parameters and body fields once ParseForm or
+	// ParseMultipartForm has been called, and PostForm the body fields only.
+	Form     url.Values
+	PostForm url.Values
+	// MultipartForm is the multipart/form-data body, files included, once
+	// ParseMultipartForm has been called.
+	MultipartForm *MultipartForm
 
-	state          requestState
-	bodyLengthRead int
+	state         requestState
+	bodyRemaining int
+	maxBodySize   int64
 }
 
 type RequestLine struct {
@@ -32,48 +47,123 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 const crlf = "\r\n"
 const bufferSize = 8
 
+// DefaultMaxBodySize bounds the body of a request when the Reader sets no
+// other limit. Bodies are read into memory in full, so this is what one
+// request can cost.
+const DefaultMaxBodySize = 32 << 20
+
+// ErrBodyTooLarge is returned for a request whose body is over the limit
+// of the Reader; the server answers 413 Content Too Large.
+var ErrBodyTooLarge = errors.New("request: body too large")
+
+// Reader parses requests from a connection. Bytes read past the end of one
+// request are kept for the next one, or for a handler that takes over the
+// connection.
+type Reader struct {
+	// MaxBodySize bounds the body of each request, after dechunking. Zero
+	// means DefaultMaxBodySize and a negative value means no limit.
+	MaxBodySize int64
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
+	return NewReader(reader).ReadRequest()
+}
+
+// ReadRequest parses the next complete request, body included. It returns
+// io.EOF if the connection ends cleanly before a new request starts.
+func (r *Reader) ReadRequest() (*Request, error) {
 	req := &Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
+		state:       requestStateInitialized,
+		Headers:     headers.NewHeaders(),
+		Body:        make([]byte, 0),
+		Trailers:    headers.NewHeaders(),
+		maxBodySize: r.MaxBodySize,
 	}
-	for req.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+	if req.maxBodySize == 0 {
+		req.maxBodySize = DefaultMaxBodySize
+	}
+	for {
+		numBytesParsed, err := req.parse(r.buf[:r.readToIndex])
+		if err != nil {
+			return nil, err
+		}
+		copy(r.buf, r.buf[numBytesParsed:r.readToIndex])
+		r.readToIndex -= numBytesParsed
+		if req.state == requestStateDone {
+			return req, nil
 		}
 
-		numBytesRead, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(io.EOF, err) {
-				if req.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", req.state, numBytesRead)
-				}
-				break
+		if r.eof {
+			if req.state == requestStateInitialized && r.readToIndex == 0 {
+				return nil, io.EOF
 			}
+			return nil, fmt.Errorf("incomplete request, in state: %d: %w", req.state, io.ErrUnexpectedEOF)
+		}
+		if err := r.fill(); err != nil {
 			return nil, err
 		}
-		readToIndex += numBytesRead
+	}
+}
 
-		numBytesParsed, err := req.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
+// Peek returns the next n unparsed bytes without consuming them, reading
+// from the connection as needed. It returns io.ErrUnexpectedEOF along with
+// the bytes there are if the connection ends first.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	for r.readToIndex < n {
+		if r.eof {
+			return r.buf[:r.readToIndex], io.ErrUnexpectedEOF
+		}
+		if err := r.fill(); err != nil {
+			return r.buf[:r.readToIndex], err
 		}
+	}
+	return r.buf[:n], nil
+}
 
-		copy(buf, buf[numBytesParsed:])
-		readToIndex -= numBytesParsed
+// fill reads once from the connection into the end of the buffer, growing
+// it when full.
+func (r *Reader) fill() error {
+	if r.readToIndex >= len(r.buf) {
+		newBuf := make([]byte, len(r.buf)*2)
+		copy(newBuf, r.buf)
+		r.buf = newBuf
+	}
+	numBytesRead, err := r.reader.Read(r.buf[r.readToIndex:])
+	r.readToIndex += numBytesRead
+	if errors.Is(err, io.EOF) {
+		r.eof = true
+	} else if err != nil {
+		return err
 	}
-	return req, nil
+	return nil
+}
+
+// Buffered returns the bytes read from the connection that have not been
+// parsed yet.
+func (r *Reader) Buffered() []byte {
+	return r.buf[:r.readToIndex]
 }
 
 func parseRequestLine(data []byte) (*RequestLine, int, error) {
@@ -103,6 +193,9 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	}
 
 	requestTarget := parts[1]
+	if err := validateTarget(method, requestTarget); err != nil {
+		return nil, err
+	}
 
 	versionParts := strings.Split(parts[2], "/")
 	if len(versionParts) != 2 {
@@ -125,6 +218,25 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	}, nil
 }
 
+// validateTarget checks the form of the request target. CONNECT names the
+// host and port to tunnel to in authority-form, which no other method uses.
+func validateTarget(method, target string) error {
+	if target == "" {
+		return fmt.Errorf("empty request target")
+	}
+	if method != "CONNECT" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || strings.ContainsAny(target, "/?#@") {
+		return fmt.Errorf("CONNECT target is not in authority-form: %s", target)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port in CONNECT target: %s", target)
+	}
+	return nil
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.state != requestStateDone {
@@ -161,32 +273,103 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if done {
-			r.state = requestStateParsingBody
+			return n, r.startBody()
 		}
 		return n, nil
 	case requestStateParsingBody:
-		contentLenStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			// assume that if no content-length header is present, there is no body
+		n := min(len(data), r.bodyRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRemaining -= n
+		if r.bodyRemaining == 0 {
 			r.state = requestStateDone
-			return len(data), nil
 		}
-		contentLen, err := strconv.Atoi(contentLenStr)
+		return n, nil
+	case requestStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := chunked.ParseSize(data[:idx])
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, err
 		}
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("Content-Length too large")
+		if r.maxBodySize > 0 && int64(len(r.Body)+size) > r.maxBodySize {
+			return 0, fmt.Errorf("%w: chunked body over %d bytes", ErrBodyTooLarge, r.maxBodySize)
 		}
-		if r.bodyLengthRead == contentLen {
+		if size == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.bodyRemaining = size
+			r.state = requestStateParsingChunkData
+		}
+		return idx + 2, nil
+	case requestStateParsingChunkData:
+		n := min(len(data), r.bodyRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRemaining -= n
+		if r.bodyRemaining == 0 {
+			r.state = requestStateParsingChunkEnd
+		}
+		return n, nil
+	case requestStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		r.state = requestStateParsingChunkSize
+		return 2, nil
+	case requestStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.state = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
 		return 0, fmt.Errorf("unknown state")
 	}
 }
+
+// startBody picks the body framing once the headers are complete. Requests
+// carrying both framing headers are rejected, since a proxy in front might
+// disagree about where the body ends.
+func (r *Request) startBody() error {
+	transferEncoding, chunked := r.Headers.Get("Transfer-Encoding")
+	_, hasLength := r.Headers.Get("Content-Length")
+	if chunked && hasLength {
+		return fmt.Errorf("both Transfer-Encoding and Content-Length present")
+	}
+	if chunked {
+		codings := strings.Split(transferEncoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return fmt.Errorf("unsupported Transfer-Encoding: %s", transferEncoding)
+		}
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+	if !hasLength {
+		// assume that if no content-length header is present, there is no body
+		r.state = requestStateDone
+		return nil
+	}
+	contentLen, err := r.Headers.ContentLength()
+	if err != nil {
+		return err
+	}
+	if contentLen == 0 {
+		r.state = requestStateDone
+		return nil
+	}
+	if r.maxBodySize > 0 && contentLen > r.maxBodySize {
+		return fmt.Errorf("%w: Content-Length %d over %d bytes", ErrBodyTooLarge, contentLen, r.maxBodySize)
+	}
+	r.bodyRemaining = int(contentLen)
+	r.state = requestStateParsingBody
+	return nil
+}
@@ -1,17 +1,43 @@
 package request
 
 import (
+	"fmt"
 	"io"
 	"testing"
 
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// requestFixtures are the well-formed requests the parser tests read.
+// TestWriteRoundTrip writes every one of them back, so a request added
+// here is covered by both.
+var requestFixtures = map[string]string{
+	"get":                 "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"get path":            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"post json":           "POST /submit HTTP/1.1\r\nContent-Type: application/json\r\n\r\n{\"data\":\"test\"}",
+	"get users":           "GET /api/v1/users HTTP/1.1\r\nHost: api.example.com\r\n\r\n",
+	"put":                 "PUT /resource HTTP/1.1\r\nContent-Type: text/plain\r\n\r\n",
+	"empty headers":       "GET / HTTP/1.1\r\n\r\n",
+	"duplicate headers":   "GET / HTTP/1.1\r\nAccept: text/html\r\nAccept: application/json\r\n\r\n",
+	"mixed case headers":  "GET / HTTP/1.1\r\nHost: example.com\r\nHOST: example2.com\r\n\r\n",
+	"header whitespace":   "GET / HTTP/1.1\r\nContent-Type: application/json; charset=utf-8\r\n\r\n",
+	"connect":             "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+	"connect ipv6":        "CONNECT [2001:db8::1]:8443 HTTP/1.1\r\n\r\n",
+	"cookies":             "GET / HTTP/1.1\r\nCookie: session=abc; theme=dark\r\nCookie: bad cookie=1; lang=en\r\n\r\n",
+	"delete":              "DELETE /items/42 HTTP/1.1\r\nAuthorization: Bearer token123\r\n\r\n",
+	"body":                "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"empty body":          "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 0\r\n\r\n",
+	"body without length": "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\n\r\nhello world!\n",
+	"chunked":             "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n6;name=value\r\nhello \r\nA\r\n0123456789\r\n0\r\nX-Checksum: abc\r\n\r\n",
+	"gzip chunked":        "POST /empty HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+}
+
 func TestRequestFromReader(t *testing.T) {
     // Test: Good GET Request line
     reader := &chunkReader{
-        data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+        data:            requestFixtures["get"],
         numBytesPerRead: 3,
     }
     r, err := RequestFromReader(reader)
@@ -23,7 +49,7 @@ func TestRequestFromReader(t *testing.T) {
 
     // Test: Good GET Request line with path
     reader = &chunkReader{
-        data:            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+        data:            requestFixtures["get path"],
         numBytesPerRead: 1,
     }
     r, err = RequestFromReader(reader)
@@ -35,7 +61,7 @@ func TestRequestFromReader(t *testing.T) {
 
     // Test: Good POST Request with path
     reader = &chunkReader{
-        data:            "POST /submit HTTP/1.1\r\nContent-Type: application/json\r\n\r\n{\"data\":\"test\"}",
+        data:            requestFixtures["post json"],
         numBytesPerRead: 5,
     }
     r, err = RequestFromReader(reader)
@@ -74,7 +100,7 @@ func TestRequestFromReader(t *testing.T) {
 
     // Test with different chunk sizes
     reader = &chunkReader{
-        data:            "GET /api/v1/users HTTP/1.1\r\nHost: api.example.com\r\n\r\n",
+        data:            requestFixtures["get users"],
         numBytesPerRead: 10, // Larger chunk size
     }
     r, err = RequestFromReader(reader)
@@ -86,7 +112,7 @@ func TestRequestFromReader(t *testing.T) {
 
     // Test with a chunk size of exactly one request line
     reader = &chunkReader{
-        data:            "PUT /resource HTTP/1.1\r\nContent-Type: text/plain\r\n\r\n",
+        data:            requestFixtures["put"],
         numBytesPerRead: 22, // Exactly the length of "PUT /resource HTTP/1.1"
     }
     r, err = RequestFromReader(reader)
@@ -97,7 +123,7 @@ func TestRequestFromReader(t *testing.T) {
     assert.Equal(t, "HTTP/1.1", r.RequestLine.HttpVersion)
     
     // Test with the entire request read at once
-    fullRequest := "DELETE /items/42 HTTP/1.1\r\nAuthorization: Bearer token123\r\n\r\n"
+    fullRequest := requestFixtures["delete"]
     reader = &chunkReader{
         data:            fullRequest,
         numBytesPerRead: len(fullRequest), // Read the entire request in one chunk
@@ -113,7 +139,7 @@ func TestRequestFromReader(t *testing.T) {
 func TestRequestHeader(t *testing.T) {
     // Test: Standard Headers
     reader := &chunkReader{
-        data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+        data:            requestFixtures["get"],
         numBytesPerRead: 3,
     }
     r, err := RequestFromReader(reader)
@@ -133,7 +159,7 @@ func TestRequestHeader(t *testing.T) {
     
     // Test: Empty Headers
     reader = &chunkReader{
-        data:            "GET / HTTP/1.1\r\n\r\n",
+        data:            requestFixtures["empty headers"],
         numBytesPerRead: 5,
     }
     r, err = RequestFromReader(reader)
@@ -143,7 +169,7 @@ func TestRequestHeader(t *testing.T) {
     
     // Test: Duplicate Headers
     reader = &chunkReader{
-        data:            "GET / HTTP/1.1\r\nAccept: text/html\r\nAccept: application/json\r\n\r\n",
+        data:            requestFixtures["duplicate headers"],
         numBytesPerRead: 4,
     }
     r, err = RequestFromReader(reader)
@@ -153,7 +179,7 @@ func TestRequestHeader(t *testing.T) {
     
     // Test: Case Insensitive Headers
     reader = &chunkReader{
-        data:            "GET / HTTP/1.1\r\nHost: example.com\r\nHOST: example2.com\r\n\r\n",
+        data:            requestFixtures["mixed case headers"],
         numBytesPerRead: 6,
     }
     r, err = RequestFromReader(reader)
@@ -176,7 +202,7 @@ func TestRequestHeader(t *testing.T) {
     
     // Test: Header with whitespace
     reader = &chunkReader{
-        data:            "GET / HTTP/1.1\r\nContent-Type: application/json; charset=utf-8\r\n\r\n",
+        data:            requestFixtures["header whitespace"],
         numBytesPerRead: 7,
     }
     r, err = RequestFromReader(reader)
@@ -189,11 +215,7 @@ func TestRequestHeader(t *testing.T) {
 func TestBodyParse(t *testing.T) {
 	// Test: Standard Body
 	reader := &chunkReader{
-		data: "POST /submit HTTP/1.1\r\n" +
-			"Host: localhost:42069\r\n" +
-			"Content-Length: 13\r\n" +
-			"\r\n" +
-			"hello world!\n",
+		data:            requestFixtures["body"],
 		numBytesPerRead: 3,
 	}
 	r, err := RequestFromReader(reader)
@@ -203,10 +225,7 @@ func TestBodyParse(t *testing.T) {
 
 	// Test: Empty Body, 0 reported content length
 	reader = &chunkReader{
-		data: "POST /submit HTTP/1.1\r\n" +
-			"Host: localhost:42069\r\n" +
-			"Content-Length: 0\r\n" +
-			"\r\n",
+		data:            requestFixtures["empty body"],
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
@@ -216,10 +235,7 @@ func TestBodyParse(t *testing.T) {
 
 	// Test: No Content-Length but Body Exists
 	reader = &chunkReader{
-		data: "POST /submit HTTP/1.1\r\n" +
-			"Host: localhost:42069\r\n" +
-			"\r\n" +
-			"hello world!\n",
+		data:            requestFixtures["body without length"],
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
@@ -251,4 +267,203 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
         cr.pos -= n - cr.numBytesPerRead
     }
     return n, nil
-}
\ No newline at end of file
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data:            requestFixtures["chunked"],
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello 0123456789", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Test: Chunked as the final of several codings
+	reader = &chunkReader{
+		data:            requestFixtures["gzip chunked"],
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	assert.Equal(t, "gzip, chunked", r.Headers["transfer-encoding"])
+
+	// Test: Transfer-Encoding and Content-Length together
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Signs and whitespace around the chunk size are refused
+	for _, size := range []string{"+5", " 5", "5 ", "0x5"} {
+		reader = &chunkReader{
+			data:            "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + size + "\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err = RequestFromReader(reader)
+		assert.Error(t, err, size)
+	}
+}
+
+func TestReaderReadAhead(t *testing.T) {
+	// Test: Two requests in one stream are read one after the other
+	reader := NewReader(&chunkReader{
+		data: "POST /one HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"extra bytes",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/one", r.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", string(r.Body))
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/two", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost", r.Headers["host"])
+
+	// Test: Bytes past the request are left for the caller
+	r, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.Nil(t, r)
+
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\n\r\nleft over",
+		numBytesPerRead: 64,
+	})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "left over", string(reader.Buffered()))
+
+	// Test: A clean end of the stream between requests is io.EOF
+	reader = NewReader(&chunkReader{data: "GET / HTTP/1.1\r\n\r\n", numBytesPerRead: 5})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderPeek(t *testing.T) {
+	// Test: Peeked bytes are still parsed afterwards
+	reader := NewReader(&chunkReader{
+		data:            "GET /peek HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	b, err := reader.Peek(5)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /", string(b))
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/peek", r.RequestLine.RequestTarget)
+
+	// Test: Peeking past the end returns what there is
+	b, err = reader.Peek(1)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Empty(t, b)
+}
+
+func TestConnectTarget(t *testing.T) {
+	// Test: CONNECT takes an authority-form target
+	reader := &chunkReader{
+		data:            requestFixtures["connect"],
+		numBytesPerRead: 4,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: IPv6 literals are bracketed
+	reader = &chunkReader{
+		data:            requestFixtures["connect ipv6"],
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:8443", r.RequestLine.RequestTarget)
+
+	// Test: Targets in other forms or without a port are rejected
+	for _, target := range []string{"/", "example.com", "http://example.com:443/", "example.com:0", "example.com:https", ":443"} {
+		reader = &chunkReader{
+			data:            "CONNECT " + target + " HTTP/1.1\r\n\r\n",
+			numBytesPerRead: 4,
+		}
+		_, err = RequestFromReader(reader)
+		assert.Error(t, err, target)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	reader := &chunkReader{
+		data:            requestFixtures["cookies"],
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+
+	// Test: Cookies from every Cookie line are found, malformed ones skipped
+	assert.Equal(t, []headers.Cookie{
+		{Name: "session", Value: "abc"},
+		{Name: "theme", Value: "dark"},
+		{Name: "lang", Value: "en"},
+	}, r.Cookies())
+	value, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", value)
+	_, ok = r.Cookie("bad cookie")
+	assert.False(t, ok)
+
+	// Test: No Cookie header means no cookies
+	r, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\n\r\n", numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+func TestMaxBodySize(t *testing.T) {
+	read := func(maxBodySize int64, data string) (*Request, error) {
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+		reader.MaxBodySize = maxBodySize
+		return reader.ReadRequest()
+	}
+
+	// Test: Bodies up to the limit are read
+	r, err := read(5, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Longer bodies are refused before they are read
+	_, err = read(4, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	_, err = read(0, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Length: %d\r\n\r\n", DefaultMaxBodySize+1))
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked bodies are refused once their chunks add up past it
+	_, err = read(8, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n")
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: A negative limit means none
+	r, err = read(-1, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "helloworld", string(r.Body))
+}
@@ -0,0 +1,72 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// Write serializes the request in HTTP/1.1 message syntax. Header and
+// trailer fields are written in sorted order. With a chunked
+// Transfer-Encoding the body goes out as a single chunk followed by the
+// trailers; otherwise a Content-Length is added for a non-empty body when
+// the request does not carry one.
+//
+// Parsing a request and writing it back is byte-identical for canonical
+// input: lower-case field names in sorted order, single spaces after the
+// colons and, for chunked bodies, at most one chunk with a lower-case hex
+// size and no extensions.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	version := strings.TrimPrefix(r.RequestLine.HttpVersion, "HTTP/")
+	if version == "" {
+		version = "1.1"
+	}
+	fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+
+	transferEncoding, hasEncoding := r.Headers.Get("Transfer-Encoding")
+	_, hasLength := r.Headers.Get("Content-Length")
+	chunked := hasEncoding && strings.HasSuffix(strings.ToLower(strings.TrimSpace(transferEncoding)), "chunked")
+
+	fields := headers.NewHeaders()
+	for k, v := range r.Headers {
+		fields.Override(k, v)
+	}
+	if len(r.Body) > 0 && !hasLength && !hasEncoding {
+		fields.Override("Content-Length", fmt.Sprintf("%d", len(r.Body)))
+	}
+	writeFields(bw, fields)
+
+	if !chunked {
+		bw.Write(r.Body)
+		return bw.Flush()
+	}
+	if len(r.Body) > 0 {
+		fmt.Fprintf(bw, "%x\r\n", len(r.Body))
+		bw.Write(r.Body)
+		bw.WriteString(crlf)
+	}
+	bw.WriteString("0" + crlf)
+	writeFields(bw, r.Trailers)
+	return bw.Flush()
+}
+
+// writeFields writes a field section in sorted order followed by the empty
+// line that terminates it.
+func writeFields(bw *bufio.Writer, h headers.Headers) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h.Values(k) {
+			fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+		}
+	}
+	bw.WriteString(crlf)
+}
@@ -0,0 +1,63 @@
+package request
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	// Test: Every request the parser tests read is written back faithfully
+	for name, fixture := range requestFixtures {
+		for _, perRead := range []int{1, 3, len(fixture)} {
+			original, err := RequestFromReader(&chunkReader{data: fixture, numBytesPerRead: perRead})
+			require.NoError(t, err, name)
+
+			var first bytes.Buffer
+			require.NoError(t, original.Write(&first))
+			parsed, err := RequestFromReader(&chunkReader{data: first.String(), numBytesPerRead: perRead})
+			require.NoError(t, err, first.String())
+
+			assert.Equal(t, original.RequestLine, parsed.RequestLine)
+			assert.Equal(t, string(original.Body), string(parsed.Body))
+			assert.Equal(t, original.Trailers, parsed.Trailers)
+			assert.Equal(t, original.Headers, parsed.Headers)
+
+			// Writing is idempotent: the written form is canonical.
+			var second bytes.Buffer
+			require.NoError(t, parsed.Write(&second))
+			assert.Equal(t, first.String(), second.String())
+		}
+	}
+}
+
+func TestWriteCanonical(t *testing.T) {
+	canonical := []string{
+		"GET /api/v1/users HTTP/1.1\r\nhost: api.example.com\r\n\r\n",
+		"GET / HTTP/1.1\r\nhost: localhost\r\nset-cookie: a=1\r\nset-cookie: b=2\r\n\r\n",
+		"POST /submit HTTP/1.1\r\ncontent-length: 13\r\nhost: localhost:42069\r\n\r\nhello world!\n",
+		"POST /upload HTTP/1.1\r\ntrailer: X-Count\r\ntransfer-encoding: chunked\r\n\r\n" +
+			"c\r\nhello, world\r\n0\r\nx-count: 12\r\n\r\n",
+		"POST /empty HTTP/1.1\r\ntransfer-encoding: chunked\r\n\r\n0\r\n\r\n",
+	}
+	for _, input := range canonical {
+		r, err := RequestFromReader(&chunkReader{data: input, numBytesPerRead: 4})
+		require.NoError(t, err, input)
+		var out bytes.Buffer
+		require.NoError(t, r.Write(&out))
+		assert.Equal(t, input, out.String())
+	}
+}
+
+func TestWriteAddsContentLength(t *testing.T) {
+	r := &Request{
+		RequestLine: RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "HTTP/1.1"},
+		Headers:     map[string]string{"host": "example.com"},
+		Body:        []byte("payload"),
+	}
+	var out bytes.Buffer
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, "POST / HTTP/1.1\r\ncontent-length: 7\r\nhost: example.com\r\n\r\npayload", out.String())
+}
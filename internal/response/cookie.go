@@ -0,0 +1,157 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// SameSite is the SameSite attribute of a cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, for the browser to decide.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+var sameSiteNames = map[SameSite]string{
+	SameSiteLax:    "Lax",
+	SameSiteStrict: "Strict",
+	SameSiteNone:   "None",
+}
+
+// Cookie is a cookie to set with a Set-Cookie header, RFC 6265 section 4.1.
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is left out when 0. A negative MaxAge deletes the cookie at
+	// once, and is sent as Max-Age=0.
+	MaxAge   int
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps the cookie in storage partitioned by top-level
+	// site, per CHIPS. It needs Secure.
+	Partitioned bool
+}
+
+// Valid checks the cookie against RFC 6265 and the rules browsers add:
+// SameSite=None and Partitioned need Secure, and the __Secure- and __Host-
+// name prefixes hold.
+func (c *Cookie) Valid() error {
+	if !headers.ValidCookieName(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+	if !headers.ValidCookieValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie %s", c.Name)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("cookie %s expires before 1601", c.Name)
+	}
+	if c.Domain != "" && !validCookieDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("invalid domain %q for cookie %s", c.Domain, c.Name)
+	}
+	if strings.ContainsFunc(c.Path, func(r rune) bool { return r < ' ' || r >= 0x7f || r == ';' }) {
+		return fmt.Errorf("invalid path %q for cookie %s", c.Path, c.Name)
+	}
+	if _, ok := sameSiteNames[c.SameSite]; !ok && c.SameSite != SameSiteDefault {
+		return fmt.Errorf("invalid SameSite %d for cookie %s", c.SameSite, c.Name)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %s has SameSite=None without Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %s is Partitioned without Secure", c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("cookie %s needs Secure", c.Name)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("cookie %s needs Secure, Path=/ and no Domain", c.Name)
+	}
+	return nil
+}
+
+// validCookieDomain reports whether domain is a host name: dot-separated
+// labels of letters, digits and hyphens, not starting or ending with a
+// hyphen.
+func validCookieDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the cookie as a Set-Cookie value. It does not check the
+// cookie; see Valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if name, ok := sameSiteNames[c.SameSite]; ok {
+		b.WriteString("; SameSite=")
+		b.WriteString(name)
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// SetCookie adds a Set-Cookie field for c to the headers of a response,
+// after checking it with Valid. Each cookie gets a field line of its own.
+func SetCookie(h headers.Headers, c *Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	h.Set("Set-Cookie", c.String())
+	return nil
+}
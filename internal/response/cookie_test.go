@@ -0,0 +1,85 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieString(t *testing.T) {
+	// Test: Every attribute is written in order
+	c := &Cookie{
+		Name:        "__Host-session",
+		Value:       "abc123",
+		Expires:     time.Date(2026, 10, 21, 9, 28, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		MaxAge:      3600,
+		Path:        "/",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "__Host-session=abc123; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Max-Age=3600; "+
+		"Path=/; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: Unset attributes are left out and negative Max-Age deletes
+	c = &Cookie{Name: "theme", Value: `"dark"`, MaxAge: -1, Domain: ".example.com", SameSite: SameSiteLax}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, `theme="dark"; Max-Age=0; Domain=example.com; SameSite=Lax`, c.String())
+	c = &Cookie{Name: "id", SameSite: SameSiteStrict}
+	assert.Equal(t, "id=; SameSite=Strict", c.String())
+}
+
+func TestCookieValid(t *testing.T) {
+	invalid := map[string]Cookie{
+		"name":            {Name: "a b", Value: "1"},
+		"empty name":      {Value: "1"},
+		"value":           {Name: "a", Value: "x;y"},
+		"expires":         {Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"domain":          {Name: "a", Domain: "exa mple.com"},
+		"domain label":    {Name: "a", Domain: "-example.com"},
+		"empty label":     {Name: "a", Domain: "example..com"},
+		"path":            {Name: "a", Path: "/x;Secure"},
+		"path control":    {Name: "a", Path: "/x\r\nX-Injected: 1"},
+		"samesite":        {Name: "a", SameSite: SameSite(7)},
+		"samesite none":   {Name: "a", SameSite: SameSiteNone},
+		"partitioned":     {Name: "a", Partitioned: true},
+		"secure prefix":   {Name: "__Secure-a"},
+		"host prefix":     {Name: "__Host-a", Secure: true},
+		"host domain":     {Name: "__Host-a", Secure: true, Path: "/", Domain: "example.com"},
+		"host path":       {Name: "__Host-a", Secure: true, Path: "/app"},
+		"value non-ascii": {Name: "a", Value: "é"},
+	}
+	// Test: Invalid cookies are rejected and not added
+	for name, c := range invalid {
+		h := headers.NewHeaders()
+		assert.Error(t, SetCookie(h, &c), name)
+		assert.Empty(t, h, name)
+	}
+
+	// Test: Prefixed cookies following their rules are accepted
+	assert.NoError(t, (&Cookie{Name: "__Secure-a", Secure: true, Domain: "example.com"}).Valid())
+	assert.NoError(t, (&Cookie{Name: "__Host-a", Secure: true, Path: "/"}).Valid())
+}
+
+func TestSetCookie(t *testing.T) {
+	h := GetDefaultHeaders(0)
+	require.NoError(t, SetCookie(h, &Cookie{Name: "a", Value: "1", Path: "/"}))
+	require.NoError(t, SetCookie(h, &Cookie{Name: "b", Value: "2", HttpOnly: true}))
+
+	// Test: Each cookie goes on a field line of its own
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	out := buf.String()
+	assert.Contains(t, out, "\r\nset-cookie: a=1; Path=/\r\n")
+	assert.Contains(t, out, "\r\nset-cookie: b=2; HttpOnly\r\n")
+	assert.Equal(t, 2, strings.Count(out, "set-cookie:"))
+}
@@ -0,0 +1,30 @@
+package response
+
+import (
+	"fmt"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// ErrorBody returns the plain text body of an error response: the status
+// code and its reason phrase.
+func ErrorBody(statusCode StatusCode) []byte {
+	return []byte(fmt.Sprintf("%d %s\n", statusCode, StatusText(statusCode)))
+}
+
+// ErrorHeaders returns the default headers for ErrorBody, for callers that
+// add fields such as Allow or Location before calling WriteError.
+func ErrorHeaders(statusCode StatusCode) headers.Headers {
+	return GetDefaultHeaders(len(ErrorBody(statusCode)))
+}
+
+// WriteError writes a plain text error response. A nil h means
+// ErrorHeaders(statusCode).
+func WriteError(w *Writer, statusCode StatusCode, h headers.Headers) {
+	if h == nil {
+		h = ErrorHeaders(statusCode)
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(ErrorBody(statusCode))
+}
@@ -0,0 +1,82 @@
+package response
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+// HeadFramer wraps a Framer for responses to HEAD requests. Body bytes,
+// chunked or not, are counted but never reach the wire. The header block is
+// held back until Finish so that a Content-Length can be filled in from the
+// counted body when the handler did not set one.
+type HeadFramer struct {
+	framer     Framer
+	headers    headers.Headers
+	bodyLength int
+	finished   bool
+}
+
+func NewHeadFramer(f Framer) *HeadFramer {
+	return &HeadFramer{framer: f}
+}
+
+// BodyLength returns the number of body bytes the handler has written so far.
+func (f *HeadFramer) BodyLength() int {
+	return f.bodyLength
+}
+
+func (f *HeadFramer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	return f.framer.WriteInformational(statusCode, h)
+}
+
+func (f *HeadFramer) WriteStatusLine(statusCode StatusCode) error {
+	return f.framer.WriteStatusLine(statusCode)
+}
+
+func (f *HeadFramer) WriteHeaders(h headers.Headers) error {
+	f.headers = h
+	return nil
+}
+
+func (f *HeadFramer) WriteBody(p []byte) (int, error) {
+	f.bodyLength += len(p)
+	return len(p), nil
+}
+
+func (f *HeadFramer) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(io.Discard, r)
+	f.bodyLength += int(n)
+	return n, err
+}
+
+func (f *HeadFramer) WriteChunkedBody(p []byte) (int, error) {
+	f.bodyLength += len(p)
+	return len(p), nil
+}
+
+func (f *HeadFramer) WriteChunkedBodyDone() (int, error) {
+	return 0, nil
+}
+
+func (f *HeadFramer) WriteTrailers(_ headers.Headers) error {
+	return nil
+}
+
+// Finish writes the held back header block. If the handler set neither
+// Content-Length nor Transfer-Encoding, Content-Length is set to the number
+// of body bytes that were discarded. It is a no-op if no headers were
+// written or Finish has already been called.
+func (f *HeadFramer) Finish() error {
+	if f.headers == nil || f.finished {
+		return nil
+	}
+	f.finished = true
+	_, hasLength := f.headers.Get("Content-Length")
+	_, hasEncoding := f.headers.Get("Transfer-Encoding")
+	if !hasLength && !hasEncoding {
+		f.headers.Override("Content-Length", fmt.Sprintf("%d", f.bodyLength))
+	}
+	return f.framer.WriteHeaders(f.headers)
+}
@@ -0,0 +1,378 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/chunked"
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+)
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	// Trailers holds the trailer section of a chunked body. It is only
+	// complete once the body has been read to the end.
+	Trailers headers.Headers
+
+	state          responseState
+	requestMethod  string
+	bodyRemaining  int
+	closeDelimited bool
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type responseState int
+
+const (
+	responseStateInitialized responseState = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateParsingChunkSize
+	responseStateParsingChunkData
+	responseStateParsingChunkEnd
+	responseStateParsingTrailers
+	responseStateDone
+)
+
+const crlf = "\r\n"
+const bufferSize = 4096
+
+// Reader parses consecutive responses from a single connection. Bytes read
+// past the end of one response are kept for the next one.
+type Reader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// ResponseFromReader parses one complete response to a GET request.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return NewReader(reader).ReadResponse("GET")
+}
+
+// ReadResponse parses a complete response, body included. The request
+// method is needed because responses to HEAD never carry a body.
+func (r *Reader) ReadResponse(method string) (*Response, error) {
+	resp, body, err := r.ReadResponseHeader(method)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = data
+	return resp, nil
+}
+
+// ReadResponseHeader parses the status line and headers, skipping any
+// interim 1xx responses, and returns a reader that decodes the body as it
+// is read.
+func (r *Reader) ReadResponseHeader(method string) (*Response, io.Reader, error) {
+	resp := &Response{
+		state:         responseStateInitialized,
+		Headers:       headers.NewHeaders(),
+		Body:          make([]byte, 0),
+		Trailers:      headers.NewHeaders(),
+		requestMethod: method,
+	}
+	err := r.advance(resp, func() bool { return resp.state >= responseStateParsingBody })
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, &bodyReader{reader: r, resp: resp}, nil
+}
+
+// Buffered returns the bytes read from the connection that have not been
+// parsed yet.
+func (r *Reader) Buffered() []byte {
+	return r.buf[:r.readToIndex]
+}
+
+// advance feeds buffered and newly read bytes to the parser until stop
+// reports true or the response is complete.
+func (r *Reader) advance(resp *Response, stop func() bool) error {
+	for {
+		numBytesParsed, err := resp.parse(r.buf[:r.readToIndex], stop)
+		if err != nil {
+			return err
+		}
+		copy(r.buf, r.buf[numBytesParsed:r.readToIndex])
+		r.readToIndex -= numBytesParsed
+		if stop() || resp.state == responseStateDone {
+			return nil
+		}
+
+		if r.eof {
+			if resp.closeDelimited {
+				resp.state = responseStateDone
+				return nil
+			}
+			return fmt.Errorf("incomplete response, in state: %d: %w", resp.state, io.ErrUnexpectedEOF)
+		}
+		if r.readToIndex >= len(r.buf) {
+			newBuf := make([]byte, len(r.buf)*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
+		}
+		numBytesRead, err := r.reader.Read(r.buf[r.readToIndex:])
+		r.readToIndex += numBytesRead
+		if errors.Is(err, io.EOF) {
+			r.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// bodyReader hands out body bytes as the parser produces them.
+type bodyReader struct {
+	reader *Reader
+	resp   *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	resp := b.resp
+	if len(resp.Body) == 0 {
+		if resp.state == responseStateDone {
+			return 0, io.EOF
+		}
+		err := b.reader.advance(resp, func() bool { return len(resp.Body) > 0 })
+		if err != nil {
+			return 0, err
+		}
+		if len(resp.Body) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, resp.Body)
+	resp.Body = resp.Body[n:]
+	return n, nil
+}
+
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	statusLine, err := statusLineFromString(string(data[:idx]))
+	if err != nil {
+		return nil, 0, err
+	}
+	return statusLine, idx + 2, nil
+}
+
+func statusLineFromString(str string) (*StatusLine, error) {
+	parts := strings.SplitN(str, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("poorly formatted status-line: %s", str)
+	}
+
+	versionParts := strings.Split(parts[0], "/")
+	if len(versionParts) != 2 || versionParts[0] != "HTTP" {
+		return nil, fmt.Errorf("malformed status-line: %s", str)
+	}
+	version := versionParts[1]
+	if version != "1.1" && version != "1.0" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
+	}
+
+	if len(parts[1]) != 3 {
+		return nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+	statusCode, err := strconv.Atoi(parts[1])
+	if err != nil || statusCode < 100 {
+		return nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+
+	reasonPhrase := ""
+	if len(parts) == 3 {
+		reasonPhrase = parts[2]
+	}
+	return &StatusLine{
+		HttpVersion:  version,
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reasonPhrase,
+	}, nil
+}
+
+// parse consumes as much of data as it can, stopping early once stop
+// reports true so body bytes can be handed out as they arrive.
+func (r *Response) parse(data []byte, stop func() bool) (int, error) {
+	totalBytesParsed := 0
+	for r.state != responseStateDone && !stop() {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case responseStateInitialized:
+		statusLine, n, err := parseStatusLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		r.StatusLine = *statusLine
+		r.state = responseStateParsingHeaders
+		return n, nil
+	case responseStateParsingHeaders:
+		n, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			return n, r.startBody()
+		}
+		return n, nil
+	case responseStateParsingBody:
+		if r.closeDelimited {
+			r.Body = append(r.Body, data...)
+			return len(data), nil
+		}
+		n := min(len(data), r.bodyRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRemaining -= n
+		if r.bodyRemaining == 0 {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := chunked.ParseSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.state = responseStateParsingTrailers
+		} else {
+			r.bodyRemaining = size
+			r.state = responseStateParsingChunkData
+		}
+		return idx + 2, nil
+	case responseStateParsingChunkData:
+		n := min(len(data), r.bodyRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRemaining -= n
+		if r.bodyRemaining == 0 {
+			r.state = responseStateParsingChunkEnd
+		}
+		return n, nil
+	case responseStateParsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		r.state = responseStateParsingChunkSize
+		return 2, nil
+	case responseStateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = responseStateDone
+		}
+		return n, nil
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	default:
+		return 0, fmt.Errorf("unknown state")
+	}
+}
+
+// startBody picks the body framing once the headers are complete, following
+// the message length rules of RFC 9112 section 6.3.
+func (r *Response) startBody() error {
+	statusCode := r.StatusLine.StatusCode
+	if statusCode.IsInformational() && statusCode != StatusCodeSwitchingProtocols {
+		// interim response, the final one follows
+		r.Headers = headers.NewHeaders()
+		r.state = responseStateInitialized
+		return nil
+	}
+	if r.requestMethod == "HEAD" || statusCode.IsInformational() ||
+		statusCode == StatusCodeNoContent || statusCode == StatusCodeNotModified {
+		r.state = responseStateDone
+		return nil
+	}
+	if transferEncoding, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(transferEncoding, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.state = responseStateParsingChunkSize
+			return nil
+		}
+		r.closeDelimited = true
+		r.state = responseStateParsingBody
+		return nil
+	}
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		contentLen, err := r.Headers.ContentLength()
+		if err != nil {
+			return err
+		}
+		if contentLen == 0 {
+			r.state = responseStateDone
+			return nil
+		}
+		r.bodyRemaining = int(contentLen)
+		r.state = responseStateParsingBody
+		return nil
+	}
+	r.closeDelimited = true
+	r.state = responseStateParsingBody
+	return nil
+}
+
+// KeepAlive reports whether the connection can carry another response
+// after this one has been read completely.
+func (r *Response) KeepAlive() bool {
+	if r.closeDelimited {
+		return false
+	}
+	connection, _ := r.Headers.Get("Connection")
+	for _, option := range strings.Split(connection, ",") {
+		option = strings.ToLower(strings.TrimSpace(option))
+		if option == "close" {
+			return false
+		}
+		if option == "keep-alive" {
+			return true
+		}
+	}
+	return r.StatusLine.HttpVersion == "1.1"
+}
@@ -0,0 +1,312 @@
+package response
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Good status line and headers
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Reason phrase with spaces
+	reader = &chunkReader{
+		data:            "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Empty(t, r.Body)
+
+	// Test: Empty and missing reason phrase
+	for _, line := range []string{"HTTP/1.1 418 \r\n", "HTTP/1.1 418\r\n"} {
+		reader = &chunkReader{data: line + "Content-Length: 0\r\n\r\n", numBytesPerRead: 4}
+		r, err = ResponseFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, StatusCode(418), r.StatusLine.StatusCode)
+		assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+	}
+
+	// Test: HTTP/1.0 response
+	reader = &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Invalid status lines
+	for _, line := range []string{
+		"HTTP/1.1\r\n",
+		"HTTP/2.0 200 OK\r\n",
+		"HTTPS/1.1 200 OK\r\n",
+		"HTTP/1.1 20 OK\r\n",
+		"HTTP/1.1 abc OK\r\n",
+		"HTTP/1.1 099 Too Low\r\n",
+	} {
+		reader = &chunkReader{data: line + "\r\n", numBytesPerRead: 3}
+		r, err = ResponseFromReader(reader)
+		require.Error(t, err, line)
+		require.Nil(t, r, line)
+	}
+
+	// Test: Malformed header
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type text/plain\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Incomplete headers
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestResponseBody(t *testing.T) {
+	// Test: Body shorter than Content-Length
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 3,
+	}
+	_, err := ResponseFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Repeated identical Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(r.Body))
+
+	// Test: Conflicting Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\nok!",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Close-delimited body
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nread until EOF",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "read until EOF", string(r.Body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: Responses that never have a body
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		reader = &chunkReader{data: "HTTP/1.1 " + status + "\r\n\r\n", numBytesPerRead: 2}
+		r, err = ResponseFromReader(reader)
+		require.NoError(t, err, status)
+		assert.Empty(t, r.Body, status)
+	}
+
+	// Test: Response to HEAD ignores Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+		numBytesPerRead: 6,
+	}
+	r, err = NewReader(reader).ReadResponse("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "1000", r.Headers["content-length"])
+	assert.Empty(t, r.Body)
+
+	// Test: Interim responses are skipped
+	reader = &chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ndone",
+		numBytesPerRead: 7,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	assert.NotContains(t, r.Headers, "link")
+	assert.Equal(t, "done", string(r.Body))
+}
+
+func TestChunkedResponse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5\r\nhello\r\n" +
+			"7;ext=1\r\n, world\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "abc123", r.Trailers["x-checksum"])
+
+	// Test: Upper case hex sizes and no trailers
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nA\r\n0123456789\r\n0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Empty(t, r.Trailers)
+
+	// Test: Transfer-Encoding wins over Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(r.Body))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nok\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = ResponseFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Signs and whitespace around the chunk size are refused
+	for _, size := range []string{"+2", " 2", "2 ", "0x2"} {
+		reader = &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + size + "\r\nok\r\n0\r\n\r\n",
+			numBytesPerRead: 5,
+		}
+		_, err = ResponseFromReader(reader)
+		assert.Error(t, err, size)
+	}
+
+	// Test: Missing CRLF after chunk data
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nokay\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = ResponseFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = ResponseFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestChecksumTrailersRoundTrip(t *testing.T) {
+	// Test: Parse the chunked body and checksum trailers the proxy writes
+	body := []byte("streamed through the proxy in a few chunks")
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	h.Override("Trailer", "X-Content-SHA256, X-Content-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	for i := 0; i < len(body); i += 10 {
+		_, err := w.WriteChunkedBody(body[i:min(i+10, len(body))])
+		require.NoError(t, err)
+	}
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Override("X-Content-SHA256", fmt.Sprintf("%x", sha256.Sum256(body)))
+	trailers.Override("X-Content-Length", fmt.Sprintf("%d", len(body)))
+	require.NoError(t, w.WriteTrailers(trailers))
+
+	r, err := ResponseFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.Equal(t, body, r.Body)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(r.Body)), r.Trailers["x-content-sha256"])
+	assert.Equal(t, fmt.Sprintf("%d", len(r.Body)), r.Trailers["x-content-length"])
+}
+
+func TestReaderKeepAlive(t *testing.T) {
+	// Test: Consecutive responses on one connection
+	reader := NewReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n" +
+			"HTTP/1.1 201 Created\r\nContent-Length: 5\r\n\r\nthird",
+		numBytesPerRead: 1000,
+	})
+	for _, want := range []string{"first", "second", "third"} {
+		r, err := reader.ReadResponse("GET")
+		require.NoError(t, err)
+		assert.Equal(t, want, string(r.Body))
+		assert.True(t, r.KeepAlive())
+	}
+	assert.Empty(t, reader.Buffered())
+
+	// Test: Streaming the body as it arrives
+	reader = NewReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\nX-Done: yes\r\n\r\n",
+		numBytesPerRead: 2,
+	})
+	r, body, err := reader.ReadResponseHeader("GET")
+	require.NoError(t, err)
+	assert.Empty(t, r.Trailers)
+	p := make([]byte, 2)
+	n, err := body.Read(p)
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(p[:n]))
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "cdef", string(rest))
+	assert.Equal(t, "yes", r.Trailers["x-done"])
+}
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
@@ -7,20 +7,164 @@ import (
 type StatusCode int
 
 const (
-	StatusCodeSuccess             StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
+	StatusCodeContinue                    StatusCode = 100
+	StatusCodeSwitchingProtocols          StatusCode = 101
+	StatusCodeProcessing                  StatusCode = 102
+	StatusCodeEarlyHints                  StatusCode = 103
+	StatusCodeSuccess                     StatusCode = 200
+	StatusCodeCreated                     StatusCode = 201
+	StatusCodeAccepted                    StatusCode = 202
+	StatusCodeNonAuthoritativeInfo        StatusCode = 203
+	StatusCodeNoContent                   StatusCode = 204
+	StatusCodeResetContent                StatusCode = 205
+	StatusCodePartialContent              StatusCode = 206
+	StatusCodeMultipleChoices             StatusCode = 300
+	StatusCodeMovedPermanently            StatusCode = 301
+	StatusCodeFound                       StatusCode = 302
+	StatusCodeSeeOther                    StatusCode = 303
+	StatusCodeNotModified                 StatusCode = 304
+	StatusCodeTemporaryRedirect           StatusCode = 307
+	StatusCodePermanentRedirect           StatusCode = 308
+	StatusCodeBadRequest                  StatusCode = 400
+	StatusCodeUnauthorized                StatusCode = 401
+	StatusCodeForbidden                   StatusCode = 403
+	StatusCodeNotFound                    StatusCode = 404
+	StatusCodeMethodNotAllowed            StatusCode = 405
+	StatusCodeNotAcceptable               StatusCode = 406
+	StatusCodeProxyAuthRequired           StatusCode = 407
+	StatusCodeRequestTimeout              StatusCode = 408
+	StatusCodeConflict                    StatusCode = 409
+	StatusCodeGone                        StatusCode = 410
+	StatusCodeLengthRequired              StatusCode = 411
+	StatusCodePreconditionFailed          StatusCode = 412
+	StatusCodeContentTooLarge             StatusCode = 413
+	StatusCodeURITooLong                  StatusCode = 414
+	StatusCodeUnsupportedMediaType        StatusCode = 415
+	StatusCodeRangeNotSatisfiable         StatusCode = 416
+	StatusCodeExpectationFailed           StatusCode = 417
+	StatusCodeMisdirectedRequest          StatusCode = 421
+	StatusCodeUnprocessableContent        StatusCode = 422
+	StatusCodeUpgradeRequired             StatusCode = 426
+	StatusCodePreconditionRequired        StatusCode = 428
+	StatusCodeTooManyRequests             StatusCode = 429
+	StatusCodeRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusCodeInternalServerError         StatusCode = 500
+	StatusCodeNotImplemented              StatusCode = 501
+	StatusCodeBadGateway                  StatusCode = 502
+	StatusCodeServiceUnavailable          StatusCode = 503
+	StatusCodeGatewayTimeout              StatusCode = 504
+	StatusCodeHTTPVersionNotSupported     StatusCode = 505
 )
 
-func getStatusLine(statusCode StatusCode) []byte {
+// IsInformational reports whether the status code is an interim 1xx
+// response that must be followed by a final status line.
+func (s StatusCode) IsInformational() bool {
+	return s >= 100 && s < 200
+}
+
+// StatusText returns the reason phrase for the status code, or an empty
+// string if the code is unknown.
+func StatusText(statusCode StatusCode) string {
 	reasonPhrase := ""
 	switch statusCode {
+	case StatusCodeContinue:
+		reasonPhrase = "Continue"
+	case StatusCodeSwitchingProtocols:
+		reasonPhrase = "Switching Protocols"
+	case StatusCodeProcessing:
+		reasonPhrase = "Processing"
+	case StatusCodeEarlyHints:
+		reasonPhrase = "Early Hints"
 	case StatusCodeSuccess:
 		reasonPhrase = "OK"
+	case StatusCodeCreated:
+		reasonPhrase = "Created"
+	case StatusCodeAccepted:
+		reasonPhrase = "Accepted"
+	case StatusCodeNonAuthoritativeInfo:
+		reasonPhrase = "Non-Authoritative Information"
+	case StatusCodeNoContent:
+		reasonPhrase = "No Content"
+	case StatusCodeResetContent:
+		reasonPhrase = "Reset Content"
+	case StatusCodePartialContent:
+		reasonPhrase = "Partial Content"
+	case StatusCodeMultipleChoices:
+		reasonPhrase = "Multiple Choices"
+	case StatusCodeMovedPermanently:
+		reasonPhrase = "Moved Permanently"
+	case StatusCodeFound:
+		reasonPhrase = "Found"
+	case StatusCodeSeeOther:
+		reasonPhrase = "See Other"
+	case StatusCodeNotModified:
+		reasonPhrase = "Not Modified"
+	case StatusCodeTemporaryRedirect:
+		reasonPhrase = "Temporary Redirect"
+	case StatusCodePermanentRedirect:
+		reasonPhrase = "Permanent Redirect"
 	case StatusCodeBadRequest:
 		reasonPhrase = "Bad Request"
+	case StatusCodeUnauthorized:
+		reasonPhrase = "Unauthorized"
+	case StatusCodeForbidden:
+		reasonPhrase = "Forbidden"
+	case StatusCodeNotFound:
+		reasonPhrase = "Not Found"
+	case StatusCodeMethodNotAllowed:
+		reasonPhrase = "Method Not Allowed"
+	case StatusCodeNotAcceptable:
+		reasonPhrase = "Not Acceptable"
+	case StatusCodeProxyAuthRequired:
+		reasonPhrase = "Proxy Authentication Required"
+	case StatusCodeRequestTimeout:
+		reasonPhrase = "Request Timeout"
+	case StatusCodeConflict:
+		reasonPhrase = "Conflict"
+	case StatusCodeGone:
+		reasonPhrase = "Gone"
+	case StatusCodeLengthRequired:
+		reasonPhrase = "Length Required"
+	case StatusCodePreconditionFailed:
+		reasonPhrase = "Precondition Failed"
+	case StatusCodeContentTooLarge:
+		reasonPhrase = "Content Too Large"
+	case StatusCodeURITooLong:
+		reasonPhrase = "URI Too Long"
+	case StatusCodeUnsupportedMediaType:
+		reasonPhrase = "Unsupported Media Type"
+	case StatusCodeRangeNotSatisfiable:
+		reasonPhrase = "Range Not Satisfiable"
+	case StatusCodeExpectationFailed:
+		reasonPhrase = "Expectation Failed"
+	case StatusCodeMisdirectedRequest:
+		reasonPhrase = "Misdirected Request"
+	case StatusCodeUnprocessableContent:
+		reasonPhrase = "Unprocessable Content"
+	case StatusCodeUpgradeRequired:
+		reasonPhrase = "Upgrade Required"
+	case StatusCodePreconditionRequired:
+		reasonPhrase = "Precondition Required"
+	case StatusCodeTooManyRequests:
+		reasonPhrase = "Too Many Requests"
+	case StatusCodeRequestHeaderFieldsTooLarge:
+		reasonPhrase = "Request Header Fields Too Large"
 	case StatusCodeInternalServerError:
 		reasonPhrase = "Internal Server Error"
+	case StatusCodeNotImplemented:
+		reasonPhrase = "Not Implemented"
+	case StatusCodeBadGateway:
+		reasonPhrase = "Bad Gateway"
+	case StatusCodeServiceUnavailable:
+		reasonPhrase = "Service Unavailable"
+	case StatusCodeGatewayTimeout:
+		reasonPhrase = "Gateway Timeout"
+	case StatusCodeHTTPVersionNotSupported:
+		reasonPhrase = "HTTP Version Not Supported"
 	}
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase))
+	return reasonPhrase
+}
+
+func getStatusLine(statusCode StatusCode) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode)))
 }
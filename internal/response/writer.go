@@ -1,8 +1,13 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 
 	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
 )
@@ -14,27 +19,92 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
+	writerStateHijacked
 )
 
+// Framer serializes the parts of a response onto a transport. The Writer
+// enforces the order of calls, so a Framer only has to put each part on the
+// wire. A *Writer is itself a Framer, which lets wrappers sit in front of an
+// existing Writer.
+type Framer interface {
+	WriteInformational(statusCode StatusCode, h headers.Headers) error
+	WriteStatusLine(statusCode StatusCode) error
+	WriteHeaders(h headers.Headers) error
+	WriteBody(p []byte) (int, error)
+	WriteChunkedBody(p []byte) (int, error)
+	WriteChunkedBodyDone() (int, error)
+	WriteTrailers(h headers.Headers) error
+}
+
+// Flusher is implemented by Framers that hold back output, so that a
+// streaming handler can push what it has written so far to the client.
+type Flusher interface {
+	Flush() error
+}
+
+// Hijacker is implemented by Framers that can hand the underlying
+// connection over to the handler, for protocols that take over the
+// connection after an HTTP exchange such as WebSocket or CONNECT tunnels.
+// The returned reader yields any bytes the server read past the request
+// before reading from the connection itself.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.Reader, error)
+}
+
+// ErrNotHijackable is returned by Hijack when the response is not written
+// to a connection the handler can take over.
+var ErrNotHijackable = errors.New("response: connection cannot be hijacked")
+
 type Writer struct {
 	writerState writerState
-	writer      io.Writer
+	framer      Framer
 }
 
+// NewWriter returns a Writer that writes an HTTP/1.1 response to w.
 func NewWriter(w io.Writer) *Writer {
+	return NewFramedWriter(&wireFramer{writer: w})
+}
+
+// NewConnWriter returns a Writer for a response on conn that the handler
+// can hijack. readAhead holds the bytes read from conn past the end of the
+// request; it must stay untouched while the handler runs.
+func NewConnWriter(conn net.Conn, readAhead []byte) *Writer {
+	return NewFramedWriter(&wireFramer{writer: conn, readAhead: readAhead})
+}
+
+// NewFramedWriter returns a Writer that hands each part of the response to f.
+func NewFramedWriter(f Framer) *Writer {
 	return &Writer{
 		writerState: writerStateStatusLine,
-		writer:      w,
+		framer:      f,
 	}
 }
 
+// WriteInformational writes an interim 1xx response, such as 103 Early Hints,
+// along with its own header block. Any number of interim responses may be
+// written, but only before the final status line.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if w.writerState != writerStateStatusLine {
+		return fmt.Errorf("cannot write informational response in state %d", w.writerState)
+	}
+	if !statusCode.IsInformational() {
+		return fmt.Errorf("status code %d is not informational", statusCode)
+	}
+	if statusCode == StatusCodeSwitchingProtocols {
+		return fmt.Errorf("status code 101 is a final response for the connection")
+	}
+	return w.framer.WriteInformational(statusCode, h)
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.writerState != writerStateStatusLine {
 		return fmt.Errorf("cannot write status line in state %d", w.writerState)
 	}
+	if statusCode.IsInformational() && statusCode != StatusCodeSwitchingProtocols {
+		return fmt.Errorf("status code %d is informational, use WriteInformational", statusCode)
+	}
 	defer func() { w.writerState = writerStateHeaders }()
-	_, err := w.writer.Write(getStatusLine(statusCode))
-	return err
+	return w.framer.WriteStatusLine(statusCode)
 }
 
 func (w *Writer) WriteHeaders(h headers.Headers) error {
@@ -42,55 +112,76 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 		return fmt.Errorf("cannot write headers in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateBody }()
-	for k, v := range h {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
-		if err != nil {
-			return err
-		}
-	}
-	_, err := w.writer.Write([]byte("\r\n"))
-	return err
+	return w.framer.WriteHeaders(h)
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	return w.writer.Write(p)
+	return w.framer.WriteBody(p)
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+// ReadFrom copies r into an identity-encoded body until EOF. When the
+// response is written straight to a *net.TCPConn and r is an *os.File,
+// possibly behind an io.LimitReader, the copy is done by the kernel with
+// sendfile or splice instead of passing through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	chunkSize := len(p)
+	if rf, ok := w.framer.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(bodyWriter{w.framer}, r)
+}
 
-	nTotal := 0
-	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
-	if err != nil {
-		return nTotal, err
+// Flush sends any buffered part of the response to the client. It is a
+// no-op when the Framer does not buffer.
+func (w *Writer) Flush() error {
+	if f, ok := w.framer.(Flusher); ok {
+		return f.Flush()
 	}
-	nTotal += n
+	return nil
+}
 
-	n, err = w.writer.Write(p)
+// Hijack takes over the connection the response is written to. Anything
+// the Writer was given before is already on the wire; afterwards every
+// Write method fails. The server neither closes nor reuses a hijacked
+// connection, so the handler must close it, possibly after returning.
+func (w *Writer) Hijack() (net.Conn, *bufio.Reader, error) {
+	if w.writerState == writerStateHijacked {
+		return nil, nil, fmt.Errorf("connection already hijacked")
+	}
+	h, ok := w.framer.(Hijacker)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+	conn, reader, err := h.Hijack()
 	if err != nil {
-		return nTotal, err
+		return nil, nil, err
 	}
-	nTotal += n
+	w.writerState = writerStateHijacked
+	return conn, reader, nil
+}
 
-	n, err = w.writer.Write([]byte("\r\n"))
-	if err != nil {
-		return nTotal, err
+// Hijacked reports whether the handler has taken over the connection.
+func (w *Writer) Hijacked() bool {
+	return w.writerState == writerStateHijacked
+}
+
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.writerState != writerStateBody {
+		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	nTotal += n
-	return nTotal, nil
+	return w.framer.WriteChunkedBody(p)
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	n, err := w.writer.Write([]byte("0\r\n"))
+	n, err := w.framer.WriteChunkedBodyDone()
 	if err != nil {
 		return n, err
 	}
@@ -103,12 +194,118 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		return fmt.Errorf("cannot write trailers in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateBody }()
-	for k, v := range h {
-		_, err := w.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
-		if err != nil {
-			return err
+	return w.framer.WriteTrailers(h)
+}
+
+// wireFramer writes the response in HTTP/1.1 message syntax.
+type wireFramer struct {
+	writer    io.Writer
+	readAhead []byte
+}
+
+func (f *wireFramer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	_, err := f.writer.Write(getStatusLine(statusCode))
+	if err != nil {
+		return err
+	}
+	return f.writeFieldLines(h)
+}
+
+func (f *wireFramer) WriteStatusLine(statusCode StatusCode) error {
+	_, err := f.writer.Write(getStatusLine(statusCode))
+	return err
+}
+
+func (f *wireFramer) WriteHeaders(h headers.Headers) error {
+	return f.writeFieldLines(h)
+}
+
+func (f *wireFramer) WriteBody(p []byte) (int, error) {
+	return f.writer.Write(p)
+}
+
+func (f *wireFramer) ReadFrom(r io.Reader) (int64, error) {
+	if conn, ok := f.writer.(*net.TCPConn); ok && isFile(r) {
+		return conn.ReadFrom(r)
+	}
+	return io.Copy(bodyWriter{f}, r)
+}
+
+func (f *wireFramer) Hijack() (net.Conn, *bufio.Reader, error) {
+	conn, ok := f.writer.(net.Conn)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+	var reader io.Reader = conn
+	if len(f.readAhead) > 0 {
+		readAhead := bytes.Clone(f.readAhead)
+		reader = io.MultiReader(bytes.NewReader(readAhead), conn)
+	}
+	return conn, bufio.NewReader(reader), nil
+}
+
+func (f *wireFramer) WriteChunkedBody(p []byte) (int, error) {
+	chunkSize := len(p)
+
+	nTotal := 0
+	n, err := fmt.Fprintf(f.writer, "%x\r\n", chunkSize)
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+
+	n, err = f.writer.Write(p)
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+
+	n, err = f.writer.Write([]byte("\r\n"))
+	if err != nil {
+		return nTotal, err
+	}
+	nTotal += n
+	return nTotal, nil
+}
+
+func (f *wireFramer) WriteChunkedBodyDone() (int, error) {
+	return f.writer.Write([]byte("0\r\n"))
+}
+
+func (f *wireFramer) WriteTrailers(h headers.Headers) error {
+	return f.writeFieldLines(h)
+}
+
+// writeFieldLines writes each header as a field line followed by the empty
+// line that terminates the block.
+func (f *wireFramer) writeFieldLines(h headers.Headers) error {
+	for k := range h {
+		for _, v := range h.Values(k) {
+			_, err := f.writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
+			if err != nil {
+				return err
+			}
 		}
 	}
-	_, err := w.writer.Write([]byte("\r\n"))
+	_, err := f.writer.Write([]byte("\r\n"))
 	return err
 }
+
+// bodyWriter adapts a Framer's WriteBody to io.Writer. It deliberately
+// hides any ReadFrom method so io.Copy does not recurse into it.
+type bodyWriter struct {
+	framer Framer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.framer.WriteBody(p)
+}
+
+// isFile reports whether the kernel can copy from r directly to a socket.
+func isFile(r io.Reader) bool {
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
+	}
+	_, ok := r.(*os.File)
+	return ok
+}
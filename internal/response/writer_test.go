@@ -0,0 +1,240 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteInformational(t *testing.T) {
+	// Test: Early Hints before the final response
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusCodeEarlyHints, hints))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "2")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n"+
+		"link: </style.css>; rel=preload; as=style\r\n"+
+		"\r\n"+
+		"HTTP/1.1 200 OK\r\n"+
+		"content-length: 2\r\n"+
+		"\r\n"+
+		"ok", buf.String())
+
+	// Test: Multiple interim responses
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteInformational(StatusCodeContinue, headers.NewHeaders()))
+	require.NoError(t, w.WriteInformational(StatusCodeEarlyHints, hints))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n"+
+		"\r\n"+
+		"HTTP/1.1 103 Early Hints\r\n"+
+		"link: </style.css>; rel=preload; as=style\r\n"+
+		"\r\n"+
+		"HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: Interim response after the final status line
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.Error(t, w.WriteInformational(StatusCodeEarlyHints, hints))
+
+	// Test: Non-1xx status code as interim response
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteInformational(StatusCodeSuccess, hints))
+
+	// Test: 101 is not an interim response
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteInformational(StatusCodeSwitchingProtocols, hints))
+
+	// Test: 1xx as the final status line
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteStatusLine(StatusCodeEarlyHints))
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(tb, err)
+	server := <-accepted
+	require.NotNil(tb, server)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func TestHijack(t *testing.T) {
+	// Test: A Writer on a plain io.Writer cannot be hijacked
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+	assert.False(t, w.Hijacked())
+
+	// Test: The connection is handed over with the read-ahead bytes first
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	w = NewConnWriter(server, []byte("read ahead, "))
+	conn, reader, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.True(t, w.Hijacked())
+	go func() {
+		client.Write([]byte("then the connection\n"))
+	}()
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "read ahead, then the connection\n", line)
+
+	// Test: The Writer stops writing
+	_, err = w.WriteBody([]byte("late"))
+	assert.Error(t, err)
+	_, _, err = w.Hijack()
+	assert.Error(t, err)
+}
+
+func TestReadFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "body.txt")
+	content := strings.Repeat("sendfile ", 10000)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	// Test: File body over a TCP connection
+	serverConn, clientConn := tcpPair(t)
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	w := NewWriter(serverConn)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(content))))
+	n, err := w.ReadFrom(io.LimitReader(f, 9))
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+	serverConn.Close()
+	out, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+	_, body, _ := strings.Cut(string(out), "\r\n\r\n")
+	assert.Equal(t, "sendfile ", body)
+
+	// Test: Non-file body through a wrapping framer
+	buf := &bytes.Buffer{}
+	head := NewHeadFramer(NewWriter(buf))
+	w = NewFramedWriter(head)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	n, err = w.ReadFrom(strings.NewReader("discarded"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+	require.NoError(t, head.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 9\r\n\r\n", buf.String())
+
+	// Test: Body before headers
+	w = NewWriter(&bytes.Buffer{})
+	_, err = w.ReadFrom(strings.NewReader("too early"))
+	require.Error(t, err)
+}
+
+const benchmarkFileSize = 16 << 20
+
+func benchmarkFile(b *testing.B) string {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "bench.bin")
+	require.NoError(b, os.WriteFile(path, bytes.Repeat([]byte{0xAB}, benchmarkFileSize), 0o644))
+	return path
+}
+
+// drain reads everything from the connection in the background so the
+// writer never blocks on a full socket buffer.
+func drain(conn net.Conn) {
+	go io.Copy(io.Discard, conn)
+}
+
+// BenchmarkWriteBodyFile measures reading the whole file into memory and
+// writing it with WriteBody.
+func BenchmarkWriteBodyFile(b *testing.B) {
+	path := benchmarkFile(b)
+	serverConn, clientConn := tcpPair(b)
+	drain(clientConn)
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := NewWriter(serverConn)
+		w.WriteStatusLine(StatusCodeSuccess)
+		w.WriteHeaders(GetDefaultHeaders(len(data)))
+		if _, err := w.WriteBody(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadFromFile measures streaming the file with ReadFrom, which
+// uses sendfile on a TCP connection.
+func BenchmarkReadFromFile(b *testing.B) {
+	path := benchmarkFile(b)
+	serverConn, clientConn := tcpPair(b)
+	drain(clientConn)
+	b.SetBytes(benchmarkFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w := NewWriter(serverConn)
+		w.WriteStatusLine(StatusCodeSuccess)
+		w.WriteHeaders(GetDefaultHeaders(benchmarkFileSize))
+		if _, err := w.ReadFrom(f); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	// Test: Default error response
+	buf := &bytes.Buffer{}
+	WriteError(NewWriter(buf), StatusCodeNotFound, nil)
+	resp, err := ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeNotFound, resp.StatusLine.StatusCode)
+	assert.Equal(t, "404 Not Found\n", string(resp.Body))
+	assert.Equal(t, "text/plain", resp.Headers["content-type"])
+
+	// Test: Fields added to ErrorHeaders are sent
+	buf = &bytes.Buffer{}
+	h := ErrorHeaders(StatusCodeMethodNotAllowed)
+	h.Override("Allow", "GET")
+	WriteError(NewWriter(buf), StatusCodeMethodNotAllowed, h)
+	resp, err = ResponseFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", resp.Headers["allow"])
+	assert.Equal(t, "405 Method Not Allowed\n", string(resp.Body))
+}
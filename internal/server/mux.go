@@ -0,0 +1,116 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// AnyMethod registers a route that matches every request method.
+const AnyMethod = ""
+
+// Mux routes requests to handlers by method and path. A pattern ending in
+// "/" matches every path under it, and the longest matching pattern wins.
+// HEAD requests fall back to the GET handler of a route that has no
+// explicit HEAD handler.
+type Mux struct {
+	routes   map[string]map[string]Handler
+	NotFound Handler
+	// Connect handles CONNECT requests, whose authority-form target is not
+	// a path. Without it they are answered with 405.
+	Connect Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{
+		routes: map[string]map[string]Handler{},
+	}
+}
+
+func (m *Mux) Handle(method, pattern string, handler Handler) {
+	methods, ok := m.routes[pattern]
+	if !ok {
+		methods = map[string]Handler{}
+		m.routes[pattern] = methods
+	}
+	methods[method] = handler
+}
+
+// Serve dispatches the request to the matching handler. It has the shape of
+// a Handler, so a Mux can be passed directly to Serve.
+func (m *Mux) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		if m.Connect == nil {
+			response.WriteError(w, response.StatusCodeMethodNotAllowed, nil)
+			return
+		}
+		m.Connect(w, req)
+		return
+	}
+	methods, ok := m.match(RequestPath(req.RequestLine.RequestTarget))
+	if !ok {
+		if m.NotFound != nil {
+			m.NotFound(w, req)
+			return
+		}
+		response.WriteError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+	handler, ok := lookupMethod(methods, req.RequestLine.Method)
+	if !ok {
+		allowed := make([]string, 0, len(methods))
+		for method := range methods {
+			allowed = append(allowed, method)
+		}
+		if _, hasGet := methods["GET"]; hasGet {
+			if _, hasHead := methods["HEAD"]; !hasHead {
+				allowed = append(allowed, "HEAD")
+			}
+		}
+		sort.Strings(allowed)
+		h := response.ErrorHeaders(response.StatusCodeMethodNotAllowed)
+		h.Override("Allow", strings.Join(allowed, ", "))
+		response.WriteError(w, response.StatusCodeMethodNotAllowed, h)
+		return
+	}
+	handler(w, req)
+}
+
+func (m *Mux) match(path string) (map[string]Handler, bool) {
+	if methods, ok := m.routes[path]; ok {
+		return methods, true
+	}
+	best := ""
+	for pattern := range m.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return nil, false
+	}
+	return m.routes[best], true
+}
+
+func lookupMethod(methods map[string]Handler, method string) (Handler, bool) {
+	if handler, ok := methods[method]; ok {
+		return handler, true
+	}
+	if method == "HEAD" {
+		if handler, ok := methods["GET"]; ok {
+			return handler, true
+		}
+	}
+	handler, ok := methods[AnyMethod]
+	return handler, ok
+}
+
+// RequestPath strips the query string from an origin-form request target.
+func RequestPath(target string) string {
+	if idx := strings.IndexByte(target, '?'); idx != -1 {
+		return target[:idx]
+	}
+	return target
+}
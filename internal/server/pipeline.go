@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// lingerTimeout bounds how long a connection being closed is drained.
+const lingerTimeout = 500 * time.Millisecond
+
+// safeMethods may be handled concurrently when pipelined, RFC 9112
+// section 9.3.2, as none of them is meant to change anything. PUT and
+// DELETE are idempotent too, but left out: a later request may depend on
+// what an earlier one changed, so they are handled in order.
+var safeMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+}
+
+// messageFramer sits between a handler and the Writer of its connection.
+// It watches the final header block and the body go by, to tell whether
+// the response ended in a way that lets the connection carry another one.
+type messageFramer struct {
+	w   *response.Writer
+	req *request.Request
+
+	status          response.StatusCode
+	header          headers.Headers
+	contentLength   int
+	written         int
+	chunkedDone     bool
+	trailersWritten bool
+	err             error
+}
+
+func newMessageFramer(w *response.Writer, req *request.Request) *messageFramer {
+	return &messageFramer{w: w, req: req, contentLength: -1}
+}
+
+func (f *messageFramer) track(err error) error {
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return err
+}
+
+func (f *messageFramer) WriteInformational(statusCode response.StatusCode, h headers.Headers) error {
+	return f.track(f.w.WriteInformational(statusCode, h))
+}
+
+func (f *messageFramer) WriteStatusLine(statusCode response.StatusCode) error {
+	f.status = statusCode
+	return f.track(f.w.WriteStatusLine(statusCode))
+}
+
+func (f *messageFramer) WriteHeaders(h headers.Headers) error {
+	f.header = h
+	if n, err := h.ContentLength(); err == nil {
+		f.contentLength = int(n)
+	}
+	return f.track(f.w.WriteHeaders(h))
+}
+
+func (f *messageFramer) WriteBody(p []byte) (int, error) {
+	n, err := f.w.WriteBody(p)
+	f.written += n
+	return n, f.track(err)
+}
+
+func (f *messageFramer) ReadFrom(r io.Reader) (int64, error) {
+	n, err := f.w.ReadFrom(r)
+	f.written += int(n)
+	return n, f.track(err)
+}
+
+func (f *messageFramer) WriteChunkedBody(p []byte) (int, error) {
+	n, err := f.w.WriteChunkedBody(p)
+	return n, f.track(err)
+}
+
+func (f *messageFramer) WriteChunkedBodyDone() (int, error) {
+	n, err := f.w.WriteChunkedBodyDone()
+	f.chunkedDone = err == nil
+	return n, f.track(err)
+}
+
+func (f *messageFramer) WriteTrailers(h headers.Headers) error {
+	f.trailersWritten = true
+	return f.track(f.w.WriteTrailers(h))
+}
+
+func (f *messageFramer) Flush() error {
+	return f.track(f.w.Flush())
+}
+
+func (f *messageFramer) Hijack() (net.Conn, *bufio.Reader, error) {
+	return f.w.Hijack()
+}
+
+// finish completes a chunked body the handler left open once it has
+// returned, and reports whether the connection can be kept alive.
+func (f *messageFramer) finish() bool {
+	if f.err != nil || f.header == nil || f.status == response.StatusCodeSwitchingProtocols {
+		// nothing, or not a whole response, was written
+		return false
+	}
+	if value, ok := f.req.Headers.Get("Connection"); ok && hasToken(value, "close") {
+		return false
+	}
+	if value, ok := f.header.Get("Connection"); ok && hasToken(value, "close") {
+		return false
+	}
+	if f.req.RequestLine.Method == "HEAD" || f.status == response.StatusCodeNoContent ||
+		f.status == response.StatusCodeNotModified {
+		return true
+	}
+	if value, ok := f.header.Get("Transfer-Encoding"); ok && hasToken(value, "chunked") {
+		if !f.chunkedDone {
+			f.WriteChunkedBodyDone()
+		}
+		if !f.trailersWritten {
+			f.WriteTrailers(headers.NewHeaders())
+		}
+		return f.err == nil
+	}
+	// without a length the body is delimited by closing the connection
+	return f.contentLength >= 0 && f.written == f.contentLength
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRequest runs the handler with the response going to w, and reports
+// whether the connection can carry another response afterwards and
+// whether the handler took it over.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) (keepAlive, hijacked bool) {
+	f := newMessageFramer(w, req)
+	if req.RequestLine.Method == "HEAD" {
+		head := response.NewHeadFramer(f)
+		s.handler(response.NewFramedWriter(head), req)
+		if err := f.track(head.Finish()); err != nil {
+			log.Printf("Error writing HEAD response headers: %v", err)
+		}
+	} else {
+		s.handler(response.NewFramedWriter(f), req)
+	}
+	if w.Hijacked() {
+		return false, true
+	}
+	return f.finish(), false
+}
+
+// pipeline orders the responses to requests handled concurrently on one
+// connection.
+type pipeline struct {
+	srv  *Server
+	conn net.Conn
+	// slots bounds the requests in flight
+	slots chan struct{}
+	// last is closed once the response to the latest request is written
+	last chan struct{}
+
+	mu      sync.Mutex
+	closing bool
+}
+
+func newPipeline(srv *Server, conn net.Conn, depth int) *pipeline {
+	last := make(chan struct{})
+	close(last)
+	return &pipeline{srv: srv, conn: conn, slots: make(chan struct{}, depth), last: last}
+}
+
+// concurrent reports whether req can be handled while earlier requests
+// are, and the next one read before it is answered: it has to be safe,
+// and neither end the connection nor ask to take it over.
+func (p *pipeline) concurrent(req *request.Request) bool {
+	if cap(p.slots) < 2 || !safeMethods[req.RequestLine.Method] {
+		return false
+	}
+	if _, ok := req.Headers.Get("Upgrade"); ok {
+		return false
+	}
+	value, ok := req.Headers.Get("Connection")
+	return !ok || !hasToken(value, "close")
+}
+
+// wait waits for every response so far to be written, and reports whether
+// the connection may go on.
+func (p *pipeline) wait() bool {
+	<-p.last
+	return !p.isClosing()
+}
+
+func (p *pipeline) isClosing() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closing
+}
+
+// close ends the connection after the response just written. Only the
+// sending side is shut for now, and the read loop woken up to finish.
+func (p *pipeline) close() {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	if cw, ok := p.conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	p.conn.SetReadDeadline(time.Now())
+}
+
+// shutdown closes the connection once the last response is written.
+// Requests the client sent that will go unanswered are read and thrown
+// away for a while first, as closing with unread data makes TCP reset the
+// connection and the client may lose the responses it has not read yet.
+func (p *pipeline) shutdown() {
+	<-p.last
+	if !p.isClosing() {
+		p.close()
+	}
+	p.conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, p.conn)
+	p.conn.Close()
+}
+
+// start handles req concurrently with the requests before it. Its response
+// is buffered until theirs are written, and then streamed.
+func (p *pipeline) start(req *request.Request) {
+	p.slots <- struct{}{}
+	prev := p.last
+	done := make(chan struct{})
+	p.last = done
+	out := &orderedWriter{conn: p.conn}
+	turn := make(chan struct{})
+	go func() {
+		<-prev
+		out.takeTurn(p.isClosing())
+		close(turn)
+	}()
+	go func() {
+		defer func() { <-p.slots }()
+		keepAlive, _ := p.srv.serveRequest(response.NewWriter(out), req)
+		<-turn
+		if !keepAlive && !p.isClosing() {
+			p.close()
+		}
+		close(done)
+	}()
+}
+
+// orderedWriter holds a response back until it is its turn on the
+// connection, then passes it straight through.
+type orderedWriter struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	turn    bool
+	discard bool
+}
+
+func (w *orderedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case w.discard:
+		return 0, net.ErrClosed
+	case w.turn:
+		return w.conn.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// takeTurn writes out what was held back, unless an earlier response
+// ended the connection, and lets later writes through.
+func (w *orderedWriter) takeTurn(discard bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turn = true
+	w.discard = discard
+	if !discard && w.buf.Len() > 0 {
+		if _, err := w.conn.Write(w.buf.Bytes()); err != nil {
+			w.discard = true
+		}
+	}
+	w.buf = bytes.Buffer{}
+}
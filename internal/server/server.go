@@ -1,39 +1,96 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"slices"
 	"sync/atomic"
 
+	"github.com/KrishKoria/HTTPfromTCP/internal/http2"
+	"github.com/KrishKoria/HTTPfromTCP/internal/http3"
+	"github.com/KrishKoria/HTTPfromTCP/internal/quic"
 	"github.com/KrishKoria/HTTPfromTCP/internal/request"
 	"github.com/KrishKoria/HTTPfromTCP/internal/response"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
-// Server is an HTTP 1.1 server
+// Server is an HTTP 1.1 server. Clients may also speak HTTP/2 over
+// cleartext, either with prior knowledge or by upgrading from HTTP/1.1,
+// and the same handler can be served over HTTP/3 with ServeHTTP3.
+// HTTP/1.1 connections are kept alive for as long as the responses allow,
+// and pipelined requests are answered in order.
 type Server struct {
 	handler  Handler
+	options  Options
 	listener net.Listener
+	h3       *quic.Listener
 	closed   atomic.Bool
 }
 
+// Options tune how HTTP/1.1 connections are served.
+type Options struct {
+	// PipelineDepth is how many pipelined requests on a connection may be
+	// handled at once. Only requests with a safe method, such as GET and
+	// HEAD, are handled concurrently, and responses are always sent in the
+	// order of the requests. 0 or 1 handles one request at a time.
+	PipelineDepth int
+	// MaxBodySize bounds the body of an HTTP/1.1 request, which is read
+	// into memory before the handler runs; larger ones get 413 Content Too
+	// Large. Zero means request.DefaultMaxBodySize and a negative value
+	// means no limit.
+	MaxBodySize int64
+}
+
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(port, handler, Options{})
+}
+
+func ServeWithOptions(port int, handler Handler, options Options) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
 	s := &Server{
 		handler:  handler,
+		options:  options,
 		listener: listener,
 	}
 	go s.listen()
 	return s, nil
 }
 
+// ServeHTTP3 also serves the handler over HTTP/3 on a UDP port, which is
+// experimental. tlsConfig needs a certificate; the h3 protocol is added to
+// its NextProtos if missing.
+func (s *Server) ServeHTTP3(port int, tlsConfig *tls.Config) error {
+	tlsConfig = tlsConfig.Clone()
+	if !slices.Contains(tlsConfig.NextProtos, http3.NextProto) {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, http3.NextProto)
+	}
+	l, err := quic.Listen(fmt.Sprintf(":%d", port), tlsConfig, nil)
+	if err != nil {
+		return err
+	}
+	s.h3 = l
+	h3 := &http3.Server{Handler: http3.Handler(s.handler)}
+	go func() {
+		if err := h3.Serve(l); err != nil && !s.closed.Load() {
+			log.Printf("Error serving HTTP/3: %v", err)
+		}
+	}()
+	return nil
+}
+
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	if s.h3 != nil {
+		s.h3.Close()
+	}
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -55,16 +112,80 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.StatusCodeBadRequest)
-		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+	reader := request.NewReader(conn)
+	reader.MaxBodySize = s.options.MaxBodySize
+	if hasHTTP2Preface(reader) {
+		h2 := &http2.Server{Handler: http2.Handler(s.handler)}
+		if err := h2.ServeConn(conn, reader.Buffered()); err != nil {
+			log.Printf("Error serving HTTP/2 connection: %v", err)
+		}
 		return
 	}
-	s.handler(w, req)
-	return
+	s.serveHTTP1(conn, reader)
+}
+
+// serveHTTP1 serves the requests on a persistent connection until either
+// side ends it. Requests the client pipelined are read ahead of their
+// responses, and handled concurrently if the Options allow.
+func (s *Server) serveHTTP1(conn net.Conn, reader *request.Reader) {
+	p := newPipeline(s, conn, max(s.options.PipelineDepth, 1))
+	for {
+		req, err := reader.ReadRequest()
+		if err == io.EOF || err != nil && !p.wait() {
+			p.shutdown()
+			return
+		}
+		if err != nil {
+			statusCode := response.StatusCodeBadRequest
+			if errors.Is(err, request.ErrBodyTooLarge) {
+				statusCode = response.StatusCodeContentTooLarge
+			}
+			w := response.NewWriter(conn)
+			w.WriteStatusLine(statusCode)
+			body := []byte(fmt.Sprintf("Error parsing request: %v", err))
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+			p.shutdown()
+			return
+		}
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if p.concurrent(req) {
+			p.start(req)
+			continue
+		}
+		if !p.wait() {
+			p.shutdown()
+			return
+		}
+		if http2.IsUpgradeRequest(req) {
+			h2 := &http2.Server{Handler: http2.Handler(s.handler)}
+			if err := h2.ServeUpgrade(conn, reader.Buffered(), req); err != nil {
+				log.Printf("Error serving upgraded HTTP/2 connection: %v", err)
+			}
+			conn.Close()
+			return
+		}
+		keepAlive, hijacked := s.serveRequest(response.NewConnWriter(conn, reader.Buffered()), req)
+		if hijacked {
+			// a hijacked connection belongs to the handler
+			return
+		}
+		if !keepAlive {
+			p.shutdown()
+			return
+		}
+	}
+}
+
+// hasHTTP2Preface reports whether the client opened the connection with the
+// HTTP/2 preface. It stops reading as soon as the bytes diverge from it, so
+// an HTTP/1.1 request shorter than the preface does not stall.
+func hasHTTP2Preface(reader *request.Reader) bool {
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		b, err := reader.Peek(n)
+		if err != nil || b[n-1] != http2.ClientPreface[n-1] {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/http3"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip starts a server with the handler, sends the raw request and
+// returns everything the server wrote before closing the connection.
+func roundTrip(t *testing.T, handler Handler, raw string) string {
+	t.Helper()
+	return roundTripWithOptions(t, handler, Options{}, raw)
+}
+
+// roundTripWithOptions is roundTrip with a server started with options.
+// The client shuts its sending side once raw is written, which makes the
+// server close a persistent connection after the last response.
+func roundTripWithOptions(t *testing.T, handler Handler, options Options, raw string) string {
+	t.Helper()
+	s, err := ServeWithOptions(0, handler, options)
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func htmlHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("<h1>hello</h1>")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "14")
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func chunkedHandler(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("hello"))
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "5")
+	w.WriteTrailers(trailers)
+}
+
+func TestHeadRequest(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/", htmlHandler)
+	mux.Handle("GET", "/chunked", chunkedHandler)
+	mux.Handle("POST", "/submit", htmlHandler)
+
+	// Test: GET writes the body
+	out := roundTrip(t, mux.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 14\r\n\r\n<h1>hello</h1>", out)
+
+	// Test: HEAD falls back to the GET route and drops the body
+	out = roundTrip(t, mux.Serve, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 14\r\n\r\n", out)
+
+	// Test: HEAD drops chunked bodies and trailers
+	out = roundTrip(t, mux.Serve, "HEAD /chunked HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n", out)
+
+	// Test: HEAD does not fall back to other methods
+	out = roundTrip(t, mux.Serve, "HEAD /submit HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 405 Method Not Allowed\r\n")
+	assert.Contains(t, out, "allow: POST\r\n")
+	assert.NotContains(t, out, "405 Method Not Allowed\n")
+
+	// Test: Explicit HEAD handlers win over GET
+	mux.Handle("HEAD", "/", func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeNotFound)
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("not here"))
+	})
+	out = roundTrip(t, mux.Serve, "HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\ncontent-length: 8\r\n\r\n", out)
+}
+
+func TestMuxRouting(t *testing.T) {
+	called := ""
+	route := func(name string) Handler {
+		return func(w *response.Writer, _ *request.Request) {
+			called = name
+		}
+	}
+	mux := NewMux()
+	mux.Handle("GET", "/", route("root"))
+	mux.Handle("GET", "/static/", route("static"))
+	mux.Handle("GET", "/static/img/", route("img"))
+	mux.Handle(AnyMethod, "/exact", route("exact"))
+
+	cases := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{"GET", "/", "root"},
+		{"GET", "/missing", "root"},
+		{"GET", "/static/app.js", "static"},
+		{"GET", "/static/img/logo.png?v=2", "img"},
+		{"DELETE", "/exact", "exact"},
+		{"HEAD", "/static/app.js", "static"},
+	}
+	for _, c := range cases {
+		called = ""
+		req := &request.Request{RequestLine: request.RequestLine{Method: c.method, RequestTarget: c.target}}
+		mux.Serve(response.NewWriter(io.Discard), req)
+		assert.Equal(t, c.want, called, "%s %s", c.method, c.target)
+	}
+}
+
+func TestChunkedTrailers(t *testing.T) {
+	s, err := Serve(0, chunkedHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	resp, err := response.ResponseFromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, "5", resp.Trailers["x-content-length"])
+}
+
+func TestHijack(t *testing.T) {
+	handler := func(w *response.Writer, _ *request.Request) {
+		conn, reader, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		// the connection outlives the handler
+		go func() {
+			defer conn.Close()
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil || line == "quit\n" {
+					return
+				}
+				conn.Write([]byte("echo: " + line))
+			}
+		}()
+	}
+
+	// Test: Bytes sent along with the request reach the handler first
+	out := roundTrip(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\none\ntwo\nquit\n")
+	assert.Equal(t, "echo: one\necho: two\n", out)
+}
+
+func TestMuxConnect(t *testing.T) {
+	mux := NewMux()
+	mux.Handle(AnyMethod, "/", htmlHandler)
+	req := &request.Request{RequestLine: request.RequestLine{Method: "CONNECT", RequestTarget: "example.com:443"}}
+
+	// Test: CONNECT is refused without a Connect handler
+	buf := &bytes.Buffer{}
+	mux.Serve(response.NewWriter(buf), req)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 405 Method Not Allowed\r\n"))
+
+	// Test: CONNECT goes to the Connect handler whatever the routes
+	called := false
+	mux.Connect = func(*response.Writer, *request.Request) { called = true }
+	mux.Serve(response.NewWriter(io.Discard), req)
+	assert.True(t, called)
+}
+
+func TestHTTP2(t *testing.T) {
+	s, err := Serve(0, htmlHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	url := "http://" + s.listener.Addr().String() + "/"
+
+	// Test: Clients with prior knowledge are served over HTTP/2
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	defer tr.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: tr}).Get(url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "<h1>hello</h1>", string(body))
+
+	// Test: Requests shorter than the preface are still read as HTTP/1.1
+	out := roundTrip(t, htmlHandler, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestHTTP3(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	s, err := Serve(0, htmlHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	err = s.ServeHTTP3(0, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	require.NoError(t, err)
+
+	// Test: The handler is served over HTTP/3 too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := http3.Dial(ctx, s.h3.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer cc.Close()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "3.0"},
+		Headers:     headers.NewHeaders(),
+	}
+	req.Headers.Set("Host", "localhost")
+	resp, err := cc.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, resp.StatusLine.StatusCode)
+	assert.Equal(t, "<h1>hello</h1>", string(resp.Body))
+}
+
+// pathHandler answers with the request target as the body, after sleeping
+// for the duration in the query string, if any.
+func pathHandler(w *response.Writer, req *request.Request) {
+	path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if d, err := time.ParseDuration(query); err == nil {
+		time.Sleep(d)
+	}
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(len(path)))
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(path))
+}
+
+// readResponses parses the responses in out, to GET requests, and lists
+// each as its status code and body.
+func readResponses(t *testing.T, out string) []string {
+	t.Helper()
+	var resps []string
+	r := bufio.NewReader(strings.NewReader(out))
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return resps
+		}
+		resp, err := http.ReadResponse(r, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resps = append(resps, fmt.Sprintf("%d %s", resp.StatusCode, body))
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	raw := "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+		"HEAD /c HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /d HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Requests sent in one write are all answered, in order
+	out := roundTrip(t, pathHandler, raw)
+	assert.Equal(t, 4, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/d"))
+	aIdx, bIdx, dIdx := strings.Index(out, "/a"), strings.Index(out, "/b"), strings.Index(out, "/d")
+	assert.True(t, aIdx < bIdx && bIdx < dIdx)
+
+	// Test: Connection: close ends the connection after its response
+	raw = "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out = roundTripWithOptions(t, pathHandler, Options{PipelineDepth: 4}, raw)
+	assert.Equal(t, []string{"200 /a", "200 /b"}, readResponses(t, out))
+
+	// Test: A response delimited by the end of the connection is the last
+	closeDelimited := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte(req.RequestLine.RequestTarget))
+	}
+	raw = "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out = roundTrip(t, closeDelimited, raw)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\n/a", out)
+
+	// Test: A malformed request is answered after the ones before it
+	raw = "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.0\r\n\r\n"
+	out = roundTripWithOptions(t, pathHandler, Options{PipelineDepth: 4}, raw)
+	resps := readResponses(t, out)
+	require.Len(t, resps, 2)
+	assert.Equal(t, "200 /a", resps[0])
+	assert.True(t, strings.HasPrefix(resps[1], "400 "))
+
+	// Test: Responses built on the default headers, and the mux's own
+	// errors, keep the connection open
+	mux := NewMux()
+	mux.Handle("GET", "/a", func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("/a"))
+	})
+	raw = "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n" +
+		"GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out = roundTripWithOptions(t, mux.Serve, Options{PipelineDepth: 4}, raw)
+	assert.Equal(t, []string{"200 /a", "404 404 Not Found\n", "405 405 Method Not Allowed\n", "200 /a"}, readResponses(t, out))
+}
+
+func TestMaxBodySize(t *testing.T) {
+	called := false
+	handler := func(w *response.Writer, req *request.Request) {
+		called = true
+		pathHandler(w, req)
+	}
+
+	// Test: A body over the limit is refused without running the handler
+	raw := "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world"
+	out := roundTripWithOptions(t, handler, Options{MaxBodySize: 10}, raw)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"), out)
+	assert.False(t, called)
+
+	// Test: Bodies within it are served
+	out = roundTripWithOptions(t, handler, Options{MaxBodySize: 11}, raw)
+	assert.Equal(t, []string{"200 /a"}, readResponses(t, out))
+}
+
+func TestPipelineConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	var events []string
+	// the server only waits for the handlers before closing the connection,
+	// which the race detector cannot see
+	observe := func() (int, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxInFlight, events
+	}
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		maxInFlight, events = 0, nil
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		events = append(events, "start "+req.RequestLine.Method)
+		mu.Unlock()
+		pathHandler(w, req)
+		mu.Lock()
+		inFlight--
+		events = append(events, "end "+req.RequestLine.Method)
+		mu.Unlock()
+	}
+
+	// Test: Safe requests run concurrently and are answered in order, the
+	// slowest first
+	raw := "GET /a?60ms HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /b?30ms HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"OPTIONS /c?30ms HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /d HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out := roundTripWithOptions(t, handler, Options{PipelineDepth: 3}, raw)
+	assert.Equal(t, []string{"200 /a", "200 /b", "200 /c", "200 /d"}, readResponses(t, out))
+	n, _ := observe()
+	assert.Equal(t, 3, n)
+
+	// Test: An unsafe request waits for the ones before it, and the ones
+	// after it wait for it
+	reset()
+	raw = "GET /a?30ms HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out = roundTripWithOptions(t, handler, Options{PipelineDepth: 3}, raw)
+	assert.Equal(t, []string{"200 /a", "200 /b", "200 /c"}, readResponses(t, out))
+	_, got := observe()
+	assert.Equal(t, []string{"start GET", "end GET", "start POST", "end POST", "start GET", "end GET"}, got)
+
+	// Test: Without a depth, pipelined requests are handled one at a time
+	reset()
+	raw = "GET /a?30ms HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	out = roundTrip(t, handler, raw)
+	assert.Equal(t, []string{"200 /a", "200 /b"}, readResponses(t, out))
+	n, _ = observe()
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxCookieSize is the most browsers are sure to keep for a cookie.
+const maxCookieSize = 4096
+
+var errInvalidValue = errors.New("session: invalid cookie value")
+
+// Codec protects cookie values with a list of keys. The first key seals
+// new values and all of them are tried to open one, so a key is rotated
+// by putting a new one first and dropping the old one once the cookies it
+// sealed have expired.
+type Codec struct {
+	keys  [][]byte
+	aeads []cipher.AEAD
+}
+
+// NewSigner returns a Codec that signs values with HMAC-SHA256. Values
+// stay readable by the client. Keys must be at least 32 bytes.
+func NewSigner(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("session: no keys")
+	}
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("session: signing key shorter than 32 bytes")
+		}
+	}
+	return &Codec{keys: keys}, nil
+}
+
+// NewEncrypter returns a Codec that encrypts and authenticates values with
+// AES-GCM. Keys must be 16, 24 or 32 bytes.
+func NewEncrypter(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("session: no keys")
+	}
+	c := &Codec{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Seal protects data as a cookie-safe string.
+func (c *Codec) Seal(data []byte) string {
+	if c.aeads != nil {
+		aead := c.aeads[0]
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+		rand.Read(nonce)
+		return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil))
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(c.keys[0], payload))
+}
+
+// Open returns the data sealed in value, if any of the keys sealed it.
+func (c *Codec) Open(value string) ([]byte, error) {
+	if c.aeads != nil {
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errInvalidValue
+		}
+		for _, aead := range c.aeads {
+			if len(sealed) < aead.NonceSize() {
+				return nil, errInvalidValue
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			if data, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+				return data, nil
+			}
+		}
+		return nil, errInvalidValue
+	}
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errInvalidValue
+	}
+	got, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil {
+		return nil, errInvalidValue
+	}
+	for _, key := range c.keys {
+		if hmac.Equal(got, sign(key, payload)) {
+			return base64.RawURLEncoding.DecodeString(payload)
+		}
+	}
+	return nil, errInvalidValue
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// CookieStore keeps the whole session in the cookie, sealed by a Codec,
+// so the server holds no state. Deleting a session cannot revoke a copy
+// of its cookie; only the timeouts of the Manager limit its use.
+type CookieStore struct {
+	codec *Codec
+}
+
+func NewCookieStore(codec *Codec) *CookieStore {
+	return &CookieStore{codec: codec}
+}
+
+// Load opens the record in a cookie value. Values that do not open, being
+// forged, corrupt or sealed by a retired key, are not found.
+func (s *CookieStore) Load(value string) (*Record, error) {
+	data, err := s.codec.Open(value)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+func (s *CookieStore) Save(r *Record) (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	value := s.codec.Seal(data)
+	if len(value) > maxCookieSize {
+		return "", fmt.Errorf("session: %d byte cookie is too large", len(value))
+	}
+	return value, nil
+}
+
+func (s *CookieStore) Delete(string) error {
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package session keeps per-client state across requests, identified by a
+// cookie. A Manager wraps a server.Handler, loads the session named by the
+// request cookie from a Store before the handler runs, and saves it and
+// sets the cookie when the handler writes its response headers.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/KrishKoria/HTTPfromTCP/internal/server"
+)
+
+const (
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// ErrNotFound is returned by a Store for a cookie that names no session.
+var ErrNotFound = errors.New("session: not found")
+
+// Record is the state of a session a Store keeps.
+type Record struct {
+	ID       string            `json:"id"`
+	Values   map[string]string `json:"values"`
+	Created  time.Time         `json:"created"`
+	Accessed time.Time         `json:"accessed"`
+}
+
+// Store keeps session records. The cookie value sent to the client is
+// whatever Save returns: the session ID for stores on the server, the
+// whole record for a CookieStore.
+type Store interface {
+	// Load returns the record the cookie value refers to, or ErrNotFound.
+	Load(value string) (*Record, error)
+	// Save stores r and returns the cookie value for it.
+	Save(r *Record) (string, error)
+	// Delete forgets the session with the given ID.
+	Delete(id string) error
+}
+
+// Session is the session of the request being handled. Changes made after
+// the response headers are written are not saved, as the cookie has gone
+// out by then.
+type Session struct {
+	mu        sync.Mutex
+	record    Record
+	oldID     string
+	isNew     bool
+	modified  bool
+	destroyed bool
+	hadCookie bool
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.ID
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.record.Values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.record.Values, key)
+	s.modified = true
+}
+
+// Regenerate gives the session a new ID, keeping its values, and forgets
+// the old one. Call it whenever the privileges of the client change, as
+// on login, so that an ID planted or seen before cannot be used after.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.record.ID
+	}
+	s.record.ID = newID()
+	s.modified = true
+}
+
+// Destroy ends the session, as on logout, and clears the cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// Manager is middleware that gives each request a session.
+type Manager struct {
+	Store Store
+	// Cookie holds the name and attributes of the session cookie. Its
+	// value and lifetime are set by the Manager.
+	Cookie response.Cookie
+	// IdleTimeout ends sessions not used for that long, and
+	// AbsoluteTimeout sessions created that long ago however much they
+	// are used. Zero means no limit.
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+
+	active sync.Map // *request.Request to *Session
+	now    func() time.Time
+}
+
+// New returns a Manager keeping sessions in store, with a session cookie
+// for the whole site that scripts cannot read.
+func New(store Store) *Manager {
+	return &Manager{
+		Store: store,
+		Cookie: response.Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: response.SameSiteLax,
+		},
+		IdleTimeout:     DefaultIdleTimeout,
+		AbsoluteTimeout: DefaultAbsoluteTimeout,
+		now:             time.Now,
+	}
+}
+
+// Wrap returns a handler that runs next with the session of the request
+// available from Session.
+func (m *Manager) Wrap(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		m.active.Store(req, s)
+		defer m.active.Delete(req)
+		next(response.NewFramedWriter(&sessionFramer{Writer: w, m: m, s: s}), req)
+	}
+}
+
+// Session returns the session of a request being handled by Wrap, or nil.
+func (m *Manager) Session(req *request.Request) *Session {
+	s, ok := m.active.Load(req)
+	if !ok {
+		return nil
+	}
+	return s.(*Session)
+}
+
+// load finds the session the request cookie names. A missing, forged or
+// expired session is replaced by a new one, which is only saved if the
+// handler puts something in it.
+func (m *Manager) load(req *request.Request) *Session {
+	value, hadCookie := req.Cookie(m.Cookie.Name)
+	if hadCookie {
+		r, err := m.Store.Load(value)
+		switch {
+		case err == nil && !m.expired(r):
+			if r.Values == nil {
+				r.Values = map[string]string{}
+			}
+			return &Session{record: *r, hadCookie: true}
+		case err == nil:
+			m.Store.Delete(r.ID)
+		case !errors.Is(err, ErrNotFound):
+			log.Printf("Error loading session: %v", err)
+		}
+	}
+	now := m.now()
+	return &Session{
+		record:    Record{ID: newID(), Values: map[string]string{}, Created: now, Accessed: now},
+		isNew:     true,
+		hadCookie: hadCookie,
+	}
+}
+
+func (m *Manager) expired(r *Record) bool {
+	now := m.now()
+	return m.IdleTimeout > 0 && now.Sub(r.Accessed) >= m.IdleTimeout ||
+		m.AbsoluteTimeout > 0 && now.Sub(r.Created) >= m.AbsoluteTimeout
+}
+
+// commit saves the session and adds its cookie to the response headers.
+func (m *Manager) commit(s *Session, h headers.Headers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID != "" {
+		m.Store.Delete(s.oldID)
+	}
+	if s.destroyed || s.isNew && !s.modified {
+		if !s.isNew {
+			m.Store.Delete(s.record.ID)
+		}
+		if s.hadCookie {
+			m.setCookie(h, "", -1)
+		}
+		return
+	}
+
+	now := m.now()
+	s.record.Accessed = now
+	record := s.record
+	record.Values = maps.Clone(s.record.Values)
+	value, err := m.Store.Save(&record)
+	if err != nil {
+		log.Printf("Error saving session: %v", err)
+		return
+	}
+	// the cookie lasts as long as the session can
+	var lifetime time.Duration
+	if m.IdleTimeout > 0 {
+		lifetime = m.IdleTimeout
+	}
+	if m.AbsoluteTimeout > 0 {
+		left := s.record.Created.Add(m.AbsoluteTimeout).Sub(now)
+		if lifetime == 0 || left < lifetime {
+			lifetime = left
+		}
+	}
+	m.setCookie(h, value, int(lifetime/time.Second))
+}
+
+func (m *Manager) setCookie(h headers.Headers, value string, maxAge int) {
+	c := m.Cookie
+	c.Value = value
+	c.MaxAge = maxAge
+	if err := response.SetCookie(h, &c); err != nil {
+		log.Printf("Error setting session cookie: %v", err)
+	}
+}
+
+// sessionFramer commits the session when the response headers go out.
+type sessionFramer struct {
+	*response.Writer
+	m *Manager
+	s *Session
+}
+
+func (f *sessionFramer) WriteHeaders(h headers.Headers) error {
+	f.m.commit(f.s, h)
+	return f.Writer.WriteHeaders(h)
+}
+
+// newID returns a random session ID, 256 bits in URL-safe base64.
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,279 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a fake time source tests move forward by hand.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// serve runs handler behind m for a request carrying cookie, if any, and
+// returns the Set-Cookie values of the response.
+func serve(t *testing.T, m *Manager, cookie string, handler func(*Session)) []string {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	if cookie != "" {
+		req.Headers.Set("Cookie", cookie)
+	}
+	buf := &bytes.Buffer{}
+	m.Wrap(func(w *response.Writer, req *request.Request) {
+		handler(m.Session(req))
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})(response.NewWriter(buf), req)
+	resp, err := response.ResponseFromReader(buf)
+	require.NoError(t, err)
+	return resp.Headers.Values("Set-Cookie")
+}
+
+// cookiePair returns the name=value part of a Set-Cookie value.
+func cookiePair(setCookie []string) string {
+	if len(setCookie) == 0 {
+		return ""
+	}
+	pair, _, _ := strings.Cut(setCookie[0], ";")
+	return pair
+}
+
+func newTestManager(store Store) (*Manager, *clock) {
+	c := &clock{t: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}
+	m := New(store)
+	m.now = c.now
+	return m, c
+}
+
+func TestManager(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	m, c := newTestManager(store)
+	store.now = c.now
+
+	// Test: Untouched new sessions are not saved
+	assert.Empty(t, serve(t, m, "", func(s *Session) {}))
+	assert.Equal(t, 0, store.Len())
+
+	// Test: A session set up by the handler gets a cookie
+	setCookie := serve(t, m, "", func(s *Session) { s.Set("user", "krish") })
+	require.Len(t, setCookie, 1)
+	assert.Contains(t, setCookie[0], "; Max-Age=1800; Path=/; HttpOnly; SameSite=Lax")
+	cookie := cookiePair(setCookie)
+
+	// Test: The next request finds the session by its cookie
+	var user string
+	var ok bool
+	serve(t, m, "other=1; "+cookie, func(s *Session) { user, ok = s.Get("user") })
+	assert.True(t, ok)
+	assert.Equal(t, "krish", user)
+
+	// Test: Regenerating changes the ID, keeps values and drops the old ID
+	var oldID, newID string
+	setCookie = serve(t, m, cookie, func(s *Session) {
+		oldID = s.ID()
+		s.Regenerate()
+		newID = s.ID()
+	})
+	assert.NotEqual(t, oldID, newID)
+	assert.Equal(t, "session="+newID, cookiePair(setCookie))
+	_, err := store.Load(oldID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	serve(t, m, cookie, func(s *Session) { _, ok = s.Get("user") })
+	assert.False(t, ok)
+	cookie = cookiePair(setCookie)
+	serve(t, m, cookie, func(s *Session) { user, _ = s.Get("user") })
+	assert.Equal(t, "krish", user)
+
+	// Test: Destroying removes the session and clears the cookie
+	setCookie = serve(t, m, cookie, func(s *Session) { s.Destroy() })
+	assert.Equal(t, []string{"session=; Max-Age=0; Path=/; HttpOnly; SameSite=Lax"}, setCookie)
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Unknown session cookies get a fresh session and are cleared
+	setCookie = serve(t, m, "session=forged", func(s *Session) { _, ok = s.Get("user") })
+	assert.False(t, ok)
+	assert.Equal(t, "session=", cookiePair(setCookie))
+}
+
+func TestManagerExpiry(t *testing.T) {
+	store := NewMemoryStore(0)
+	m, c := newTestManager(store)
+	m.IdleTimeout = 10 * time.Minute
+	m.AbsoluteTimeout = time.Hour
+	cookie := cookiePair(serve(t, m, "", func(s *Session) { s.Set("n", "1") }))
+	found := func() bool {
+		var ok bool
+		serve(t, m, cookie, func(s *Session) { _, ok = s.Get("n") })
+		return ok
+	}
+
+	// Test: Use within the idle timeout keeps the session alive
+	for range 5 {
+		c.advance(9 * time.Minute)
+		assert.True(t, found())
+	}
+
+	// Test: The cookie does not outlive the absolute timeout
+	c.advance(9 * time.Minute)
+	setCookie := serve(t, m, cookie, func(s *Session) {})
+	assert.Contains(t, setCookie[0], "; Max-Age=360;")
+
+	// Test: Sessions end at the absolute timeout however much they are used
+	c.advance(6 * time.Minute)
+	assert.False(t, found())
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Sessions end after the idle timeout
+	cookie = cookiePair(serve(t, m, "", func(s *Session) { s.Set("n", "1") }))
+	c.advance(10 * time.Minute)
+	assert.False(t, found())
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	c := &clock{t: time.Now()}
+	store.now = c.now
+	r := &Record{ID: "a", Values: map[string]string{"k": "v"}, Accessed: c.now()}
+	_, err := store.Save(r)
+	require.NoError(t, err)
+
+	// Test: Loaded records are copies
+	got, err := store.Load("a")
+	require.NoError(t, err)
+	got.Values["k"] = "changed"
+	got, _ = store.Load("a")
+	assert.Equal(t, "v", got.Values["k"])
+
+	// Test: Records expire after the TTL and are swept on a later save
+	c.advance(time.Minute + time.Second)
+	_, err = store.Load("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, store.Len())
+	store.Save(&Record{ID: "b", Accessed: c.now()})
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store, err := NewFileStore(dir, time.Hour)
+	require.NoError(t, err)
+	m, _ := newTestManager(store)
+
+	// Test: Sessions survive in files
+	cookie := cookiePair(serve(t, m, "", func(s *Session) { s.Set("user", "krish") }))
+	id := strings.TrimPrefix(cookie, "session=")
+	assert.FileExists(t, filepath.Join(dir, id+".json"))
+	other, err := NewFileStore(dir, time.Hour)
+	require.NoError(t, err)
+	r, err := other.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "krish", r.Values["user"])
+
+	// Test: IDs that are not file names are refused
+	for _, id := range []string{"", "../x", "a/b", "a.b"} {
+		_, err := store.Load(id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+	}
+
+	// Test: Deleted sessions are gone
+	require.NoError(t, store.Delete(id))
+	_, err = store.Load(id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete(id))
+
+	// Test: Files untouched for the TTL are swept
+	stale := &Record{ID: "stale"}
+	_, err = store.Save(stale)
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "stale.json"), old, old))
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = store.Save(&Record{ID: "fresh"})
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "stale.json"))
+}
+
+func TestCodec(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	data := []byte(`{"user":"krish"}`)
+
+	for _, newCodec := range []func(...[]byte) (*Codec, error){NewSigner, NewEncrypter} {
+		old, err := newCodec(oldKey)
+		require.NoError(t, err)
+		rotated, err := newCodec(newKey, oldKey)
+		require.NoError(t, err)
+		retired, err := newCodec(newKey)
+		require.NoError(t, err)
+
+		// Test: Sealed values open, also after the key is rotated
+		value := old.Seal(data)
+		assert.NoError(t, response.SetCookie(headers.NewHeaders(), &response.Cookie{Name: "s", Value: value}))
+		for _, c := range []*Codec{old, rotated} {
+			got, err := c.Open(value)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		}
+
+		// Test: Retired keys no longer open values
+		_, err = retired.Open(value)
+		assert.Error(t, err)
+		got, err := retired.Open(rotated.Seal(data))
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		// Test: Tampered values do not open
+		tampered := []byte(value)
+		tampered[len(tampered)/3] ^= 1
+		_, err = old.Open(string(tampered))
+		assert.Error(t, err)
+		for _, v := range []string{"", ".", "!!", "a.b"} {
+			_, err = old.Open(v)
+			assert.Error(t, err, v)
+		}
+	}
+
+	// Test: Weak keys are refused
+	_, err := NewSigner(make([]byte, 16))
+	assert.Error(t, err)
+	_, err = NewEncrypter(make([]byte, 20))
+	assert.Error(t, err)
+	_, err = NewSigner()
+	assert.Error(t, err)
+}
+
+func TestCookieStore(t *testing.T) {
+	codec, err := NewEncrypter(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	m, _ := newTestManager(NewCookieStore(codec))
+
+	// Test: The session travels in the cookie itself
+	cookie := cookiePair(serve(t, m, "", func(s *Session) { s.Set("user", "krish") }))
+	assert.NotContains(t, cookie, "krish")
+	var user string
+	serve(t, m, cookie, func(s *Session) { user, _ = s.Get("user") })
+	assert.Equal(t, "krish", user)
+
+	// Test: Forged cookies start a new session
+	var ok bool
+	serve(t, m, cookie+"x", func(s *Session) { _, ok = s.Get("user") })
+	assert.False(t, ok)
+
+	// Test: Sessions too large for a cookie are not saved
+	setCookie := serve(t, m, "", func(s *Session) { s.Set("big", strings.Repeat("x", maxCookieSize)) })
+	assert.Empty(t, setCookie)
+}
@@ -0,0 +1,198 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in memory, evicting those not accessed for
+// the TTL. Expired sessions are swept out every TTL, as part of a Save.
+type MemoryStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	records   map[string]Record
+	nextSweep time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, now: time.Now, records: map[string]Record{}}
+}
+
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok || s.expired(r) {
+		return nil, ErrNotFound
+	}
+	r.Values = maps.Clone(r.Values)
+	return &r, nil
+}
+
+func (s *MemoryStore) Save(r *Record) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.After(s.nextSweep) {
+		for id, r := range s.records {
+			if s.expired(r) {
+				delete(s.records, id)
+			}
+		}
+		s.nextSweep = now.Add(s.ttl)
+	}
+	saved := *r
+	saved.Values = maps.Clone(r.Values)
+	s.records[r.ID] = saved
+	return r.ID, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// Len returns the number of sessions held, expired ones included until
+// they are swept.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func (s *MemoryStore) expired(r Record) bool {
+	return s.ttl > 0 && s.now().Sub(r.Accessed) >= s.ttl
+}
+
+// FileStore keeps each session as a JSON file in a directory, so sessions
+// survive restarts. Files not written for the TTL are removed as part of
+// a Save, at most once every TTL.
+type FileStore struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// NewFileStore returns a FileStore in dir, creating it if needed.
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+// path returns the file of a session. IDs come from cookies, so anything
+// but the characters newID produces is refused rather than joined to the
+// directory.
+func (s *FileStore) path(id string) (string, bool) {
+	if id == "" || strings.ContainsFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) {
+		return "", false
+	}
+	return filepath.Join(s.dir, id+".json"), true
+}
+
+func (s *FileStore) Load(id string) (*Record, error) {
+	path, ok := s.path(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("session: corrupt file %s: %w", path, err)
+	}
+	if r.ID != id {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+// Save writes the session to a temporary file first and renames it, so
+// that a concurrent Load never sees half a file.
+func (s *FileStore) Save(r *Record) (string, error) {
+	path, ok := s.path(r.ID)
+	if !ok {
+		return "", fmt.Errorf("session: invalid ID %q", r.ID)
+	}
+	s.sweep()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return r.ID, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, ok := s.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) sweep() {
+	if s.ttl <= 0 {
+		return
+	}
+	s.mu.Lock()
+	now := s.now()
+	if now.Before(s.nextSweep) {
+		s.mu.Unlock()
+		return
+	}
+	s.nextSweep = now.Add(s.ttl)
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && now.Sub(info.ModTime()) >= s.ttl {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
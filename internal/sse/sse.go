@@ -0,0 +1,222 @@
+// Package sse writes Server-Sent Events (text/event-stream) responses on
+// top of chunked transfer coding.
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// DefaultHeartbeat is how often a Stream sends a comment to keep idle
+// connections open through proxies and to notice clients that went away.
+const DefaultHeartbeat = 15 * time.Second
+
+// ErrClosed is returned when sending on a stream that was closed or whose
+// client disconnected.
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is a single message on an event stream. Empty fields other than
+// Data are left out.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Streamer starts event streams.
+type Streamer struct {
+	// Heartbeat is the interval between keep-alive comments. Zero means
+	// DefaultHeartbeat and a negative value disables them.
+	Heartbeat time.Duration
+	// Retry, when set, is sent once at the start of every stream.
+	Retry time.Duration
+}
+
+// Stream is an open event stream. Send and Comment may be called from any
+// goroutine. Close must be called before the handler returns.
+type Stream struct {
+	// LastEventID is the ID of the last event the client saw before it
+	// reconnected, taken from the Last-Event-ID request header.
+	LastEventID string
+
+	w    *response.Writer
+	mu   sync.Mutex
+	err  error
+	done chan struct{}
+}
+
+// Start writes the response header of an event stream and starts the
+// heartbeat. A client that disconnects is noticed on the next write, so at
+// the latest after a heartbeat or two; Done is closed when that happens.
+// A HEAD request gets the header only, and a stream that has already ended.
+func (s *Streamer) Start(w *response.Writer, req *request.Request) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+	// keeps nginx and similar proxies from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	if err := w.WriteStatusLine(response.StatusCodeSuccess); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	stream := &Stream{
+		LastEventID: lastEventID,
+		w:           w,
+		done:        make(chan struct{}),
+	}
+	if req.RequestLine.Method == "HEAD" {
+		stream.end(ErrClosed)
+		return stream, nil
+	}
+	if s.Retry > 0 {
+		if err := stream.write(fmt.Sprintf("retry: %d\n\n", s.Retry.Milliseconds())); err != nil {
+			return nil, err
+		}
+	}
+	heartbeat := s.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	if heartbeat > 0 {
+		go stream.heartbeat(heartbeat)
+	}
+	return stream, nil
+}
+
+// Send writes the event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	text, err := formatEvent(e)
+	if err != nil {
+		return err
+	}
+	return s.write(text)
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Done is closed once the stream has ended, either by Close or because the
+// client disconnected.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the write error that ended the stream, if any.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close stops the heartbeat and ends the chunked body.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	s.end(ErrClosed)
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (s *Stream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.WriteChunkedBody([]byte(text)); err != nil {
+		s.end(err)
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.end(err)
+		return err
+	}
+	return nil
+}
+
+// end records why the stream ended and closes Done. s.mu must be held.
+func (s *Stream) end(err error) {
+	if s.err == nil {
+		s.err = err
+		close(s.done)
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.write(": heartbeat\n\n") != nil {
+				return
+			}
+		}
+	}
+}
+
+// formatEvent renders an event in the text/event-stream format. Data is
+// split into one data field per line; IDs and event names cannot span
+// lines.
+func formatEvent(e Event) (string, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return "", fmt.Errorf("sse: event ID contains a line break or NUL")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return "", fmt.Errorf("sse: event name contains a line break")
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range splitLines(e.Data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// splitLines splits on any of the line endings the format allows.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
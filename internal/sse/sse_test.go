@@ -0,0 +1,194 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(lastEventID string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/events", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	if lastEventID != "" {
+		req.Headers.Set("Last-Event-ID", lastEventID)
+	}
+	return req
+}
+
+// dechunk decodes a chunked body and returns the data.
+func dechunk(t *testing.T, body string) string {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(body))
+	var data []byte
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		data = append(data, chunk[:size]...)
+	}
+	return string(data)
+}
+
+func TestFormatEvent(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{ID: "7", Event: "update", Data: "one\ntwo\r\nthree\rfour"}, "id: 7\nevent: update\ndata: one\ndata: two\ndata: three\ndata: four\n\n"},
+		{Event{Data: " leading space"}, "data:  leading space\n\n"},
+		{Event{Retry: 2500 * time.Millisecond, Data: ""}, "retry: 2500\ndata: \n\n"},
+	}
+	for _, tc := range tests {
+		got, err := formatEvent(tc.event)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+
+	// Test: Fields that would break the framing are rejected
+	_, err := formatEvent(Event{ID: "1\n2", Data: "x"})
+	assert.Error(t, err)
+	_, err = formatEvent(Event{Event: "a\rb", Data: "x"})
+	assert.Error(t, err)
+}
+
+func TestStream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	streamer := &Streamer{Heartbeat: -1, Retry: 3 * time.Second}
+	stream, err := streamer.Start(response.NewWriter(buf), newRequest("41"))
+	require.NoError(t, err)
+
+	// Test: Last-Event-ID from the request is exposed
+	assert.Equal(t, "41", stream.LastEventID)
+
+	require.NoError(t, stream.Send(Event{ID: "42", Data: "first"}))
+	require.NoError(t, stream.Comment("note"))
+	require.NoError(t, stream.Send(Event{ID: "43", Event: "multi", Data: "a\nb"}))
+	require.NoError(t, stream.Close())
+	<-stream.Done()
+	assert.ErrorIs(t, stream.Send(Event{Data: "late"}), ErrClosed)
+
+	head, body, ok := strings.Cut(buf.String(), "\r\n\r\n")
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head+"\r\n", "content-type: text/event-stream; charset=utf-8\r\n")
+	assert.Contains(t, head+"\r\n", "cache-control: no-cache\r\n")
+	assert.Contains(t, head+"\r\n", "transfer-encoding: chunked\r\n")
+	assert.Equal(t, "retry: 3000\n\n"+
+		"id: 42\ndata: first\n\n"+
+		": note\n\n"+
+		"id: 43\nevent: multi\ndata: a\ndata: b\n\n", dechunk(t, body))
+	assert.True(t, strings.HasSuffix(body, "0\r\n\r\n"))
+}
+
+// syncBuffer is a bytes.Buffer safe for the heartbeat goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeat(t *testing.T) {
+	buf := &syncBuffer{}
+	stream, err := (&Streamer{Heartbeat: 5 * time.Millisecond}).Start(response.NewWriter(buf), newRequest(""))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return strings.Count(buf.String(), ": heartbeat\n\n") >= 2
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, stream.Close())
+}
+
+// failingWriter fails every write once the client is gone.
+type failingWriter struct {
+	mu   sync.Mutex
+	gone bool
+}
+
+var errGone = errors.New("connection reset by peer")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.gone {
+		return 0, errGone
+	}
+	return len(p), nil
+}
+
+func (w *failingWriter) disconnect() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gone = true
+}
+
+func TestClientDisconnect(t *testing.T) {
+	// Test: A failed heartbeat ends the stream
+	w := &failingWriter{}
+	stream, err := (&Streamer{Heartbeat: 5 * time.Millisecond}).Start(response.NewWriter(w), newRequest(""))
+	require.NoError(t, err)
+	w.disconnect()
+	select {
+	case <-stream.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream did not notice the disconnect")
+	}
+	assert.ErrorIs(t, stream.Err(), errGone)
+	assert.ErrorIs(t, stream.Send(Event{Data: "x"}), errGone)
+	assert.NoError(t, stream.Close())
+}
+
+func TestHead(t *testing.T) {
+	// Test: HEAD gets the header of the stream, which ends straight away
+	buf := &bytes.Buffer{}
+	head := response.NewHeadFramer(response.NewWriter(buf))
+	req := newRequest("")
+	req.RequestLine.Method = "HEAD"
+	stream, err := (&Streamer{Retry: time.Second}).Start(response.NewFramedWriter(head), req)
+	require.NoError(t, err)
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("stream to a HEAD request did not end")
+	}
+	assert.ErrorIs(t, stream.Send(Event{Data: "x"}), ErrClosed)
+	require.NoError(t, stream.Close())
+	require.NoError(t, head.Finish())
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/event-stream; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.Zero(t, head.BodyLength())
+}
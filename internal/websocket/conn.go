@@ -0,0 +1,463 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the kind of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const maxControlPayload = 125
+
+// closeTimeout bounds how long Close waits for the peer to answer.
+const closeTimeout = 5 * time.Second
+
+// ErrCloseSent is returned when writing after a close frame has been sent.
+var ErrCloseSent = errors.New("websocket: close sent")
+
+// CloseError reports the end of the connection, either by a close frame
+// from the peer or because this side failed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write; writes are serialized.
+type Conn struct {
+	// Subprotocol is the subprotocol agreed in the handshake, if any.
+	Subprotocol string
+	// MaxMessageSize limits the size of a reassembled message. Larger
+	// messages fail the connection with CloseMessageTooBig.
+	MaxMessageSize int
+
+	conn   net.Conn
+	reader *bufio.Reader
+	server bool
+
+	writeMu   sync.Mutex
+	closeSent bool
+	readErr   error
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, server bool) *Conn {
+	return &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		conn:           conn,
+		reader:         reader,
+		server:         server,
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the next data message, reassembled from its
+// fragments. Pings are answered and pongs dropped along the way. A close
+// frame from the peer is answered and returned as a *CloseError, as is any
+// protocol violation, after which the connection is closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	var messageType MessageType
+	var message []byte
+	inMessage := false
+	validated := 0
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.readFailed(err)
+		}
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(true, opPong, f.payload); err != nil && err != ErrCloseSent {
+				return 0, nil, c.readFailed(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.readFailed(c.closeReceived(f.payload))
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.readFailed(protocolError("continuation frame without a message"))
+			}
+		case opText, opBinary:
+			if inMessage {
+				return 0, nil, c.readFailed(protocolError("new message before the previous one finished"))
+			}
+			inMessage = true
+			messageType = MessageType(f.opcode)
+		default:
+			return 0, nil, c.readFailed(protocolError(fmt.Sprintf("reserved opcode %#x", f.opcode)))
+		}
+
+		if len(message)+len(f.payload) > c.MaxMessageSize {
+			return 0, nil, c.readFailed(&CloseError{Code: CloseMessageTooBig, Text: "message too big"})
+		}
+		message = append(message, f.payload...)
+		if messageType == TextMessage {
+			// fail fast on the first fragment that cannot be valid UTF-8
+			n, ok := checkUTF8(message[validated:], f.fin)
+			if !ok {
+				return 0, nil, c.readFailed(&CloseError{Code: CloseInvalidFramePayloadData, Text: "invalid UTF-8"})
+			}
+			validated += n
+		}
+		if f.fin {
+			if message == nil {
+				message = []byte{}
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// WriteMessage sends data as a single unfragmented message.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", messageType)
+	}
+	return c.writeFrame(true, byte(messageType), data)
+}
+
+// NextWriter returns a writer that sends every Write as one fragment of a
+// message, finished by Close. No other message may be written until the
+// writer is closed; control frames may be.
+func (c *Conn) NextWriter(messageType MessageType) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("websocket: unknown message type %d", messageType)
+	}
+	return &messageWriter{conn: c, opcode: byte(messageType)}, nil
+}
+
+// WritePing sends a ping with up to 125 bytes of application data.
+func (c *Conn) WritePing(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload too long")
+	}
+	return c.writeFrame(true, opPing, data)
+}
+
+// Close starts the closing handshake, waits for the peer's close frame
+// and closes the connection. It must not be called while another goroutine
+// is in ReadMessage.
+func (c *Conn) Close(code int, reason string) error {
+	if c.readErr != nil {
+		// reading already ended the connection
+		return nil
+	}
+	err := c.writeFrame(true, opClose, closePayload(code, reason))
+	if err != nil && err != ErrCloseSent {
+		c.conn.Close()
+		return err
+	}
+	// ReadMessage closes the connection once the peer's close frame
+	// arrives, the deadline passes or the connection fails.
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return nil
+		}
+	}
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: head[0] & 0x0f,
+	}
+	if head[0]&0x70 != 0 {
+		return frame{}, protocolError("reserved bits set")
+	}
+	masked := head[1]&0x80 != 0
+	if masked != c.server {
+		if c.server {
+			return frame{}, protocolError("unmasked client frame")
+		}
+		return frame{}, protocolError("masked server frame")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, protocolError("payload length has the most significant bit set")
+		}
+	}
+	if f.opcode&0x8 != 0 {
+		if length > maxControlPayload {
+			return frame{}, protocolError("control frame payload too long")
+		}
+		if !f.fin {
+			return frame{}, protocolError("fragmented control frame")
+		}
+	}
+	if length > uint64(c.MaxMessageSize) {
+		return frame{}, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, key[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// closeReceived answers a close frame from the peer, echoing its status
+// code, and closes the connection.
+func (c *Conn) closeReceived(payload []byte) error {
+	code := CloseNoStatusReceived
+	reason := ""
+	if len(payload) == 1 {
+		return protocolError("close frame with a one byte payload")
+	}
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload))
+		if !validCloseCode(code) {
+			return protocolError(fmt.Sprintf("invalid close code %d", code))
+		}
+		if !utf8.Valid(payload[2:]) {
+			return &CloseError{Code: CloseInvalidFramePayloadData, Text: "invalid UTF-8 in close reason"}
+		}
+		reason = string(payload[2:])
+	}
+	reply := []byte{}
+	if code != CloseNoStatusReceived {
+		reply = closePayload(code, "")
+	}
+	c.writeFrame(true, opClose, reply)
+	c.conn.Close()
+	return &CloseError{Code: code, Text: reason}
+}
+
+// readFailed records the error that ended reading. A *CloseError produced
+// on this side fails the connection: its close frame is sent before the
+// connection is closed.
+func (c *Conn) readFailed(err error) error {
+	var closeErr *CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		if closeErr.Code != CloseNoStatusReceived {
+			c.writeFrame(true, opClose, closePayload(closeErr.Code, closeErr.Text))
+		}
+	case err == io.EOF:
+		err = &CloseError{Code: CloseAbnormalClosure, Text: "unexpected EOF"}
+	}
+	c.conn.Close()
+	c.readErr = err
+	return err
+}
+
+func (c *Conn) writeFrame(fin bool, opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, b0, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, b0, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, b0, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if c.server {
+		buf = append(buf, payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	}
+
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// messageWriter sends a message as a sequence of fragments.
+type messageWriter struct {
+	conn    *Conn
+	opcode  byte
+	started bool
+	closed  bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("websocket: write to closed message writer")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.conn.writeFrame(false, w.frameOpcode(), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.conn.writeFrame(true, w.frameOpcode(), nil)
+}
+
+// frameOpcode returns the message opcode for the first fragment and the
+// continuation opcode after it.
+func (w *messageWriter) frameOpcode() byte {
+	if w.started {
+		return opContinuation
+	}
+	w.started = true
+	return w.opcode
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return []byte{}
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	return append(payload, reason...)
+}
+
+// validCloseCode reports whether a peer may send the code in a close frame:
+// a registered code or one from the ranges for libraries and applications.
+// 1005, 1006 and 1015 are reserved for reporting and never go on the wire.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// checkUTF8 validates a text payload and returns the length of its prefix
+// made of complete code points. Unless final, p may end part way into a
+// code point.
+func checkUTF8(p []byte, final bool) (int, bool) {
+	n := 0
+	for n < len(p) {
+		r, size := utf8.DecodeRune(p[n:])
+		if r == utf8.RuneError && size == 1 {
+			// a truncated but so far valid sequence is not a full rune
+			return n, !final && !utf8.FullRune(p[n:])
+		}
+		n += size
+	}
+	return n, true
+}
+
+func protocolError(text string) error {
+	return &CloseError{Code: CloseProtocolError, Text: text}
+}
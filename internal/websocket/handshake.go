@@ -0,0 +1,157 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// connections hijacked from the server.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/headers"
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the largest message a Conn reassembles when no
+// other limit is configured.
+const DefaultMaxMessageSize = 16 << 20
+
+// ErrBadHandshake is returned by Upgrade when the request is not a valid
+// WebSocket opening handshake. The error response has already been written.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader validates opening handshakes and turns the connection of an
+// accepted one into a Conn.
+type Upgrader struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	// The first one the client also offers is selected.
+	Subprotocols []string
+	// CheckOrigin decides whether a handshake is accepted. When nil,
+	// requests without an Origin or with an Origin whose host equals the
+	// Host header are accepted.
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize limits the size of a reassembled message. Zero means
+	// DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+// Upgrade completes the opening handshake with a 101 response and takes over
+// the connection. On failure the matching error response is written and the
+// returned error wraps ErrBadHandshake.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" {
+		h := response.ErrorHeaders(response.StatusCodeMethodNotAllowed)
+		h.Override("Allow", "GET")
+		return nil, handshakeError(w, response.StatusCodeMethodNotAllowed, h, "method is not GET")
+	}
+	if req.RequestLine.HttpVersion != "1.1" {
+		return nil, handshakeError(w, response.StatusCodeBadRequest, nil, "protocol is not HTTP/1.1")
+	}
+	if _, ok := req.Headers.Get("Host"); !ok {
+		return nil, handshakeError(w, response.StatusCodeBadRequest, nil, "missing Host")
+	}
+	if upgrade, _ := req.Headers.Get("Upgrade"); !hasToken(upgrade, "websocket") {
+		h := response.ErrorHeaders(response.StatusCodeUpgradeRequired)
+		h.Override("Upgrade", "websocket")
+		return nil, handshakeError(w, response.StatusCodeUpgradeRequired, h, "Upgrade is not websocket")
+	}
+	if connection, _ := req.Headers.Get("Connection"); !hasToken(connection, "upgrade") {
+		return nil, handshakeError(w, response.StatusCodeBadRequest, nil, "Connection does not include upgrade")
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(version) != "13" {
+		h := response.ErrorHeaders(response.StatusCodeUpgradeRequired)
+		h.Override("Sec-WebSocket-Version", "13")
+		return nil, handshakeError(w, response.StatusCodeUpgradeRequired, h, "unsupported version")
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, handshakeError(w, response.StatusCodeBadRequest, nil, "malformed Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, handshakeError(w, response.StatusCodeForbidden, nil, "origin not allowed")
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	offered, _ := req.Headers.Get("Sec-WebSocket-Protocol")
+	subprotocol := u.selectSubprotocol(offered)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := w.WriteStatusLine(response.StatusCodeSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	conn, reader, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(conn, reader, true)
+	c.Subprotocol = subprotocol
+	if u.MaxMessageSize > 0 {
+		c.MaxMessageSize = u.MaxMessageSize
+	}
+	return c, nil
+}
+
+func (u *Upgrader) selectSubprotocol(offered string) string {
+	for _, supported := range u.Subprotocols {
+		for _, protocol := range strings.Split(offered, ",") {
+			if strings.TrimSpace(protocol) == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// sameOrigin accepts requests from browsers on the same host and requests
+// from clients that do not send an Origin at all.
+func sameOrigin(req *request.Request) bool {
+	origin, ok := req.Headers.Get("Origin")
+	if !ok {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host, _ := req.Headers.Get("Host")
+	return strings.EqualFold(u.Host, host)
+}
+
+// hasToken reports whether a comma-separated header value contains token,
+// compared case-insensitively.
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func handshakeError(w *response.Writer, statusCode response.StatusCode, h headers.Headers, reason string) error {
+	response.WriteError(w, statusCode, h)
+	return fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+}
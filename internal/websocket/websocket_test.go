@@ -0,0 +1,488 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/KrishKoria/HTTPfromTCP/internal/request"
+	"github.com/KrishKoria/HTTPfromTCP/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEcho runs an echo endpoint on a loopback connection and returns the
+// raw client side together with a client Conn for reading frames.
+func startEcho(t *testing.T, maxMessageSize int) (net.Conn, *Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		c := newConn(conn, bufio.NewReader(conn), true)
+		if maxMessageSize > 0 {
+			c.MaxMessageSize = maxMessageSize
+		}
+		for {
+			messageType, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(messageType, data)
+		}
+	}()
+
+	raw, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { raw.Close() })
+	return raw, newConn(raw, bufio.NewReader(raw), false)
+}
+
+// clientFrame builds a masked frame. b0 carries FIN, RSV and the opcode.
+func clientFrame(b0 byte, payload []byte) []byte {
+	key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	buf := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, 0x80|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0x80|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0x80|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	maskBytes(key, buf[start:])
+	return buf
+}
+
+const fin = 0x80
+
+func closeFrame(code int, reason string) []byte {
+	return clientFrame(fin|opClose, append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...))
+}
+
+func closeWith(code int) frame {
+	return frame{fin: true, opcode: opClose, payload: binary.BigEndian.AppendUint16(nil, uint16(code))}
+}
+
+func text(s string) frame {
+	return frame{fin: true, opcode: opText, payload: []byte(s)}
+}
+
+type echoCase struct {
+	name   string
+	max    int
+	send   [][]byte
+	expect []frame
+}
+
+// Cases follow the sections of the Autobahn test suite.
+func echoCases() []echoCase {
+	cases := []echoCase{
+		{
+			name:   "1.1.1 empty text message",
+			send:   [][]byte{clientFrame(fin|opText, nil)},
+			expect: []frame{text("")},
+		},
+		{
+			name:   "1.2.1 empty binary message",
+			send:   [][]byte{clientFrame(fin|opBinary, nil)},
+			expect: []frame{{fin: true, opcode: opBinary, payload: []byte{}}},
+		},
+		{
+			name:   "2.1 ping without payload",
+			send:   [][]byte{clientFrame(fin|opPing, nil)},
+			expect: []frame{{fin: true, opcode: opPong, payload: []byte{}}},
+		},
+		{
+			name:   "2.2 ping with payload",
+			send:   [][]byte{clientFrame(fin|opPing, []byte("Hello, world!"))},
+			expect: []frame{{fin: true, opcode: opPong, payload: []byte("Hello, world!")}},
+		},
+		{
+			name:   "2.5 ping with a payload over 125 bytes",
+			send:   [][]byte{clientFrame(fin|opPing, make([]byte, 126))},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name: "2.8 unsolicited pong",
+			send: [][]byte{
+				clientFrame(fin|opPong, []byte("unsolicited")),
+				clientFrame(fin|opText, []byte("after pong")),
+			},
+			expect: []frame{text("after pong")},
+		},
+		{
+			name:   "3.1 RSV1 set",
+			send:   [][]byte{clientFrame(fin|0x40|opText, []byte("Hello"))},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "3.7 RSV bits set on a ping",
+			send:   [][]byte{clientFrame(fin|0x70|opPing, nil)},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "4.1.1 reserved non-control opcode",
+			send:   [][]byte{clientFrame(fin|0x3, nil)},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "4.2.1 reserved control opcode",
+			send:   [][]byte{clientFrame(fin|0xb, nil)},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "5.1 fragmented ping",
+			send:   [][]byte{clientFrame(opPing, []byte("frag")), clientFrame(fin|opContinuation, []byte("ment"))},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "5.3 text message in two fragments",
+			send:   [][]byte{clientFrame(opText, []byte("hello, ")), clientFrame(fin|opContinuation, []byte("world"))},
+			expect: []frame{text("hello, world")},
+		},
+		{
+			name: "5.6 ping between fragments",
+			send: [][]byte{
+				clientFrame(opText, []byte("frag")),
+				clientFrame(fin|opPing, []byte("ping")),
+				clientFrame(fin|opContinuation, []byte("ment")),
+			},
+			expect: []frame{{fin: true, opcode: opPong, payload: []byte("ping")}, text("fragment")},
+		},
+		{
+			name:   "5.9 continuation without a message",
+			send:   [][]byte{clientFrame(fin|opContinuation, []byte("orphan"))},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "5.18 new message before the previous one finished",
+			send:   [][]byte{clientFrame(opText, []byte("one")), clientFrame(fin|opText, []byte("two"))},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name: "6.2.3 valid UTF-8 split inside a code point",
+			send: [][]byte{
+				clientFrame(opText, []byte("κόσμε")[:3]),
+				clientFrame(fin|opContinuation, []byte("κόσμε")[3:]),
+			},
+			expect: []frame{text("κόσμε")},
+		},
+		{
+			name:   "6.3.1 invalid UTF-8 in a single frame",
+			send:   [][]byte{clientFrame(fin|opText, []byte("\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80edited"))},
+			expect: []frame{closeWith(CloseInvalidFramePayloadData)},
+		},
+		{
+			name:   "6.4.1 fail fast on an invalid first fragment",
+			send:   [][]byte{clientFrame(opText, []byte("\xce\xba\xff"))},
+			expect: []frame{closeWith(CloseInvalidFramePayloadData)},
+		},
+		{
+			name:   "6.x truncated code point at the end of a message",
+			send:   [][]byte{clientFrame(fin|opText, []byte("\xce"))},
+			expect: []frame{closeWith(CloseInvalidFramePayloadData)},
+		},
+		{
+			name:   "7.1.1 close after a message",
+			send:   [][]byte{clientFrame(fin|opText, []byte("Hello")), closeFrame(CloseNormalClosure, "")},
+			expect: []frame{text("Hello"), closeWith(CloseNormalClosure)},
+		},
+		{
+			name:   "7.1.3 message after close is ignored",
+			send:   [][]byte{closeFrame(CloseNormalClosure, ""), clientFrame(fin|opText, []byte("late"))},
+			expect: []frame{closeWith(CloseNormalClosure)},
+		},
+		{
+			name:   "7.3.1 close without payload",
+			send:   [][]byte{clientFrame(fin|opClose, nil)},
+			expect: []frame{{fin: true, opcode: opClose, payload: []byte{}}},
+		},
+		{
+			name:   "7.3.2 close with a one byte payload",
+			send:   [][]byte{clientFrame(fin|opClose, []byte{0x03})},
+			expect: []frame{closeWith(CloseProtocolError)},
+		},
+		{
+			name:   "7.3.5 close with a reason",
+			send:   [][]byte{closeFrame(CloseNormalClosure, strings.Repeat("*", 123))},
+			expect: []frame{closeWith(CloseNormalClosure)},
+		},
+		{
+			name:   "7.5.1 close reason that is not UTF-8",
+			send:   [][]byte{closeFrame(CloseNormalClosure, "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80")},
+			expect: []frame{closeWith(CloseInvalidFramePayloadData)},
+		},
+		{
+			name:   "9.1 message over the size limit",
+			max:    1024,
+			send:   [][]byte{clientFrame(fin|opBinary, make([]byte, 2048))},
+			expect: []frame{closeWith(CloseMessageTooBig)},
+		},
+		{
+			name:   "9.x fragments over the size limit",
+			max:    1024,
+			send:   [][]byte{clientFrame(opBinary, make([]byte, 1000)), clientFrame(fin|opContinuation, make([]byte, 1000))},
+			expect: []frame{closeWith(CloseMessageTooBig)},
+		},
+	}
+	for _, n := range []int{125, 126, 127, 128, 65535, 65536} {
+		payload := strings.Repeat("*", n)
+		cases = append(cases, echoCase{
+			name:   fmt.Sprintf("1.1.x text message of %d bytes", n),
+			send:   [][]byte{clientFrame(fin|opText, []byte(payload))},
+			expect: []frame{text(payload)},
+		})
+	}
+	for _, code := range []int{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 3000, 3999, 4000, 4999} {
+		cases = append(cases, echoCase{
+			name:   fmt.Sprintf("7.7.x valid close code %d", code),
+			send:   [][]byte{closeFrame(code, "")},
+			expect: []frame{closeWith(code)},
+		})
+	}
+	for _, code := range []int{0, 999, 1004, 1005, 1006, 1015, 1016, 1100, 2000, 2999, 5000, 65535} {
+		cases = append(cases, echoCase{
+			name:   fmt.Sprintf("7.9.x invalid close code %d", code),
+			send:   [][]byte{closeFrame(code, "")},
+			expect: []frame{closeWith(CloseProtocolError)},
+		})
+	}
+	return cases
+}
+
+func TestEchoCases(t *testing.T) {
+	for _, tc := range echoCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, client := startEcho(t, tc.max)
+			for _, data := range tc.send {
+				if _, err := raw.Write(data); err != nil {
+					// the server may already have failed the connection
+					break
+				}
+			}
+			for _, want := range tc.expect {
+				got, err := client.readFrame()
+				require.NoError(t, err)
+				assert.Equal(t, want.opcode, got.opcode)
+				assert.Equal(t, want.fin, got.fin)
+				if want.opcode == opClose && len(want.payload) >= 2 {
+					require.GreaterOrEqual(t, len(got.payload), 2)
+					assert.Equal(t, want.payload[:2], got.payload[:2])
+				} else {
+					assert.Equal(t, want.payload, got.payload)
+				}
+			}
+			if last := tc.expect[len(tc.expect)-1]; last.opcode == opClose {
+				_, err := client.readFrame()
+				assert.ErrorIs(t, err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestUnmaskedClientFrame(t *testing.T) {
+	raw, client := startEcho(t, 0)
+	_, err := raw.Write([]byte{fin | opText, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	got, err := client.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(opClose), got.opcode)
+	assert.Equal(t, closeWith(CloseProtocolError).payload, got.payload[:2])
+}
+
+func TestFragmentedWrite(t *testing.T) {
+	_, client := startEcho(t, 0)
+	w, err := client.NextWriter(TextMessage)
+	require.NoError(t, err)
+	io.WriteString(w, "hello, ")
+	io.WriteString(w, "world")
+	require.NoError(t, w.Close())
+
+	messageType, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello, world", string(data))
+
+	// Test: Close completes the handshake
+	require.NoError(t, client.Close(CloseNormalClosure, "bye"))
+	_, _, err = client.ReadMessage()
+	require.Error(t, err)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// serveOne accepts a single connection, parses its request and runs the
+// handler on it the way the server does.
+func serveOne(t *testing.T, handler func(w *response.Writer, req *request.Request)) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		handler(response.NewWriter(conn), req)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: server.example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Origin: http://server.example.com\r\n" +
+	"Sec-WebSocket-Protocol: superchat, chat\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"\r\n"
+
+func TestUpgrade(t *testing.T) {
+	upgrader := &Upgrader{Subprotocols: []string{"chat", "superchat"}}
+	closed := make(chan error, 1)
+	conn := serveOne(t, func(w *response.Writer, req *request.Request) {
+		c, err := upgrader.Upgrade(w, req)
+		if err != nil {
+			closed <- err
+			return
+		}
+		for {
+			messageType, data, err := c.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			c.WriteMessage(messageType, data)
+		}
+	})
+	_, err := conn.Write([]byte(handshake))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	var head []string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head = append(head, strings.TrimRight(line, "\r\n"))
+	}
+	require.NotEmpty(t, head)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", head[0])
+	assert.Contains(t, head, "upgrade: websocket")
+	assert.Contains(t, head, "connection: Upgrade")
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	assert.Contains(t, head, "sec-websocket-protocol: chat")
+
+	client := newConn(conn, reader, false)
+	require.NoError(t, client.WriteMessage(BinaryMessage, []byte{1, 2, 3}))
+	messageType, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+
+	require.NoError(t, client.Close(CloseGoingAway, ""))
+	err = <-closed
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		upgrader   Upgrader
+		statusCode response.StatusCode
+		header     string
+		value      string
+	}{
+		{
+			name:       "wrong version",
+			raw:        strings.Replace(handshake, "Version: 13", "Version: 8", 1),
+			statusCode: response.StatusCodeUpgradeRequired,
+			header:     "sec-websocket-version",
+			value:      "13",
+		},
+		{
+			name:       "not GET",
+			raw:        strings.Replace(handshake, "GET", "POST", 1),
+			statusCode: response.StatusCodeMethodNotAllowed,
+			header:     "allow",
+			value:      "GET",
+		},
+		{
+			name:       "malformed key",
+			raw:        strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1),
+			statusCode: response.StatusCodeBadRequest,
+		},
+		{
+			name:       "missing upgrade",
+			raw:        strings.Replace(handshake, "Upgrade: websocket\r\n", "", 1),
+			statusCode: response.StatusCodeUpgradeRequired,
+			header:     "upgrade",
+			value:      "websocket",
+		},
+		{
+			name:       "cross origin",
+			raw:        strings.Replace(handshake, "http://server.example.com", "http://evil.example.com", 1),
+			statusCode: response.StatusCodeForbidden,
+		},
+		{
+			name:       "origin allowed by CheckOrigin",
+			raw:        strings.Replace(handshake, "http://server.example.com", "http://evil.example.com", 1),
+			upgrader:   Upgrader{CheckOrigin: func(*request.Request) bool { return true }},
+			statusCode: response.StatusCodeSwitchingProtocols,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			conn := serveOne(t, func(w *response.Writer, req *request.Request) {
+				_, err := tc.upgrader.Upgrade(w, req)
+				errs <- err
+			})
+			_, err := conn.Write([]byte(tc.raw))
+			require.NoError(t, err)
+			err = <-errs
+			if tc.statusCode == response.StatusCodeSwitchingProtocols {
+				require.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrBadHandshake)
+			resp, err := response.ResponseFromReader(conn)
+			require.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusLine.StatusCode)
+			if tc.header != "" {
+				assert.Equal(t, tc.value, resp.Headers[tc.header])
+			}
+		})
+	}
+}